@@ -1,9 +1,18 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"store/pkg/kvstore"
 	"store/pkg/server"
@@ -11,6 +20,16 @@ import (
 
 const restServerPort = 8000
 
+var errInvalidMember = errors.New("invalid cluster member")
+
+// channelOnlyFlags are the flags for features only the channel store backend has.
+var channelOnlyFlags = []string{
+	"shards", "wal", "fsync", "fsync-interval", "snapshot", "snapshot-interval", "expiry-interval", "max-keys",
+	"max-bytes", "max-value-size", "evict-policy", "evict-max-keys", "evict-max-bytes", "change-feed",
+	"history-versions", "history-age", "groups", "namespaces", "follow", "forward-writes", "cluster-id",
+	"cluster-members", "partition-id", "partition-nodes", "virtual-nodes",
+}
+
 func main() {
 	htaccessFile, err := os.OpenFile("htaccess.log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
@@ -28,15 +47,241 @@ func main() {
 
 	appLogger.Println("Starting up...")
 
+	host := flag.String("host", "localhost", "host name or IP address to listen on (every interface if empty)")
 	port := flag.Int("port", restServerPort, "HTTP server port to listen on")
+	backend := flag.String("backend", "channel", "store implementation to use: channel, mutex or disk")
+	dataDir := flag.String("data-dir", "data", "directory to hold the keys in, when using -backend=disk")
+	shards := flag.Int("shards", runtime.NumCPU(), "number of shards to spread the keys across")
+	walPath := flag.String("wal", "", "write-ahead log file to persist the store in (in-memory only if not set)")
+	fsync := flag.String("fsync", "always", "when to flush the write-ahead log to disk: always, interval or never")
+	fsyncInterval := flag.Duration("fsync-interval", kvstore.DefaultSyncInterval,
+		"how often to flush the write-ahead log to disk, when using -fsync=interval")
+	snapshotPath := flag.String("snapshot", "", "file to write snapshots of the store to (disabled if not set)")
+	snapshotInterval := flag.Duration("snapshot-interval", 0,
+		"how often to take a snapshot automatically (only on demand if not set)")
+	expiryInterval := flag.Duration("expiry-interval", time.Second,
+		"how often to remove keys whose time-to-live has passed")
+	maxKeys := flag.Int("max-keys", 0, "most keys each user can own (unlimited if not set)")
+	maxBytes := flag.Int64("max-bytes", 0, "most bytes of keys and values each user can store (unlimited if not set)")
+	maxValueSize := flag.Int64("max-value-size", 0, "longest value that can be stored, in bytes (unlimited if not set)")
+	evictPolicy := flag.String("evict-policy", "lru", "which keys to evict first when over size: lru, lfu or ttl")
+	evictMaxKeys := flag.Int("evict-max-keys", 0, "most keys to hold before evicting (no limit if not set)")
+	evictMaxBytes := flag.Int64("evict-max-bytes", 0,
+		"most bytes of keys and values to hold before evicting (no limit if not set)")
+	changeFeed := flag.Int("change-feed", 0,
+		"most recent changes to keep for consumers of the change feed to catch up from (10000 if not set)")
+	historyVersions := flag.Int("history-versions", 0,
+		"earlier versions of each key to keep, for reading past revisions (off unless this or -history-age is set)")
+	historyAge := flag.Duration("history-age", 0, "how long to keep earlier versions of keys (no limit if not set)")
+	groupsPath := flag.String("groups", "",
+		`JSON file of the members of each group, for granting permissions to groups e.g. {"team": ["user_a"]}`)
+	namespacesPath := flag.String("namespaces", "",
+		"file to record namespaces in, so they're recreated on restart (in-memory only if not set)")
+	follow := flag.String("follow", "",
+		"base URL of a leader to replicate as a read-only follower, e.g. http://localhost:8000 (leader if not set)")
+	forwardWrites := flag.Bool("forward-writes", false,
+		"forward writes made to a follower on to its leader, rather than rejecting them")
+	clusterID := flag.String("cluster-id", "", "ID of this node in a Raft cluster (not part of a cluster if not set)")
+	clusterMembers := flag.String("cluster-members", "",
+		"each node in the cluster as id=url, comma separated, e.g. a=http://localhost:8000,b=http://localhost:8001 "+
+			"(none when joining an existing cluster)")
+	partitionID := flag.String("partition-id", "",
+		"ID of this node in a cluster with keys partitioned across its nodes (not partitioned if not set)")
+	partitionNodes := flag.String("partition-nodes", "",
+		"each node in the partitioned cluster as id=url, comma separated (only this node when joining a cluster)")
+	virtualNodes := flag.Int("virtual-nodes", 0,
+		"points each node of a partitioned cluster has on the hash ring (100 if not set)")
 	flag.Parse()
 
-	store := kvstore.NewKVStore()
-	server.Start(*port, store, htaccessLogger, appLogger)
+	// rather than silently ignoring them, refuse flags the backend has no use for
+	if *backend != "channel" {
+		checkFlagsUnset(*backend, channelOnlyFlags...)
+	}
+
+	if *backend != "disk" {
+		checkFlagsUnset(*backend, "data-dir")
+	}
+
+	var leader *url.URL
+
+	if *follow != "" {
+		if leader, err = url.Parse(*follow); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var cluster *kvstore.Cluster
+
+	if *clusterID != "" {
+		members, parseErr := parseMembers(*clusterMembers)
+		if parseErr != nil {
+			log.Fatal(parseErr)
+		}
+
+		cluster = &kvstore.Cluster{ID: *clusterID, Members: members, Transport: server.NewClusterTransport()}
+	}
+
+	var partitioning *server.Partitioning
+
+	if *partitionID != "" {
+		if leader != nil || cluster != nil {
+			log.Fatal("A store can't be partitioned when following or in a cluster")
+		}
+
+		if partitioning, err = parsePartitioning(*partitionID, *partitionNodes, *virtualNodes); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	syncPolicy, err := kvstore.ParseSyncPolicy(*fsync)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	evictionPolicy, err := kvstore.ParseEvictionPolicy(*evictPolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	eviction := kvstore.Eviction{Policy: evictionPolicy, MaxKeys: *evictMaxKeys, MaxBytes: *evictMaxBytes}
+
+	var groups map[string][]string
+
+	if *groupsPath != "" {
+		if groups, err = loadGroups(*groupsPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var store kvstore.Store
+
+	switch *backend {
+	case "channel":
+		store, err = kvstore.NewKVStoreWithConfig(kvstore.Config{
+			Shards:           *shards,
+			WALPath:          *walPath,
+			SyncPolicy:       syncPolicy,
+			SyncInterval:     *fsyncInterval,
+			SnapshotPath:     *snapshotPath,
+			SnapshotInterval: *snapshotInterval,
+			ExpiryInterval:   *expiryInterval,
+			ChangeFeed:       *changeFeed,
+			Follower:         leader != nil,
+			Cluster:          cluster,
+			Eviction:         eviction,
+			History:          kvstore.History{MaxVersions: *historyVersions, MaxAge: *historyAge},
+			Quota:            kvstore.Quota{MaxKeys: *maxKeys, MaxBytes: *maxBytes, MaxValueSize: *maxValueSize},
+			NamespacesPath:   *namespacesPath,
+			Groups:           groups,
+			Logger:           appLogger,
+		})
+	case "mutex":
+		store = kvstore.NewMutexStore()
+	case "disk":
+		store, err = kvstore.NewDiskStore(*dataDir)
+	default:
+		log.Fatal("Unknown store backend: ", *backend)
+	}
+
+	if err != nil {
+		appLogger.Fatal("Unable to open store: ", err)
+	}
+
+	appLogger.Printf("Using %s store", *backend)
+
+	kvStore, isKVStore := store.(*kvstore.KVStore)
+	address := net.JoinHostPort(*host, strconv.Itoa(*port))
+
+	switch {
+	case isKVStore && leader != nil:
+		appLogger.Printf("Following leader %s", leader)
+		server.StartFollower(address, kvStore, server.Follower{Leader: leader, ForwardWrites: *forwardWrites},
+			htaccessLogger, appLogger)
+	case isKVStore && partitioning != nil:
+		appLogger.Printf("Partitioned as node %s", partitioning.ID)
+		server.StartPartitioned(address, kvStore, *partitioning, htaccessLogger, appLogger)
+	default:
+		server.Start(address, store, htaccessLogger, appLogger)
+	}
 
 	appLogger.Println("Shutting down...")
-	kvstore.Close(store)
+
+	if err = store.Close(); err != nil {
+		appLogger.Println("Error closing store: ", err)
+	}
 
 	htaccessFile.Close()
 	storeFile.Close()
 }
+
+// checkFlagsUnset exits if any of the flags have been set, as they don't apply to the store backend.
+func checkFlagsUnset(backend string, names ...string) {
+	unsupported := make(map[string]bool, len(names))
+	for _, name := range names {
+		unsupported[name] = true
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		if unsupported[f.Name] {
+			log.Fatalf("The -%s flag isn't supported by the %s store backend", f.Name, backend)
+		}
+	})
+}
+
+// loadGroups returns the usernames of the members of each group, from a JSON file.
+func loadGroups(path string) (map[string][]string, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]string)
+	if err = json.Unmarshal(bytes, &groups); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// parseMembers returns the address of each node in a cluster, from a comma separated list of id=url.
+func parseMembers(list string) (map[string]string, error) {
+	members := make(map[string]string)
+
+	for _, member := range strings.Split(list, ",") {
+		if member == "" {
+			continue
+		}
+
+		id, address, ok := strings.Cut(member, "=")
+		if !ok || id == "" || address == "" {
+			return nil, fmt.Errorf("%w: %q should be id=url", errInvalidMember, member)
+		}
+
+		members[id] = address
+	}
+
+	return members, nil
+}
+
+// parsePartitioning returns the config of a node of a partitioned cluster, from a comma separated list of
+// id=url of the nodes, which must include the node itself.
+func parsePartitioning(id string, list string, virtualNodes int) (*server.Partitioning, error) {
+	members, err := parseMembers(list)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]*url.URL)
+
+	for member, address := range members {
+		if nodes[member], err = url.Parse(address); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := nodes[id]; !ok {
+		return nil, fmt.Errorf("%w: %s should be one of the nodes", errInvalidMember, id)
+	}
+
+	return &server.Partitioning{ID: id, Nodes: nodes, VirtualNodes: virtualNodes}, nil
+}
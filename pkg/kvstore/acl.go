@@ -0,0 +1,230 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Permission is the right to perform a type of operation on a key.
+type Permission string
+
+const (
+	// PermissionRead allows reading the key's value, if the key is private.
+	PermissionRead Permission = "read"
+	// PermissionWrite allows updating the key's value.
+	PermissionWrite Permission = "write"
+	// PermissionDelete allows deleting the key.
+	PermissionDelete Permission = "delete"
+	// PermissionAdmin allows viewing and changing the key's access control list, and all other operations.
+	PermissionAdmin Permission = "admin"
+)
+
+var (
+	// ErrPermissionDenied is returned when a user doesn't have permission to perform an operation on a key.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrInvalidACL is returned when setting an access control list that isn't valid.
+	ErrInvalidACL = errors.New("invalid access control list")
+)
+
+// ACL is the access control list of a key, granting permissions on the key to users other than its owner,
+// either by name or through the groups they belong to. The owner of a key can always perform any operation.
+type ACL struct {
+	// Users maps usernames to the permissions granted to each of them.
+	Users map[string][]Permission `json:"users,omitempty"`
+	// Groups maps group names to the permissions granted to all their members.
+	Groups map[string][]Permission `json:"groups,omitempty"`
+	// Private restricts reading the key to those with read permission. Otherwise any user can read the key.
+	Private bool `json:"private,omitempty"`
+}
+
+type aclRequest struct {
+	key             string
+	username        string
+	acl             *ACL // nil when getting the ACL
+	responseChannel chan<- *aclResponse
+}
+
+type aclResponse struct {
+	acl   *ACL
+	found bool
+	err   error
+}
+
+// userGroups maps each username to the set of groups the user belongs to.
+type userGroups map[string]map[string]bool
+
+// newUserGroups returns the groups each user belongs to, from the members of each group.
+func newUserGroups(members map[string][]string) userGroups {
+	groups := make(userGroups)
+
+	for group, usernames := range members {
+		for _, username := range usernames {
+			if groups[username] == nil {
+				groups[username] = make(map[string]bool)
+			}
+
+			groups[username][group] = true
+		}
+	}
+
+	return groups
+}
+
+// GetACL returns the access control list of the key, and a flag indicating if the key was present. A key
+// whose ACL has never been set has an empty one.
+//
+// Only the owner, or a user with admin permission on the key, can get its ACL.
+func GetACL(s *KVStore, key string, username string) (*ACL, bool, error) {
+	return GetACLContext(context.Background(), s, key, username)
+}
+
+// GetACLContext is the same as GetACL, but gives up if the context is done first, or the store is closed.
+func GetACLContext(ctx context.Context, s *KVStore, key string, username string) (*ACL, bool, error) {
+	return sendACLRequest(ctx, s, key, username, nil)
+}
+
+// SetACL replaces the access control list of the key, and returns a flag indicating if the key was present.
+// Changing the ACL gives the key a new version, in the same way as writing it.
+//
+// Only the owner, or a user with admin permission on the key, can set its ACL.
+func SetACL(s *KVStore, key string, acl ACL, username string) (bool, error) {
+	return SetACLContext(context.Background(), s, key, acl, username)
+}
+
+// SetACLContext is the same as SetACL, but gives up if the context is done first, or the store is closed.
+// If the context is done once the change has been passed to the store, the change may still be made.
+func SetACLContext(ctx context.Context, s *KVStore, key string, acl ACL, username string) (bool, error) {
+	if err := validateACL(acl); err != nil {
+		return false, err
+	}
+
+	_, found, err := sendACLRequest(ctx, s, key, username, &acl)
+
+	return found, err
+}
+
+func sendACLRequest(ctx context.Context, s *KVStore, key string, username string, acl *ACL) (*ACL, bool, error) {
+	responseChannel := make(chan *aclResponse, 1)
+	if err := sendRequest(ctx, shardFor(s, key), aclOperation,
+		&aclRequest{key, username, acl, responseChannel}); err != nil {
+		return nil, false, err
+	}
+
+	select {
+	case response := <-responseChannel:
+		return response.acl, response.found, response.err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// handleACL gets or sets the key's access control list, if permitted.
+func handleACL(sh *shard, params *aclRequest) *aclResponse {
+	existingEntry, ok := lookupEntry(sh, params.key)
+	if !ok {
+		return &aclResponse{nil, false, nil}
+	}
+
+	if !hasPermission(existingEntry, params.username, sh.store.groups, PermissionAdmin) {
+		return &aclResponse{nil, true, ErrPermissionDenied}
+	}
+
+	if params.acl == nil {
+		return &aclResponse{copyACL(existingEntry.ACL), true, nil}
+	}
+
+	updatedEntry := *existingEntry
+	updatedEntry.ACL = copyACL(params.acl)
+
+	if err := storeEntry(sh.store, params.key, &updatedEntry); err != nil {
+		return &aclResponse{nil, true, err}
+	}
+
+	return &aclResponse{nil, true, nil}
+}
+
+// hasPermission returns whether the user can perform operations needing the permission on the entry, either
+// as its owner or by being granted the permission (or admin permission) directly or through one of their groups.
+func hasPermission(e *entry, username string, groups userGroups, permission Permission) bool {
+	if e.Owner == username {
+		return true
+	}
+
+	if permission == PermissionRead && (e.ACL == nil || !e.ACL.Private) {
+		return true
+	}
+
+	if e.ACL == nil {
+		return false
+	}
+
+	if grants(e.ACL.Users[username], permission) {
+		return true
+	}
+
+	for group := range groups[username] {
+		if grants(e.ACL.Groups[group], permission) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// grants returns whether the granted permissions include the permission, or admin permission.
+func grants(granted []Permission, permission Permission) bool {
+	for _, grantedPermission := range granted {
+		if grantedPermission == permission || grantedPermission == PermissionAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateACL(acl ACL) error {
+	for _, grantees := range []map[string][]Permission{acl.Users, acl.Groups} {
+		for grantee, permissions := range grantees {
+			if grantee == "" {
+				return fmt.Errorf("%w: no user or group name specified", ErrInvalidACL)
+			}
+
+			for _, permission := range permissions {
+				switch permission {
+				case PermissionRead, PermissionWrite, PermissionDelete, PermissionAdmin:
+				default:
+					return fmt.Errorf("%w: unknown permission %q", ErrInvalidACL, permission)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// copyACL returns an empty ACL if the ACL is nil, otherwise a copy of the ACL, so that an ACL held
+// in the store is never shared with callers.
+func copyACL(acl *ACL) *ACL {
+	if acl == nil {
+		return &ACL{}
+	}
+
+	copied := &ACL{Private: acl.Private}
+
+	if acl.Users != nil {
+		copied.Users = make(map[string][]Permission, len(acl.Users))
+		for username, permissions := range acl.Users {
+			copied.Users[username] = append([]Permission{}, permissions...)
+		}
+	}
+
+	if acl.Groups != nil {
+		copied.Groups = make(map[string][]Permission, len(acl.Groups))
+		for group, permissions := range acl.Groups {
+			copied.Groups[group] = append([]Permission{}, permissions...)
+		}
+	}
+
+	return copied
+}
@@ -0,0 +1,149 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"store/pkg/kvstore"
+	"testing"
+)
+
+const user3 = "user3"
+
+func newGroupStore(t *testing.T) *kvstore.KVStore {
+	t.Helper()
+
+	store, err := kvstore.NewKVStoreWithConfig(kvstore.Config{
+		Shards: testShards,
+		Groups: map[string][]string{"team": {user3}},
+	})
+	if err != nil {
+		t.Fatal("Error opening store: ", err)
+	}
+
+	return store
+}
+
+func TestACLUserPermissions(t *testing.T) {
+	store := newGroupStore(t)
+	kvstore.Write(store, key1, value1, user1)
+
+	if err := kvstore.Write(store, key1, value2, user2); !errors.Is(err, kvstore.ErrPermissionDenied) {
+		t.Fatal("Update by different user should have been denied but got: ", err)
+	}
+
+	acl := kvstore.ACL{Users: map[string][]kvstore.Permission{user2: {kvstore.PermissionWrite}}}
+	if found, err := kvstore.SetACL(store, key1, acl, user1); !found || err != nil {
+		t.Fatal("Owner should have been able to set ACL but got: ", found, err)
+	}
+
+	if err := kvstore.Write(store, key1, value2, user2); err != nil {
+		t.Fatal("Update with write permission should have been successful but got: ", err)
+	}
+
+	if entryInfo := kvstore.List(store, key1, user1); entryInfo.Owner != user1 {
+		t.Fatal("Update by another user should have kept the owner but was: ", entryInfo.Owner)
+	}
+
+	if _, err := kvstore.Delete(store, key1, user2); !errors.Is(err, kvstore.ErrPermissionDenied) {
+		t.Fatal("Delete without delete permission should have been denied but got: ", err)
+	}
+
+	if _, _, err := kvstore.GetACL(store, key1, user2); !errors.Is(err, kvstore.ErrPermissionDenied) {
+		t.Fatal("Getting ACL without admin permission should have been denied but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestACLGroupPermissions(t *testing.T) {
+	store := newGroupStore(t)
+	kvstore.Write(store, key1, value1, user1)
+
+	acl := kvstore.ACL{Groups: map[string][]kvstore.Permission{"team": {kvstore.PermissionAdmin}}}
+	kvstore.SetACL(store, key1, acl, user1)
+
+	got, found, err := kvstore.GetACL(store, key1, user3)
+	if !found || err != nil || len(got.Groups["team"]) != 1 {
+		t.Fatal("Group member with admin permission should have been able to get ACL but got: ", got, found, err)
+	}
+
+	if deleted, err := kvstore.Delete(store, key1, user3); !deleted || err != nil {
+		t.Fatal("Admin permission should have allowed delete but got: ", deleted, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestACLPrivateRead(t *testing.T) {
+	store := newGroupStore(t)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key2, value2, user1)
+
+	acl := kvstore.ACL{Private: true, Users: map[string][]kvstore.Permission{user3: {kvstore.PermissionRead}}}
+	kvstore.SetACL(store, key1, acl, user1)
+
+	_, _, err := kvstore.ReadContext(context.Background(), store, key1, user2)
+	if !errors.Is(err, kvstore.ErrPermissionDenied) {
+		t.Fatal("Read of private key should have been denied but got: ", err)
+	}
+
+	for _, username := range []string{user1, user3} {
+		if value, ok := kvstore.Read(store, key1, username); !ok || !bytes.Equal(value, value1) {
+			t.Fatalf("Read of private key by %s should have been allowed but got: %t %s", username, ok, value)
+		}
+	}
+
+	checkScan(t, kvstore.Scan(store, "", "", 0, user2), key2)
+
+	if _, err = kvstore.ListContext(context.Background(), store, key1, user2); !errors.Is(err,
+		kvstore.ErrPermissionDenied) {
+		t.Fatal("List of private key should have been denied but got: ", err)
+	}
+
+	if entryInfo := kvstore.List(store, key1, user3); entryInfo == nil {
+		t.Fatal("List of private key by a user with read permission should have been allowed")
+	}
+
+	if entries := kvstore.ListAll(store, user2); len(entries) != 1 || entries[0].Key != key2 {
+		t.Fatal("ListAll should have left out the private key but got: ", entries)
+	}
+
+	_, err = kvstore.Transaction(store, []kvstore.TxnOp{{Type: kvstore.TxnGet, Key: key1}}, user2)
+	if !errors.Is(err, kvstore.ErrPermissionDenied) {
+		t.Fatal("Transaction reading private key should have been denied but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestACLPrivateWatch(t *testing.T) {
+	store := newGroupStore(t)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.SetACL(store, key1, kvstore.ACL{Private: true}, user1)
+
+	watcher, _ := kvstore.Watch(store, "", true, 0, user2)
+
+	kvstore.Write(store, key1, value2, user1)
+	kvstore.Write(store, key2, value2, user1)
+
+	checkEvent(t, watcher, kvstore.Event{Type: kvstore.PutEvent, Key: key2, Value: value2, Revision: 4})
+
+	kvstore.Close(store)
+}
+
+func TestACLInvalid(t *testing.T) {
+	store := newGroupStore(t)
+	kvstore.Write(store, key1, value1, user1)
+
+	acl := kvstore.ACL{Users: map[string][]kvstore.Permission{user2: {"execute"}}}
+	if _, err := kvstore.SetACL(store, key1, acl, user1); !errors.Is(err, kvstore.ErrInvalidACL) {
+		t.Fatal("Unknown permission should have been invalid but got: ", err)
+	}
+
+	if found, err := kvstore.SetACL(store, key2, kvstore.ACL{}, user1); found || err != nil {
+		t.Fatal("Setting ACL of missing key should have found nothing but got: ", found, err)
+	}
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,223 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"store/pkg/raft"
+	"sync"
+	"time"
+)
+
+var (
+	errClusterConfig = fmt.Errorf("%w: a cluster node is held in memory only, and can't be a follower",
+		ErrNotSupported)
+	errClusterNamespaces = fmt.Errorf("%w: namespaces can't be created in a cluster", ErrNotSupported)
+)
+
+// Cluster configures a store as a single node of a Raft cluster, which keeps every node's store the same. Each
+// change to the store is made by the cluster's leader, and only once a majority of the nodes have committed
+// it, with reads being linearizable (see raft.ReadIndex). The other nodes return raft.ErrNotLeader, both for
+// changes and reads.
+//
+// Leases are held by the leader that granted them, so keys attached to a lease are removed if the leader is
+// replaced. Namespaces aren't supported.
+type Cluster struct {
+	// ID identifies the node within the cluster.
+	ID string
+	// Members maps the ID of each node in the cluster (including this one) to its address. A node joining an
+	// existing cluster starts with no members, and is then added by the leader, see raft.AddMember.
+	Members map[string]string
+	// Transport sends requests to the other nodes.
+	Transport raft.Transport
+	// ElectionTimeout is how long a node waits to hear from the leader before standing for election. If zero,
+	// a default of 500ms is used.
+	ElectionTimeout time.Duration
+	// HeartbeatInterval is how often the leader contacts the other nodes. If zero, a default of 50ms is used.
+	HeartbeatInterval time.Duration
+}
+
+// consensus is the Raft node of a store in a cluster, along with the changes it has proposed as the leader
+// which have yet to be committed.
+type consensus struct {
+	node      *raft.Node
+	mutex     sync.Mutex
+	proposals map[uint64]*proposal
+}
+
+// proposal is a change waiting to be committed. Once it has been, the proposer is sent true on the outcome
+// channel, and then makes the change itself (since it's already handling the shards involved) before closing
+// the applied channel. If a different change was committed in its place, it's sent false instead.
+type proposal struct {
+	term    uint64
+	outcome chan bool
+	applied chan struct{}
+}
+
+// ClusterNode returns the store's Raft node, for passing on requests from the other nodes and changing the
+// cluster's members, and a flag indicating if the store is part of a cluster.
+func ClusterNode(s *KVStore) (*raft.Node, bool) {
+	if s.consensus == nil {
+		return nil, false
+	}
+
+	return s.consensus.node, true
+}
+
+// startCluster starts the store's Raft node, if the store is part of a cluster.
+func startCluster(s *KVStore) error {
+	cluster := s.config.Cluster
+	if cluster == nil {
+		return nil
+	}
+
+	s.consensus = &consensus{proposals: make(map[uint64]*proposal)}
+
+	node, err := raft.NewNode(raft.Config{
+		ID:                cluster.ID,
+		Members:           cluster.Members,
+		Transport:         cluster.Transport,
+		ElectionTimeout:   cluster.ElectionTimeout,
+		HeartbeatInterval: cluster.HeartbeatInterval,
+		Logger:            s.logger,
+		Apply: func(index uint64, term uint64, command []byte) {
+			applyCommitted(s, index, term, command)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	s.consensus.node = node
+
+	return nil
+}
+
+// checkClusterConfig returns an error if the store's config can't be used in a cluster.
+func checkClusterConfig(config Config) error {
+	if config.Cluster != nil && (config.WALPath != "" || config.SnapshotPath != "" || config.NamespacesPath != "" ||
+		config.Follower) {
+		return errClusterConfig
+	}
+
+	return nil
+}
+
+// proposeChanges has the changes committed by the cluster. Once they have been, the caller must make the
+// changes and then call the returned function, so that the commands committed after them can be applied. The
+// caller must be handling the shard of every changed key.
+func proposeChanges(s *KVStore, record *ReplicationRecord) (func(), error) {
+	command, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	c := s.consensus
+
+	// the proposal must be waiting before it can be committed
+	c.mutex.Lock()
+
+	submitted, err := raft.Propose(c.node, command)
+	if err != nil {
+		c.mutex.Unlock()
+
+		return nil, err
+	}
+
+	waiting := &proposal{submitted.Term, make(chan bool, 1), make(chan struct{})}
+	c.proposals[submitted.Index] = waiting
+	c.mutex.Unlock()
+
+	applied := func() { close(waiting.applied) }
+
+	select {
+	case committed := <-waiting.outcome:
+		if committed {
+			return applied, nil
+		}
+	case <-submitted.Lost:
+		c.mutex.Lock()
+		_, stillWaiting := c.proposals[submitted.Index]
+		delete(c.proposals, submitted.Index)
+		c.mutex.Unlock()
+
+		// unless the outcome is already on its way
+		if !stillWaiting && <-waiting.outcome {
+			return applied, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: the change may still be made by the next leader", raft.ErrNotLeader)
+}
+
+// applyCommitted makes a change committed by the cluster. If the change was proposed by this node, the
+// proposer makes it, otherwise it's made in the same way as a change replicated from a leader.
+func applyCommitted(s *KVStore, index uint64, term uint64, command []byte) {
+	c := s.consensus
+
+	c.mutex.Lock()
+	waiting, ok := c.proposals[index]
+	delete(c.proposals, index)
+	c.mutex.Unlock()
+
+	if ok {
+		waiting.outcome <- waiting.term == term
+
+		if waiting.term == term {
+			<-waiting.applied
+
+			return
+		}
+	}
+
+	record := &ReplicationRecord{}
+	if err := json.Unmarshal(command, record); err != nil {
+		s.logger.Println("Unable to parse committed change: ", err)
+
+		return
+	}
+
+	keys := make([]string, len(record.Changes))
+	for i, keyChange := range record.Changes {
+		keys[i] = keyChange.Key
+	}
+
+	release, err := parkShards(context.Background(), shardsFor(s, keys))
+	if err != nil {
+		// the store is being closed
+		return
+	}
+
+	defer release()
+
+	if _, err = commitRevision(s, record.Changes, record.Removal, record.Time, false); err != nil {
+		s.logger.Println("Unable to make committed change: ", err)
+	}
+}
+
+// linearize waits until the store is up to date with every change committed by the cluster before the call,
+// if the store is part of a cluster, so that a read then made sees them.
+func linearize(ctx context.Context, s *KVStore) error {
+	if s.consensus == nil {
+		return nil
+	}
+
+	return raft.ReadIndex(ctx, s.consensus.node)
+}
+
+// leadsChanges returns whether changes to the store are decided by the store itself, rather than by a leader
+// whose changes are replicated to it.
+func leadsChanges(s *KVStore) bool {
+	if s.config.Follower {
+		return false
+	}
+
+	return s.consensus == nil || raft.IsLeader(s.consensus.node)
+}
+
+// stopCluster stops the store's Raft node, if the store is part of a cluster.
+func stopCluster(s *KVStore) {
+	if s.consensus != nil {
+		raft.Stop(s.consensus.node)
+	}
+}
@@ -0,0 +1,213 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"store/pkg/kvstore"
+	"store/pkg/raft"
+	"testing"
+	"time"
+)
+
+const clusterWaitTimeout = 5 * time.Second
+
+// startCluster starts a store for each of the IDs, as the nodes of a cluster on a local network.
+func startCluster(t *testing.T, ids ...string) (*raft.LocalNetwork, map[string]*kvstore.KVStore) {
+	t.Helper()
+
+	network := raft.NewLocalNetwork()
+	members := make(map[string]string)
+
+	for _, id := range ids {
+		members[id] = id
+	}
+
+	stores := make(map[string]*kvstore.KVStore)
+
+	for _, id := range ids {
+		store, err := kvstore.NewKVStoreWithConfig(kvstore.Config{Shards: testShards, Cluster: &kvstore.Cluster{
+			ID:                id,
+			Members:           members,
+			Transport:         network,
+			ElectionTimeout:   100 * time.Millisecond,
+			HeartbeatInterval: 10 * time.Millisecond,
+		}})
+		if err != nil {
+			t.Fatal("Cluster node should have been created but got: ", err)
+		}
+
+		t.Cleanup(func() { kvstore.Close(store) })
+
+		node, _ := kvstore.ClusterNode(store)
+		network.Connect(node)
+		stores[id] = store
+	}
+
+	return network, stores
+}
+
+// clusterLeader waits for one of the stores to be the cluster's leader, and returns its ID.
+func clusterLeader(t *testing.T, stores map[string]*kvstore.KVStore, ids ...string) string {
+	t.Helper()
+
+	for deadline := time.Now().Add(clusterWaitTimeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, id := range ids {
+			if node, _ := kvstore.ClusterNode(stores[id]); raft.IsLeader(node) {
+				return id
+			}
+		}
+	}
+
+	t.Fatal("One of the stores should have been elected leader: ", ids)
+
+	return ""
+}
+
+// clusterWrite writes the value to the key through the leader, retrying until the leader is ready for changes.
+func clusterWrite(t *testing.T, leader *kvstore.KVStore, key string, value []byte) {
+	t.Helper()
+
+	for deadline := time.Now().Add(clusterWaitTimeout); ; time.Sleep(10 * time.Millisecond) {
+		err := kvstore.Write(leader, key, value, user1)
+		if err == nil {
+			return
+		}
+
+		if !errors.Is(err, raft.ErrNotLeader) || time.Now().After(deadline) {
+			t.Fatal("Value should have been written but got: ", err)
+		}
+	}
+}
+
+// clusterRead reads the key through the leader, retrying until the leader is ready for reads.
+func clusterRead(t *testing.T, leader *kvstore.KVStore, key string) ([]byte, bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(clusterWaitTimeout); ; time.Sleep(10 * time.Millisecond) {
+		value, ok, err := kvstore.ReadContext(context.Background(), leader, key, user1)
+		if err == nil {
+			return value, ok
+		}
+
+		if !errors.Is(err, raft.ErrNotLeader) || time.Now().After(deadline) {
+			t.Fatal("Key should have been read but got: ", err)
+		}
+	}
+}
+
+// waitForRevision waits for each of the stores to have made the changes up to the revision.
+func waitForRevision(t *testing.T, stores map[string]*kvstore.KVStore, revision uint64) {
+	t.Helper()
+
+	for deadline := time.Now().Add(clusterWaitTimeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		done := true
+		for _, store := range stores {
+			done = done && kvstore.StoreStats(store).Revision == revision
+		}
+
+		if done {
+			return
+		}
+	}
+
+	for id, store := range stores {
+		t.Logf("Store %s has revision %d", id, kvstore.StoreStats(store).Revision)
+	}
+
+	t.Fatal("Every store should have reached revision ", revision)
+}
+
+func others(ids []string, id string) []string {
+	rest := make([]string, 0, len(ids))
+
+	for _, other := range ids {
+		if other != id {
+			rest = append(rest, other)
+		}
+	}
+
+	return rest
+}
+
+func TestClusterConfigRejected(t *testing.T) {
+	_, err := kvstore.NewKVStoreWithConfig(kvstore.Config{WALPath: t.TempDir() + "/wal",
+		Cluster: &kvstore.Cluster{ID: "a", Members: map[string]string{"a": "a"}, Transport: raft.NewLocalNetwork()}})
+	if !errors.Is(err, kvstore.ErrNotSupported) {
+		t.Fatal("Cluster node with a write-ahead log should have been rejected but got: ", err)
+	}
+}
+
+func TestClusterCommitsWrites(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	_, stores := startCluster(t, ids...)
+
+	leader := clusterLeader(t, stores, ids...)
+
+	clusterWrite(t, stores[leader], key1, value1)
+	clusterWrite(t, stores[leader], key2, value2)
+
+	if _, err := kvstore.Delete(stores[leader], key2, user1); err != nil {
+		t.Fatal("Key should have been deleted but got: ", err)
+	}
+
+	if value, ok := kvstore.Read(stores[leader], key1, user1); !ok || !bytes.Equal(value, value1) {
+		t.Fatalf("Leader should have had the value but had: %t value %s", ok, value)
+	}
+
+	revision := kvstore.StoreStats(stores[leader]).Revision
+	waitForRevision(t, stores, revision)
+
+	follower := stores[others(ids, leader)[0]]
+
+	if err := kvstore.Write(follower, key1, value2, user1); !errors.Is(err, raft.ErrNotLeader) {
+		t.Fatal("Write to a follower should have been rejected but got: ", err)
+	}
+
+	if _, _, err := kvstore.ReadContext(context.Background(), follower, key1, user1); !errors.Is(err,
+		raft.ErrNotLeader) {
+		t.Fatal("Linearizable read from a follower should have been rejected but got: ", err)
+	}
+
+	if info := kvstore.List(stores[leader], key1, user1); info == nil || info.Version != revision-2 {
+		t.Fatal("Leader should have given the write its revision but had: ", info)
+	}
+}
+
+func TestClusterSurvivesPartition(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	network, stores := startCluster(t, ids...)
+
+	oldLeader := clusterLeader(t, stores, ids...)
+	clusterWrite(t, stores[oldLeader], key1, value1)
+
+	network.Partition(oldLeader)
+
+	// the old leader stands down once it can't reach a majority, without making the change
+	if err := kvstore.Write(stores[oldLeader], key2, value1, user1); !errors.Is(err, raft.ErrNotLeader) {
+		t.Fatal("Write to a partitioned leader should have failed but got: ", err)
+	}
+
+	rest := others(ids, oldLeader)
+	newLeader := clusterLeader(t, stores, rest...)
+
+	if value, ok := clusterRead(t, stores[newLeader], key1); !ok || !bytes.Equal(value, value1) {
+		t.Fatalf("New leader should have had the committed value but had: %t value %s", ok, value)
+	}
+
+	clusterWrite(t, stores[newLeader], key1, value2)
+
+	// the old leader catches up once it can reach the others again
+	network.Heal()
+	waitForRevision(t, stores, kvstore.StoreStats(stores[newLeader]).Revision)
+
+	newLeader = clusterLeader(t, stores, ids...)
+
+	if value, ok := clusterRead(t, stores[newLeader], key1); !ok || !bytes.Equal(value, value2) {
+		t.Fatalf("Leader should have had the latest value but had: %t value %s", ok, value)
+	}
+
+	if _, ok := clusterRead(t, stores[newLeader], key2); ok {
+		t.Fatal("Write to the partitioned leader should never have been made")
+	}
+}
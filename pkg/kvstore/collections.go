@@ -0,0 +1,501 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+// DataType is the type of value held by a key. Keys written as usual hold a plain value, while those changed
+// through the list, set and hash functions hold a collection of strings, which can only be changed by the
+// functions for that type.
+type DataType string
+
+const (
+	// TypeValue is a plain value, as set by a write.
+	TypeValue DataType = ""
+	// TypeList is an ordered list of strings, which can be pushed and popped at either end.
+	TypeList DataType = "list"
+	// TypeSet is an unordered set of unique strings.
+	TypeSet DataType = "set"
+	// TypeHash maps field names to string values.
+	TypeHash DataType = "hash"
+)
+
+// ErrWrongType is returned when using a key holding one type of value as another, such as pushing to a set.
+var ErrWrongType = errors.New("key holds the wrong type of value")
+
+// collection is the decoded value of a list, set or hash key. Collections are held in the store as JSON, so
+// that they can also be read whole like any other value: a list or set as an array (with a set's members in
+// order), and a hash as an object.
+type collection struct {
+	list []string
+	set  map[string]bool
+	hash map[string]string
+}
+
+type collectionRequest struct {
+	key      string
+	dataType DataType
+	username string
+	// update is set if the collection may be changed, otherwise it is only read
+	update bool
+	// apply performs the operation on the collection, returning the result and whether the collection changed
+	apply           func(c *collection) (interface{}, bool)
+	responseChannel chan<- *collectionResponse
+}
+
+type collectionResponse struct {
+	result interface{}
+	found  bool
+	err    error
+}
+
+// ListPush adds the values to the front or back of the list, and returns the new length of the list. A key
+// that isn't present is created as an empty list owned by the user. Values pushed to the front end up in
+// reverse order, as each is pushed in turn.
+//
+// Only the owning user, or those granted write permission, can change an existing key.
+func ListPush(s *KVStore, key string, values []string, front bool, username string) (int, error) {
+	return ListPushContext(context.Background(), s, key, values, front, username)
+}
+
+// ListPushContext is the same as ListPush, but gives up if the context is done first, or the store is closed.
+func ListPushContext(ctx context.Context, s *KVStore, key string, values []string, front bool,
+	username string) (int, error) {
+	result, _, err := sendCollectionRequest(ctx, s, key, TypeList, username, true,
+		func(c *collection) (interface{}, bool) {
+			for _, value := range values {
+				if front {
+					c.list = append([]string{value}, c.list...)
+				} else {
+					c.list = append(c.list, value)
+				}
+			}
+
+			return len(c.list), len(values) > 0
+		})
+	if err != nil {
+		return 0, err
+	}
+
+	length, _ := result.(int)
+
+	return length, nil
+}
+
+// ListPop removes and returns the value at the front or back of the list, and a flag indicating if there was
+// one, being false if the list is empty or not present.
+//
+// Only the owning user, or those granted write permission, can change an existing key.
+func ListPop(s *KVStore, key string, front bool, username string) (string, bool, error) {
+	return ListPopContext(context.Background(), s, key, front, username)
+}
+
+// ListPopContext is the same as ListPop, but gives up if the context is done first, or the store is closed.
+func ListPopContext(ctx context.Context, s *KVStore, key string, front bool, username string) (string, bool,
+	error) {
+	result, _, err := sendCollectionRequest(ctx, s, key, TypeList, username, true,
+		func(c *collection) (interface{}, bool) {
+			if len(c.list) == 0 {
+				return nil, false
+			}
+
+			var value string
+			if front {
+				value, c.list = c.list[0], c.list[1:]
+			} else {
+				value, c.list = c.list[len(c.list)-1], c.list[:len(c.list)-1]
+			}
+
+			return value, true
+		})
+
+	value, ok := result.(string)
+
+	return value, ok, err
+}
+
+// ListRange returns the values of the list from the start to the stop index inclusive, and a flag indicating
+// if the list was present. Negative indexes count back from the end of the list, so that -1 is the last value.
+// Indexes beyond the end of the list are treated as the end.
+//
+// Any user can read a list, unless the key's ACL makes it private.
+func ListRange(s *KVStore, key string, start int, stop int, username string) ([]string, bool, error) {
+	return ListRangeContext(context.Background(), s, key, start, stop, username)
+}
+
+// ListRangeContext is the same as ListRange, but gives up if the context is done first, or the store is closed.
+func ListRangeContext(ctx context.Context, s *KVStore, key string, start int, stop int,
+	username string) ([]string, bool, error) {
+	result, found, err := sendCollectionRequest(ctx, s, key, TypeList, username, false,
+		func(c *collection) (interface{}, bool) {
+			length := len(c.list)
+
+			if start < 0 {
+				start += length
+			}
+
+			if stop < 0 {
+				stop += length
+			}
+
+			if start < 0 {
+				start = 0
+			}
+
+			if stop >= length {
+				stop = length - 1
+			}
+
+			if start > stop {
+				return []string{}, false
+			}
+
+			return append([]string{}, c.list[start:stop+1]...), false
+		})
+
+	values, _ := result.([]string)
+
+	return values, found, err
+}
+
+// SetAdd adds the members to the set, and returns how many weren't already members. A key that isn't present
+// is created as an empty set owned by the user.
+//
+// Only the owning user, or those granted write permission, can change an existing key.
+func SetAdd(s *KVStore, key string, members []string, username string) (int, error) {
+	return SetAddContext(context.Background(), s, key, members, username)
+}
+
+// SetAddContext is the same as SetAdd, but gives up if the context is done first, or the store is closed.
+func SetAddContext(ctx context.Context, s *KVStore, key string, members []string, username string) (int, error) {
+	return countCollectionChanges(sendCollectionRequest(ctx, s, key, TypeSet, username, true,
+		func(c *collection) (interface{}, bool) {
+			added := 0
+
+			for _, member := range members {
+				if !c.set[member] {
+					c.set[member] = true
+					added++
+				}
+			}
+
+			return added, added > 0
+		}))
+}
+
+// SetRemove removes the members from the set, and returns how many were members.
+//
+// Only the owning user, or those granted write permission, can change an existing key.
+func SetRemove(s *KVStore, key string, members []string, username string) (int, error) {
+	return SetRemoveContext(context.Background(), s, key, members, username)
+}
+
+// SetRemoveContext is the same as SetRemove, but gives up if the context is done first, or the store is closed.
+func SetRemoveContext(ctx context.Context, s *KVStore, key string, members []string, username string) (int,
+	error) {
+	return countCollectionChanges(sendCollectionRequest(ctx, s, key, TypeSet, username, true,
+		func(c *collection) (interface{}, bool) {
+			removed := 0
+
+			for _, member := range members {
+				if c.set[member] {
+					delete(c.set, member)
+					removed++
+				}
+			}
+
+			return removed, removed > 0
+		}))
+}
+
+// SetMembers returns the members of the set in order, and a flag indicating if the set was present.
+//
+// Any user can read a set, unless the key's ACL makes it private.
+func SetMembers(s *KVStore, key string, username string) ([]string, bool, error) {
+	return SetMembersContext(context.Background(), s, key, username)
+}
+
+// SetMembersContext is the same as SetMembers, but gives up if the context is done first, or the store is
+// closed.
+func SetMembersContext(ctx context.Context, s *KVStore, key string, username string) ([]string, bool, error) {
+	result, found, err := sendCollectionRequest(ctx, s, key, TypeSet, username, false,
+		func(c *collection) (interface{}, bool) {
+			return setMembers(c.set), false
+		})
+
+	members, _ := result.([]string)
+
+	return members, found, err
+}
+
+// HashSet sets the values of the fields in the hash, and returns how many of the fields are new. A key that
+// isn't present is created as an empty hash owned by the user.
+//
+// Only the owning user, or those granted write permission, can change an existing key.
+func HashSet(s *KVStore, key string, fields map[string]string, username string) (int, error) {
+	return HashSetContext(context.Background(), s, key, fields, username)
+}
+
+// HashSetContext is the same as HashSet, but gives up if the context is done first, or the store is closed.
+func HashSetContext(ctx context.Context, s *KVStore, key string, fields map[string]string, username string) (int,
+	error) {
+	return countCollectionChanges(sendCollectionRequest(ctx, s, key, TypeHash, username, true,
+		func(c *collection) (interface{}, bool) {
+			added := 0
+			changed := false
+
+			for field, value := range fields {
+				existing, ok := c.hash[field]
+				if !ok {
+					added++
+				}
+
+				if !ok || existing != value {
+					c.hash[field] = value
+					changed = true
+				}
+			}
+
+			return added, changed
+		}))
+}
+
+// HashDelete removes the fields from the hash, and returns how many were present.
+//
+// Only the owning user, or those granted write permission, can change an existing key.
+func HashDelete(s *KVStore, key string, fields []string, username string) (int, error) {
+	return HashDeleteContext(context.Background(), s, key, fields, username)
+}
+
+// HashDeleteContext is the same as HashDelete, but gives up if the context is done first, or the store is
+// closed.
+func HashDeleteContext(ctx context.Context, s *KVStore, key string, fields []string, username string) (int,
+	error) {
+	return countCollectionChanges(sendCollectionRequest(ctx, s, key, TypeHash, username, true,
+		func(c *collection) (interface{}, bool) {
+			removed := 0
+
+			for _, field := range fields {
+				if _, ok := c.hash[field]; ok {
+					delete(c.hash, field)
+					removed++
+				}
+			}
+
+			return removed, removed > 0
+		}))
+}
+
+// HashGet returns the value of the field in the hash, and a flag indicating if both the hash and the field
+// were present.
+//
+// Any user can read a hash, unless the key's ACL makes it private.
+func HashGet(s *KVStore, key string, field string, username string) (string, bool, error) {
+	return HashGetContext(context.Background(), s, key, field, username)
+}
+
+// HashGetContext is the same as HashGet, but gives up if the context is done first, or the store is closed.
+func HashGetContext(ctx context.Context, s *KVStore, key string, field string, username string) (string, bool,
+	error) {
+	result, _, err := sendCollectionRequest(ctx, s, key, TypeHash, username, false,
+		func(c *collection) (interface{}, bool) {
+			value, ok := c.hash[field]
+			if !ok {
+				return nil, false
+			}
+
+			return value, false
+		})
+
+	value, ok := result.(string)
+
+	return value, ok, err
+}
+
+// HashGetAll returns all the fields of the hash, and a flag indicating if the hash was present.
+//
+// Any user can read a hash, unless the key's ACL makes it private.
+func HashGetAll(s *KVStore, key string, username string) (map[string]string, bool, error) {
+	return HashGetAllContext(context.Background(), s, key, username)
+}
+
+// HashGetAllContext is the same as HashGetAll, but gives up if the context is done first, or the store is
+// closed.
+func HashGetAllContext(ctx context.Context, s *KVStore, key string, username string) (map[string]string, bool,
+	error) {
+	result, found, err := sendCollectionRequest(ctx, s, key, TypeHash, username, false,
+		func(c *collection) (interface{}, bool) {
+			fields := make(map[string]string, len(c.hash))
+			for field, value := range c.hash {
+				fields[field] = value
+			}
+
+			return fields, false
+		})
+
+	fields, _ := result.(map[string]string)
+
+	return fields, found, err
+}
+
+func sendCollectionRequest(ctx context.Context, s *KVStore, key string, dataType DataType, username string,
+	update bool, apply func(c *collection) (interface{}, bool)) (interface{}, bool, error) {
+	responseChannel := make(chan *collectionResponse, 1)
+	if err := sendRequest(ctx, shardFor(s, key), collectionOperation,
+		&collectionRequest{key, dataType, username, update, apply, responseChannel}); err != nil {
+		return nil, false, err
+	}
+
+	select {
+	case response := <-responseChannel:
+		if update && response.err == nil {
+			enforceLimits(ctx, s, key)
+		}
+
+		return response.result, response.found, response.err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// countCollectionChanges returns the count resulting from a collection operation, or zero if it failed.
+func countCollectionChanges(result interface{}, _ bool, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	count, _ := result.(int)
+
+	return count, nil
+}
+
+// handleCollection reads or changes a list, set or hash key, if permitted.
+func handleCollection(sh *shard, params *collectionRequest) *collectionResponse {
+	now := time.Now()
+	existingEntry, found := lookupEntry(sh, params.key)
+
+	var updatedEntry *entry
+
+	if params.update {
+		// check the user can write the key before looking at its value
+		var err error
+		if updatedEntry, err = prepareStoreWrite(sh.store, existingEntry, nil, params.username, WriteOptions{},
+			now); err != nil {
+			return &collectionResponse{nil, found, err}
+		}
+	} else {
+		if !found {
+			return &collectionResponse{nil, false, nil}
+		}
+
+		if !hasPermission(existingEntry, params.username, sh.store.groups, PermissionRead) {
+			return &collectionResponse{nil, false, ErrPermissionDenied}
+		}
+	}
+
+	c := &collection{set: make(map[string]bool), hash: make(map[string]string)}
+
+	if found {
+		if existingEntry.Type != params.dataType {
+			return &collectionResponse{nil, found, ErrWrongType}
+		}
+
+		if err := decodeCollection(existingEntry, c); err != nil {
+			return &collectionResponse{nil, found, err}
+		}
+	}
+
+	result, changed := params.apply(c)
+
+	if !params.update {
+		touchEntry(sh, params.key, existingEntry, now)
+
+		return &collectionResponse{result, true, nil}
+	}
+
+	if !changed {
+		return &collectionResponse{result, found, nil}
+	}
+
+	value, err := encodeCollection(params.dataType, c)
+	if err != nil {
+		return &collectionResponse{nil, found, err}
+	}
+
+	updatedEntry.Value = value
+	updatedEntry.Type = params.dataType
+	updatedEntry.ContentType = ContentTypeJSON
+
+	if found {
+		// collections keep their time-to-live and lease as they change
+		updatedEntry.ExpiresAt = existingEntry.ExpiresAt
+		updatedEntry.Lease = existingEntry.Lease
+	}
+
+	if err = storeEntry(sh.store, params.key, updatedEntry); err != nil {
+		return &collectionResponse{nil, found, err}
+	}
+
+	return &collectionResponse{result, true, nil}
+}
+
+// decodeCollection parses the JSON value of a list, set or hash key into the collection.
+func decodeCollection(e *entry, c *collection) error {
+	var err error
+
+	switch e.Type {
+	case TypeList:
+		err = json.Unmarshal(e.Value, &c.list)
+	case TypeSet:
+		var members []string
+		if err = json.Unmarshal(e.Value, &members); err == nil {
+			for _, member := range members {
+				c.set[member] = true
+			}
+		}
+	case TypeHash:
+		err = json.Unmarshal(e.Value, &c.hash)
+	default:
+		return ErrWrongType
+	}
+
+	if err != nil {
+		return ErrWrongType
+	}
+
+	return nil
+}
+
+// encodeCollection returns the JSON value of a list, set or hash.
+func encodeCollection(dataType DataType, c *collection) ([]byte, error) {
+	switch dataType {
+	case TypeList:
+		if c.list == nil {
+			return json.Marshal([]string{})
+		}
+
+		return json.Marshal(c.list)
+	case TypeSet:
+		return json.Marshal(setMembers(c.set))
+	case TypeHash:
+		return json.Marshal(c.hash)
+	default:
+		return nil, ErrWrongType
+	}
+}
+
+// setMembers returns the members of the set in order.
+func setMembers(set map[string]bool) []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+
+	sort.Strings(members)
+
+	return members
+}
@@ -0,0 +1,153 @@
+package kvstore_test
+
+import (
+	"errors"
+	"reflect"
+	"store/pkg/kvstore"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	if length, err := kvstore.ListPush(store, key1, []string{"b", "c"}, false, user1); length != 2 || err != nil {
+		t.Fatal("Values should have been pushed to the back but got: ", length, err)
+	}
+
+	if length, err := kvstore.ListPush(store, key1, []string{"a"}, true, user1); length != 3 || err != nil {
+		t.Fatal("Value should have been pushed to the front but got: ", length, err)
+	}
+
+	for _, test := range []struct {
+		start, stop int
+		expected    []string
+	}{
+		{0, -1, []string{"a", "b", "c"}},
+		{1, 1, []string{"b"}},
+		{-2, 10, []string{"b", "c"}},
+		{2, 1, []string{}},
+	} {
+		values, found, err := kvstore.ListRange(store, key1, test.start, test.stop, user2)
+		if !found || err != nil || !reflect.DeepEqual(values, test.expected) {
+			t.Fatalf("Range %d to %d should have been %v but was: %v (%t, %v)", test.start, test.stop,
+				test.expected, values, found, err)
+		}
+	}
+
+	if value, ok, err := kvstore.ListPop(store, key1, true, user1); value != "a" || !ok || err != nil {
+		t.Fatal("Front value should have been popped but got: ", value, ok, err)
+	}
+
+	if value, ok, err := kvstore.ListPop(store, key1, false, user1); value != "c" || !ok || err != nil {
+		t.Fatal("Back value should have been popped but got: ", value, ok, err)
+	}
+
+	if stored, _ := kvstore.Read(store, key1, user1); string(stored) != `["b"]` {
+		t.Fatal("List should have been held as JSON but was: ", string(stored))
+	}
+
+	if _, _, err := kvstore.ListPop(store, key1, false, user2); !errors.Is(err, kvstore.ErrPermissionDenied) {
+		t.Fatal("Pop by different user should have been denied but got: ", err)
+	}
+
+	kvstore.ListPop(store, key1, false, user1)
+
+	if value, ok, err := kvstore.ListPop(store, key1, false, user1); ok || err != nil {
+		t.Fatal("Empty list should have had nothing to pop but got: ", value, ok, err)
+	}
+
+	if entryInfo := kvstore.List(store, key1, user1); entryInfo.Type != kvstore.TypeList {
+		t.Fatal("Key should have been listed as a list but was: ", entryInfo.Type)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestSet(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	if added, err := kvstore.SetAdd(store, key1, []string{"b", "a", "b"}, user1); added != 2 || err != nil {
+		t.Fatal("Unique members should have been added but got: ", added, err)
+	}
+
+	if added, err := kvstore.SetAdd(store, key1, []string{"a", "c"}, user1); added != 1 || err != nil {
+		t.Fatal("Only new members should have been counted but got: ", added, err)
+	}
+
+	if removed, err := kvstore.SetRemove(store, key1, []string{"b", "d"}, user1); removed != 1 || err != nil {
+		t.Fatal("Only members should have been removed but got: ", removed, err)
+	}
+
+	members, found, err := kvstore.SetMembers(store, key1, user2)
+	if !found || err != nil || !reflect.DeepEqual(members, []string{"a", "c"}) {
+		t.Fatal("Set should have had members in order but was: ", members, found, err)
+	}
+
+	if _, err = kvstore.SetAdd(store, key1, []string{"e"}, user2); !errors.Is(err, kvstore.ErrPermissionDenied) {
+		t.Fatal("Add by different user should have been denied but got: ", err)
+	}
+
+	if _, found, err = kvstore.SetMembers(store, key2, user1); found || err != nil {
+		t.Fatal("Missing set should not have been found but got: ", found, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestHash(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	added, err := kvstore.HashSet(store, key1, map[string]string{"a": "1", "b": "2"}, user1)
+	if added != 2 || err != nil {
+		t.Fatal("Fields should have been added but got: ", added, err)
+	}
+
+	if added, err = kvstore.HashSet(store, key1, map[string]string{"b": "3", "c": "4"}, user1); added != 1 ||
+		err != nil {
+		t.Fatal("Only new fields should have been counted but got: ", added, err)
+	}
+
+	if value, ok, getErr := kvstore.HashGet(store, key1, "b", user2); value != "3" || !ok || getErr != nil {
+		t.Fatal("Field should have been updated but got: ", value, ok, getErr)
+	}
+
+	if value, ok, getErr := kvstore.HashGet(store, key1, "z", user2); ok || getErr != nil {
+		t.Fatal("Missing field should not have been found but got: ", value, ok, getErr)
+	}
+
+	if removed, deleteErr := kvstore.HashDelete(store, key1, []string{"a", "z"}, user1); removed != 1 ||
+		deleteErr != nil {
+		t.Fatal("Only present fields should have been removed but got: ", removed, deleteErr)
+	}
+
+	fields, found, err := kvstore.HashGetAll(store, key1, user1)
+	if !found || err != nil || !reflect.DeepEqual(fields, map[string]string{"b": "3", "c": "4"}) {
+		t.Fatal("Hash should have had remaining fields but was: ", fields, found, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestWrongType(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.SetAdd(store, key2, []string{"a"}, user1)
+
+	if _, err := kvstore.ListPush(store, key1, []string{"a"}, false, user1); !errors.Is(err,
+		kvstore.ErrWrongType) {
+		t.Fatal("Push to a plain value should have failed but got: ", err)
+	}
+
+	if _, _, err := kvstore.HashGetAll(store, key2, user1); !errors.Is(err, kvstore.ErrWrongType) {
+		t.Fatal("Reading a set as a hash should have failed but got: ", err)
+	}
+
+	// writing a plain value replaces the collection
+	kvstore.Write(store, key2, value2, user1)
+
+	if entryInfo := kvstore.List(store, key2, user1); entryInfo.Type != kvstore.TypeValue {
+		t.Fatal("Key should have held a plain value but was: ", entryInfo.Type)
+	}
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,228 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotNumber is returned when incrementing a key whose value isn't a number of the right kind.
+	ErrNotNumber = errors.New("value is not a number")
+	// ErrOutOfBounds is returned when an increment would take a counter past one of its bounds, or out of the
+	// range of numbers that can be held.
+	ErrOutOfBounds = errors.New("value out of bounds")
+)
+
+// IncrementOptions provides optional settings for an increment.
+type IncrementOptions struct {
+	// Min (if set) is the lowest value the counter can be decremented to.
+	Min *float64
+	// Max (if set) is the highest value the counter can be incremented to.
+	Max *float64
+	// TTL (if set) replaces the counter's time-to-live. If zero, an existing counter keeps its time-to-live.
+	TTL time.Duration
+	// Condition (if set) must hold for the key's current version, otherwise the increment is not made.
+	Condition *Condition
+}
+
+type incrementRequest struct {
+	key             string
+	intDelta        int64
+	floatDelta      float64
+	float           bool
+	username        string
+	options         IncrementOptions
+	responseChannel chan<- *incrementResponse
+}
+
+type incrementResponse struct {
+	intValue   int64
+	floatValue float64
+	err        error
+}
+
+// Increment adds the delta (which may be negative) to the integer value of the key, and returns the new value.
+// The read and write are made as a single change, so concurrent increments are never lost. A key that isn't
+// present is treated as zero, and created owned by the user. The value is held as decimal text, so it can
+// also be read and written as usual.
+//
+// Only the owning user, or those granted write permission, can increment an existing key.
+func Increment(s *KVStore, key string, delta int64, username string) (int64, error) {
+	return IncrementWithOptionsContext(context.Background(), s, key, delta, username, IncrementOptions{})
+}
+
+// IncrementWithOptions is the same as Increment, using the specified options. If the new value would be out of
+// bounds, the increment is not made and ErrOutOfBounds is returned.
+func IncrementWithOptions(s *KVStore, key string, delta int64, username string,
+	options IncrementOptions) (int64, error) {
+	return IncrementWithOptionsContext(context.Background(), s, key, delta, username, options)
+}
+
+// IncrementWithOptionsContext is the same as IncrementWithOptions, but gives up if the context is done first,
+// or the store is closed. If the context is done once the increment has been passed to the store, the
+// increment may still be made.
+func IncrementWithOptionsContext(ctx context.Context, s *KVStore, key string, delta int64, username string,
+	options IncrementOptions) (int64, error) {
+	response, err := sendIncrementRequest(ctx, s, &incrementRequest{
+		key: key, intDelta: delta, username: username, options: options,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return response.intValue, nil
+}
+
+// IncrementFloat is the same as Increment, but for a floating point value.
+func IncrementFloat(s *KVStore, key string, delta float64, username string) (float64, error) {
+	return IncrementFloatWithOptionsContext(context.Background(), s, key, delta, username, IncrementOptions{})
+}
+
+// IncrementFloatWithOptions is the same as IncrementWithOptions, but for a floating point value.
+func IncrementFloatWithOptions(s *KVStore, key string, delta float64, username string,
+	options IncrementOptions) (float64, error) {
+	return IncrementFloatWithOptionsContext(context.Background(), s, key, delta, username, options)
+}
+
+// IncrementFloatWithOptionsContext is the same as IncrementWithOptionsContext, but for a floating point value.
+func IncrementFloatWithOptionsContext(ctx context.Context, s *KVStore, key string, delta float64,
+	username string, options IncrementOptions) (float64, error) {
+	response, err := sendIncrementRequest(ctx, s, &incrementRequest{
+		key: key, floatDelta: delta, float: true, username: username, options: options,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return response.floatValue, nil
+}
+
+func sendIncrementRequest(ctx context.Context, s *KVStore, params *incrementRequest) (*incrementResponse, error) {
+	responseChannel := make(chan *incrementResponse, 1)
+	params.responseChannel = responseChannel
+
+	if err := sendRequest(ctx, shardFor(s, params.key), incrementOperation, params); err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-responseChannel:
+		if response.err == nil {
+			enforceLimits(ctx, s, params.key)
+		}
+
+		return response, response.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// incrementEntry adds the delta to the key's value, if permitted.
+func incrementEntry(sh *shard, params *incrementRequest) *incrementResponse {
+	existingEntry, _ := lookupEntry(sh, params.key)
+
+	writeOptions := WriteOptions{TTL: params.options.TTL, Condition: params.options.Condition}
+
+	// check the user can write the key before looking at its value
+	updatedEntry, err := prepareStoreWrite(sh.store, existingEntry, nil, params.username, writeOptions, time.Now())
+	if err != nil {
+		return &incrementResponse{err: err}
+	}
+
+	var current string
+	if existingEntry != nil {
+		current = strings.TrimSpace(string(existingEntry.Value))
+		updatedEntry.ContentType = existingEntry.ContentType
+		updatedEntry.ContentEncoding = existingEntry.ContentEncoding
+		updatedEntry.Lease = existingEntry.Lease
+
+		if params.options.TTL <= 0 {
+			updatedEntry.ExpiresAt = existingEntry.ExpiresAt
+		}
+	}
+
+	response := &incrementResponse{}
+
+	if params.float {
+		response.floatValue, err = addFloat(current, params.floatDelta, params.options)
+		updatedEntry.Value = []byte(strconv.FormatFloat(response.floatValue, 'f', -1, 64))
+	} else {
+		response.intValue, err = addInt(current, params.intDelta, params.options)
+		updatedEntry.Value = []byte(strconv.FormatInt(response.intValue, 10))
+	}
+
+	if err != nil {
+		return &incrementResponse{err: err}
+	}
+
+	if err = storeEntry(sh.store, params.key, updatedEntry); err != nil {
+		return &incrementResponse{err: err}
+	}
+
+	return response
+}
+
+// addInt returns the integer value (zero if empty) plus the delta, if within bounds.
+func addInt(current string, delta int64, options IncrementOptions) (int64, error) {
+	var value int64
+
+	if current != "" {
+		var err error
+		if value, err = strconv.ParseInt(current, 10, 64); err != nil {
+			return 0, fmt.Errorf("%w: not an integer", ErrNotNumber)
+		}
+	}
+
+	if (delta > 0 && value > math.MaxInt64-delta) || (delta < 0 && value < math.MinInt64-delta) {
+		return 0, fmt.Errorf("%w: integer overflow", ErrOutOfBounds)
+	}
+
+	value += delta
+
+	if err := checkBounds(float64(value), options); err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// addFloat returns the floating point value (zero if empty) plus the delta, if within bounds.
+func addFloat(current string, delta float64, options IncrementOptions) (float64, error) {
+	var value float64
+
+	if current != "" {
+		var err error
+		if value, err = strconv.ParseFloat(current, 64); err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return 0, fmt.Errorf("%w: not a finite number", ErrNotNumber)
+		}
+	}
+
+	value += delta
+
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("%w: not a finite number", ErrOutOfBounds)
+	}
+
+	if err := checkBounds(value, options); err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+func checkBounds(value float64, options IncrementOptions) error {
+	if options.Min != nil && value < *options.Min {
+		return fmt.Errorf("%w: below minimum %v", ErrOutOfBounds, *options.Min)
+	}
+
+	if options.Max != nil && value > *options.Max {
+		return fmt.Errorf("%w: above maximum %v", ErrOutOfBounds, *options.Max)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package kvstore_test
+
+import (
+	"errors"
+	"math"
+	"store/pkg/kvstore"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIncrement(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	value, err := kvstore.Increment(store, key1, 5, user1)
+	if value != 5 || err != nil {
+		t.Fatal("Missing key should have been incremented from zero but got: ", value, err)
+	}
+
+	if value, err = kvstore.Increment(store, key1, -7, user1); value != -2 || err != nil {
+		t.Fatal("Key should have been decremented but got: ", value, err)
+	}
+
+	if stored, _ := kvstore.Read(store, key1, user1); string(stored) != "-2" {
+		t.Fatal("Key should have held the value as text but was: ", string(stored))
+	}
+
+	if entryInfo := kvstore.List(store, key1, user1); entryInfo.Owner != user1 || entryInfo.Writes != 2 {
+		t.Fatal("Key should have been created by the user and updated but was: ", entryInfo)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestIncrementConcurrent(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	const increments = 100
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < increments; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			kvstore.Increment(store, key1, 1, user1)
+		}()
+	}
+
+	wg.Wait()
+
+	if value, _ := kvstore.Increment(store, key1, 0, user1); value != increments {
+		t.Fatalf("Every increment should have been counted, expected %d but was: %d", increments, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestIncrementFloat(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	kvstore.Write(store, key1, []byte("2"), user1)
+
+	value, err := kvstore.IncrementFloat(store, key1, 0.5, user1)
+	if value != 2.5 || err != nil {
+		t.Fatal("Key should have been incremented but got: ", value, err)
+	}
+
+	if _, err = kvstore.Increment(store, key1, 1, user1); !errors.Is(err, kvstore.ErrNotNumber) {
+		t.Fatal("Integer increment of a floating point value should have failed but got: ", err)
+	}
+
+	if _, err = kvstore.IncrementFloat(store, key1, math.Inf(1), user1); !errors.Is(err, kvstore.ErrOutOfBounds) {
+		t.Fatal("Increment to infinity should have been out of bounds but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestIncrementNotNumber(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	kvstore.Write(store, key1, value1, user1)
+
+	if _, err := kvstore.Increment(store, key1, 1, user1); !errors.Is(err, kvstore.ErrNotNumber) {
+		t.Fatal("Increment of a value that isn't a number should have failed but got: ", err)
+	}
+
+	if _, err := kvstore.Increment(store, key1, 1, user2); !errors.Is(err, kvstore.ErrPermissionDenied) {
+		t.Fatal("Increment by different user should have been denied but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestIncrementBounds(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	lowest, highest := 0.0, 10.0
+	options := kvstore.IncrementOptions{Min: &lowest, Max: &highest}
+
+	if value, err := kvstore.IncrementWithOptions(store, key1, 10, user1, options); value != 10 || err != nil {
+		t.Fatal("Increment to the maximum should have been made but got: ", value, err)
+	}
+
+	if _, err := kvstore.IncrementWithOptions(store, key1, 1, user1, options); !errors.Is(err,
+		kvstore.ErrOutOfBounds) {
+		t.Fatal("Increment past the maximum should have failed but got: ", err)
+	}
+
+	if _, err := kvstore.IncrementWithOptions(store, key1, -11, user1, options); !errors.Is(err,
+		kvstore.ErrOutOfBounds) {
+		t.Fatal("Decrement past the minimum should have failed but got: ", err)
+	}
+
+	kvstore.Write(store, key2, []byte("9223372036854775807"), user1)
+
+	if _, err := kvstore.Increment(store, key2, 1, user1); !errors.Is(err, kvstore.ErrOutOfBounds) {
+		t.Fatal("Increment should have overflowed but got: ", err)
+	}
+
+	if value, _ := kvstore.Read(store, key1, user1); string(value) != "10" {
+		t.Fatal("Failed increments should have left the value unchanged but was: ", string(value))
+	}
+
+	kvstore.Close(store)
+}
+
+func TestIncrementKeepsTTL(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	kvstore.WriteWithOptions(store, key1, []byte("1"), user1, kvstore.WriteOptions{TTL: time.Hour})
+
+	kvstore.Increment(store, key1, 1, user1)
+
+	if entryInfo := kvstore.List(store, key1, user1); entryInfo.TTL <= 0 {
+		t.Fatal("Increment should have kept the key's time-to-live but was: ", entryInfo.TTL)
+	}
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,378 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	diskDirPermissions = 0700
+	diskFileSuffix     = ".json"
+	// diskMetadataFile holds the store's revision, and doesn't have the suffix of a key's file
+	diskMetadataFile = "metadata"
+)
+
+// DiskStore is a Store which keeps each key in its own file within a directory, rather than in memory,
+// so the number of keys is only limited by disk space. Each file is replaced atomically when its key
+// changes, and operations are guarded by a read-write mutex.
+//
+// Versions are given out in the same way as by KVStore, the latest being kept in a metadata file in the
+// directory so that they carry on from there when the store is reopened. Reads aren't written to disk, so that
+// reading a key doesn't rewrite its file: the reads of each key are counted in memory, and saved along with the
+// key's next write, so any since then are lost when the store is closed. It has no groups, so permissions granted
+// to groups by a key's access control list don't apply.
+type DiskStore struct {
+	mutex    sync.RWMutex
+	dir      string
+	revision uint64
+	// accessMutex guards the reads of each key since it was last written, which can be recorded while only
+	// holding a read lock
+	accessMutex sync.Mutex
+	accesses    map[string]*keyAccess
+	closed      bool
+}
+
+// keyAccess is the reads of a key that haven't been saved yet.
+type keyAccess struct {
+	reads        int
+	lastAccessed time.Time
+}
+
+// diskMetadata is the content of the metadata file.
+type diskMetadata struct {
+	Revision uint64 `json:"revision"`
+}
+
+// NewDiskStore returns a key value store instance holding its keys in the specified directory,
+// which is created if it doesn't already exist. Any keys already in the directory are kept.
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, diskDirPermissions); err != nil {
+		return nil, err
+	}
+
+	s := &DiskStore{dir: dir, accesses: make(map[string]*keyAccess)}
+
+	if err := s.loadMetadata(); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	// a directory written before the revision was kept in the metadata file carries on from its keys' versions
+	for _, key := range keys {
+		e, found, loadErr := s.load(key)
+		if loadErr != nil {
+			return nil, loadErr
+		}
+
+		if found && e.Version > s.revision {
+			s.revision = e.Version
+		}
+	}
+
+	return s, nil
+}
+
+// Read implements Store. The read is only counted in memory, see DiskStore.
+func (s *DiskStore) Read(ctx context.Context, key string, username string) (*Item, bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := checkUsable(ctx, s.closed); err != nil {
+		return nil, false, err
+	}
+
+	e, found, err := s.load(key)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	if !hasPermission(e, username, nil, PermissionRead) {
+		return nil, false, ErrPermissionDenied
+	}
+
+	s.accessMutex.Lock()
+	access, ok := s.accesses[key]
+	if !ok {
+		access = &keyAccess{}
+		s.accesses[key] = access
+	}
+
+	access.reads++
+	access.lastAccessed = time.Now()
+	s.accessMutex.Unlock()
+
+	return newItem(e), true, nil
+}
+
+// Write implements Store. Keys can't be given a time-to-live, as there is nothing to expire them.
+func (s *DiskStore) Write(ctx context.Context, key string, value []byte, username string,
+	options WriteOptions) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := checkUsable(ctx, s.closed); err != nil {
+		return err
+	}
+
+	if options.TTL > 0 || options.Lease != 0 {
+		return ErrNotSupported
+	}
+
+	if err := checkDocument(options.ContentType, value); err != nil {
+		return err
+	}
+
+	existingEntry, found, err := s.load(key)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		s.addAccesses(key, existingEntry)
+	}
+
+	updatedEntry, err := prepareWrite(existingEntry, value, username, nil, options, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// the revision is saved first, so that it's never given out again even if the key's file isn't written
+	if err = s.saveMetadata(s.revision + 1); err != nil {
+		return err
+	}
+
+	s.revision++
+	updatedEntry.Version = s.revision
+
+	if err = s.save(key, updatedEntry); err != nil {
+		return err
+	}
+
+	s.accessMutex.Lock()
+	delete(s.accesses, key)
+	s.accessMutex.Unlock()
+
+	return nil
+}
+
+// Delete implements Store.
+func (s *DiskStore) Delete(ctx context.Context, key string, username string,
+	options DeleteOptions) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := checkUsable(ctx, s.closed); err != nil {
+		return false, err
+	}
+
+	existingEntry, found, err := s.load(key)
+	if err != nil {
+		return false, err
+	}
+
+	if err = prepareDelete(existingEntry, username, nil, options); err != nil {
+		return false, err
+	}
+
+	if !found {
+		return false, nil
+	}
+
+	if err = os.Remove(s.path(key)); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	s.accessMutex.Lock()
+	delete(s.accesses, key)
+	s.accessMutex.Unlock()
+
+	return true, nil
+}
+
+// List implements Store.
+func (s *DiskStore) List(ctx context.Context, key string, username string) (*EntryInfo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := checkUsable(ctx, s.closed); err != nil {
+		return nil, err
+	}
+
+	e, found, err := s.load(key)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	if !hasPermission(e, username, nil, PermissionRead) {
+		return nil, ErrPermissionDenied
+	}
+
+	s.addAccesses(key, e)
+
+	return newEntryInfo(key, e, time.Now()), nil
+}
+
+// ListAll implements Store. Any keys that can't be read from disk are left out.
+func (s *DiskStore) ListAll(ctx context.Context, username string) ([]*EntryInfo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := checkUsable(ctx, s.closed); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*EntryInfo, 0, len(keys))
+
+	now := time.Now()
+
+	for _, key := range keys {
+		e, found, loadErr := s.load(key)
+		if loadErr == nil && found && hasPermission(e, username, nil, PermissionRead) {
+			s.addAccesses(key, e)
+			entries = append(entries, newEntryInfo(key, e, now))
+		}
+	}
+
+	return entries, nil
+}
+
+// Close implements Store. Every change is already on disk, so there is nothing to flush.
+func (s *DiskStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	s.closed = true
+
+	return nil
+}
+
+// path returns the file holding the key. Keys are hex encoded, as they can contain any characters,
+// which means most file systems limit keys to around 120 bytes long.
+func (s *DiskStore) path(key string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(key))+diskFileSuffix)
+}
+
+// load returns the key's entry, and a flag indicating if the key was present.
+func (s *DiskStore) load(key string) (*entry, bool, error) {
+	bytes, err := os.ReadFile(s.path(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+
+		return nil, false, fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	e := &entry{}
+	if err = json.Unmarshal(bytes, e); err != nil {
+		return nil, false, fmt.Errorf("%w: unable to parse key %s: %v", ErrPersistence, key, err)
+	}
+
+	return e, true, nil
+}
+
+// save replaces the key's file with the entry.
+func (s *DiskStore) save(key string, e *entry) error {
+	bytes, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	if err = writeFileAtomically(s.path(key), bytes); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	return nil
+}
+
+// addAccesses adds the reads of the key which haven't been saved yet to its entry, as loaded from disk.
+func (s *DiskStore) addAccesses(key string, e *entry) {
+	s.accessMutex.Lock()
+	defer s.accessMutex.Unlock()
+
+	if access, ok := s.accesses[key]; ok {
+		e.Reads += access.reads
+		e.LastAccesed = access.lastAccessed
+	}
+}
+
+// loadMetadata reads the store's revision from the metadata file. It is not an error for the file not to exist
+// yet.
+func (s *DiskStore) loadMetadata() error {
+	bytes, err := os.ReadFile(filepath.Join(s.dir, diskMetadataFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	metadata := &diskMetadata{}
+	if err = json.Unmarshal(bytes, metadata); err != nil {
+		return fmt.Errorf("%w: unable to parse metadata: %v", ErrPersistence, err)
+	}
+
+	s.revision = metadata.Revision
+
+	return nil
+}
+
+// saveMetadata replaces the metadata file, recording the revision given.
+func (s *DiskStore) saveMetadata(revision uint64) error {
+	bytes, err := json.Marshal(&diskMetadata{revision})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	if err = writeFileAtomically(filepath.Join(s.dir, diskMetadataFile), bytes); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	return nil
+}
+
+// keys returns every key held in the directory, ignoring any other files.
+func (s *DiskStore) keys() ([]string, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	keys := make([]string, 0, len(files))
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, diskFileSuffix) {
+			continue
+		}
+
+		key, decodeErr := hex.DecodeString(strings.TrimSuffix(name, diskFileSuffix))
+		if decodeErr == nil {
+			keys = append(keys, string(key))
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,184 @@
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// ContentTypeJSON is the content type of JSON documents. Values written with it must be valid JSON, and can
+// then be read a part at a time with ReadPath, and changed in place with Patch.
+const ContentTypeJSON = "application/json"
+
+var (
+	// ErrNotDocument is returned when a value that should be a JSON document isn't valid JSON.
+	ErrNotDocument = errors.New("value is not a JSON document")
+	// ErrInvalidPath is returned when a JSON path can't be parsed.
+	ErrInvalidPath = errors.New("invalid JSON path")
+)
+
+// pathSegment is a single step into a JSON document, either the member of an object or the element of an array.
+type pathSegment struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// ReadPath returns the part of the key's JSON document at the path as JSON, and a flag indicating if both the
+// key and the path were present. The path is a simple JSONPath, starting with $ for the whole document, followed
+// by object members and array elements, e.g. $.a.b[0] or $['a b'].
+//
+// Any user can read a key's value, unless the key's ACL makes it private.
+func ReadPath(s *KVStore, key string, path string, username string) ([]byte, bool, error) {
+	return ReadPathContext(context.Background(), s, key, path, username)
+}
+
+// ReadPathContext is the same as ReadPath, but gives up if the context is done first, or the store is closed.
+func ReadPathContext(ctx context.Context, s *KVStore, key string, path string, username string) ([]byte, bool,
+	error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	item, ok, err := ReadItemContext(ctx, s, key, username)
+	if !ok {
+		return nil, false, err
+	}
+
+	document, err := decodeDocument(item.Value)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, segment := range segments {
+		if document, ok = selectSegment(document, segment); !ok {
+			return nil, false, nil
+		}
+	}
+
+	value, err := json.Marshal(document)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrNotDocument, err)
+	}
+
+	return value, true, nil
+}
+
+// isDocument returns whether the content type is that of a JSON document.
+func isDocument(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	return err == nil && mediaType == ContentTypeJSON
+}
+
+// checkDocument returns ErrNotDocument if the content type is that of a JSON document, but the value isn't one.
+func checkDocument(contentType string, value []byte) error {
+	if isDocument(contentType) && !json.Valid(value) {
+		return ErrNotDocument
+	}
+
+	return nil
+}
+
+// decodeDocument parses a JSON document, keeping numbers exactly as written.
+func decodeDocument(value []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, ErrNotDocument
+	}
+
+	// there must be nothing else after the document
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return nil, ErrNotDocument
+	}
+
+	return document, nil
+}
+
+// parseJSONPath splits a JSONPath such as $.a.b[0]['c d'] into its segments.
+func parseJSONPath(path string) ([]pathSegment, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("%w: %q must start with $", ErrInvalidPath, path)
+	}
+
+	segments := make([]pathSegment, 0)
+	rest := path[1:]
+
+	for rest != "" {
+		var segment pathSegment
+
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+
+			segment.name = rest[1 : end+1]
+			rest = rest[end+1:]
+
+			if segment.name == "" || segment.name == "*" {
+				return nil, fmt.Errorf("%w: %q has an empty or wildcard member name", ErrInvalidPath, path)
+			}
+
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: %q has an unclosed [", ErrInvalidPath, path)
+			}
+
+			inner := rest[1:end]
+			rest = rest[end+1:]
+
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				segment.name = inner[1 : len(inner)-1]
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("%w: %q has an invalid array index %q", ErrInvalidPath, path, inner)
+				}
+
+				segment.index = index
+				segment.isIndex = true
+			}
+
+		default:
+			return nil, fmt.Errorf("%w: %q has an unexpected %q", ErrInvalidPath, path, rest[0])
+		}
+
+		segments = append(segments, segment)
+	}
+
+	return segments, nil
+}
+
+// selectSegment returns the object member or array element of the JSON value, if present.
+func selectSegment(value interface{}, segment pathSegment) (interface{}, bool) {
+	if segment.isIndex {
+		array, ok := value.([]interface{})
+		if !ok || segment.index >= len(array) {
+			return nil, false
+		}
+
+		return array[segment.index], true
+	}
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	member, ok := object[segment.name]
+
+	return member, ok
+}
@@ -0,0 +1,153 @@
+package kvstore_test
+
+import (
+	"errors"
+	"store/pkg/kvstore"
+	"testing"
+)
+
+var document1 = []byte(`{"a":{"b":[1,2.50,{"c d":"x"}]},"e":true}`)
+
+func writeDocument(t *testing.T, store *kvstore.KVStore, key string, document []byte) {
+	t.Helper()
+
+	options := kvstore.WriteOptions{ContentType: kvstore.ContentTypeJSON}
+	if err := kvstore.WriteWithOptions(store, key, document, user1, options); err != nil {
+		t.Fatal("Error writing document: ", err)
+	}
+}
+
+func TestReadPath(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	writeDocument(t, store, key1, document1)
+
+	for path, expected := range map[string]string{
+		"$":               string(document1),
+		"$.e":             "true",
+		"$.a.b[1]":        "2.50",
+		"$.a.b[2]['c d']": `"x"`,
+		`$["a"].b[0]`:     "1",
+	} {
+		value, found, err := kvstore.ReadPath(store, key1, path, user1)
+		if !found || err != nil || string(value) != expected {
+			t.Fatalf("Path %s should have been %s but was: %s (%t, %v)", path, expected, value, found, err)
+		}
+	}
+
+	for _, path := range []string{"$.f", "$.a.b[3]", "$.e.f", "$.a[0]"} {
+		if value, found, err := kvstore.ReadPath(store, key1, path, user1); found || err != nil {
+			t.Fatalf("Path %s should not have been found but was: %s (%t, %v)", path, value, found, err)
+		}
+	}
+
+	for _, path := range []string{"a.b", "$.", "$.a.*", "$[x]", "$.a[0"} {
+		if _, _, err := kvstore.ReadPath(store, key1, path, user1); !errors.Is(err, kvstore.ErrInvalidPath) {
+			t.Fatalf("Path %s should have been invalid but got: %v", path, err)
+		}
+	}
+
+	kvstore.Write(store, key2, value1, user1)
+
+	if _, _, err := kvstore.ReadPath(store, key2, "$", user1); !errors.Is(err, kvstore.ErrNotDocument) {
+		t.Fatal("Value that isn't JSON should not have been read as a document but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestWriteInvalidDocument(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	options := kvstore.WriteOptions{ContentType: "application/json; charset=utf-8"}
+	if err := kvstore.WriteWithOptions(store, key1, []byte(`{"a":`), user1, options); !errors.Is(err,
+		kvstore.ErrNotDocument) {
+		t.Fatal("Invalid JSON document should not have been written but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestMergePatch(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	writeDocument(t, store, key1, []byte(`{"a":"b","c":{"d":"e","f":"g"}}`))
+
+	item, err := kvstore.Patch(store, key1, []byte(`{"a":"z","c":{"f":null},"h":[1]}`), kvstore.MergePatch, user1)
+	if err != nil {
+		t.Fatal("Error patching document: ", err)
+	}
+
+	expected := `{"a":"z","c":{"d":"e"},"h":[1]}`
+	if string(item.Value) != expected || item.Version != 2 || item.ContentType != kvstore.ContentTypeJSON {
+		t.Fatalf("Document should have been patched to %s but was: %s (version %d)", expected, item.Value,
+			item.Version)
+	}
+
+	if item, err = kvstore.Patch(store, key2, []byte(`{"a":1}`), kvstore.MergePatch, user2); err != nil ||
+		string(item.Value) != `{"a":1}` {
+		t.Fatal("Missing key should have been created by the patch but got: ", err)
+	}
+
+	if _, err = kvstore.Patch(store, key1, []byte(`{"a":1}`), kvstore.MergePatch, user2); !errors.Is(err,
+		kvstore.ErrPermissionDenied) {
+		t.Fatal("Patch by different user should have been denied but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestJSONPatch(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	writeDocument(t, store, key1, []byte(`{"a":[1,2,3],"b":{"c":"d"},"e~f":1}`))
+
+	patch := []byte(`[
+		{"op":"test","path":"/a/1","value":2.0},
+		{"op":"add","path":"/a/1","value":9},
+		{"op":"add","path":"/a/-","value":4},
+		{"op":"remove","path":"/a/0"},
+		{"op":"replace","path":"/b/c","value":"x"},
+		{"op":"copy","from":"/b","path":"/g"},
+		{"op":"move","from":"/e~0f","path":"/b/h"}
+	]`)
+
+	item, err := kvstore.Patch(store, key1, patch, kvstore.JSONPatch, user1)
+	if err != nil {
+		t.Fatal("Error patching document: ", err)
+	}
+
+	expected := `{"a":[9,2,3,4],"b":{"c":"x","h":1},"g":{"c":"x"}}`
+	if string(item.Value) != expected {
+		t.Fatalf("Document should have been patched to %s but was: %s", expected, item.Value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestJSONPatchFailed(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	writeDocument(t, store, key1, []byte(`{"a":1}`))
+
+	for _, patch := range []string{
+		`[{"op":"replace","path":"/a","value":2},{"op":"test","path":"/a","value":1}]`,
+		`[{"op":"remove","path":"/b"}]`,
+		`[{"op":"add","path":"/b/c","value":1}]`,
+		`[{"op":"move","from":"","path":"/a/b"}]`,
+	} {
+		if _, err := kvstore.Patch(store, key1, []byte(patch), kvstore.JSONPatch, user1); !errors.Is(err,
+			kvstore.ErrPatchFailed) {
+			t.Fatalf("Patch %s should have failed but got: %v", patch, err)
+		}
+	}
+
+	for _, patch := range []string{`{"op":"add"}`, `[{"op":"add","path":"/b"}]`, `[{"op":"undo","path":""}]`} {
+		if _, err := kvstore.Patch(store, key1, []byte(patch), kvstore.JSONPatch, user1); !errors.Is(err,
+			kvstore.ErrInvalidPatch) {
+			t.Fatalf("Patch %s should have been invalid but got: %v", patch, err)
+		}
+	}
+
+	if value, _ := kvstore.Read(store, key1, user1); string(value) != `{"a":1}` {
+		t.Fatal("Failed patches should have left the document unchanged but was: ", string(value))
+	}
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,375 @@
+package kvstore
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EvictionPolicy determines which keys are removed first when the store is over its size limit.
+type EvictionPolicy int
+
+const (
+	// EvictLRU removes the least recently used keys first.
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU removes the least frequently read keys first, or the least recently used of those read equally.
+	EvictLFU EvictionPolicy = iota
+	// EvictTTLFirst removes the keys with a time-to-live first, those due to expire soonest first,
+	// then the least recently used keys without a time-to-live.
+	EvictTTLFirst EvictionPolicy = iota
+)
+
+var errUnknownEvictionPolicy = errors.New("unknown eviction policy")
+
+// Eviction limits the size of the store, so that it can be used as a cache with a fixed memory budget. Once
+// a change takes the store over either limit, keys are removed using the policy until it's back within them.
+// Any limit that is zero is not enforced, and if both are zero keys are never evicted.
+//
+// The limits apply to the store as a whole, with the keys to evict chosen from across all of the shards. Every
+// shard is paused while this happens, so a store that is constantly at its limits gains little from sharding.
+type Eviction struct {
+	Policy EvictionPolicy
+	// MaxKeys is the most keys the store holds.
+	MaxKeys int
+	// MaxBytes is the most bytes the store holds, counting the length of each key and value.
+	MaxBytes int64
+}
+
+// Stats reports the size of the store, and what has happened to it since it was opened.
+type Stats struct {
+	Revision  uint64 `json:"revision"`
+	Keys      int    `json:"keys"`
+	Bytes     int64  `json:"bytes"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// ParseEvictionPolicy converts the name of an eviction policy ("lru", "lfu" or "ttl") into an EvictionPolicy.
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch name {
+	case "lru":
+		return EvictLRU, nil
+	case "lfu":
+		return EvictLFU, nil
+	case "ttl":
+		return EvictTTLFirst, nil
+	default:
+		return EvictLRU, fmt.Errorf("%w: %s", errUnknownEvictionPolicy, name)
+	}
+}
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case EvictLRU:
+		return "lru"
+	case EvictLFU:
+		return "lfu"
+	case EvictTTLFirst:
+		return "ttl"
+	default:
+		return fmt.Sprintf("EvictionPolicy(%d)", int(p))
+	}
+}
+
+// StoreStats returns the size of the store, and how many keys have been evicted since it was opened.
+// Keys whose time-to-live has passed are counted until they are removed.
+func StoreStats(s *KVStore) Stats {
+	s.commitMutex.Lock()
+	defer s.commitMutex.Unlock()
+
+	keys, bytes := storeSize(s)
+
+	return Stats{Revision: s.revision, Keys: keys, Bytes: bytes, Evictions: s.evictions}
+}
+
+// storeSize returns how many keys the store holds, and their total size, from the usage of every user. The
+// caller must hold the commit mutex.
+func storeSize(s *KVStore) (int, int64) {
+	keys := 0
+	bytes := int64(0)
+
+	for _, usage := range s.usage {
+		keys += usage.Keys
+		bytes += usage.Bytes
+	}
+
+	return keys, bytes
+}
+
+// overLimits returns whether a store of the size given is over either of the limits.
+func overLimits(eviction Eviction, keys int, bytes int64) bool {
+	return (eviction.MaxKeys > 0 && keys > eviction.MaxKeys) || (eviction.MaxBytes > 0 && bytes > eviction.MaxBytes)
+}
+
+// enforceLimits evicts keys if the store is over its size limits, until it's back within them, as a single
+// further change to the store. The keys given (those just changed) are never evicted, even if they alone take the
+// store over its limits. Since the keys to evict are chosen from every shard, all of the shards are parked, so
+// this must be called once the change has been made and its shards let go. If the context is done first, the
+// store stays over its limits until the next change.
+func enforceLimits(ctx context.Context, s *KVStore, changed ...string) {
+	eviction := s.config.Eviction
+	if eviction.MaxKeys <= 0 && eviction.MaxBytes <= 0 {
+		return
+	}
+
+	s.commitMutex.Lock()
+	keys, bytes := storeSize(s)
+	s.commitMutex.Unlock()
+
+	if !overLimits(eviction, keys, bytes) || !leadsChanges(s) {
+		return
+	}
+
+	release, err := parkShards(ctx, s.shards)
+	if err != nil {
+		return
+	}
+
+	defer release()
+
+	// keys which have already expired go first, whatever the policy
+	for _, sh := range s.shards {
+		removeExpiredEntries(sh)
+	}
+
+	evictEntries(s, changed)
+}
+
+// evictEntries removes the keys that are first in eviction order across all of the shards, other than those
+// given, until the store is within its size limits. The caller must have parked every shard.
+func evictEntries(s *KVStore, protectedKeys []string) {
+	eviction := s.config.Eviction
+
+	s.commitMutex.Lock()
+	keys, bytes := storeSize(s)
+	s.commitMutex.Unlock()
+
+	protected := make(map[string]bool, len(protectedKeys))
+	for _, key := range protectedKeys {
+		protected[key] = true
+	}
+
+	candidates := &evictionCandidates{policy: eviction.Policy}
+
+	for _, sh := range s.shards {
+		if sh.evictions.Len() > 0 {
+			heap.Push(candidates, queuePosition{sh.evictions, 0})
+		}
+	}
+
+	evictions := make([]change, 0)
+
+	for overLimits(eviction, keys, bytes) {
+		item, ok := nextEviction(candidates)
+		if !ok {
+			break
+		}
+
+		if protected[item.key] {
+			continue
+		}
+
+		keys--
+		bytes -= entrySize(item.key, item.entry)
+
+		evictions = append(evictions, change{item.key, nil})
+	}
+
+	if len(evictions) == 0 {
+		return
+	}
+
+	if _, err := applyChanges(s, evictions, MutationEvict); err != nil {
+		// the store stays over its limits until the next change
+		s.logger.Println("Unable to evict keys: ", err)
+
+		return
+	}
+
+	s.commitMutex.Lock()
+	s.evictions += uint64(len(evictions))
+	s.commitMutex.Unlock()
+
+	for _, evicted := range evictions {
+		s.logger.Printf("Evicted key %s using %s policy", evicted.Key, eviction.Policy)
+	}
+}
+
+// evictBefore returns whether entry a should be evicted before entry b using the policy.
+func evictBefore(policy EvictionPolicy, a *entry, b *entry) bool {
+	switch policy {
+	case EvictLFU:
+		if a.Reads != b.Reads {
+			return a.Reads < b.Reads
+		}
+	case EvictTTLFirst:
+		if aHasTTL, bHasTTL := !a.ExpiresAt.IsZero(), !b.ExpiresAt.IsZero(); aHasTTL != bHasTTL {
+			return aHasTTL
+		} else if aHasTTL && !a.ExpiresAt.Equal(b.ExpiresAt) {
+			return a.ExpiresAt.Before(b.ExpiresAt)
+		}
+	case EvictLRU:
+	}
+
+	return a.LastAccesed.Before(b.LastAccesed)
+}
+
+// evictionItem is a key's place in its shard's eviction queue.
+type evictionItem struct {
+	key   string
+	entry *entry
+	index int
+}
+
+// evictionQueue is a min-heap of a shard's keys in eviction order, so that the key to evict next is always at the
+// front. A key is moved within the queue whenever its entry is changed or read.
+type evictionQueue struct {
+	policy EvictionPolicy
+	items  []*evictionItem
+	keys   map[string]*evictionItem
+}
+
+func newEvictionQueue(policy EvictionPolicy) *evictionQueue {
+	return &evictionQueue{policy: policy, items: make([]*evictionItem, 0), keys: make(map[string]*evictionItem)}
+}
+
+func (q *evictionQueue) Len() int {
+	if q == nil {
+		return 0
+	}
+
+	return len(q.items)
+}
+
+func (q *evictionQueue) Less(i, j int) bool {
+	return evictBefore(q.policy, q.items[i].entry, q.items[j].entry)
+}
+
+func (q *evictionQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *evictionQueue) Push(x interface{}) {
+	item, ok := x.(*evictionItem)
+	if ok {
+		item.index = len(q.items)
+		q.items = append(q.items, item)
+	}
+}
+
+func (q *evictionQueue) Pop() interface{} {
+	old := q.items
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	q.items = old[:n-1]
+
+	return item
+}
+
+// queueEviction adds the key to the shard's eviction queue, or moves it within the queue if already there. Does
+// nothing if the store doesn't evict keys.
+func queueEviction(sh *shard, key string, e *entry) {
+	if sh.evictions == nil {
+		return
+	}
+
+	if item, ok := sh.evictions.keys[key]; ok {
+		item.entry = e
+		heap.Fix(sh.evictions, item.index)
+
+		return
+	}
+
+	item := &evictionItem{key: key, entry: e}
+	sh.evictions.keys[key] = item
+	heap.Push(sh.evictions, item)
+}
+
+// dequeueEviction takes the key out of the shard's eviction queue.
+func dequeueEviction(sh *shard, key string) {
+	if sh.evictions == nil {
+		return
+	}
+
+	if item, ok := sh.evictions.keys[key]; ok {
+		delete(sh.evictions.keys, key)
+		heap.Remove(sh.evictions, item.index)
+	}
+}
+
+// touchEntry records that the key has been read.
+func touchEntry(sh *shard, key string, e *entry, now time.Time) {
+	e.Reads++
+	e.LastAccesed = now
+
+	queueEviction(sh, key, e)
+}
+
+// queuePosition is a position within a shard's eviction queue.
+type queuePosition struct {
+	queue *evictionQueue
+	index int
+}
+
+// evictionCandidates walks the eviction queues of all the shards in eviction order without changing them. Since
+// the key at any position of a queue is evicted before the keys at its children, it holds a heap of the positions
+// that could be next: to begin with the front of each queue, and then the children of each position taken.
+type evictionCandidates struct {
+	policy    EvictionPolicy
+	positions []queuePosition
+}
+
+func (c *evictionCandidates) Len() int {
+	return len(c.positions)
+}
+
+func (c *evictionCandidates) Less(i, j int) bool {
+	a := c.positions[i]
+	b := c.positions[j]
+
+	return evictBefore(c.policy, a.queue.items[a.index].entry, b.queue.items[b.index].entry)
+}
+
+func (c *evictionCandidates) Swap(i, j int) {
+	c.positions[i], c.positions[j] = c.positions[j], c.positions[i]
+}
+
+func (c *evictionCandidates) Push(x interface{}) {
+	position, ok := x.(queuePosition)
+	if ok {
+		c.positions = append(c.positions, position)
+	}
+}
+
+func (c *evictionCandidates) Pop() interface{} {
+	old := c.positions
+	n := len(old)
+	position := old[n-1]
+	c.positions = old[:n-1]
+
+	return position
+}
+
+// nextEviction returns the next key in eviction order across all the shards, or false if there are none left.
+func nextEviction(c *evictionCandidates) (*evictionItem, bool) {
+	if c.Len() == 0 {
+		return nil, false
+	}
+
+	position, ok := heap.Pop(c).(queuePosition)
+	if !ok {
+		return nil, false
+	}
+
+	for _, child := range []int{2*position.index + 1, 2*position.index + 2} {
+		if child < position.queue.Len() {
+			heap.Push(c, queuePosition{position.queue, child})
+		}
+	}
+
+	return position.queue.items[position.index], true
+}
@@ -0,0 +1,186 @@
+package kvstore_test
+
+import (
+	"fmt"
+	"store/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func newEvictingStore(t *testing.T, eviction kvstore.Eviction) *kvstore.KVStore {
+	t.Helper()
+
+	// a single shard, so that the limits apply exactly
+	store, err := kvstore.NewKVStoreWithConfig(kvstore.Config{Shards: 1, Eviction: eviction})
+	if err != nil {
+		t.Fatal("Error opening store: ", err)
+	}
+
+	return store
+}
+
+func checkKeys(t *testing.T, store *kvstore.KVStore, present []string, evicted []string) {
+	t.Helper()
+
+	for _, key := range present {
+		if kvstore.List(store, key, user1) == nil {
+			t.Error("Key should have been present: ", key)
+		}
+	}
+
+	for _, key := range evicted {
+		if kvstore.List(store, key, user1) != nil {
+			t.Error("Key should have been evicted: ", key)
+		}
+	}
+}
+
+func TestEvictLRU(t *testing.T) {
+	store := newEvictingStore(t, kvstore.Eviction{Policy: kvstore.EvictLRU, MaxKeys: 3})
+
+	kvstore.Write(store, "a", value1, user1)
+	kvstore.Write(store, "b", value1, user1)
+	kvstore.Write(store, "c", value1, user1)
+	kvstore.Read(store, "a", user1)
+	kvstore.Write(store, "d", value1, user1)
+
+	checkKeys(t, store, []string{"a", "c", "d"}, []string{"b"})
+
+	if stats := kvstore.StoreStats(store); stats.Keys != 3 || stats.Evictions != 1 {
+		t.Fatal("Stats should have shown 3 keys and 1 eviction but were: ", stats)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestEvictLFU(t *testing.T) {
+	store := newEvictingStore(t, kvstore.Eviction{Policy: kvstore.EvictLFU, MaxKeys: 3})
+
+	kvstore.Write(store, "a", value1, user1)
+	kvstore.Write(store, "b", value1, user1)
+	kvstore.Write(store, "c", value1, user1)
+	kvstore.Read(store, "a", user1)
+	kvstore.Read(store, "a", user1)
+	kvstore.Read(store, "c", user1)
+	kvstore.Read(store, "b", user1)
+	kvstore.Write(store, "d", value1, user1)
+
+	// b and c have been read as often as each other, but b more recently
+	checkKeys(t, store, []string{"a", "b", "d"}, []string{"c"})
+
+	kvstore.Close(store)
+}
+
+func TestEvictTTLFirst(t *testing.T) {
+	store := newEvictingStore(t, kvstore.Eviction{Policy: kvstore.EvictTTLFirst, MaxKeys: 3})
+
+	kvstore.WriteWithOptions(store, "a", value1, user1, kvstore.WriteOptions{TTL: time.Hour})
+	kvstore.Write(store, "b", value1, user1)
+	kvstore.WriteWithOptions(store, "c", value1, user1, kvstore.WriteOptions{TTL: time.Minute})
+	kvstore.Write(store, "d", value1, user1)
+
+	checkKeys(t, store, []string{"a", "b", "d"}, []string{"c"})
+
+	kvstore.Write(store, "e", value1, user1)
+
+	checkKeys(t, store, []string{"b", "d", "e"}, []string{"a"})
+
+	kvstore.Close(store)
+}
+
+func TestEvictionOrderWithManyKeys(t *testing.T) {
+	const keys = 100
+
+	for _, policy := range []kvstore.EvictionPolicy{kvstore.EvictLRU, kvstore.EvictLFU} {
+		store := newEvictingStore(t, kvstore.Eviction{Policy: policy, MaxKeys: keys})
+
+		for i := 0; i < keys; i++ {
+			kvstore.Write(store, fmt.Sprintf("key%d", i), value1, user1)
+		}
+
+		// every key but one is read, so that it's the only one which can be evicted
+		for i := 0; i < keys; i++ {
+			if i != 57 {
+				kvstore.Read(store, fmt.Sprintf("key%d", i), user1)
+			}
+		}
+
+		kvstore.Write(store, "new", value1, user1)
+
+		checkKeys(t, store, []string{"key0", "key56", "key58", "key99", "new"}, []string{"key57"})
+
+		kvstore.Close(store)
+	}
+}
+
+func TestEvictionLimitsWholeStore(t *testing.T) {
+	const maxKeys = 10
+
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Shards: 16,
+		Eviction: kvstore.Eviction{Policy: kvstore.EvictLRU, MaxKeys: maxKeys}})
+
+	for i := 0; i < 100; i++ {
+		kvstore.Write(store, fmt.Sprintf("key%d", i), value1, user1)
+
+		if keys := kvstore.StoreStats(store).Keys; keys != i+1 && keys != maxKeys {
+			t.Fatalf("Store should have held %d keys after %d writes but held %d", maxKeys, i+1, keys)
+		}
+	}
+
+	// the least recently used keys are evicted from whichever shard they are in
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+
+		if present := kvstore.List(store, key, user1) != nil; present != (i >= 100-maxKeys) {
+			t.Fatalf("Key %s should have been present %t but was %t", key, i >= 100-maxKeys, present)
+		}
+	}
+
+	kvstore.Close(store)
+}
+
+func TestEvictMaxBytes(t *testing.T) {
+	store := newEvictingStore(t, kvstore.Eviction{Policy: kvstore.EvictLRU, MaxBytes: 10})
+
+	// each key uses 1 byte for the key and 3 for the value
+	kvstore.Write(store, "a", value1, user1)
+	kvstore.Write(store, "b", value1, user1)
+	kvstore.Write(store, "c", []byte("1234567"), user1)
+
+	checkKeys(t, store, []string{"c"}, []string{"a", "b"})
+
+	if stats := kvstore.StoreStats(store); stats.Bytes != 8 || stats.Evictions != 2 {
+		t.Fatal("Stats should have shown 8 bytes and 2 evictions but were: ", stats)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestEvictionWatched(t *testing.T) {
+	store := newEvictingStore(t, kvstore.Eviction{MaxKeys: 1})
+
+	watcher, _ := kvstore.Watch(store, "", true, 0, user1)
+
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key2, value2, user1)
+
+	checkEvent(t, watcher, kvstore.Event{Type: kvstore.PutEvent, Key: key1, Value: value1, Revision: 1})
+	checkEvent(t, watcher, kvstore.Event{Type: kvstore.PutEvent, Key: key2, Value: value2, Revision: 2})
+	checkEvent(t, watcher, kvstore.Event{Type: kvstore.DeleteEvent, Key: key1, Revision: 3})
+
+	kvstore.Close(store)
+}
+
+func TestParseEvictionPolicy(t *testing.T) {
+	for name, expected := range map[string]kvstore.EvictionPolicy{
+		"lru": kvstore.EvictLRU, "lfu": kvstore.EvictLFU, "ttl": kvstore.EvictTTLFirst,
+	} {
+		if policy, err := kvstore.ParseEvictionPolicy(name); err != nil || policy != expected {
+			t.Errorf("Policy %s should have been %v but got: %v %v", name, expected, policy, err)
+		}
+	}
+
+	if _, err := kvstore.ParseEvictionPolicy("random"); err == nil {
+		t.Error("Unknown policy should have failed")
+	}
+}
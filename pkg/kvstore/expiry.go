@@ -0,0 +1,128 @@
+package kvstore
+
+import (
+	"container/heap"
+	"time"
+)
+
+const defaultExpiryInterval = time.Second
+
+// expiryItem records when a key is due to expire. Items are not removed from the queue when a key is
+// updated or deleted, instead they are ignored if they no longer match the key's entry when they fall due.
+type expiryItem struct {
+	key       string
+	expiresAt time.Time
+}
+
+// expiryQueue is a min-heap of expiry items, ordered by expiry time.
+type expiryQueue []*expiryItem
+
+func (q expiryQueue) Len() int {
+	return len(q)
+}
+
+func (q expiryQueue) Less(i, j int) bool {
+	return q[i].expiresAt.Before(q[j].expiresAt)
+}
+
+func (q expiryQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *expiryQueue) Push(x interface{}) {
+	item, ok := x.(*expiryItem)
+	if ok {
+		*q = append(*q, item)
+	}
+}
+
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+
+	return item
+}
+
+// hasExpired returns whether the entry has a time-to-live which has now passed.
+func hasExpired(e *entry, now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// lookupEntry returns the entry for the key, removing it instead if it has expired or its lease has.
+func lookupEntry(sh *shard, key string) (*entry, bool) {
+	e, ok := sh.data[key]
+	if !ok {
+		return nil, false
+	}
+
+	if now := time.Now(); hasExpired(e, now) || hasLapsed(sh.store, e, now) {
+		expireKeys(sh, []string{key})
+
+		return nil, false
+	}
+
+	return e, true
+}
+
+// scheduleExpiry adds the key to the expiry queue, if its entry has a time-to-live.
+func scheduleExpiry(sh *shard, key string, e *entry) {
+	if !e.ExpiresAt.IsZero() {
+		heap.Push(&sh.expiries, &expiryItem{key, e.ExpiresAt})
+	}
+}
+
+// removeExpiredEntries actively removes all of the shard's keys whose time-to-live has passed,
+// so that keys which are never accessed again don't linger in the store.
+func removeExpiredEntries(sh *shard) {
+	now := time.Now()
+	expiredKeys := make([]string, 0)
+
+	for len(sh.expiries) > 0 && !now.Before(sh.expiries[0].expiresAt) {
+		item, ok := heap.Pop(&sh.expiries).(*expiryItem)
+		if !ok {
+			continue
+		}
+
+		// ignore keys that have since been updated or deleted
+		if current, found := sh.data[item.key]; found && current.ExpiresAt.Equal(item.expiresAt) {
+			expiredKeys = append(expiredKeys, item.key)
+		}
+	}
+
+	if len(expiredKeys) > 0 {
+		expireKeys(sh, expiredKeys)
+	}
+}
+
+// expireKeys removes keys whose time-to-live has passed as a single change to the store, so that
+// their removal is recorded in the write-ahead log and reported to watchers like any other delete.
+// A follower (or a node of a cluster that isn't the leader) leaves this to its leader, whose removal of the keys
+// is then replicated.
+func expireKeys(sh *shard, keys []string) {
+	if !leadsChanges(sh.store) {
+		return
+	}
+
+	changes := make([]change, len(keys))
+	for i, key := range keys {
+		changes[i] = change{key, nil}
+	}
+
+	if err := commitChangesAs(sh.store, changes, MutationExpire); err != nil {
+		// the keys remain in the store for now, but are never returned since they've expired
+		sh.store.logger.Println("Unable to remove expired keys: ", err)
+	}
+}
+
+// remainingTTL returns how long the entry has left before it expires, in milliseconds,
+// or zero if it has no time-to-live.
+func remainingTTL(e *entry, now time.Time) int64 {
+	if e.ExpiresAt.IsZero() {
+		return 0
+	}
+
+	return e.ExpiresAt.Sub(now).Milliseconds()
+}
@@ -0,0 +1,83 @@
+package kvstore_test
+
+import (
+	"store/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+// waitForExpiry waits for the condition to hold once the key has expired, failing the test if it takes too long.
+func waitForExpiry(t *testing.T, expired func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !expired(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Key should have expired")
+		}
+	}
+}
+
+func TestExpiredKeyNotReturned(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	err := kvstore.WriteWithOptions(store, key1, value1, user1, kvstore.WriteOptions{TTL: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal("Write should have been successful but got:", err)
+	}
+
+	if value, ok := kvstore.Read(store, key1, user1); !ok {
+		t.Fatalf("Key should have been present before expiry but was: %t (value %s)", ok, value)
+	}
+
+	waitForExpiry(t, func() bool {
+		_, ok := kvstore.Read(store, key1, user1)
+
+		return !ok
+	})
+
+	if entryInfo := kvstore.List(store, key1, user1); entryInfo != nil {
+		t.Fatal("Expired key should not be listed but was:", entryInfo)
+	}
+
+	if entries := kvstore.ListAll(store, user1); len(entries) != 0 {
+		t.Fatal("Expired key should not be listed but was:", entries)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestExpiredKeyCanBeWrittenByOtherUser(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{ExpiryInterval: 5 * time.Millisecond})
+
+	kvstore.WriteWithOptions(store, key1, value1, user1, kvstore.WriteOptions{TTL: 10 * time.Millisecond})
+
+	// removed in the background
+	waitForExpiry(t, func() bool { return kvstore.StoreStats(store).Keys == 0 })
+
+	// once expired, the key is free to be written by another user
+	if err := kvstore.Write(store, key1, value2, user2); err != nil {
+		t.Fatal("Write of expired key should have been successful but got:", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestTTLReportedAndClearedByUpdate(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	kvstore.WriteWithOptions(store, key1, value1, user1, kvstore.WriteOptions{TTL: time.Minute})
+
+	entryInfo := kvstore.List(store, key1, user1)
+	if entryInfo.TTL <= 0 || entryInfo.TTL > time.Minute.Milliseconds() {
+		t.Fatal("Key should have a TTL but was:", entryInfo.TTL)
+	}
+
+	kvstore.Write(store, key1, value2, user1) // no TTL
+
+	entryInfo = kvstore.List(store, key1, user1)
+	if entryInfo.TTL != 0 {
+		t.Fatal("Key TTL should have been cleared but was:", entryInfo.TTL)
+	}
+
+	kvstore.Close(store)
+}
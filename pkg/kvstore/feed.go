@@ -0,0 +1,211 @@
+package kvstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultChangeFeed = 10000
+
+// MutationType is the cause of a change to a key recorded in the change feed.
+type MutationType string
+
+const (
+	// MutationWrite is recorded when a key is set or updated.
+	MutationWrite MutationType = "write"
+	// MutationDelete is recorded when a key is deleted, including when the lease it's attached to is released.
+	MutationDelete MutationType = "delete"
+	// MutationExpire is recorded when a key is removed because its time-to-live, or its lease, has expired.
+	MutationExpire MutationType = "expire"
+	// MutationEvict is recorded when a key is evicted to keep the store within its size limits.
+	MutationEvict MutationType = "evict"
+	// MutationMove is recorded when a key is removed because it has moved to another node of a partitioned
+	// cluster, see ExportKeys.
+	MutationMove MutationType = "move"
+)
+
+// Mutation is a change to a single key recorded in the change feed. All the changes made in one revision
+// (e.g. by a transaction) are recorded as separate mutations with the same revision. The value is encoded in
+// JSON as base64, since it may not be text.
+type Mutation struct {
+	Revision        uint64       `json:"revision"`
+	Type            MutationType `json:"type"`
+	Key             string       `json:"key"`
+	Value           []byte       `json:"value,omitempty"`
+	ContentType     string       `json:"content_type,omitempty"`
+	ContentEncoding string       `json:"content_encoding,omitempty"`
+	Owner           string       `json:"owner,omitempty"`
+	Time            time.Time    `json:"time"`
+}
+
+// changeFeed is a bounded, ordered record of the most recent mutations to every key in the store, along with
+// the revisions they were made in for replicating the store. It is safe to use from multiple go routines.
+type changeFeed struct {
+	mutex     sync.Mutex
+	mutations []*Mutation
+	records   []*ReplicationRecord
+	capacity  int
+	// firstRevision is the earliest revision whose mutations are all still held
+	firstRevision uint64
+	// added is closed (and replaced) whenever mutations are recorded, to wake up those waiting for them
+	added  chan struct{}
+	closed bool
+}
+
+func newChangeFeed(capacity int, currentRevision uint64) *changeFeed {
+	if capacity <= 0 {
+		capacity = defaultChangeFeed
+	}
+
+	return &changeFeed{
+		mutations:     make([]*Mutation, 0),
+		records:       make([]*ReplicationRecord, 0),
+		capacity:      capacity,
+		firstRevision: currentRevision + 1,
+		added:         make(chan struct{}),
+	}
+}
+
+// Changes returns the mutations made to any key after the specified revision, in revision order, so that a
+// consumer can mirror the store by passing the last revision it has seen each time. At most limit mutations are
+// returned (if limit is positive) other than to finish the last revision, so that a revision is never split
+// between calls. ErrCompacted is returned if some of the mutations after the revision are no longer kept, in
+// which case the consumer must start again from the current state of the store.
+//
+// The mutations to every key are included regardless of access control, so callers must only give the changes
+// to administrators.
+func Changes(s *KVStore, since uint64, limit int) ([]*Mutation, error) {
+	feed := s.feed
+
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	if feed.closed {
+		return nil, ErrClosed
+	}
+
+	return changesSince(feed, since, limit)
+}
+
+// WaitForChanges is the same as Changes, but if there are no mutations after the revision yet, waits until
+// there are, the context is done, or the store is closed.
+func WaitForChanges(ctx context.Context, s *KVStore, since uint64, limit int) ([]*Mutation, error) {
+	var mutations []*Mutation
+
+	err := waitForFeed(ctx, s.feed, func() (bool, error) {
+		var readErr error
+		mutations, readErr = changesSince(s.feed, since, limit)
+
+		return len(mutations) > 0, readErr
+	})
+
+	return mutations, err
+}
+
+// waitForFeed calls read with the feed's mutex held, each time something is recorded in the feed, until read
+// finds what it's after or returns an error, the context is done, or the store is closed.
+func waitForFeed(ctx context.Context, feed *changeFeed, read func() (bool, error)) error {
+	for {
+		feed.mutex.Lock()
+
+		if feed.closed {
+			feed.mutex.Unlock()
+
+			return ErrClosed
+		}
+
+		found, err := read()
+		added := feed.added
+
+		feed.mutex.Unlock()
+
+		if err != nil || found {
+			return err
+		}
+
+		select {
+		case <-added:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// changesSince returns the mutations after the revision, see Changes. The caller must hold the feed's mutex.
+func changesSince(feed *changeFeed, since uint64, limit int) ([]*Mutation, error) {
+	if since+1 < feed.firstRevision {
+		return nil, ErrCompacted
+	}
+
+	start := sort.Search(len(feed.mutations), func(i int) bool {
+		return feed.mutations[i].Revision > since
+	})
+
+	end := len(feed.mutations)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+
+		// finish the last revision
+		for end < len(feed.mutations) && feed.mutations[end].Revision == feed.mutations[end-1].Revision {
+			end++
+		}
+	}
+
+	return append([]*Mutation{}, feed.mutations[start:end]...), nil
+}
+
+// recordRevision adds the revision's record, and the mutations it made, to the feed, discarding the oldest
+// mutations once over capacity along with the records of their revisions, and wakes up anyone waiting for them.
+func recordRevision(feed *changeFeed, record *ReplicationRecord) {
+	if len(record.Changes) == 0 {
+		return
+	}
+
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	feed.mutations = append(feed.mutations, changeMutations(record)...)
+	feed.records = append(feed.records, record)
+
+	if discard := len(feed.mutations) - feed.capacity; discard > 0 {
+		feed.firstRevision = feed.mutations[discard-1].Revision + 1
+		feed.mutations = feed.mutations[discard:]
+
+		for len(feed.records) > 0 && feed.records[0].Revision < feed.firstRevision {
+			feed.records = feed.records[1:]
+		}
+	}
+
+	close(feed.added)
+	feed.added = make(chan struct{})
+}
+
+// closeFeed wakes up anyone waiting for mutations, when the store is closed.
+func closeFeed(feed *changeFeed) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	feed.closed = true
+	close(feed.added)
+	feed.added = make(chan struct{})
+}
+
+// changeMutations returns the mutations describing the changes made in a revision.
+func changeMutations(record *ReplicationRecord) []*Mutation {
+	mutations := make([]*Mutation, len(record.Changes))
+
+	for i, keyChange := range record.Changes {
+		if keyChange.Entry == nil {
+			mutations[i] = &Mutation{Revision: record.Revision, Type: record.Removal, Key: keyChange.Key,
+				Time: record.Time}
+		} else {
+			e := keyChange.Entry
+			mutations[i] = &Mutation{record.Revision, MutationWrite, keyChange.Key, copyBytes(e.Value), e.ContentType,
+				e.ContentEncoding, e.Owner, record.Time}
+		}
+	}
+
+	return mutations
+}
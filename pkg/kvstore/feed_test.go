@@ -0,0 +1,114 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"store/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func TestChangesRecordEveryMutation(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{ExpiryInterval: 5 * time.Millisecond,
+		Eviction: kvstore.Eviction{Policy: kvstore.EvictLRU, MaxKeys: 1}})
+
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Delete(store, key1, user1)
+	kvstore.WriteWithOptions(store, key1, value1, user1, kvstore.WriteOptions{TTL: 10 * time.Millisecond})
+	time.Sleep(30 * time.Millisecond)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key2, value2, user2)
+
+	mutations, err := kvstore.Changes(store, 0, 0)
+	if err != nil {
+		t.Fatal("Changes should have been returned but got: ", err)
+	}
+
+	expected := []kvstore.MutationType{kvstore.MutationWrite, kvstore.MutationDelete, kvstore.MutationWrite,
+		kvstore.MutationExpire, kvstore.MutationWrite, kvstore.MutationWrite, kvstore.MutationEvict}
+
+	if len(mutations) != len(expected) {
+		t.Fatal("Every mutation should have been recorded but got: ", mutations)
+	}
+
+	for i, mutation := range mutations {
+		if mutation.Type != expected[i] || (i > 0 && mutation.Revision <= mutations[i-1].Revision) {
+			t.Fatalf("Mutation %d should have been a %s in revision order but was: %+v", i, expected[i], mutation)
+		}
+	}
+
+	if mutations[5].Key != key2 || !bytes.Equal(mutations[5].Value, value2) || mutations[5].Owner != user2 {
+		t.Fatal("Write should have recorded the key, value and owner but was: ", mutations[5])
+	}
+
+	if later, _ := kvstore.Changes(store, mutations[4].Revision, 0); len(later) != 2 {
+		t.Fatal("Only the mutations after the revision should have been returned but got: ", later)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestChangesLimitKeepsRevisionsWhole(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	kvstore.Transaction(store, []kvstore.TxnOp{
+		{Type: kvstore.TxnPut, Key: key1, Value: value1},
+		{Type: kvstore.TxnPut, Key: key2, Value: value2},
+	}, user1)
+	kvstore.Write(store, key1, value2, user1)
+
+	if mutations, _ := kvstore.Changes(store, 0, 1); len(mutations) != 2 {
+		t.Fatal("Whole transaction should have been returned but got: ", mutations)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestChangesCompacted(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{ChangeFeed: 2})
+
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key1, value2, user1)
+	kvstore.Write(store, key1, value1, user1)
+
+	if _, err := kvstore.Changes(store, 0, 0); !errors.Is(err, kvstore.ErrCompacted) {
+		t.Fatal("Discarded mutations should have been compacted but got: ", err)
+	}
+
+	if mutations, err := kvstore.Changes(store, 1, 0); len(mutations) != 2 || err != nil {
+		t.Fatal("Kept mutations should have been returned but got: ", mutations, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestWaitForChanges(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		kvstore.Write(store, key1, value1, user1)
+	}()
+
+	mutations, err := kvstore.WaitForChanges(context.Background(), store, 0, 0)
+	if len(mutations) != 1 || err != nil {
+		t.Fatal("Wait should have ended with the new mutation but got: ", mutations, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err = kvstore.WaitForChanges(ctx, store, 1, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Wait should have timed out but got: ", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		kvstore.Close(store)
+	}()
+
+	if _, err = kvstore.WaitForChanges(context.Background(), store, 1, 0); !errors.Is(err, kvstore.ErrClosed) {
+		t.Fatal("Wait should have ended when the store closed but got: ", err)
+	}
+}
@@ -0,0 +1,311 @@
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// deletedHistoryAge is how long the history of a deleted key is kept when History.MaxAge is zero, so that the
+// histories of keys which are never written again don't build up.
+const deletedHistoryAge = time.Hour
+
+var errHistoryDisabled = fmt.Errorf("%w: history is not kept", ErrNotSupported)
+
+// History determines how many earlier versions of each key are kept, for reading keys as they were at an
+// earlier revision or time. History is held in memory only, and doesn't count towards quotas or eviction.
+// When a persistent store is opened again, only the current version of each key can be read.
+type History struct {
+	// MaxVersions is how many earlier versions of each key are kept, besides its current one. A deletion
+	// counts as a version. If zero, versions are only limited by MaxAge.
+	MaxVersions int
+	// MaxAge is how long a version is kept once it has been replaced. If zero, versions are only limited by
+	// MaxVersions, although the history of a deleted key is still removed after an hour.
+	MaxAge time.Duration
+}
+
+// HistoryEntry is a single version of a key in its history. The value is encoded in JSON as base64, since it
+// may not be text.
+type HistoryEntry struct {
+	// Version is the revision at which the key was written or deleted.
+	Version         uint64    `json:"version"`
+	Time            time.Time `json:"time"`
+	Deleted         bool      `json:"deleted,omitempty"`
+	Value           []byte    `json:"value,omitempty"`
+	ContentType     string    `json:"content_type,omitempty"`
+	ContentEncoding string    `json:"content_encoding,omitempty"`
+	Owner           string    `json:"owner,omitempty"`
+}
+
+// keyVersion is the state of a key from the revision at which it was written or deleted, until the next.
+type keyVersion struct {
+	revision uint64
+	time     time.Time
+	entry    *entry // nil if the key was deleted
+}
+
+// keyHistory holds the versions of a key in order, the last one being its current state.
+type keyHistory struct {
+	versions []*keyVersion
+	// compacted is set once earlier versions of the key have been discarded, or were never recorded
+	compacted bool
+}
+
+type historyRequest struct {
+	key      string
+	username string
+	// at returns whether the key's version was current at the revision or time being read, or nil
+	// to list the key's whole history
+	at              func(v *keyVersion) bool
+	responseChannel chan<- *historyResponse
+}
+
+type historyResponse struct {
+	item    *Item
+	found   bool
+	entries []*HistoryEntry
+	err     error
+}
+
+// ReadAt returns the value and version the key had at the specified revision, and a flag indicating if
+// the key was present then. ErrCompacted is returned if the key's version at that revision is no longer kept.
+//
+// The user must have been able to read that version of the key.
+func ReadAt(s *KVStore, key string, revision uint64, username string) (*Item, bool, error) {
+	return ReadAtContext(context.Background(), s, key, revision, username)
+}
+
+// ReadAtContext is the same as ReadAt, but gives up if the context is done first, or the store is closed.
+func ReadAtContext(ctx context.Context, s *KVStore, key string, revision uint64, username string) (*Item, bool,
+	error) {
+	response, err := sendHistoryRequest(ctx, s, key, username, func(v *keyVersion) bool {
+		return v.revision <= revision
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return response.item, response.found, response.err
+}
+
+// ReadAtTime returns the value and version the key had at the specified time, and a flag indicating if
+// the key was present then. ErrCompacted is returned if the key's version at that time is no longer kept.
+//
+// The user must have been able to read that version of the key.
+func ReadAtTime(s *KVStore, key string, at time.Time, username string) (*Item, bool, error) {
+	return ReadAtTimeContext(context.Background(), s, key, at, username)
+}
+
+// ReadAtTimeContext is the same as ReadAtTime, but gives up if the context is done first, or the store is
+// closed.
+func ReadAtTimeContext(ctx context.Context, s *KVStore, key string, at time.Time, username string) (*Item, bool,
+	error) {
+	response, err := sendHistoryRequest(ctx, s, key, username, func(v *keyVersion) bool {
+		return !v.time.After(at)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return response.item, response.found, response.err
+}
+
+// KeyHistory returns the versions of the key which are still kept, oldest first, ending with its current
+// version or its deletion.
+//
+// Versions which the user couldn't read are left out.
+func KeyHistory(s *KVStore, key string, username string) ([]*HistoryEntry, error) {
+	return KeyHistoryContext(context.Background(), s, key, username)
+}
+
+// KeyHistoryContext is the same as KeyHistory, but gives up if the context is done first, or the store is
+// closed.
+func KeyHistoryContext(ctx context.Context, s *KVStore, key string, username string) ([]*HistoryEntry, error) {
+	response, err := sendHistoryRequest(ctx, s, key, username, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.entries, response.err
+}
+
+func sendHistoryRequest(ctx context.Context, s *KVStore, key string, username string,
+	at func(v *keyVersion) bool) (*historyResponse, error) {
+	if !historyEnabled(s) {
+		return nil, errHistoryDisabled
+	}
+
+	responseChannel := make(chan *historyResponse, 1)
+	if err := sendRequest(ctx, shardFor(s, key), historyOperation,
+		&historyRequest{key, username, at, responseChannel}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-responseChannel:
+		return response, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// handleHistory reads the key as it was at an earlier revision or time, or lists its history.
+func handleHistory(sh *shard, params *historyRequest) *historyResponse {
+	// make sure an expired key's removal has been recorded
+	existingEntry, _ := lookupEntry(sh, params.key)
+	history := sh.history[params.key]
+
+	if params.at == nil {
+		entries := make([]*HistoryEntry, 0)
+
+		if history != nil {
+			for _, v := range history.versions {
+				if v.entry == nil || hasPermission(v.entry, params.username, sh.store.groups, PermissionRead) {
+					entries = append(entries, newHistoryEntry(v))
+				}
+			}
+		}
+
+		return &historyResponse{entries: entries}
+	}
+
+	var found *keyVersion
+
+	switch {
+	case history != nil:
+		for i := len(history.versions) - 1; i >= 0; i-- {
+			if params.at(history.versions[i]) {
+				found = history.versions[i]
+
+				break
+			}
+		}
+
+		if found == nil && history.compacted {
+			return &historyResponse{err: ErrCompacted}
+		}
+
+	case !params.at(sh.historyStart):
+		// nothing is known about the key from before its history started
+		return &historyResponse{err: ErrCompacted}
+
+	case existingEntry != nil:
+		// the key hasn't changed since its history started
+		found = &keyVersion{existingEntry.Version, sh.historyStart.time, existingEntry}
+	}
+
+	if found == nil || found.entry == nil {
+		return &historyResponse{}
+	}
+
+	if !hasPermission(found.entry, params.username, sh.store.groups, PermissionRead) {
+		return &historyResponse{err: ErrPermissionDenied}
+	}
+
+	return &historyResponse{item: newItem(found.entry), found: true}
+}
+
+// historyEnabled returns whether the store keeps earlier versions of its keys.
+func historyEnabled(s *KVStore) bool {
+	return s.config.History.MaxVersions > 0 || s.config.History.MaxAge > 0
+}
+
+// recordHistory adds the key's new state to its history, if history is enabled, and discards any versions
+// that are no longer to be kept. It must be called before the new state is set in the shard's data.
+func recordHistory(sh *shard, key string, revision uint64, now time.Time, e *entry) {
+	if !historyEnabled(sh.store) {
+		return
+	}
+
+	_, existed := sh.data[key]
+
+	history, ok := sh.history[key]
+	if !ok {
+		if e == nil && !existed {
+			return
+		}
+
+		// any state the key was in before now wasn't recorded
+		history = &keyHistory{compacted: existed}
+		sh.history[key] = history
+	}
+
+	history.versions = append(history.versions, &keyVersion{revision, now, e})
+	pruneHistory(sh, key, history, now)
+}
+
+// pruneHistory discards the versions of the key which are no longer to be kept, and the key's whole history
+// once it has been deleted for long enough.
+func pruneHistory(sh *shard, key string, history *keyHistory, now time.Time) {
+	config := sh.store.config.History
+	discard := 0
+
+	if config.MaxVersions > 0 && len(history.versions) > config.MaxVersions+1 {
+		discard = len(history.versions) - config.MaxVersions - 1
+	}
+
+	if config.MaxAge > 0 {
+		// a version is discarded once it has been replaced for long enough
+		for discard < len(history.versions)-1 && now.Sub(history.versions[discard+1].time) >= config.MaxAge {
+			discard++
+		}
+	}
+
+	if discard > 0 {
+		history.versions = append(history.versions[:0], history.versions[discard:]...)
+		history.compacted = true
+	}
+
+	deletedAge := config.MaxAge
+	if deletedAge <= 0 {
+		deletedAge = deletedHistoryAge
+	}
+
+	last := history.versions[len(history.versions)-1]
+	if len(history.versions) == 1 && last.entry == nil && now.Sub(last.time) >= deletedAge {
+		delete(sh.history, key)
+
+		// nothing is known about the key from before its deletion any more
+		if last.revision > sh.historyStart.revision {
+			sh.historyStart = &keyVersion{last.revision, last.time, nil}
+		}
+	}
+}
+
+// compactHistory discards the versions of every key in the shard which are no longer to be kept, which
+// may be due to their age.
+func compactHistory(sh *shard) {
+	if !historyEnabled(sh.store) {
+		return
+	}
+
+	now := time.Now()
+
+	for key, history := range sh.history {
+		pruneHistory(sh, key, history, now)
+	}
+}
+
+// startHistory records that nothing is known about the store's keys from before now, since the store's
+// history isn't persisted.
+func startHistory(s *KVStore) {
+	for _, sh := range s.shards {
+		sh.historyStart = &keyVersion{s.revision, time.Now(), nil}
+	}
+}
+
+// newHistoryEntry returns the details of a version of a key, as reported to users.
+func newHistoryEntry(v *keyVersion) *HistoryEntry {
+	if v.entry == nil {
+		return &HistoryEntry{Version: v.revision, Time: v.time, Deleted: true}
+	}
+
+	return &HistoryEntry{
+		Version:         v.revision,
+		Time:            v.time,
+		Value:           copyBytes(v.entry.Value),
+		ContentType:     v.entry.ContentType,
+		ContentEncoding: v.entry.ContentEncoding,
+		Owner:           v.entry.Owner,
+	}
+}
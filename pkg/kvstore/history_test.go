@@ -0,0 +1,155 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"store/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func TestReadAtRevision(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Shards: testShards,
+		History: kvstore.History{MaxVersions: 10}})
+
+	kvstore.Write(store, key1, value1, user1)
+	first, _ := kvstore.ReadItem(store, key1, user1)
+	kvstore.Write(store, key1, value2, user1)
+	second, _ := kvstore.ReadItem(store, key1, user1)
+	kvstore.Delete(store, key1, user1)
+
+	for _, test := range []struct {
+		revision uint64
+		found    bool
+		expected string
+	}{
+		{first.Version - 1, false, ""},
+		{first.Version, true, string(value1)},
+		{second.Version, true, string(value2)},
+		{second.Version + 1, false, ""},
+	} {
+		item, found, err := kvstore.ReadAt(store, key1, test.revision, user2)
+		if err != nil || found != test.found || (found && string(item.Value) != test.expected) {
+			t.Fatalf("Revision %d should have been %t %s but was: %v %t %v", test.revision, test.found,
+				test.expected, item, found, err)
+		}
+	}
+
+	history, err := kvstore.KeyHistory(store, key1, user2)
+	if err != nil || len(history) != 3 || !bytes.Equal(history[0].Value, value1) || !history[2].Deleted {
+		t.Fatal("History should have held both versions and the deletion but was: ", history, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestReadAtTime(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{History: kvstore.History{MaxVersions: 10}})
+
+	kvstore.Write(store, key1, value1, user1)
+	time.Sleep(5 * time.Millisecond)
+
+	between := time.Now()
+
+	time.Sleep(5 * time.Millisecond)
+	kvstore.Write(store, key1, value2, user1)
+
+	if item, found, err := kvstore.ReadAtTime(store, key1, between, user1); !found || err != nil ||
+		string(item.Value) != string(value1) {
+		t.Fatal("Earlier value should have been read but got: ", item, found, err)
+	}
+
+	if item, found, err := kvstore.ReadAtTime(store, key1, time.Now(), user1); !found || err != nil ||
+		string(item.Value) != string(value2) {
+		t.Fatal("Current value should have been read but got: ", item, found, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestHistoryRetention(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{History: kvstore.History{MaxVersions: 1}})
+
+	kvstore.Write(store, key1, value1, user1)
+	first, _ := kvstore.ReadItem(store, key1, user1)
+	kvstore.Write(store, key1, value2, user1)
+	kvstore.Write(store, key1, value1, user1)
+
+	if _, _, err := kvstore.ReadAt(store, key1, first.Version, user1); !errors.Is(err, kvstore.ErrCompacted) {
+		t.Fatal("Discarded version should have been compacted but got: ", err)
+	}
+
+	if history, _ := kvstore.KeyHistory(store, key1, user1); len(history) != 2 {
+		t.Fatal("History should have kept one earlier version but was: ", history)
+	}
+
+	kvstore.Close(store)
+
+	store, _ = kvstore.NewKVStoreWithConfig(kvstore.Config{ExpiryInterval: 5 * time.Millisecond,
+		History: kvstore.History{MaxAge: 10 * time.Millisecond}})
+
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key1, value2, user1)
+	time.Sleep(30 * time.Millisecond)
+
+	if history, _ := kvstore.KeyHistory(store, key1, user1); len(history) != 1 {
+		t.Fatal("History should only have kept the current version but was: ", history)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestHistoryPermissions(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{History: kvstore.History{MaxVersions: 10}})
+
+	kvstore.Write(store, key1, value1, user1)
+	private, _ := kvstore.ReadItem(store, key1, user1)
+	kvstore.SetACL(store, key1, kvstore.ACL{Private: true}, user1)
+	kvstore.SetACL(store, key1, kvstore.ACL{}, user1)
+
+	if _, _, err := kvstore.ReadAt(store, key1, private.Version+1, user2); !errors.Is(err,
+		kvstore.ErrPermissionDenied) {
+		t.Fatal("Private version should not have been read by a different user but got: ", err)
+	}
+
+	if history, _ := kvstore.KeyHistory(store, key1, user2); len(history) != 2 {
+		t.Fatal("Private version should have been left out of the history but was: ", history)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestHistoryNotKept(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	if _, _, err := kvstore.ReadAt(store, key1, 1, user1); !errors.Is(err, kvstore.ErrNotSupported) {
+		t.Fatal("Read at revision should not have been supported without history but got: ", err)
+	}
+
+	kvstore.Close(store)
+
+	// history isn't persisted, so only the current version is known after reopening
+	config := kvstore.Config{WALPath: filepath.Join(t.TempDir(), "store.wal"),
+		History: kvstore.History{MaxVersions: 10}}
+
+	store, _ = kvstore.NewKVStoreWithConfig(config)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key1, value2, user1)
+
+	current, _ := kvstore.ReadItem(store, key1, user1)
+	kvstore.Close(store)
+
+	store, _ = kvstore.NewKVStoreWithConfig(config)
+
+	if _, _, err := kvstore.ReadAt(store, key1, current.Version-1, user1); !errors.Is(err, kvstore.ErrCompacted) {
+		t.Fatal("Version from before reopening should have been compacted but got: ", err)
+	}
+
+	if item, found, err := kvstore.ReadAt(store, key1, current.Version, user1); !found || err != nil ||
+		string(item.Value) != string(value2) {
+		t.Fatal("Current version should have been read after reopening but got: ", item, found, err)
+	}
+
+	kvstore.Close(store)
+}
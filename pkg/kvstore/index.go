@@ -0,0 +1,107 @@
+package kvstore
+
+const (
+	maxIndexLevel = 24
+	// each node is promoted to the next level up with a probability of 1 in indexLevelFactor.
+	indexLevelFactor = 4
+)
+
+// keyIndex is a skiplist holding every key in the store in lexical order, so that ranges of keys
+// can be found without visiting (and sorting) every key in the store's map.
+type keyIndex struct {
+	head  *indexNode
+	level int
+	seed  uint64
+}
+
+type indexNode struct {
+	key  string
+	next []*indexNode
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{
+		head:  &indexNode{next: make([]*indexNode, maxIndexLevel)},
+		level: 1,
+		seed:  0x9E3779B97F4A7C15,
+	}
+}
+
+// randomLevel picks the number of levels for a new node, using a xorshift generator
+// as the distribution only needs to be roughly geometric.
+func (index *keyIndex) randomLevel() int {
+	level := 1
+
+	for level < maxIndexLevel {
+		index.seed ^= index.seed << 13
+		index.seed ^= index.seed >> 7
+		index.seed ^= index.seed << 17
+
+		if index.seed%indexLevelFactor != 0 {
+			break
+		}
+
+		level++
+	}
+
+	return level
+}
+
+// findPredecessors returns the last node before the key at each level.
+func (index *keyIndex) findPredecessors(key string) []*indexNode {
+	predecessors := make([]*indexNode, maxIndexLevel)
+	node := index.head
+
+	for level := index.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].key < key {
+			node = node.next[level]
+		}
+
+		predecessors[level] = node
+	}
+
+	return predecessors
+}
+
+// insert adds the key to the index, if not already present.
+func (index *keyIndex) insert(key string) {
+	predecessors := index.findPredecessors(key)
+
+	if next := predecessors[0].next[0]; next != nil && next.key == key {
+		return
+	}
+
+	level := index.randomLevel()
+	for ; index.level < level; index.level++ {
+		predecessors[index.level] = index.head
+	}
+
+	node := &indexNode{key, make([]*indexNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = predecessors[i].next[i]
+		predecessors[i].next[i] = node
+	}
+}
+
+// remove takes the key out of the index, if present.
+func (index *keyIndex) remove(key string) {
+	predecessors := index.findPredecessors(key)
+
+	node := predecessors[0].next[0]
+	if node == nil || node.key != key {
+		return
+	}
+
+	for i := range node.next {
+		predecessors[i].next[i] = node.next[i]
+	}
+
+	for index.level > 1 && index.head.next[index.level-1] == nil {
+		index.level--
+	}
+}
+
+// seek returns the node for the first key that is greater than or equal to start, or nil if there isn't one.
+func (index *keyIndex) seek(start string) *indexNode {
+	return index.findPredecessors(start)[0].next[0]
+}
@@ -1,49 +1,194 @@
 // Package kvstore provides a thread-safe key value store.
+//
+// The keys are spread across shards, each with its own go routine, so that reads of keys in different shards run
+// in parallel. Changes mostly are too: a change only takes a single store-wide lock while it is given the next
+// revision and written to the write-ahead log, and is then flushed to disk (sharing the flush with any other
+// changes waiting for one) and applied to its shards without it. Each change is reported to watchers and the change
+// feed once those before it have been, so that they still see changes in revision order.
 package kvstore
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
 	"time"
 )
 
 // Entry is is stored against each key in the store.
 type entry struct {
-	Value       string
-	Owner       string
-	Reads       int
-	Writes      int
-	LastAccesed time.Time
+	Value           []byte
+	ContentType     string
+	ContentEncoding string
+	Type            DataType `json:",omitempty"`
+	Owner           string
+	Reads           int
+	Writes          int
+	LastAccesed     time.Time
+	ExpiresAt       time.Time
+	Version         uint64
+	ACL             *ACL   `json:",omitempty"`
+	Lease           uint64 `json:",omitempty"`
 }
 
 // EntryInfo provides details on a single store key.
 type EntryInfo struct {
-	Key    string `json:"key"`
-	Owner  string `json:"owner"`
-	Writes int    `json:"writes"`
-	Reads  int    `json:"reads"`
-	Age    int64  `json:"age"`
+	Key         string   `json:"key"`
+	Owner       string   `json:"owner"`
+	Version     uint64   `json:"version"`
+	Size        int      `json:"size"`
+	ContentType string   `json:"content_type,omitempty"`
+	Type        DataType `json:"type,omitempty"`
+	Writes      int      `json:"writes"`
+	Reads       int      `json:"reads"`
+	Age         int64    `json:"age"`
+	TTL         int64    `json:"ttl,omitempty"`
+	Lease       uint64   `json:"lease,omitempty"`
+}
+
+// WriteOptions provides optional settings for a write.
+type WriteOptions struct {
+	// TTL is how long the key should live for before expiring. If zero the key never expires.
+	TTL time.Duration
+	// Condition (if set) must hold for the key's current version, otherwise the write is not made.
+	Condition *Condition
+	// ContentType is the media type of the value (e.g. "image/png"), if known.
+	ContentType string
+	// ContentEncoding is how the value has been encoded (e.g. "gzip"), if at all.
+	ContentEncoding string
+	// Override allows the write even though the user doesn't own the key or have permission to write it.
+	// The store doesn't check who the user is, so callers must only allow administrators to override.
+	Override bool
+	// Lease (if set) attaches the key to the lease, so that the key is removed when the lease expires or is
+	// released. The lease must be held by the user.
+	Lease uint64
+}
+
+// DeleteOptions provides optional settings for a delete.
+type DeleteOptions struct {
+	// Condition (if set) must hold for the key's current version, otherwise the delete is not made.
+	Condition *Condition
+	// Override allows the delete even though the user doesn't own the key, see WriteOptions.
+	Override bool
 }
 
 // KVStore is a thread-safe key value store.
 type KVStore struct {
-	data           map[string]*entry
-	requestChannel chan *request
+	shards []*shard
+	// commitMutex serialises changes across all the shards, so that each change gets the next revision and
+	// is written to the write-ahead log in revision order. It's only held while that's done (and the quota is
+	// checked), so that changes to different shards are then flushed to disk and made in parallel.
+	commitMutex sync.Mutex
+	revision    uint64
+	usage       map[string]*Usage
+	// reported is closed once the latest revision has been reported to watchers and the change feed, each
+	// revision waiting for the one before, so that they're reported in order
+	reported  chan struct{}
+	groups    userGroups
+	evictions uint64
+	// namespaceMutex guards the namespaces, each of which is held in a store of its own
+	namespaceMutex sync.Mutex
+	namespaces     map[string]*namespace
+	// leaseMutex guards the leases, and the lease holding each lock by name
+	leaseMutex    sync.Mutex
+	leases        map[uint64]*lease
+	leaseNames    map[string]uint64
+	leasesDone    chan struct{}
+	wal           *writeAheadLog
+	watches       *watchHub
+	feed          *changeFeed
+	consensus     *consensus
+	stopSnapshots chan struct{}
+	snapshotsDone chan struct{}
+	closed        chan struct{}
+	closeOnce     sync.Once
+	config        Config
+	logger        *log.Logger
+}
+
+// Config holds the optional settings for a key value store.
+type Config struct {
+	// Shards is how many independent partitions the keys are spread across, each with its own go routine,
+	// so that operations on different keys can run in parallel. Changes are still given revisions one at a time
+	// across the whole store, see the package documentation. If zero or less, a single shard is used.
+	Shards int
+	// WALPath is the write-ahead log file used to persist changes. If empty, the store is held in memory only.
+	WALPath string
+	// SyncPolicy determines how often the write-ahead log is flushed to disk.
+	SyncPolicy SyncPolicy
+	// SyncInterval is how often the write-ahead log is flushed to disk when using SyncInterval. If zero,
+	// DefaultSyncInterval is used.
+	SyncInterval time.Duration
+	// SnapshotPath is the file the whole store is periodically written to, allowing the write-ahead log
+	// to be discarded. If empty, snapshots are disabled.
+	SnapshotPath string
+	// SnapshotInterval is how often a snapshot is taken automatically. If zero, snapshots are only
+	// taken on demand.
+	SnapshotInterval time.Duration
+	// ExpiryInterval is how often keys whose time-to-live has passed are actively removed. If zero,
+	// a default of one second is used. Expired keys are never returned, even before they are removed.
+	ExpiryInterval time.Duration
+	// WatchHistory is how many recent events are kept, so that watchers can resume from an earlier
+	// revision. If zero, a default of 1000 is used.
+	WatchHistory int
+	// ChangeFeed is how many of the most recent mutations are kept in the change feed, so that consumers (and
+	// followers, see ReplicationLog) can catch up from an earlier revision. If zero, a default of 10000 is used.
+	ChangeFeed int
+	// Follower makes the store a read-only replica of a leader store, which is only changed by applying the
+	// leader's replication log (see ApplyReplicationRecord) and snapshots. Any other change returns ErrReadOnly.
+	// The leader's namespaces aren't replicated, so a follower has none of its own.
+	Follower bool
+	// Eviction (if enabled) removes keys to keep the store within a fixed size, so it can be used as a cache.
+	Eviction Eviction
+	// History determines how many earlier versions of each key are kept. If zero, only the current version
+	// of each key is kept.
+	History History
+	// Quota limits how much of the store each user can take up. If zero, there are no limits.
+	Quota Quota
+	// DefaultTTL is the time-to-live given to keys written without one. If zero, such keys never expire.
+	DefaultTTL time.Duration
+	// DefaultACL (if set) is the access control list given to each new key.
+	DefaultACL *ACL
+	// NamespacesPath is the file the store's namespaces are recorded in, so that they are recreated when the
+	// store is opened again. If empty, namespaces are held in memory only.
+	NamespacesPath string
+	// Groups maps each group name to the usernames of its members, for granting permissions on keys to
+	// every member of a group.
+	Groups map[string][]string
+	// Cluster (if set) makes the store a node of a Raft cluster, whose changes are only made once committed by a
+	// majority of the nodes. The store must then be held in memory only.
+	Cluster *Cluster
+	// Logger is used to report background activity, such as snapshots being taken. If nil, nothing is logged.
+	Logger *log.Logger
 }
 
 type operation int
 
 const (
-	readOperation    operation = iota
-	writeOperation   operation = iota
-	deleteOperation  operation = iota
-	listOperation    operation = iota
-	listAllOperation operation = iota
-	closeOperation   operation = iota
+	readOperation       operation = iota
+	writeOperation      operation = iota
+	deleteOperation     operation = iota
+	listOperation       operation = iota
+	listAllOperation    operation = iota
+	scanOperation       operation = iota
+	aclOperation        operation = iota
+	transferOperation   operation = iota
+	incrementOperation  operation = iota
+	patchOperation      operation = iota
+	collectionOperation operation = iota
+	historyOperation    operation = iota
+	parkOperation       operation = iota
+	closeOperation      operation = iota
 )
 
+// ErrClosed is returned by operations made once the store has been closed.
+var ErrClosed = errors.New("store is closed")
+
 var (
-	errUpdateSameUser = errors.New("cannot update entry owned by someone else")
-	errDeleteSameUser = errors.New("cannot delete entry owned by someone else")
+	errUpdateSameUser = fmt.Errorf("%w: cannot update entry owned by someone else", ErrPermissionDenied)
+	errDeleteSameUser = fmt.Errorf("%w: cannot delete entry owned by someone else", ErrPermissionDenied)
 )
 
 type request struct {
@@ -53,18 +198,21 @@ type request struct {
 
 type readRequest struct {
 	key             string
+	username        string
 	responseChannel chan<- *readResponse
 }
 
 type readResponse struct {
-	value string
-	ok    bool
+	item *Item
+	ok   bool
+	err  error
 }
 
 type writeRequest struct {
 	key             string
-	value           string
+	value           []byte
 	username        string
+	options         WriteOptions
 	responseChannel chan<- *writeResponse
 }
 
@@ -75,6 +223,7 @@ type writeResponse struct {
 type deleteRequest struct {
 	key             string
 	username        string
+	options         DeleteOptions
 	responseChannel chan<- *deleteResponse
 }
 
@@ -85,14 +234,17 @@ type deleteResponse struct {
 
 type listRequest struct {
 	key             string
+	username        string
 	responseChannel chan<- *listResponse
 }
 
 type listResponse struct {
 	entryInfo *EntryInfo
+	err       error
 }
 
 type listAllRequest struct {
+	username        string
 	responseChannel chan<- *listAllResponse
 }
 
@@ -100,175 +252,1002 @@ type listAllResponse struct {
 	entries []*EntryInfo
 }
 
-// NewKVStore returns a new key value store instance.
-func NewKVStore() *KVStore {
+type closeRequest struct {
+	responseChannel chan<- struct{}
+}
+
+// NewKVStore returns a new in-memory key value store instance, with the keys spread across
+// the specified number of shards.
+func NewKVStore(shards int) *KVStore {
+	store := newStore(Config{Shards: shards})
+
+	// start the internal go routines
+	startStore(store)
+
+	return store
+}
+
+// NewKVStoreWithConfig returns a new key value store instance using the specified config. If a
+// snapshot is configured it is loaded first, then any changes in the write-ahead log are replayed on top.
+func NewKVStoreWithConfig(config Config) (*KVStore, error) {
+	if err := checkClusterConfig(config); err != nil {
+		return nil, err
+	}
+
+	store := newStore(config)
+
+	if config.SnapshotPath != "" {
+		if err := loadSnapshot(store, config.SnapshotPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.WALPath != "" {
+		wal, err := openWAL(config.WALPath, config.SyncPolicy, config.SyncInterval, func(record *walRecord) {
+			applyRecord(store, record)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		store.wal = wal
+	}
+
+	for _, sh := range store.shards {
+		for key, entry := range sh.data {
+			scheduleExpiry(sh, key, entry)
+		}
+	}
+
+	if err := removeLeasedKeys(store); err != nil {
+		return nil, err
+	}
+
+	if config.NamespacesPath != "" {
+		if err := loadNamespaces(store); err != nil {
+			return nil, err
+		}
+	}
+
+	startHistory(store)
+
+	// only changes from now on can be watched, or read from the change feed
+	store.watches.firstRevision = store.revision + 1
+	store.feed.firstRevision = store.revision + 1
+
+	// start the internal go routines
+	startStore(store)
+
+	if err := startCluster(store); err != nil {
+		Close(store)
+
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func newStore(config Config) *KVStore {
+	logger := config.Logger
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+
+	groups := newUserGroups(config.Groups)
+
+	reported := make(chan struct{})
+	close(reported)
+
 	store := &KVStore{
-		make(map[string]*entry),
-		make(chan *request),
+		usage:      make(map[string]*Usage),
+		reported:   reported,
+		groups:     groups,
+		namespaces: make(map[string]*namespace),
+		leases:     make(map[uint64]*lease),
+		leaseNames: make(map[string]uint64),
+		leasesDone: make(chan struct{}),
+		watches:    newWatchHub(config.WatchHistory, 0, groups),
+		feed:       newChangeFeed(config.ChangeFeed, 0),
+		closed:     make(chan struct{}),
+		config:     config,
+		logger:     logger,
 	}
 
-	// start the internal go routine
-	handleStoreOperations(store)
+	shardCount := config.Shards
+	if shardCount <= 0 {
+		shardCount = 1
+	}
+
+	store.shards = make([]*shard, shardCount)
+	for i := range store.shards {
+		store.shards[i] = newShard(store, i)
+	}
 
 	return store
 }
 
-// Close shuts down the key value store cleanly.
-func Close(s *KVStore) {
-	s.requestChannel <- &request{closeOperation, nil}
+// startStore starts the go routine for each shard, the one removing expired leases, and the one taking
+// periodic snapshots if enabled.
+func startStore(s *KVStore) {
+	for _, sh := range s.shards {
+		handleStoreOperations(sh)
+	}
+
+	expiryInterval := s.config.ExpiryInterval
+	if expiryInterval <= 0 {
+		expiryInterval = defaultExpiryInterval
+	}
+
+	go expireLeasesPeriodically(s, expiryInterval)
+
+	if s.config.SnapshotPath != "" && s.config.SnapshotInterval > 0 {
+		s.stopSnapshots = make(chan struct{})
+		s.snapshotsDone = make(chan struct{})
+
+		go snapshotPeriodically(s, s.config.SnapshotInterval)
+	}
+}
+
+// Snapshot writes the whole store to the configured snapshot file, and discards the write-ahead log.
+// The same is then done for each of the store's namespaces.
+func Snapshot(s *KVStore) error {
+	if s.config.SnapshotPath == "" {
+		return ErrSnapshotsDisabled
+	}
+
+	if err := snapshotStore(s); err != nil {
+		return err
+	}
+
+	s.namespaceMutex.Lock()
+	defer s.namespaceMutex.Unlock()
+
+	for name, ns := range s.namespaces {
+		if err := snapshotStore(ns.store); err != nil {
+			return fmt.Errorf("namespace %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// snapshotStore takes a snapshot of the store itself, without its namespaces.
+func snapshotStore(s *KVStore) error {
+	// nothing can change while every shard is parked
+	release, err := parkShards(context.Background(), s.shards)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	for _, sh := range s.shards {
+		removeExpiredEntries(sh)
+	}
+
+	return takeSnapshot(s)
+}
+
+// Close shuts down the key value store cleanly, flushing any outstanding changes to disk.
+// Any operations made after the store is closed return ErrClosed (or fail in the same way as a
+// missing key, for those that don't return an error), as does closing it again.
+func Close(s *KVStore) error {
+	closing := false
+
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		closing = true
+	})
+
+	if !closing {
+		return ErrClosed
+	}
+
+	if s.stopSnapshots != nil {
+		close(s.stopSnapshots)
+		<-s.snapshotsDone
+	}
+
+	<-s.leasesDone
+
+	stopCluster(s)
+
+	// operations already accepted by a shard are completed first
+	for _, sh := range s.shards {
+		responseChannel := make(chan struct{})
+		sh.requestChannel <- &request{closeOperation, &closeRequest{responseChannel}}
+		<-responseChannel
+	}
+
+	var err error
+	if s.wal != nil {
+		err = closeWAL(s.wal)
+	}
+
+	closeWatchers(s.watches)
+	closeFeed(s.feed)
+
+	if namespacesErr := closeNamespaces(s); err == nil {
+		err = namespacesErr
+	}
+
+	return err
 }
 
 // Read returns the value of the specified key, and a flag
 // indicating if the key was present.
 //
-// Any user can read a key's value.
-func Read(s *KVStore, key string) (string, bool) {
-	responseChannel := make(chan *readResponse)
-	s.requestChannel <- &request{readOperation, &readRequest{key, responseChannel}}
+// Any user can read a key's value, unless the key's ACL makes it private.
+func Read(s *KVStore, key string, username string) ([]byte, bool) {
+	value, ok, _ := ReadContext(context.Background(), s, key, username)
+
+	return value, ok
+}
+
+// ReadContext is the same as Read, but gives up if the context is done first, or the store is closed,
+// and returns ErrPermissionDenied if the user can't read the key.
+func ReadContext(ctx context.Context, s *KVStore, key string, username string) ([]byte, bool, error) {
+	item, ok, err := ReadItemContext(ctx, s, key, username)
+	if !ok {
+		return nil, false, err
+	}
+
+	return item.Value, true, nil
+}
+
+// ReadItem returns the value and version of the specified key, and a flag
+// indicating if the key was present.
+//
+// Any user can read a key's value, unless the key's ACL makes it private.
+func ReadItem(s *KVStore, key string, username string) (*Item, bool) {
+	item, ok, _ := ReadItemContext(context.Background(), s, key, username)
+
+	return item, ok
+}
+
+// ReadItemContext is the same as ReadItem, but gives up if the context is done first, or the store is closed,
+// and returns ErrPermissionDenied if the user can't read the key.
+func ReadItemContext(ctx context.Context, s *KVStore, key string, username string) (*Item, bool, error) {
+	if err := linearize(ctx, s); err != nil {
+		return nil, false, err
+	}
 
-	response := <-responseChannel
+	responseChannel := make(chan *readResponse, 1)
+	if err := sendRequest(ctx, shardFor(s, key), readOperation,
+		&readRequest{key, username, responseChannel}); err != nil {
+		return nil, false, err
+	}
 
-	return response.value, response.ok
+	select {
+	case response := <-responseChannel:
+		return response.item, response.ok, response.err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
 }
 
 // Write sets or updates the key value.
 //
-// Only the owning user can update an existing entry.
-func Write(s *KVStore, key string, value string, username string) error {
-	responseChannel := make(chan *writeResponse)
-	s.requestChannel <- &request{writeOperation, &writeRequest{key, value, username, responseChannel}}
+// Only the owning user, or those granted write permission, can update an existing entry.
+func Write(s *KVStore, key string, value []byte, username string) error {
+	return WriteWithOptionsContext(context.Background(), s, key, value, username, WriteOptions{})
+}
 
-	response := <-responseChannel
+// WriteContext is the same as Write, but gives up if the context is done first, or the store is closed.
+func WriteContext(ctx context.Context, s *KVStore, key string, value []byte, username string) error {
+	return WriteWithOptionsContext(ctx, s, key, value, username, WriteOptions{})
+}
 
-	return response.err
+// WriteWithOptions sets or updates the key value, using the specified options. Any previous
+// time-to-live is replaced by the one in the options.
+//
+// Only the owning user, or those granted write permission, can update an existing entry.
+func WriteWithOptions(s *KVStore, key string, value []byte, username string, options WriteOptions) error {
+	return WriteWithOptionsContext(context.Background(), s, key, value, username, options)
+}
+
+// WriteWithOptionsContext is the same as WriteWithOptions, but gives up if the context is done first,
+// or the store is closed. If the context is done once the write has been passed to the store, the write
+// may still be made.
+func WriteWithOptionsContext(ctx context.Context, s *KVStore, key string, value []byte, username string,
+	options WriteOptions) error {
+	responseChannel := make(chan *writeResponse, 1)
+	if err := sendRequest(ctx, shardFor(s, key), writeOperation,
+		&writeRequest{key, value, username, options, responseChannel}); err != nil {
+		return err
+	}
+
+	select {
+	case response := <-responseChannel:
+		if response.err == nil {
+			enforceLimits(ctx, s, key)
+		}
+
+		return response.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // Delete removes a key, and returns a flag
 // indicating if the key was deleted.
 //
-// Only the owning user can delete a key.
+// Only the owning user, or those granted delete permission, can delete a key.
 func Delete(s *KVStore, key string, username string) (bool, error) {
-	responseChannel := make(chan *deleteResponse)
-	s.requestChannel <- &request{deleteOperation, &deleteRequest{key, username, responseChannel}}
+	return DeleteWithOptionsContext(context.Background(), s, key, username, DeleteOptions{})
+}
+
+// DeleteContext is the same as Delete, but gives up if the context is done first, or the store is closed.
+func DeleteContext(ctx context.Context, s *KVStore, key string, username string) (bool, error) {
+	return DeleteWithOptionsContext(ctx, s, key, username, DeleteOptions{})
+}
+
+// DeleteWithOptions removes a key using the specified options, and returns a flag
+// indicating if the key was deleted.
+//
+// Only the owning user, or those granted delete permission, can delete a key.
+func DeleteWithOptions(s *KVStore, key string, username string, options DeleteOptions) (bool, error) {
+	return DeleteWithOptionsContext(context.Background(), s, key, username, options)
+}
 
-	response := <-responseChannel
+// DeleteWithOptionsContext is the same as DeleteWithOptions, but gives up if the context is done first,
+// or the store is closed. If the context is done once the delete has been passed to the store, the key
+// may still be deleted.
+func DeleteWithOptionsContext(ctx context.Context, s *KVStore, key string, username string,
+	options DeleteOptions) (bool, error) {
+	responseChannel := make(chan *deleteResponse, 1)
+	if err := sendRequest(ctx, shardFor(s, key), deleteOperation,
+		&deleteRequest{key, username, options, responseChannel}); err != nil {
+		return false, err
+	}
 
-	return response.deleted, response.err
+	select {
+	case response := <-responseChannel:
+		return response.deleted, response.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
 }
 
 // List returns the value and owner of the specified key, or nil if not present.
 //
-// Any user can list a key's value.
-func List(s *KVStore, key string) *EntryInfo {
-	responseChannel := make(chan *listResponse)
-	s.requestChannel <- &request{listOperation, &listRequest{key, responseChannel}}
+// Any user can list a key, unless the key's ACL makes it private.
+func List(s *KVStore, key string, username string) *EntryInfo {
+	entryInfo, _ := ListContext(context.Background(), s, key, username)
 
-	response := <-responseChannel
+	return entryInfo
+}
+
+// ListContext is the same as List, but gives up if the context is done first, or the store is closed,
+// and returns ErrPermissionDenied if the user can't read the key.
+func ListContext(ctx context.Context, s *KVStore, key string, username string) (*EntryInfo, error) {
+	if err := linearize(ctx, s); err != nil {
+		return nil, err
+	}
 
-	return response.entryInfo
+	responseChannel := make(chan *listResponse, 1)
+	if err := sendRequest(ctx, shardFor(s, key), listOperation,
+		&listRequest{key, username, responseChannel}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-responseChannel:
+		return response.entryInfo, response.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
 // ListAll returns the value and owner of all keys.
 //
-// Any user can list all keys.
-func ListAll(s *KVStore) []*EntryInfo {
-	responseChannel := make(chan *listAllResponse)
-	s.requestChannel <- &request{listAllOperation, &listAllRequest{responseChannel}}
+// Any user can list all keys, but private keys the user can't read are left out.
+func ListAll(s *KVStore, username string) []*EntryInfo {
+	entries, _ := ListAllContext(context.Background(), s, username)
 
-	response := <-responseChannel
+	return entries
+}
+
+// ListAllContext is the same as ListAll, but gives up if the context is done first, or the store is closed.
+func ListAllContext(ctx context.Context, s *KVStore, username string) ([]*EntryInfo, error) {
+	if err := linearize(ctx, s); err != nil {
+		return nil, err
+	}
 
-	return response.entries
+	// ask every shard at once, then gather up their responses
+	responseChannel := make(chan *listAllResponse, len(s.shards))
+	for _, sh := range s.shards {
+		if err := sendRequest(ctx, sh, listAllOperation, &listAllRequest{username, responseChannel}); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make([]*EntryInfo, 0)
+
+	for range s.shards {
+		select {
+		case response := <-responseChannel:
+			entries = append(entries, response.entries...)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return entries, nil
+}
+
+// sendRequest passes the request to the shard's go routine, unless the context is done first or the store
+// is closed. Any response channel in the request must be buffered, so that the shard's go routine isn't
+// held up if the caller gives up waiting for the response.
+func sendRequest(ctx context.Context, sh *shard, op operation, params interface{}) error {
+	// don't leave it to chance which case is picked if the context is already done
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case sh.requestChannel <- &request{op, params}:
+		return nil
+	case <-sh.store.closed:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-// handleStoreOperations provides thread-safety for the key value store, by performing operations
-// on the store in a single go routine in serial, with input provided through messages on a channel.
-func handleStoreOperations(s *KVStore) {
+// handleStoreOperations provides thread-safety for a shard of the key value store, by performing operations
+// on the shard in a single go routine in serial, with input provided through messages on a channel.
+func handleStoreOperations(sh *shard) {
 	go func() {
+		expiryInterval := sh.store.config.ExpiryInterval
+		if expiryInterval <= 0 {
+			expiryInterval = defaultExpiryInterval
+		}
+
+		expiryTicker := time.NewTicker(expiryInterval)
+		defer expiryTicker.Stop()
+
 		for {
-			request := <-s.requestChannel
+			var request *request
+
+			select {
+			case <-expiryTicker.C:
+				removeExpiredEntries(sh)
+				compactHistory(sh)
+
+				continue
+
+			case request = <-sh.requestChannel:
+			}
+
 			switch request.op {
 			case readOperation:
 				params, ok := request.params.(*readRequest)
 				if ok {
-					if entry, ok := s.data[params.key]; ok {
-						// key is present
-						entry.Reads++
-						entry.LastAccesed = time.Now()
-						params.responseChannel <- &readResponse{entry.Value, true}
-					} else {
+					entry, ok := lookupEntry(sh, params.key)
+
+					switch {
+					case !ok:
 						// key not present
-						params.responseChannel <- &readResponse{"", false}
+						params.responseChannel <- &readResponse{nil, false, nil}
+					case !hasPermission(entry, params.username, sh.store.groups, PermissionRead):
+						params.responseChannel <- &readResponse{nil, false, ErrPermissionDenied}
+					default:
+						// key is present
+						touchEntry(sh, params.key, entry, time.Now())
+						params.responseChannel <- &readResponse{newItem(entry), true, nil}
 					}
 				}
 
 			case writeOperation:
 				params, ok := request.params.(*writeRequest)
 				if ok {
-					if existingEntry, ok := s.data[params.key]; ok {
-						if existingEntry.Owner == params.username {
-							// owner updating key
-							existingEntry.Value = params.value
-							existingEntry.Writes++
-							existingEntry.LastAccesed = time.Now()
-							params.responseChannel <- &writeResponse{nil}
-						} else {
-							// someone else updating key
-							params.responseChannel <- &writeResponse{errUpdateSameUser}
-						}
-					} else {
-						// new key
-						s.data[params.key] = &entry{params.value, params.username, 0, 1, time.Now()}
-						params.responseChannel <- &writeResponse{nil}
-					}
+					params.responseChannel <- &writeResponse{writeEntry(sh, params)}
 				}
 
 			case deleteOperation:
 				params, ok := request.params.(*deleteRequest)
 				if ok {
-					if entry, ok := s.data[params.key]; ok {
-						if entry.Owner == params.username {
-							// owner deleting key
-							delete(s.data, params.key)
-							params.responseChannel <- &deleteResponse{true, nil}
-						} else {
-							// someone else deleting key
-							params.responseChannel <- &deleteResponse{false, errDeleteSameUser}
-						}
-					} else {
-						// key not present
-						params.responseChannel <- &deleteResponse{false, nil}
-					}
+					deleted, err := deleteEntry(sh, params)
+					params.responseChannel <- &deleteResponse{deleted, err}
 				}
 
 			case listOperation:
 				params, ok := request.params.(*listRequest)
 				if ok {
-					if entry, ok := s.data[params.key]; ok {
-						// key is present
-						params.responseChannel <- &listResponse{
-							&EntryInfo{params.key, entry.Owner, entry.Writes, entry.Reads,
-								time.Since(entry.LastAccesed).Milliseconds()}}
-					} else {
+					entry, ok := lookupEntry(sh, params.key)
+
+					switch {
+					case !ok:
 						// key not present
-						params.responseChannel <- &listResponse{nil}
+						params.responseChannel <- &listResponse{nil, nil}
+					case !hasPermission(entry, params.username, sh.store.groups, PermissionRead):
+						params.responseChannel <- &listResponse{nil, ErrPermissionDenied}
+					default:
+						params.responseChannel <- &listResponse{newEntryInfo(params.key, entry, time.Now()), nil}
 					}
 				}
 
 			case listAllOperation:
 				params, ok := request.params.(*listAllRequest)
 				if ok {
-					// export all entries (if any) into a slice to return
-					entries := make([]*EntryInfo, 0, len(s.data))
-					for key, entry := range s.data {
-						entries = append(entries, &EntryInfo{key, entry.Owner, entry.Writes, entry.Reads,
-							time.Since(entry.LastAccesed).Milliseconds()})
+					// export all unexpired entries (if any) into a slice to return
+					now := time.Now()
+					entries := make([]*EntryInfo, 0, len(sh.data))
+					for key, entry := range sh.data {
+						if !hasExpired(entry, now) && !hasLapsed(sh.store, entry, now) &&
+							hasPermission(entry, params.username, sh.store.groups, PermissionRead) {
+							entries = append(entries, newEntryInfo(key, entry, now))
+						}
 					}
 					params.responseChannel <- &listAllResponse{entries}
 				}
 
+			case scanOperation:
+				params, ok := request.params.(*scanRequest)
+				if ok {
+					params.responseChannel <- &scanResponse{scanEntries(sh, params)}
+				}
+
+			case aclOperation:
+				params, ok := request.params.(*aclRequest)
+				if ok {
+					params.responseChannel <- handleACL(sh, params)
+				}
+
+			case transferOperation:
+				params, ok := request.params.(*transferRequest)
+				if ok {
+					params.responseChannel <- transferEntry(sh, params)
+				}
+
+			case incrementOperation:
+				params, ok := request.params.(*incrementRequest)
+				if ok {
+					params.responseChannel <- incrementEntry(sh, params)
+				}
+
+			case patchOperation:
+				params, ok := request.params.(*patchRequest)
+				if ok {
+					params.responseChannel <- patchEntry(sh, params)
+				}
+
+			case collectionOperation:
+				params, ok := request.params.(*collectionRequest)
+				if ok {
+					params.responseChannel <- handleCollection(sh, params)
+				}
+
+			case historyOperation:
+				params, ok := request.params.(*historyRequest)
+				if ok {
+					params.responseChannel <- handleHistory(sh, params)
+				}
+
+			case parkOperation:
+				params, ok := request.params.(*parkRequest)
+				if ok {
+					// hand over the shard until released
+					params.parked <- struct{}{}
+					<-params.release
+				}
+
 			case closeOperation:
+				params, ok := request.params.(*closeRequest)
+				if ok {
+					params.responseChannel <- struct{}{}
+				}
+
 				return
 			}
 		}
 	}()
 }
+
+// snapshotPeriodically takes a snapshot at the specified interval, until the store is closed.
+func snapshotPeriodically(s *KVStore, interval time.Duration) {
+	defer close(s.snapshotsDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// each namespace takes its own snapshots
+			if err := snapshotStore(s); err != nil {
+				s.logger.Println("Unable to take snapshot: ", err)
+			}
+		case <-s.stopSnapshots:
+			return
+		}
+	}
+}
+
+// writeEntry sets or updates the key value, if permitted.
+func writeEntry(sh *shard, params *writeRequest) error {
+	existingEntry, _ := lookupEntry(sh, params.key)
+
+	now := time.Now()
+
+	updatedEntry, err := prepareStoreWrite(sh.store, existingEntry, params.value, params.username,
+		params.options, now)
+	if err != nil {
+		return err
+	}
+
+	if params.options.Lease != 0 {
+		if err = attachLease(sh.store, params.options.Lease, params.key, params.username, now); err != nil {
+			return err
+		}
+
+		updatedEntry.Lease = params.options.Lease
+	}
+
+	return storeEntry(sh.store, params.key, updatedEntry)
+}
+
+// prepareStoreWrite is the same as prepareWrite, but also applies the store's default time-to-live to writes
+// without one, and its default access control list to new keys.
+func prepareStoreWrite(s *KVStore, existingEntry *entry, value []byte, username string, options WriteOptions,
+	now time.Time) (*entry, error) {
+	if options.TTL <= 0 {
+		options.TTL = s.config.DefaultTTL
+	}
+
+	if err := checkDocument(options.ContentType, value); err != nil {
+		return nil, err
+	}
+
+	updatedEntry, err := prepareWrite(existingEntry, value, username, s.groups, options, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if existingEntry == nil && s.config.DefaultACL != nil {
+		updatedEntry.ACL = copyACL(s.config.DefaultACL)
+	}
+
+	return updatedEntry, nil
+}
+
+// prepareWrite returns the new state of a key after writing the value, if permitted.
+// The key's current entry is nil if the key is not present.
+func prepareWrite(existingEntry *entry, value []byte, username string, groups userGroups, options WriteOptions,
+	now time.Time) (*entry, error) {
+	expiresAt := time.Time{}
+
+	if options.TTL > 0 {
+		expiresAt = now.Add(options.TTL)
+	}
+
+	if existingEntry == nil {
+		if !checkCondition(options.Condition, nil) {
+			return nil, ErrVersionMismatch
+		}
+
+		// new key
+		return &entry{
+			Value:           copyBytes(value),
+			ContentType:     options.ContentType,
+			ContentEncoding: options.ContentEncoding,
+			Owner:           username,
+			Writes:          1,
+			LastAccesed:     now,
+			ExpiresAt:       expiresAt,
+		}, nil
+	}
+
+	if !options.Override && !hasPermission(existingEntry, username, groups, PermissionWrite) {
+		// someone else updating key
+		return nil, errUpdateSameUser
+	}
+
+	if !checkCondition(options.Condition, existingEntry) {
+		return nil, ErrVersionMismatch
+	}
+
+	// owner (or user with permission) updating key
+	return &entry{
+		Value:           copyBytes(value),
+		ContentType:     options.ContentType,
+		ContentEncoding: options.ContentEncoding,
+		Owner:           existingEntry.Owner,
+		Reads:           existingEntry.Reads,
+		Writes:          existingEntry.Writes + 1,
+		LastAccesed:     now,
+		ExpiresAt:       expiresAt,
+		ACL:             existingEntry.ACL,
+	}, nil
+}
+
+// deleteEntry removes the key, if permitted, returning whether it was present.
+func deleteEntry(sh *shard, params *deleteRequest) (bool, error) {
+	existingEntry, ok := lookupEntry(sh, params.key)
+
+	if err := prepareDelete(existingEntry, params.username, sh.store.groups, params.options); err != nil {
+		return false, err
+	}
+
+	if !ok {
+		// key not present
+		return false, nil
+	}
+
+	// owner (or user with permission) deleting key
+	if err := removeEntry(sh.store, params.key); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// prepareDelete checks whether the key can be deleted. The key's current entry is nil if the key is not present,
+// which is not an error unless the delete was conditional on the key being present.
+func prepareDelete(existingEntry *entry, username string, groups userGroups, options DeleteOptions) error {
+	if existingEntry != nil && !options.Override &&
+		!hasPermission(existingEntry, username, groups, PermissionDelete) {
+		// someone else deleting key
+		return errDeleteSameUser
+	}
+
+	if !checkCondition(options.Condition, existingEntry) {
+		return ErrVersionMismatch
+	}
+
+	return nil
+}
+
+// storeEntry records the new state of a single key, see commitChanges.
+func storeEntry(s *KVStore, key string, updatedEntry *entry) error {
+	return commitChanges(s, []change{{key, updatedEntry}})
+}
+
+// removeEntry records the deletion of a single key, see commitChanges.
+func removeEntry(s *KVStore, key string) error {
+	return commitChanges(s, []change{{key, nil}})
+}
+
+// commitChanges gives the changes the next revision number, and records them in the write-ahead log
+// (if enabled) as a single record, then updates the store. The store is left unchanged if the changes
+// would take a user over their quota, or could not be persisted. The caller must be handling the shard of every
+// changed key, either from the shard's own go routine or by having parked it, and once it has let the shards go
+// should call enforceLimits in case the changes took the store over its size limits.
+func commitChanges(s *KVStore, changes []change) error {
+	return commitChangesAs(s, changes, MutationDelete)
+}
+
+// commitChangesAs is the same as commitChanges, but records any keys removed in the change feed as the
+// removal type, such as for keys that have expired.
+func commitChangesAs(s *KVStore, changes []change, removal MutationType) error {
+	_, err := applyChanges(s, changes, removal)
+
+	return err
+}
+
+// applyChanges makes the changes as a single revision of the store, returning the revision, see
+// commitChangesAs. If the store is part of a cluster, the changes are only made once committed by the cluster.
+// Since every node must make each change the cluster commits, the quota is checked before the changes are
+// proposed rather than as they're made, so concurrent changes to different shards can together take a user a
+// little over their quota.
+func applyChanges(s *KVStore, changes []change, removal MutationType) (uint64, error) {
+	if s.config.Follower {
+		return 0, ErrReadOnly
+	}
+
+	now := time.Now()
+
+	if s.consensus == nil {
+		return commitRevision(s, changes, removal, now, true)
+	}
+
+	s.commitMutex.Lock()
+	err := checkQuota(s, changes)
+	s.commitMutex.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	applied, err := proposeChanges(s, &ReplicationRecord{Changes: changes, Removal: removal, Time: now})
+	if err != nil {
+		return 0, err
+	}
+
+	defer applied()
+
+	return commitRevision(s, changes, removal, now, false)
+}
+
+// pendingRevision is a revision which has been given to a change, but not yet reported.
+type pendingRevision struct {
+	revision uint64
+	written  int64
+	// previous is closed once the revision before has been reported, and done once this one has
+	previous chan struct{}
+	done     chan struct{}
+}
+
+// commitRevision gives the changes the next revision number (first checking the quota if required), and records
+// them in the write-ahead log (if enabled), then updates the store and tells watchers and the change feed,
+// returning the revision. Only the first part is serialised by the commit mutex, after which changes to other
+// shards can be made at the same time, and the caller must not hold it.
+func commitRevision(s *KVStore, changes []change, removal MutationType, now time.Time,
+	withQuota bool) (uint64, error) {
+	pending, err := reserveRevision(s, changes, withQuota)
+	if err != nil {
+		return 0, err
+	}
+
+	if s.wal != nil {
+		if err = syncWrites(s.wal, pending.written); err != nil {
+			// the revision has been used, so it's still reported, but without the changes
+			s.commitMutex.Lock()
+			updateUsage(s, changes, -1)
+			s.commitMutex.Unlock()
+
+			reportRevision(s, pending, now, nil, removal)
+
+			return 0, err
+		}
+	}
+
+	for _, keyChange := range changes {
+		sh := shardFor(s, keyChange.Key)
+		recordHistory(sh, keyChange.Key, pending.revision, now, keyChange.Entry)
+
+		if keyChange.Entry == nil {
+			removeKey(sh, keyChange.Key)
+		} else {
+			setEntry(sh, keyChange.Key, keyChange.Entry)
+			scheduleExpiry(sh, keyChange.Key, keyChange.Entry)
+		}
+	}
+
+	reportRevision(s, pending, now, changes, removal)
+
+	return pending.revision, nil
+}
+
+// reserveRevision gives the changes the next revision number, writes them to the write-ahead log (if enabled),
+// and updates the owners' usage, under the commit mutex.
+func reserveRevision(s *KVStore, changes []change, withQuota bool) (*pendingRevision, error) {
+	s.commitMutex.Lock()
+	defer s.commitMutex.Unlock()
+
+	if withQuota {
+		if err := checkQuota(s, changes); err != nil {
+			return nil, err
+		}
+	}
+
+	pending := &pendingRevision{revision: s.revision + 1, previous: s.reported, done: make(chan struct{})}
+
+	for _, keyChange := range changes {
+		if keyChange.Entry != nil {
+			keyChange.Entry.Version = pending.revision
+		}
+	}
+
+	if s.wal != nil {
+		written, err := appendToWAL(s.wal, &walRecord{pending.revision, changes})
+		if err != nil {
+			return nil, err
+		}
+
+		pending.written = written
+	}
+
+	s.revision = pending.revision
+	s.reported = pending.done
+	updateUsage(s, changes, 1)
+
+	return pending, nil
+}
+
+// reportRevision tells watchers and the change feed of the revision's changes, once the revision before has
+// been reported.
+func reportRevision(s *KVStore, pending *pendingRevision, now time.Time, changes []change, removal MutationType) {
+	<-pending.previous
+
+	publishEvents(s.watches, changeEvents(pending.revision, changes))
+	recordRevision(s.feed, newReplicationRecord(pending.revision, now, changes, removal))
+
+	close(pending.done)
+}
+
+// updateUsage adds the changes to (or with a sign of -1, takes them off) their owners' usage, in place of
+// the entries they replace. The caller must hold the commit mutex, and be handling the shard of every changed key,
+// which must not have been changed yet.
+func updateUsage(s *KVStore, changes []change, sign int) {
+	// the entry each key has before the changes, which for a key changed more than once is the earlier change
+	replaced := make(map[string]*entry, len(changes))
+
+	for _, keyChange := range changes {
+		existingEntry, ok := replaced[keyChange.Key]
+		if !ok {
+			existingEntry = shardFor(s, keyChange.Key).data[keyChange.Key]
+		}
+
+		if existingEntry != nil {
+			addUsage(s, keyChange.Key, existingEntry, -sign)
+		}
+
+		if keyChange.Entry != nil {
+			addUsage(s, keyChange.Key, keyChange.Entry, sign)
+		}
+
+		replaced[keyChange.Key] = keyChange.Entry
+	}
+}
+
+// setEntry sets the key's entry, adding the key to the ordered index if it's new, and updates the shard's
+// size and its eviction order. The owners' usage is updated separately, see updateUsage.
+func setEntry(sh *shard, key string, e *entry) {
+	if existingEntry, ok := sh.data[key]; ok {
+		sh.bytes -= entrySize(key, existingEntry)
+	} else {
+		sh.index.insert(key)
+	}
+
+	sh.data[key] = e
+	sh.bytes += entrySize(key, e)
+	queueEviction(sh, key, e)
+}
+
+// removeKey removes the key's entry, takes the key out of the ordered index and the eviction order, and updates
+// the shard's size.
+func removeKey(sh *shard, key string) {
+	if existingEntry, ok := sh.data[key]; ok {
+		delete(sh.data, key)
+		sh.index.remove(key)
+		dequeueEviction(sh, key)
+		sh.bytes -= entrySize(key, existingEntry)
+	}
+}
+
+// newEntryInfo returns the details of an entry, as reported to users.
+func newEntryInfo(key string, e *entry, now time.Time) *EntryInfo {
+	return &EntryInfo{
+		Key:         key,
+		Owner:       e.Owner,
+		Version:     e.Version,
+		Size:        len(e.Value),
+		ContentType: e.ContentType,
+		Type:        e.Type,
+		Writes:      e.Writes,
+		Reads:       e.Reads,
+		Age:         now.Sub(e.LastAccesed).Milliseconds(),
+		TTL:         remainingTTL(e, now),
+		Lease:       e.Lease,
+	}
+}
+
+// newItem returns a key's value and its details, as returned by a read. The value is copied,
+// so that the stored value can't be changed through it.
+func newItem(e *entry) *Item {
+	return &Item{copyBytes(e.Value), e.Version, e.ContentType, e.ContentEncoding}
+}
+
+// copyBytes returns a copy of the bytes, so that a value held in the store is never shared with callers.
+func copyBytes(bytes []byte) []byte {
+	return append([]byte{}, bytes...)
+}
+
+// applyRecord replays a change read from the write-ahead log.
+func applyRecord(s *KVStore, record *walRecord) {
+	if record.Revision > s.revision {
+		s.revision = record.Revision
+	}
+
+	updateUsage(s, record.Changes, 1)
+
+	for _, keyChange := range record.Changes {
+		if keyChange.Entry == nil {
+			removeKey(shardFor(s, keyChange.Key), keyChange.Key)
+		} else {
+			setEntry(shardFor(s, keyChange.Key), keyChange.Key, keyChange.Entry)
+		}
+	}
+}
@@ -1,21 +1,33 @@
 package kvstore_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
 	"store/pkg/kvstore"
 	"testing"
 )
 
 const key1 = "key1"
 const key2 = "key2"
-const value1 = "ABC"
-const value2 = "DEF"
+
+var value1 = []byte("ABC")
+var value2 = []byte("DEF")
+
 const user1 = "user1"
 const user2 = "user2"
 
+// spread keys across several shards, to check operations work the same regardless
+const testShards = 4
+
 func TestEmptyStoreRead(t *testing.T) {
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(testShards)
 
-	value, ok := kvstore.Read(store, key1)
+	value, ok := kvstore.Read(store, key1, user1)
 	if ok {
 		t.Fatalf("Should have been empty but was: %t value %s", ok, value)
 	}
@@ -24,18 +36,18 @@ func TestEmptyStoreRead(t *testing.T) {
 }
 
 func TestSimpleReadAndWrite(t *testing.T) {
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(testShards)
 
 	err := kvstore.Write(store, key1, value1, user1)
 	if err != nil {
 		t.Fatal("Write should have been successful but got:", err)
 	}
 
-	value, ok := kvstore.Read(store, key1)
+	value, ok := kvstore.Read(store, key1, user1)
 	if !ok {
 		t.Fatalf("Key should have been present but was: %t (value %s)", ok, value)
 	}
-	if value != value1 {
+	if !bytes.Equal(value, value1) {
 		t.Fatalf("Key value should have been %s but was: %s", value1, value)
 	}
 
@@ -43,7 +55,7 @@ func TestSimpleReadAndWrite(t *testing.T) {
 }
 
 func TestUpdateByOwner(t *testing.T) {
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(testShards)
 
 	err := kvstore.Write(store, key1, value1, user1)
 	if err != nil {
@@ -55,11 +67,11 @@ func TestUpdateByOwner(t *testing.T) {
 		t.Fatal("Second write should have been successful but got:", err)
 	}
 
-	value, ok := kvstore.Read(store, key1)
+	value, ok := kvstore.Read(store, key1, user1)
 	if !ok {
 		t.Fatalf("Key should have been present but was: %t (value %s)", ok, value)
 	}
-	if value != value2 {
+	if !bytes.Equal(value, value2) {
 		t.Fatalf("Key value should have been %s but was: %s", value2, value)
 	}
 
@@ -67,7 +79,7 @@ func TestUpdateByOwner(t *testing.T) {
 }
 
 func TestUpdateByOtherUser(t *testing.T) {
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(testShards)
 
 	err := kvstore.Write(store, key1, value1, user1)
 	if err != nil {
@@ -79,11 +91,11 @@ func TestUpdateByOtherUser(t *testing.T) {
 		t.Fatal("Second write should have failed")
 	}
 
-	value, ok := kvstore.Read(store, key1)
+	value, ok := kvstore.Read(store, key1, user1)
 	if !ok {
 		t.Fatalf("Key should have been present but was: %t (value %s)", ok, value)
 	}
-	if value != value1 {
+	if !bytes.Equal(value, value1) {
 		t.Fatalf("Key value should have been %s but was: %s", value1, value)
 	}
 
@@ -91,7 +103,7 @@ func TestUpdateByOtherUser(t *testing.T) {
 }
 
 func TestEmptyStoreDelete(t *testing.T) {
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(testShards)
 
 	ok, err := kvstore.Delete(store, key1, user1)
 	if ok {
@@ -102,7 +114,7 @@ func TestEmptyStoreDelete(t *testing.T) {
 }
 
 func TestDeleteByOwner(t *testing.T) {
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(testShards)
 
 	err := kvstore.Write(store, key1, value1, user1)
 	if err != nil {
@@ -114,7 +126,7 @@ func TestDeleteByOwner(t *testing.T) {
 		t.Fatal("Delete should have been successful but got:", deleted, err)
 	}
 
-	value, ok := kvstore.Read(store, key1)
+	value, ok := kvstore.Read(store, key1, user1)
 	if ok {
 		t.Fatalf("Key should not be present but was: %t (value %s)", ok, value)
 	}
@@ -123,7 +135,7 @@ func TestDeleteByOwner(t *testing.T) {
 }
 
 func TestDeleteByOtherUser(t *testing.T) {
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(testShards)
 
 	err := kvstore.Write(store, key1, value1, user1)
 	if err != nil {
@@ -135,7 +147,7 @@ func TestDeleteByOtherUser(t *testing.T) {
 		t.Fatal("Delete should not be successful but got:", deleted, err)
 	}
 
-	value, ok := kvstore.Read(store, key1)
+	value, ok := kvstore.Read(store, key1, user1)
 	if !ok {
 		t.Fatalf("Key should still be present but was: %t (value %s)", ok, value)
 	}
@@ -144,14 +156,14 @@ func TestDeleteByOtherUser(t *testing.T) {
 }
 
 func TestSimpleListKey(t *testing.T) {
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(testShards)
 
 	err := kvstore.Write(store, key1, value1, user1)
 	if err != nil {
 		t.Fatal("Write should have been successful but got:", err)
 	}
 
-	entryInfo := kvstore.List(store, key1)
+	entryInfo := kvstore.List(store, key1, user1)
 	if entryInfo == nil {
 		t.Fatal("Key should have been present but was not")
 	}
@@ -166,9 +178,9 @@ func TestSimpleListKey(t *testing.T) {
 }
 
 func TestEmptyListKey(t *testing.T) {
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(testShards)
 
-	entry := kvstore.List(store, key1)
+	entry := kvstore.List(store, key1, user1)
 	if entry != nil {
 		t.Fatal("Key should have not been present but was:", entry)
 	}
@@ -177,9 +189,9 @@ func TestEmptyListKey(t *testing.T) {
 }
 
 func TestEmptyListAll(t *testing.T) {
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(testShards)
 
-	entries := kvstore.ListAll(store)
+	entries := kvstore.ListAll(store, user1)
 	if len(entries) != 0 {
 		t.Fatal("ListAll should have been empty but was:", entries)
 	}
@@ -188,7 +200,7 @@ func TestEmptyListAll(t *testing.T) {
 }
 
 func TestSimpleListAll(t *testing.T) {
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(testShards)
 
 	err := kvstore.Write(store, key1, value1, user1)
 	if err != nil {
@@ -200,10 +212,179 @@ func TestSimpleListAll(t *testing.T) {
 		t.Fatal("Second write should have been successful but got:", err)
 	}
 
-	entries := kvstore.ListAll(store)
+	entries := kvstore.ListAll(store, user1)
 	if len(entries) != 2 {
 		t.Fatal("ListAll should have 2 entries but was:", entries)
 	}
 
 	kvstore.Close(store)
 }
+
+func TestListAllAcrossShards(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	for i := 0; i < 100; i++ {
+		kvstore.Write(store, fmt.Sprintf("key%d", i), value1, user1)
+	}
+
+	entries := kvstore.ListAll(store, user1)
+	if len(entries) != 100 {
+		t.Fatal("ListAll should have 100 entries but was:", len(entries))
+	}
+
+	kvstore.Close(store)
+}
+
+func BenchmarkParallelReadWrite(b *testing.B) {
+	const keyCount = 1000
+
+	keys := make([]string, keyCount)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+
+	for _, shards := range []int{1, 4, 16} {
+		// with the write-ahead log flushed on every write, writes to different shards share the flushes, which
+		// needs more than one processor to show (e.g. -cpu 4) so that others can run while one flushes
+		for _, logged := range []bool{false, true} {
+			b.Run(fmt.Sprintf("shards=%d/wal=%t", shards, logged), func(b *testing.B) {
+				benchmarkReadWrite(b, shards, logged, keys)
+			})
+		}
+	}
+}
+
+func benchmarkReadWrite(b *testing.B, shards int, logged bool, keys []string) {
+	config := kvstore.Config{Shards: shards}
+	if logged {
+		config.WALPath = filepath.Join(b.TempDir(), "store.wal")
+		config.SyncPolicy = kvstore.SyncEveryWrite
+	}
+
+	store, err := kvstore.NewKVStoreWithConfig(config)
+	if err != nil {
+		b.Fatal("Error opening store: ", err)
+	}
+
+	for _, key := range keys {
+		kvstore.Write(store, key, value1, user1)
+	}
+
+	b.SetParallelism(8)
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		random := rand.New(rand.NewSource(rand.Int63()))
+
+		for i := 0; pb.Next(); i++ {
+			key := keys[random.Intn(len(keys))]
+
+			// one write for every three reads
+			if i%4 == 0 {
+				kvstore.Write(store, key, value2, user1)
+			} else {
+				kvstore.Read(store, key, user1)
+			}
+		}
+	})
+
+	b.StopTimer()
+	kvstore.Close(store)
+}
+
+func TestOperationsAfterClose(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Close(store)
+
+	if err := kvstore.Write(store, key1, value2, user1); !errors.Is(err, kvstore.ErrClosed) {
+		t.Fatal("Write after close should have failed but got:", err)
+	}
+
+	if _, ok := kvstore.Read(store, key1, user1); ok {
+		t.Fatal("Read after close should have found nothing")
+	}
+
+	if _, err := kvstore.Transaction(store, []kvstore.TxnOp{{Type: kvstore.TxnGet, Key: key1}},
+		user1); !errors.Is(err, kvstore.ErrClosed) {
+		t.Fatal("Transaction after close should have failed but got:", err)
+	}
+
+	if _, err := kvstore.Watch(store, key1, false, 0, user1); !errors.Is(err, kvstore.ErrClosed) {
+		t.Fatal("Watch after close should have failed but got:", err)
+	}
+
+	if err := kvstore.Close(store); !errors.Is(err, kvstore.ErrClosed) {
+		t.Fatal("Second close should have failed but got:", err)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := kvstore.WriteContext(ctx, store, key1, value1, user1); !errors.Is(err, context.Canceled) {
+		t.Fatal("Write with cancelled context should have failed but got:", err)
+	}
+
+	if _, err := kvstore.ListAllContext(ctx, store, user1); !errors.Is(err, context.Canceled) {
+		t.Fatal("ListAll with cancelled context should have failed but got:", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestBinaryValuesSurviveJSON(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	binary := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff, 0xfe}
+	options := kvstore.WriteOptions{ContentType: "image/png", ContentEncoding: "gzip"}
+
+	watcher, _ := kvstore.Watch(store, key1, false, 0, user1)
+
+	if err := kvstore.WriteWithOptions(store, key1, binary, user1, options); err != nil {
+		t.Fatal("Write should have been successful but got:", err)
+	}
+
+	// each value is checked after a round trip through JSON, as it would be sent by the REST server
+	var entries []*kvstore.ScanEntry
+	roundTrip(t, kvstore.Scan(store, "", "", 0, user1), &entries)
+
+	if len(entries) != 1 || !bytes.Equal(entries[0].Value, binary) || entries[0].ContentType != "image/png" ||
+		entries[0].ContentEncoding != "gzip" {
+		t.Fatal("Scan should have returned the value and its content type but got:", entries)
+	}
+
+	results, _ := kvstore.Transaction(store, []kvstore.TxnOp{{Type: kvstore.TxnGet, Key: key1}}, user1)
+
+	var txnResults []*kvstore.TxnResult
+	roundTrip(t, results, &txnResults)
+
+	if !bytes.Equal(txnResults[0].Value, binary) || txnResults[0].ContentType != "image/png" {
+		t.Fatal("Transaction should have returned the value and its content type but got:", txnResults[0])
+	}
+
+	var event kvstore.Event
+	roundTrip(t, <-watcher.Events, &event)
+
+	if !bytes.Equal(event.Value, binary) || event.ContentType != "image/png" || event.ContentEncoding != "gzip" {
+		t.Fatal("Event should have had the value and its content type but got:", event)
+	}
+
+	kvstore.Close(store)
+}
+
+// roundTrip encodes the value as JSON, and decodes it into decoded.
+func roundTrip(t *testing.T, value interface{}, decoded interface{}) {
+	t.Helper()
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal("Value should have been encoded but got:", err)
+	}
+
+	if err := json.Unmarshal(encoded, decoded); err != nil {
+		t.Fatal("Value should have been decoded but got:", err)
+	}
+}
@@ -0,0 +1,402 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrLeaseNotFound is returned when a lease isn't held, either because it was never granted or because it
+	// has since expired or been released.
+	ErrLeaseNotFound = errors.New("lease not found")
+	// ErrLeaseHeld is returned when acquiring a named lease (a lock) which is already held.
+	ErrLeaseHeld = errors.New("lease already held")
+	// ErrInvalidLeaseTTL is returned when granting a lease without a positive time-to-live.
+	ErrInvalidLeaseTTL = errors.New("lease time-to-live must be positive")
+)
+
+var (
+	errRenewSameUser   = fmt.Errorf("%w: cannot renew lease held by someone else", ErrPermissionDenied)
+	errReleaseSameUser = fmt.Errorf("%w: cannot release lease held by someone else", ErrPermissionDenied)
+	errAttachSameUser  = fmt.Errorf("%w: cannot attach key to lease held by someone else", ErrPermissionDenied)
+)
+
+// Lease provides details on a lease held by a user.
+type Lease struct {
+	// ID identifies the lease, and is also its fencing token: each lease is given the store's next revision
+	// as its ID, so a lease acquired later always has a higher ID than any acquired before it, even across
+	// restarts of a persistent store.
+	ID uint64 `json:"id"`
+	// Name (if set) makes the lease a lock, which only one lease can hold at a time.
+	Name  string `json:"name,omitempty"`
+	Owner string `json:"owner"`
+	// TTL is how long the lease has left before it expires, in milliseconds.
+	TTL int64 `json:"ttl"`
+	// Keys is how many keys have been attached to the lease.
+	Keys int `json:"keys"`
+}
+
+// lease is the state of a lease while it's held.
+type lease struct {
+	id        uint64
+	name      string
+	owner     string
+	ttl       time.Duration
+	expiresAt time.Time
+	// keys that have been attached to the lease, some of which may since have been deleted or detached
+	keys map[string]struct{}
+}
+
+// GrantLease gives the user a new lease, which expires once the time-to-live has passed unless renewed
+// before then. If a name is given the lease is a lock, and ErrLeaseHeld is returned if another lease with
+// the same name is still held. Keys can be attached to the lease when written, see WriteOptions, so that
+// they are removed when the lease expires or is released.
+//
+// Leases are held in memory only, so keys attached to a lease are removed when a persistent store is opened
+// again.
+func GrantLease(s *KVStore, name string, ttl time.Duration, username string) (*Lease, error) {
+	if ttl <= 0 {
+		return nil, ErrInvalidLeaseTTL
+	}
+
+	if isClosed(s) {
+		return nil, ErrClosed
+	}
+
+	for {
+		// don't give out an ID if the name is already held
+		s.leaseMutex.Lock()
+		held := leaseNameHeld(s, name, time.Now())
+		s.leaseMutex.Unlock()
+
+		if held {
+			return nil, fmt.Errorf("%w: %s", ErrLeaseHeld, name)
+		}
+
+		// the ID is given out without holding the lease mutex, as it's a change to the store
+		id, err := nextLeaseID(s)
+		if err != nil {
+			return nil, err
+		}
+
+		if granted, ok, grantErr := grantLeaseID(s, id, name, ttl, username); ok || grantErr != nil {
+			return granted, grantErr
+		}
+	}
+}
+
+// grantLeaseID gives the user the lease with the ID, returning false if another lease has since been given the
+// name with a later ID, in which case the lease needs a later ID still so that the IDs of the name's holders
+// (used as fencing tokens) keep going up.
+func grantLeaseID(s *KVStore, id uint64, name string, ttl time.Duration, username string) (*Lease, bool, error) {
+	now := time.Now()
+
+	s.leaseMutex.Lock()
+	defer s.leaseMutex.Unlock()
+
+	if leaseNameHeld(s, name, now) {
+		return nil, false, fmt.Errorf("%w: %s", ErrLeaseHeld, name)
+	}
+
+	if name != "" && s.leaseNames[name] > id {
+		return nil, false, nil
+	}
+
+	granted := &lease{id, name, username, ttl, now.Add(ttl), make(map[string]struct{})}
+	s.leases[id] = granted
+
+	// any previous holder of the name has expired, and is left for expireLeases to clear up
+	if name != "" {
+		s.leaseNames[name] = id
+	}
+
+	return newLease(granted, now), true, nil
+}
+
+// leaseNameHeld returns whether the name is that of a lease still held. The caller must hold the lease mutex.
+func leaseNameHeld(s *KVStore, name string, now time.Time) bool {
+	if name == "" {
+		return false
+	}
+
+	held, ok := s.leases[s.leaseNames[name]]
+
+	return ok && now.Before(held.expiresAt)
+}
+
+// ReadLease returns the details of the lease, and a flag indicating if it's still held.
+//
+// Any user can read a lease.
+func ReadLease(s *KVStore, id uint64) (*Lease, bool) {
+	now := time.Now()
+
+	s.leaseMutex.Lock()
+	defer s.leaseMutex.Unlock()
+
+	held, ok := s.leases[id]
+	if !ok || !now.Before(held.expiresAt) {
+		return nil, false
+	}
+
+	return newLease(held, now), true
+}
+
+// ListLeases returns the details of every lease still held, in order of ID.
+//
+// Any user can list the leases.
+func ListLeases(s *KVStore) []*Lease {
+	now := time.Now()
+
+	s.leaseMutex.Lock()
+	defer s.leaseMutex.Unlock()
+
+	leases := make([]*Lease, 0, len(s.leases))
+	for _, held := range s.leases {
+		if now.Before(held.expiresAt) {
+			leases = append(leases, newLease(held, now))
+		}
+	}
+
+	sort.Slice(leases, func(i, j int) bool { return leases[i].ID < leases[j].ID })
+
+	return leases
+}
+
+// RenewLease keeps the lease alive, restarting its time-to-live. It returns ErrLeaseNotFound if the lease
+// has already expired or been released, in which case a new lease must be granted instead.
+//
+// Only the user holding the lease can renew it.
+func RenewLease(s *KVStore, id uint64, username string) (*Lease, error) {
+	now := time.Now()
+
+	s.leaseMutex.Lock()
+	defer s.leaseMutex.Unlock()
+
+	held, ok := s.leases[id]
+	if !ok || !now.Before(held.expiresAt) {
+		return nil, ErrLeaseNotFound
+	}
+
+	if held.owner != username {
+		return nil, errRenewSameUser
+	}
+
+	held.expiresAt = now.Add(held.ttl)
+
+	return newLease(held, now), nil
+}
+
+// ReleaseLease gives up the lease before it expires, removing any keys attached to it, and returns a
+// flag indicating if the lease was still held.
+//
+// Only the user holding the lease can release it.
+func ReleaseLease(s *KVStore, id uint64, username string) (bool, error) {
+	return ReleaseLeaseContext(context.Background(), s, id, username)
+}
+
+// ReleaseLeaseContext is the same as ReleaseLease, but gives up if the context is done first, or the store
+// is closed. The lease is released even if its keys are then not removed, in which case they are no longer
+// returned and are removed when the lease would have expired.
+func ReleaseLeaseContext(ctx context.Context, s *KVStore, id uint64, username string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+
+	s.leaseMutex.Lock()
+
+	held, ok := s.leases[id]
+	if !ok || !now.Before(held.expiresAt) {
+		s.leaseMutex.Unlock()
+
+		return false, nil
+	}
+
+	if held.owner != username {
+		s.leaseMutex.Unlock()
+
+		return true, errReleaseSameUser
+	}
+
+	// the keys are removed once the lease has gone, so no more can be attached
+	removeLease(s, held)
+	s.leaseMutex.Unlock()
+
+	if err := removeLeaseKeys(ctx, s, held, MutationDelete); err != nil {
+		// leave the keys to be removed along with the expired leases
+		s.leaseMutex.Lock()
+		held.expiresAt = now
+		s.leases[id] = held
+		s.leaseMutex.Unlock()
+
+		return true, err
+	}
+
+	return true, nil
+}
+
+// nextLeaseID records a new revision of the store without any changes, so that the revision can be used as a
+// lease's ID and fencing token. The caller must not hold the lease mutex, since in a cluster the revision is only
+// recorded once committed by the cluster.
+func nextLeaseID(s *KVStore) (uint64, error) {
+	return applyChanges(s, nil, MutationDelete)
+}
+
+// attachLease records the key as attached to the lease, if the user holds the lease.
+func attachLease(s *KVStore, id uint64, key string, username string, now time.Time) error {
+	s.leaseMutex.Lock()
+	defer s.leaseMutex.Unlock()
+
+	held, ok := s.leases[id]
+	if !ok || !now.Before(held.expiresAt) {
+		return fmt.Errorf("%w: %d", ErrLeaseNotFound, id)
+	}
+
+	if held.owner != username {
+		return errAttachSameUser
+	}
+
+	held.keys[key] = struct{}{}
+
+	return nil
+}
+
+// hasLapsed returns whether the entry is attached to a lease which has expired or been released. Leases are
+// only held by the leader, so a follower relies on the leader's removal of the keys being replicated.
+func hasLapsed(s *KVStore, e *entry, now time.Time) bool {
+	if e.Lease == 0 || s.config.Follower {
+		return false
+	}
+
+	s.leaseMutex.Lock()
+	defer s.leaseMutex.Unlock()
+
+	held, ok := s.leases[e.Lease]
+
+	return !ok || !now.Before(held.expiresAt)
+}
+
+// removeLease takes the lease out of the store's leases. The caller must hold the lease mutex.
+func removeLease(s *KVStore, held *lease) {
+	delete(s.leases, held.id)
+
+	if held.name != "" && s.leaseNames[held.name] == held.id {
+		delete(s.leaseNames, held.name)
+	}
+}
+
+// removeLeaseKeys removes the keys still attached to a lease which has been taken out of the store's leases,
+// as a single change to the store recorded in the change feed as the removal type.
+func removeLeaseKeys(ctx context.Context, s *KVStore, held *lease, removal MutationType) error {
+	if len(held.keys) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(held.keys))
+	for key := range held.keys {
+		keys = append(keys, key)
+	}
+
+	release, err := parkShards(ctx, shardsFor(s, keys))
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	changes := make([]change, 0, len(keys))
+
+	for _, key := range keys {
+		// ignore keys that have since been deleted, or written without the lease
+		if e, ok := shardFor(s, key).data[key]; ok && e.Lease == held.id {
+			changes = append(changes, change{key, nil})
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	return commitChangesAs(s, changes, removal)
+}
+
+// expireLeases removes every lease whose time-to-live has passed, along with the keys attached to it.
+func expireLeases(s *KVStore) {
+	now := time.Now()
+	expired := make([]*lease, 0)
+
+	s.leaseMutex.Lock()
+
+	for _, held := range s.leases {
+		if !now.Before(held.expiresAt) {
+			expired = append(expired, held)
+			removeLease(s, held)
+		}
+	}
+
+	s.leaseMutex.Unlock()
+
+	for _, held := range expired {
+		if err := removeLeaseKeys(context.Background(), s, held, MutationExpire); err != nil {
+			// the keys remain in the store for now, but are never returned since their lease has expired
+			s.logger.Printf("Unable to remove keys of expired lease %d: %v", held.id, err)
+		}
+	}
+}
+
+// expireLeasesPeriodically removes expired leases at the specified interval, until the store is closed.
+func expireLeasesPeriodically(s *KVStore, interval time.Duration) {
+	defer close(s.leasesDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			expireLeases(s)
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// removeLeasedKeys removes any keys attached to leases when the store was last open, since leases are
+// only held in memory. It must be called before the store is started. A follower keeps them until their
+// removal by the leader is replicated.
+func removeLeasedKeys(s *KVStore) error {
+	if s.config.Follower {
+		return nil
+	}
+
+	changes := make([]change, 0)
+
+	for _, sh := range s.shards {
+		for key, e := range sh.data {
+			if e.Lease != 0 {
+				changes = append(changes, change{key, nil})
+			}
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	return commitChangesAs(s, changes, MutationExpire)
+}
+
+// newLease returns the details of a lease, as reported to users.
+func newLease(held *lease, now time.Time) *Lease {
+	return &Lease{
+		ID:    held.id,
+		Name:  held.name,
+		Owner: held.owner,
+		TTL:   held.expiresAt.Sub(now).Milliseconds(),
+		Keys:  len(held.keys),
+	}
+}
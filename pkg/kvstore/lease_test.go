@@ -0,0 +1,192 @@
+package kvstore_test
+
+import (
+	"errors"
+	"path/filepath"
+	"store/pkg/kvstore"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockHeldUntilReleased(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	first, err := kvstore.GrantLease(store, "lock", time.Minute, user1)
+	if err != nil || first.Name != "lock" || first.Owner != user1 {
+		t.Fatal("Lock should have been acquired but got: ", first, err)
+	}
+
+	if _, err = kvstore.GrantLease(store, "lock", time.Minute, user2); !errors.Is(err, kvstore.ErrLeaseHeld) {
+		t.Fatal("Lock should have been held but got: ", err)
+	}
+
+	if released, releaseErr := kvstore.ReleaseLease(store, first.ID, user2); !errors.Is(releaseErr,
+		kvstore.ErrPermissionDenied) {
+		t.Fatal("Release by different user should have been denied but got: ", released, releaseErr)
+	}
+
+	if released, releaseErr := kvstore.ReleaseLease(store, first.ID, user1); !released || releaseErr != nil {
+		t.Fatal("Lock should have been released but got: ", released, releaseErr)
+	}
+
+	second, err := kvstore.GrantLease(store, "lock", time.Minute, user2)
+	if err != nil {
+		t.Fatal("Released lock should have been acquired but got: ", err)
+	}
+
+	// the fencing token increases with each acquisition
+	if second.ID <= first.ID {
+		t.Fatalf("Later lease should have had a higher ID but had %d after %d", second.ID, first.ID)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestLeaseRenewedAndExpired(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{ExpiryInterval: 5 * time.Millisecond})
+
+	granted, _ := kvstore.GrantLease(store, "", 40*time.Millisecond, user1)
+
+	if _, err := kvstore.RenewLease(store, granted.ID, user2); !errors.Is(err, kvstore.ErrPermissionDenied) {
+		t.Fatal("Renewal by different user should have been denied but got: ", err)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+
+	if renewed, err := kvstore.RenewLease(store, granted.ID, user1); err != nil || renewed.TTL <= 25 {
+		t.Fatal("Lease should have been renewed but got: ", renewed, err)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+
+	if _, ok := kvstore.ReadLease(store, granted.ID); !ok {
+		t.Fatal("Renewed lease should still have been held")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if _, err := kvstore.RenewLease(store, granted.ID, user1); !errors.Is(err, kvstore.ErrLeaseNotFound) {
+		t.Fatal("Expired lease should not have been renewed but got: ", err)
+	}
+
+	if leases := kvstore.ListLeases(store); len(leases) != 0 {
+		t.Fatal("Expired lease should not be listed but was: ", leases)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestKeysRemovedWithLease(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Shards: testShards, ExpiryInterval: time.Hour})
+
+	granted, _ := kvstore.GrantLease(store, "", 20*time.Millisecond, user1)
+	released, _ := kvstore.GrantLease(store, "", time.Minute, user1)
+
+	if err := kvstore.WriteWithOptions(store, key1, value1, user2,
+		kvstore.WriteOptions{Lease: granted.ID}); !errors.Is(err, kvstore.ErrPermissionDenied) {
+		t.Fatal("Attaching a key to a lease held by a different user should have been denied but got: ", err)
+	}
+
+	kvstore.WriteWithOptions(store, key1, value1, user1, kvstore.WriteOptions{Lease: granted.ID})
+	kvstore.WriteWithOptions(store, key2, value2, user1, kvstore.WriteOptions{Lease: released.ID})
+
+	if entryInfo := kvstore.List(store, key1, user1); entryInfo.Lease != granted.ID {
+		t.Fatal("Key should have been listed with its lease but was: ", entryInfo)
+	}
+
+	kvstore.ReleaseLease(store, released.ID, user1)
+
+	if _, ok := kvstore.Read(store, key2, user1); ok {
+		t.Fatal("Key should have been removed along with its released lease")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	// expired before being actively removed, so never returned
+	if _, ok := kvstore.Read(store, key1, user1); ok {
+		t.Fatal("Key should have expired along with its lease")
+	}
+
+	err := kvstore.WriteWithOptions(store, key1, value1, user1, kvstore.WriteOptions{Lease: granted.ID})
+	if !errors.Is(err, kvstore.ErrLeaseNotFound) {
+		t.Fatal("Key should not have been attached to an expired lease but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestLeasedKeysRemovedOnRestart(t *testing.T) {
+	config := kvstore.Config{Shards: testShards, WALPath: filepath.Join(t.TempDir(), "store.wal")}
+
+	store, err := kvstore.NewKVStoreWithConfig(config)
+	if err != nil {
+		t.Fatal("Error opening store: ", err)
+	}
+
+	granted, _ := kvstore.GrantLease(store, "", time.Minute, user1)
+	kvstore.WriteWithOptions(store, key1, value1, user1, kvstore.WriteOptions{Lease: granted.ID})
+	kvstore.Write(store, key2, value2, user1)
+	kvstore.Close(store)
+
+	if store, err = kvstore.NewKVStoreWithConfig(config); err != nil {
+		t.Fatal("Error reopening store: ", err)
+	}
+
+	if _, ok := kvstore.Read(store, key1, user1); ok {
+		t.Fatal("Leased key should have been removed on restart")
+	}
+
+	if _, ok := kvstore.Read(store, key2, user1); !ok {
+		t.Fatal("Key without a lease should have been kept on restart")
+	}
+
+	// fencing tokens carry on increasing after a restart
+	if regranted, _ := kvstore.GrantLease(store, "", time.Minute, user1); regranted.ID <= granted.ID {
+		t.Fatalf("Lease after restart should have had a higher ID but had %d after %d", regranted.ID, granted.ID)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestLockFencingTokensIncrease(t *testing.T) {
+	const contenders = 8
+	const attempts = 50
+
+	store := kvstore.NewKVStore(testShards)
+	defer kvstore.Close(store)
+
+	var group sync.WaitGroup
+	var mutex sync.Mutex
+	var tokens []uint64
+
+	for i := 0; i < contenders; i++ {
+		group.Add(1)
+
+		go func() {
+			defer group.Done()
+
+			for j := 0; j < attempts; j++ {
+				granted, err := kvstore.GrantLease(store, "lock", time.Minute, user1)
+				if err != nil {
+					continue
+				}
+
+				// only the holder of the lock can be here, so tokens are recorded in the order they were granted
+				mutex.Lock()
+				tokens = append(tokens, granted.ID)
+				mutex.Unlock()
+
+				kvstore.ReleaseLease(store, granted.ID, user1)
+			}
+		}()
+	}
+
+	group.Wait()
+
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i] <= tokens[i-1] {
+			t.Fatalf("Fencing token %d should have been greater than the one before, %d", tokens[i], tokens[i-1])
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package kvstore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MutexStore is a simple in-memory Store, which guards its data with a read-write mutex
+// rather than performing operations on a separate go routine. It has no groups, so permissions granted to
+// groups by a key's access control list don't apply.
+type MutexStore struct {
+	mutex    sync.RWMutex
+	data     map[string]*entry
+	revision uint64
+	// accessMutex guards the read statistics of the entries, which are updated while only holding a read lock
+	accessMutex sync.Mutex
+	closed      bool
+}
+
+// NewMutexStore returns a new, empty, mutex-based key value store instance.
+func NewMutexStore() *MutexStore {
+	return &MutexStore{data: make(map[string]*entry)}
+}
+
+// Read implements Store.
+func (s *MutexStore) Read(ctx context.Context, key string, username string) (*Item, bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := checkUsable(ctx, s.closed); err != nil {
+		return nil, false, err
+	}
+
+	e, ok := s.data[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	if !hasPermission(e, username, nil, PermissionRead) {
+		return nil, false, ErrPermissionDenied
+	}
+
+	s.accessMutex.Lock()
+	e.Reads++
+	e.LastAccesed = time.Now()
+	s.accessMutex.Unlock()
+
+	return newItem(e), true, nil
+}
+
+// Write implements Store. Keys can't be given a time-to-live, as there is nothing to expire them.
+func (s *MutexStore) Write(ctx context.Context, key string, value []byte, username string,
+	options WriteOptions) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := checkUsable(ctx, s.closed); err != nil {
+		return err
+	}
+
+	if options.TTL > 0 || options.Lease != 0 {
+		return ErrNotSupported
+	}
+
+	if err := checkDocument(options.ContentType, value); err != nil {
+		return err
+	}
+
+	updatedEntry, err := prepareWrite(s.data[key], value, username, nil, options, time.Now())
+	if err != nil {
+		return err
+	}
+
+	s.revision++
+	updatedEntry.Version = s.revision
+	s.data[key] = updatedEntry
+
+	return nil
+}
+
+// Delete implements Store.
+func (s *MutexStore) Delete(ctx context.Context, key string, username string,
+	options DeleteOptions) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := checkUsable(ctx, s.closed); err != nil {
+		return false, err
+	}
+
+	existingEntry, ok := s.data[key]
+
+	if err := prepareDelete(existingEntry, username, nil, options); err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	delete(s.data, key)
+
+	return true, nil
+}
+
+// List implements Store.
+func (s *MutexStore) List(ctx context.Context, key string, username string) (*EntryInfo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := checkUsable(ctx, s.closed); err != nil {
+		return nil, err
+	}
+
+	e, ok := s.data[key]
+	if !ok {
+		return nil, nil
+	}
+
+	if !hasPermission(e, username, nil, PermissionRead) {
+		return nil, ErrPermissionDenied
+	}
+
+	s.accessMutex.Lock()
+	defer s.accessMutex.Unlock()
+
+	return newEntryInfo(key, e, time.Now()), nil
+}
+
+// ListAll implements Store.
+func (s *MutexStore) ListAll(ctx context.Context, username string) ([]*EntryInfo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := checkUsable(ctx, s.closed); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	entries := make([]*EntryInfo, 0, len(s.data))
+
+	s.accessMutex.Lock()
+	defer s.accessMutex.Unlock()
+
+	for key, e := range s.data {
+		if hasPermission(e, username, nil, PermissionRead) {
+			entries = append(entries, newEntryInfo(key, e, now))
+		}
+	}
+
+	return entries, nil
+}
+
+// Close implements Store, discarding all the keys.
+func (s *MutexStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	s.closed = true
+	s.data = nil
+
+	return nil
+}
@@ -0,0 +1,309 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrInvalidNamespace is returned when creating a namespace with a name or config that isn't valid.
+	ErrInvalidNamespace = errors.New("invalid namespace")
+	// ErrNamespaceExists is returned when creating a namespace that already exists.
+	ErrNamespaceExists = errors.New("namespace already exists")
+)
+
+// namespaceNameRegex limits namespace names to those that are safe to use in URL paths and file names.
+var namespaceNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// NamespaceConfig holds the settings of a namespace, which apply to its keys only.
+type NamespaceConfig struct {
+	// DefaultACL (if set) is the access control list given to each new key in the namespace.
+	DefaultACL *ACL `json:"default_acl,omitempty"`
+	// Quota limits how much of the namespace each user can take up. If zero, there are no limits.
+	Quota Quota `json:"quota"`
+	// DefaultTTL is the time-to-live given to keys written without one. If zero, such keys never expire.
+	DefaultTTL time.Duration `json:"default_ttl,omitempty"`
+}
+
+// NamespaceInfo provides details on a single namespace.
+type NamespaceInfo struct {
+	Name   string
+	Config NamespaceConfig
+	Stats  Stats
+}
+
+// namespace is a separate key space within a store, held in a store of its own.
+type namespace struct {
+	config NamespaceConfig
+	store  *KVStore
+}
+
+// CreateNamespace adds a namespace to the store, with its own key space. Its keys are then read and changed by
+// passing the namespace's store (see Namespace) to the usual functions, and so can't collide with those of the
+// store itself, or of any other namespace.
+//
+// The namespace shares the store's shard count, persistence settings and groups, with its write-ahead log and
+// snapshot files named after the store's with the namespace name added, e.g. store.wal.team.
+func CreateNamespace(s *KVStore, name string, config NamespaceConfig) error {
+	if !namespaceNameRegex.MatchString(name) {
+		return fmt.Errorf("%w: name %q must be 1 to 64 letters, digits, dashes or underscores",
+			ErrInvalidNamespace, name)
+	}
+
+	if config.DefaultACL != nil {
+		if err := validateACL(*config.DefaultACL); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidNamespace, err)
+		}
+	}
+
+	if config.DefaultTTL < 0 {
+		return fmt.Errorf("%w: default time-to-live must not be negative", ErrInvalidNamespace)
+	}
+
+	if s.config.Follower {
+		return ErrReadOnly
+	}
+
+	if s.consensus != nil {
+		return errClusterNamespaces
+	}
+
+	s.namespaceMutex.Lock()
+	defer s.namespaceMutex.Unlock()
+
+	if isClosed(s) {
+		return ErrClosed
+	}
+
+	if _, ok := s.namespaces[name]; ok {
+		return fmt.Errorf("%w: %s", ErrNamespaceExists, name)
+	}
+
+	config.DefaultACL = copyNamespaceACL(config.DefaultACL)
+
+	store, err := NewKVStoreWithConfig(namespaceStoreConfig(s.config, name, config))
+	if err != nil {
+		return err
+	}
+
+	s.namespaces[name] = &namespace{config, store}
+
+	if err = saveNamespaces(s); err != nil {
+		delete(s.namespaces, name)
+		Close(store)
+
+		return err
+	}
+
+	s.logger.Printf("Created namespace %s", name)
+
+	return nil
+}
+
+// Namespace returns the store holding the keys of the namespace, and a flag indicating if the namespace exists.
+// The namespace's store must not be closed directly, instead it is closed along with the store it's part of.
+// Namespaces aren't replicated, so a follower never has any, whatever its leader has.
+func Namespace(s *KVStore, name string) (*KVStore, bool) {
+	s.namespaceMutex.Lock()
+	defer s.namespaceMutex.Unlock()
+
+	ns, ok := s.namespaces[name]
+	if !ok {
+		return nil, false
+	}
+
+	return ns.store, true
+}
+
+// ListNamespaces returns the details of all the store's namespaces, in order of name.
+func ListNamespaces(s *KVStore) []*NamespaceInfo {
+	s.namespaceMutex.Lock()
+	defer s.namespaceMutex.Unlock()
+
+	namespaces := make([]*NamespaceInfo, 0, len(s.namespaces))
+	for name, ns := range s.namespaces {
+		config := ns.config
+		config.DefaultACL = copyNamespaceACL(config.DefaultACL)
+
+		namespaces = append(namespaces, &NamespaceInfo{name, config, StoreStats(ns.store)})
+	}
+
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+
+	return namespaces
+}
+
+// DeleteNamespace removes a namespace along with all its keys, and returns a flag indicating if the
+// namespace was present. Its write-ahead log and snapshot files are removed too.
+func DeleteNamespace(s *KVStore, name string) (bool, error) {
+	if s.config.Follower {
+		return false, ErrReadOnly
+	}
+
+	s.namespaceMutex.Lock()
+	defer s.namespaceMutex.Unlock()
+
+	if isClosed(s) {
+		return false, ErrClosed
+	}
+
+	ns, ok := s.namespaces[name]
+	if !ok {
+		return false, nil
+	}
+
+	delete(s.namespaces, name)
+
+	if err := saveNamespaces(s); err != nil {
+		s.namespaces[name] = ns
+
+		return true, err
+	}
+
+	// the namespace is gone regardless, so only report a failure to tidy up its files
+	Close(ns.store)
+
+	for _, path := range []string{ns.store.config.WALPath, ns.store.config.SnapshotPath} {
+		if path == "" {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.logger.Printf("Unable to remove file %s of namespace %s: %v", path, name, err)
+		}
+	}
+
+	s.logger.Printf("Deleted namespace %s", name)
+
+	return true, nil
+}
+
+// loadNamespaces opens the store of each namespace recorded in the namespaces file.
+// It is not an error for the file not to exist yet.
+func loadNamespaces(s *KVStore) error {
+	bytes, err := os.ReadFile(s.config.NamespacesPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	configs := make(map[string]NamespaceConfig)
+	if err = json.Unmarshal(bytes, &configs); err != nil {
+		return fmt.Errorf("unable to parse namespaces %s: %w", s.config.NamespacesPath, err)
+	}
+
+	for name, config := range configs {
+		store, openErr := NewKVStoreWithConfig(namespaceStoreConfig(s.config, name, config))
+		if openErr != nil {
+			closeNamespaces(s)
+
+			return fmt.Errorf("unable to open namespace %s: %w", name, openErr)
+		}
+
+		s.namespaces[name] = &namespace{config, store}
+	}
+
+	return nil
+}
+
+// saveNamespaces records the name and config of every namespace in the namespaces file, if configured.
+// The caller must hold the namespace mutex.
+func saveNamespaces(s *KVStore) error {
+	if s.config.NamespacesPath == "" {
+		return nil
+	}
+
+	configs := make(map[string]NamespaceConfig, len(s.namespaces))
+	for name, ns := range s.namespaces {
+		configs[name] = ns.config
+	}
+
+	bytes, err := json.Marshal(configs)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	if err = writeFileAtomically(s.config.NamespacesPath, bytes); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	return nil
+}
+
+// closeNamespaces closes the store of every namespace, returning the first error if any.
+func closeNamespaces(s *KVStore) error {
+	s.namespaceMutex.Lock()
+	defer s.namespaceMutex.Unlock()
+
+	var firstErr error
+
+	for _, ns := range s.namespaces {
+		if err := Close(ns.store); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// namespaceStoreConfig returns the config of the store holding a namespace's keys.
+func namespaceStoreConfig(config Config, name string, nsConfig NamespaceConfig) Config {
+	return Config{
+		Shards:           config.Shards,
+		WALPath:          namespaceFile(config.WALPath, name),
+		SyncPolicy:       config.SyncPolicy,
+		SyncInterval:     config.SyncInterval,
+		SnapshotPath:     namespaceFile(config.SnapshotPath, name),
+		SnapshotInterval: config.SnapshotInterval,
+		ExpiryInterval:   config.ExpiryInterval,
+		WatchHistory:     config.WatchHistory,
+		ChangeFeed:       config.ChangeFeed,
+		Follower:         config.Follower,
+		Eviction:         config.Eviction,
+		History:          config.History,
+		Quota:            nsConfig.Quota,
+		DefaultTTL:       nsConfig.DefaultTTL,
+		DefaultACL:       nsConfig.DefaultACL,
+		Groups:           config.Groups,
+		Logger:           config.Logger,
+	}
+}
+
+// namespaceFile returns the path of a namespace's file alongside the store's file, or an empty string if the
+// store doesn't have the file.
+func namespaceFile(path string, name string) string {
+	if path == "" {
+		return ""
+	}
+
+	return path + "." + name
+}
+
+// copyNamespaceACL returns a copy of the ACL, or nil if there isn't one.
+func copyNamespaceACL(acl *ACL) *ACL {
+	if acl == nil {
+		return nil
+	}
+
+	return copyACL(acl)
+}
+
+// isClosed returns whether the store has been closed.
+func isClosed(s *KVStore) bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
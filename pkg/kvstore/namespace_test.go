@@ -0,0 +1,193 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"store/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+const namespace1 = "team_a"
+
+func TestNamespaceIsolated(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	if err := kvstore.CreateNamespace(store, namespace1, kvstore.NamespaceConfig{}); err != nil {
+		t.Fatal("Error creating namespace: ", err)
+	}
+
+	namespaceStore, ok := kvstore.Namespace(store, namespace1)
+	if !ok {
+		t.Fatal("Namespace should have been found")
+	}
+
+	kvstore.Write(store, key1, value1, user1)
+
+	if err := kvstore.Write(namespaceStore, key1, value2, user2); err != nil {
+		t.Fatal("Key in namespace should not have collided with the store's but got: ", err)
+	}
+
+	if value, _ := kvstore.Read(store, key1, user1); !bytes.Equal(value, value1) {
+		t.Fatalf("Store should have kept value %s but was: %s", value1, value)
+	}
+
+	if value, _ := kvstore.Read(namespaceStore, key1, user1); !bytes.Equal(value, value2) {
+		t.Fatalf("Namespace should have had value %s but was: %s", value2, value)
+	}
+
+	if entries := kvstore.ListAll(store, user1); len(entries) != 1 {
+		t.Fatal("Store should only have listed its own key but listed: ", len(entries))
+	}
+
+	kvstore.Close(store)
+}
+
+func TestNamespaceDefaults(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	config := kvstore.NamespaceConfig{
+		DefaultACL: &kvstore.ACL{Private: true},
+		Quota:      kvstore.Quota{MaxKeys: 1},
+		DefaultTTL: time.Hour,
+	}
+	kvstore.CreateNamespace(store, namespace1, config)
+	namespaceStore, _ := kvstore.Namespace(store, namespace1)
+
+	kvstore.Write(namespaceStore, key1, value1, user1)
+
+	if entryInfo := kvstore.List(namespaceStore, key1, user1); entryInfo.TTL <= 0 {
+		t.Fatal("Key should have been given the default time-to-live but was: ", entryInfo.TTL)
+	}
+
+	if _, ok := kvstore.Read(namespaceStore, key1, user2); ok {
+		t.Fatal("Key should have been given the default private ACL")
+	}
+
+	if err := kvstore.Write(namespaceStore, key2, value2, user1); !errors.Is(err, kvstore.ErrQuotaExceeded) {
+		t.Fatal("Write should have taken user over the namespace's quota but got: ", err)
+	}
+
+	if err := kvstore.Write(store, key2, value2, user1); err != nil {
+		t.Fatal("Namespace's quota should not have applied to the store but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestNamespaceEvicts(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Eviction: kvstore.Eviction{MaxKeys: 1}})
+	defer kvstore.Close(store)
+
+	kvstore.CreateNamespace(store, namespace1, kvstore.NamespaceConfig{})
+	namespaceStore, _ := kvstore.Namespace(store, namespace1)
+
+	kvstore.Write(namespaceStore, key1, value1, user1)
+	kvstore.Write(namespaceStore, key2, value2, user1)
+
+	if stats := kvstore.StoreStats(namespaceStore); stats.Keys != 1 || stats.Evictions != 1 {
+		t.Fatal("Namespace should have been kept within the store's size limits but was: ", stats)
+	}
+}
+
+func TestCreateNamespaceInvalid(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	if err := kvstore.CreateNamespace(store, "a/b", kvstore.NamespaceConfig{}); !errors.Is(err,
+		kvstore.ErrInvalidNamespace) {
+		t.Fatal("Namespace name should have been invalid but got: ", err)
+	}
+
+	config := kvstore.NamespaceConfig{
+		DefaultACL: &kvstore.ACL{Users: map[string][]kvstore.Permission{user2: {"execute"}}},
+	}
+	if err := kvstore.CreateNamespace(store, namespace1, config); !errors.Is(err, kvstore.ErrInvalidNamespace) {
+		t.Fatal("Namespace ACL should have been invalid but got: ", err)
+	}
+
+	kvstore.CreateNamespace(store, namespace1, kvstore.NamespaceConfig{})
+
+	if err := kvstore.CreateNamespace(store, namespace1, kvstore.NamespaceConfig{}); !errors.Is(err,
+		kvstore.ErrNamespaceExists) {
+		t.Fatal("Namespace should already have existed but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestListAndDeleteNamespaces(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	kvstore.CreateNamespace(store, "b", kvstore.NamespaceConfig{})
+	kvstore.CreateNamespace(store, "a", kvstore.NamespaceConfig{DefaultTTL: time.Minute})
+
+	namespaceStore, _ := kvstore.Namespace(store, "a")
+	kvstore.Write(namespaceStore, key1, value1, user1)
+
+	namespaces := kvstore.ListNamespaces(store)
+	if len(namespaces) != 2 || namespaces[0].Name != "a" || namespaces[1].Name != "b" {
+		t.Fatal("Namespaces should have been listed in order of name but were: ", namespaces)
+	}
+
+	if namespaces[0].Config.DefaultTTL != time.Minute || namespaces[0].Stats.Keys != 1 {
+		t.Fatal("Namespace should have been listed with its config and stats but was: ", namespaces[0])
+	}
+
+	if deleted, err := kvstore.DeleteNamespace(store, "a"); !deleted || err != nil {
+		t.Fatal("Namespace should have been deleted but got: ", deleted, err)
+	}
+
+	if _, ok := kvstore.Namespace(store, "a"); ok {
+		t.Fatal("Deleted namespace should not have been found")
+	}
+
+	if deleted, err := kvstore.DeleteNamespace(store, "a"); deleted || err != nil {
+		t.Fatal("Missing namespace should not have been deleted but got: ", deleted, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestNamespacesPersisted(t *testing.T) {
+	dir := t.TempDir()
+	config := kvstore.Config{
+		WALPath:        filepath.Join(dir, "store.wal"),
+		NamespacesPath: filepath.Join(dir, "namespaces.json"),
+	}
+
+	store, err := kvstore.NewKVStoreWithConfig(config)
+	if err != nil {
+		t.Fatal("Error opening store: ", err)
+	}
+
+	kvstore.CreateNamespace(store, namespace1, kvstore.NamespaceConfig{Quota: kvstore.Quota{MaxKeys: 5}})
+	namespaceStore, _ := kvstore.Namespace(store, namespace1)
+	kvstore.Write(namespaceStore, key1, value1, user1)
+
+	if err = kvstore.Close(store); err != nil {
+		t.Fatal("Error closing store: ", err)
+	}
+
+	store, err = kvstore.NewKVStoreWithConfig(config)
+	if err != nil {
+		t.Fatal("Error re-opening store: ", err)
+	}
+
+	namespaceStore, ok := kvstore.Namespace(store, namespace1)
+	if !ok || kvstore.StoreQuota(namespaceStore).MaxKeys != 5 {
+		t.Fatal("Namespace should have been recreated with its config")
+	}
+
+	if value, _ := kvstore.Read(namespaceStore, key1, user1); !bytes.Equal(value, value1) {
+		t.Fatalf("Namespace key should have been replayed with value %s but was: %s", value1, value)
+	}
+
+	kvstore.DeleteNamespace(store, namespace1)
+
+	if _, err = os.Stat(config.WALPath + "." + namespace1); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("Namespace's write-ahead log should have been removed but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
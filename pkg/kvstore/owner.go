@@ -0,0 +1,95 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNoOwner is returned when transferring a key without specifying the new owner.
+var ErrNoOwner = errors.New("no new owner specified")
+
+var errTransferSameUser = fmt.Errorf("%w: cannot transfer entry owned by someone else", ErrPermissionDenied)
+
+// TransferOptions provides optional settings for transferring ownership of a key.
+type TransferOptions struct {
+	// Condition (if set) must hold for the key's current version, otherwise the transfer is not made.
+	Condition *Condition
+	// Override allows the transfer even though the user doesn't own the key, see WriteOptions.
+	Override bool
+}
+
+type transferRequest struct {
+	key             string
+	newOwner        string
+	username        string
+	options         TransferOptions
+	responseChannel chan<- *transferResponse
+}
+
+type transferResponse struct {
+	found bool
+	err   error
+}
+
+// TransferOwnership makes another user the owner of the key, and returns a flag indicating if the key was
+// present. The key's value, access control list and time-to-live are kept, and it's given a new version.
+// The key then counts towards the new owner's quota rather than the old one's.
+//
+// Only the owning user can transfer a key, unless overridden in the options.
+func TransferOwnership(s *KVStore, key string, newOwner string, username string,
+	options TransferOptions) (bool, error) {
+	return TransferOwnershipContext(context.Background(), s, key, newOwner, username, options)
+}
+
+// TransferOwnershipContext is the same as TransferOwnership, but gives up if the context is done first,
+// or the store is closed. If the context is done once the transfer has been passed to the store, the
+// transfer may still be made.
+func TransferOwnershipContext(ctx context.Context, s *KVStore, key string, newOwner string, username string,
+	options TransferOptions) (bool, error) {
+	if newOwner == "" {
+		return false, ErrNoOwner
+	}
+
+	responseChannel := make(chan *transferResponse, 1)
+	if err := sendRequest(ctx, shardFor(s, key), transferOperation,
+		&transferRequest{key, newOwner, username, options, responseChannel}); err != nil {
+		return false, err
+	}
+
+	select {
+	case response := <-responseChannel:
+		return response.found, response.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// transferEntry changes the owner of the key, if permitted.
+func transferEntry(sh *shard, params *transferRequest) *transferResponse {
+	existingEntry, ok := lookupEntry(sh, params.key)
+	if !ok {
+		if !checkCondition(params.options.Condition, nil) {
+			return &transferResponse{false, ErrVersionMismatch}
+		}
+
+		return &transferResponse{false, nil}
+	}
+
+	if existingEntry.Owner != params.username && !params.options.Override {
+		return &transferResponse{true, errTransferSameUser}
+	}
+
+	if !checkCondition(params.options.Condition, existingEntry) {
+		return &transferResponse{true, ErrVersionMismatch}
+	}
+
+	updatedEntry := *existingEntry
+	updatedEntry.Owner = params.newOwner
+
+	if err := storeEntry(sh.store, params.key, &updatedEntry); err != nil {
+		return &transferResponse{true, err}
+	}
+
+	return &transferResponse{true, nil}
+}
@@ -0,0 +1,82 @@
+package kvstore_test
+
+import (
+	"errors"
+	"store/pkg/kvstore"
+	"testing"
+)
+
+func TestTransferOwnership(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	kvstore.Write(store, key1, value1, user1)
+
+	_, err := kvstore.TransferOwnership(store, key1, user2, user2, kvstore.TransferOptions{})
+	if !errors.Is(err, kvstore.ErrPermissionDenied) {
+		t.Fatal("Transfer by different user should have been denied but got: ", err)
+	}
+
+	found, err := kvstore.TransferOwnership(store, key1, user2, user1, kvstore.TransferOptions{})
+	if !found || err != nil {
+		t.Fatal("Transfer by owner should have been successful but got: ", found, err)
+	}
+
+	entryInfo := kvstore.List(store, key1, user1)
+	if entryInfo.Owner != user2 || entryInfo.Version != 2 {
+		t.Fatal("Key should have had new owner and version but was: ", entryInfo)
+	}
+
+	if err := kvstore.Write(store, key1, value2, user1); !errors.Is(err, kvstore.ErrPermissionDenied) {
+		t.Fatal("Update by previous owner should have been denied but got: ", err)
+	}
+
+	if err := kvstore.Write(store, key1, value2, user2); err != nil {
+		t.Fatal("Update by new owner should have been successful but got: ", err)
+	}
+
+	found, err = kvstore.TransferOwnership(store, key2, user2, user1, kvstore.TransferOptions{})
+	if found || err != nil {
+		t.Fatal("Transfer of missing key should have found nothing but got: ", found, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestTransferOwnershipQuota(t *testing.T) {
+	store := newQuotaStore(t, kvstore.Quota{MaxKeys: 1})
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key2, value2, user2)
+
+	_, err := kvstore.TransferOwnership(store, key1, user2, user1, kvstore.TransferOptions{})
+	if !errors.Is(err, kvstore.ErrQuotaExceeded) {
+		t.Fatal("Transfer should have taken new owner over quota but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestOverride(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key2, value2, user1)
+
+	override := kvstore.WriteOptions{Override: true}
+	if err := kvstore.WriteWithOptions(store, key1, value2, user2, override); err != nil {
+		t.Fatal("Overridden update should have been successful but got: ", err)
+	}
+
+	if entryInfo := kvstore.List(store, key1, user1); entryInfo.Owner != user1 {
+		t.Fatal("Overridden update should have kept the owner but was: ", entryInfo.Owner)
+	}
+
+	_, err := kvstore.TransferOwnership(store, key2, user2, user2, kvstore.TransferOptions{Override: true})
+	if err != nil {
+		t.Fatal("Overridden transfer should have been successful but got: ", err)
+	}
+
+	deleted, err := kvstore.DeleteWithOptions(store, key1, user2, kvstore.DeleteOptions{Override: true})
+	if !deleted || err != nil {
+		t.Fatal("Overridden delete should have been successful but got: ", deleted, err)
+	}
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,144 @@
+package kvstore
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Handoff is a set of keys moving from one node of a partitioned cluster to another, with everything held for
+// each key (its value, owner, access control and time-to-live), see ExportKeys.
+type Handoff struct {
+	Entries map[string]*entry `json:"entries"`
+}
+
+// ExportKeys returns every key in the store that moves elsewhere, split up by where each key moves to as given
+// by the function (which returns false for keys that stay), for another store to take on with ImportKeys. Once
+// it has, the keys should be removed from this store with RemoveHandoff. Keys attached to a lease can't move,
+// since the lease is held by this store, so those that would are removed instead, as if the lease had expired.
+func ExportKeys(ctx context.Context, s *KVStore, destination func(key string) (string, bool)) (map[string]*Handoff,
+	error) {
+	release, err := parkShards(ctx, s.shards)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	now := time.Now()
+	handoffs := make(map[string]*Handoff)
+
+	var leased []change
+
+	for _, sh := range s.shards {
+		for key, e := range sh.data {
+			if hasExpired(e, now) {
+				continue
+			}
+
+			to, moves := destination(key)
+			if !moves {
+				continue
+			}
+
+			if e.Lease != 0 {
+				leased = append(leased, change{key, nil})
+
+				continue
+			}
+
+			if handoffs[to] == nil {
+				handoffs[to] = &Handoff{make(map[string]*entry)}
+			}
+
+			// a copy, since the entry's counts change as it's read
+			copied := *e
+			handoffs[to].Entries[key] = &copied
+		}
+	}
+
+	if len(leased) > 0 {
+		sort.Slice(leased, func(i, j int) bool { return leased[i].Key < leased[j].Key })
+
+		if err = commitChangesAs(s, leased, MutationExpire); err != nil {
+			return nil, err
+		}
+	}
+
+	return handoffs, nil
+}
+
+// ImportKeys adds the keys handed off by another store to this one as a single revision, replacing any keys
+// already held. Each key keeps its owner, access control and time-to-live, but is given the revision as its
+// version, and its earlier versions aren't kept.
+//
+// The store handing off the keys must have been the only one changing them until now, so its values are the
+// latest. Any already held here are left over from an earlier handoff which was never removed from the other
+// store, and so may have been changed there since.
+func ImportKeys(ctx context.Context, s *KVStore, handoff *Handoff) error {
+	keys := handoffKeys(handoff)
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	release, err := parkShards(ctx, shardsFor(s, keys))
+	if err != nil {
+		return err
+	}
+
+	changes := make([]change, len(keys))
+
+	for i, key := range keys {
+		copied := *handoff.Entries[key]
+		changes[i] = change{key, &copied}
+	}
+
+	err = commitChanges(s, changes)
+	release()
+
+	if err == nil {
+		enforceLimits(ctx, s, keys...)
+	}
+
+	return err
+}
+
+// RemoveHandoff removes the keys handed off to another store as a single revision, other than any changed
+// since they were exported.
+func RemoveHandoff(ctx context.Context, s *KVStore, handoff *Handoff) error {
+	keys := handoffKeys(handoff)
+
+	release, err := parkShards(ctx, shardsFor(s, keys))
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	changes := make([]change, 0, len(keys))
+
+	for _, key := range keys {
+		if e, ok := shardFor(s, key).data[key]; ok && e.Version == handoff.Entries[key].Version {
+			changes = append(changes, change{key, nil})
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	return commitChangesAs(s, changes, MutationMove)
+}
+
+// handoffKeys returns the keys handed off, in order.
+func handoffKeys(handoff *Handoff) []string {
+	keys := make([]string, 0, len(handoff.Entries))
+	for key := range handoff.Entries {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,110 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"context"
+	"store/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func TestKeysMovedBetweenStores(t *testing.T) {
+	from := kvstore.NewKVStore(testShards)
+	to := kvstore.NewKVStore(testShards)
+
+	kvstore.WriteWithOptions(from, key1, value1, user1, kvstore.WriteOptions{TTL: time.Hour})
+	kvstore.Write(from, key2, value2, user2)
+
+	ctx := context.Background()
+
+	handoffs, err := kvstore.ExportKeys(ctx, from, func(key string) (string, bool) { return "to", key == key1 })
+	if handoff := handoffs["to"]; err != nil || len(handoffs) != 1 || len(handoff.Entries) != 1 {
+		t.Fatal("Only the moving key should have been exported but got: ", handoffs, err)
+	}
+
+	handoff := handoffs["to"]
+
+	if err = kvstore.ImportKeys(ctx, to, handoff); err != nil {
+		t.Fatal("Keys should have been imported but got: ", err)
+	}
+
+	if err = kvstore.RemoveHandoff(ctx, from, handoff); err != nil {
+		t.Fatal("Keys should have been removed but got: ", err)
+	}
+
+	if value, ok := kvstore.Read(to, key1, user1); !ok || !bytes.Equal(value, value1) {
+		t.Fatalf("Key should have been moved but had: %t value %s", ok, value)
+	}
+
+	if info := kvstore.List(to, key1, user1); info == nil || info.Owner != user1 || info.TTL == 0 {
+		t.Fatal("Moved key should have kept its owner and time-to-live but had: ", info)
+	}
+
+	if _, ok := kvstore.Read(from, key1, user1); ok {
+		t.Fatal("Moved key should have been removed")
+	}
+
+	if _, ok := kvstore.Read(from, key2, user2); !ok {
+		t.Fatal("Key that didn't move should have been kept")
+	}
+
+	mutations, _ := kvstore.Changes(from, 0, 0)
+	if last := mutations[len(mutations)-1]; last.Key != key1 || last.Type != kvstore.MutationMove {
+		t.Fatal("Removal should have been recorded as a move but was: ", last)
+	}
+}
+
+func TestChangedKeysNotRemoved(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	granted, _ := kvstore.GrantLease(store, "", time.Hour, user1)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.WriteWithOptions(store, key2, value2, user1, kvstore.WriteOptions{Lease: granted.ID})
+
+	ctx := context.Background()
+
+	handoffs, _ := kvstore.ExportKeys(ctx, store, func(string) (string, bool) { return "to", true })
+
+	handoff := handoffs["to"]
+	if len(handoff.Entries) != 1 {
+		t.Fatal("Key attached to a lease should not have been exported but got: ", handoff.Entries)
+	}
+
+	kvstore.Write(store, key1, value2, user1)
+
+	if err := kvstore.RemoveHandoff(ctx, store, handoff); err != nil {
+		t.Fatal("Handoff should have been removed but got: ", err)
+	}
+
+	if value, ok := kvstore.Read(store, key1, user1); !ok || !bytes.Equal(value, value2) {
+		t.Fatalf("Key changed since being exported should have been kept but had: %t value %s", ok, value)
+	}
+}
+
+func TestLeasedKeysRemovedWhenMoved(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	granted, _ := kvstore.GrantLease(store, "", time.Hour, user1)
+	kvstore.WriteWithOptions(store, key1, value1, user1, kvstore.WriteOptions{Lease: granted.ID})
+	kvstore.WriteWithOptions(store, key2, value2, user1, kvstore.WriteOptions{Lease: granted.ID})
+
+	handoffs, err := kvstore.ExportKeys(context.Background(), store, func(key string) (string, bool) {
+		return "to", key == key1
+	})
+	if err != nil || len(handoffs) != 0 {
+		t.Fatal("Keys attached to a lease should not have been exported but got: ", handoffs, err)
+	}
+
+	if _, ok := kvstore.Read(store, key1, user1); ok {
+		t.Fatal("Leased key that would have moved should have been removed")
+	}
+
+	if _, ok := kvstore.Read(store, key2, user1); !ok {
+		t.Fatal("Leased key that didn't move should have been kept")
+	}
+
+	mutations, _ := kvstore.Changes(store, 0, 0)
+	if last := mutations[len(mutations)-1]; last.Key != key1 || last.Type != kvstore.MutationExpire {
+		t.Fatal("Removal should have been recorded as the lease expiring but was: ", last)
+	}
+}
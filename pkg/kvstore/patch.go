@@ -0,0 +1,515 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PatchType is the format of a patch to a JSON document.
+type PatchType int
+
+const (
+	// MergePatch is a JSON merge patch (RFC 7396), a partial document whose members replace those of the
+	// document, with null members removing them.
+	MergePatch PatchType = iota
+	// JSONPatch is a JSON patch (RFC 6902), a list of operations to apply to the document in order.
+	JSONPatch PatchType = iota
+)
+
+var (
+	// ErrInvalidPatch is returned when a patch can't be parsed.
+	ErrInvalidPatch = errors.New("invalid patch")
+	// ErrPatchFailed is returned when a patch can't be applied to the document, such as when it refers to a
+	// member that isn't present, or a test operation fails.
+	ErrPatchFailed = errors.New("patch failed")
+)
+
+// PatchOptions provides optional settings for a patch.
+type PatchOptions struct {
+	// Condition (if set) must hold for the key's current version, otherwise the patch is not made.
+	Condition *Condition
+}
+
+// patchOp is a single operation within a JSON patch.
+type patchOp struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+type patchRequest struct {
+	key             string
+	patch           []byte
+	patchType       PatchType
+	username        string
+	options         PatchOptions
+	responseChannel chan<- *patchResponse
+}
+
+type patchResponse struct {
+	item *Item
+	err  error
+}
+
+// Patch changes the key's JSON document in place, as a single change to the store, and returns the patched
+// document. A key that isn't present is treated as null, and created owned by the user. The key keeps its
+// time-to-live, and is given the JSON content type if it didn't have one.
+//
+// Only the owning user, or those granted write permission, can patch an existing key.
+func Patch(s *KVStore, key string, patch []byte, patchType PatchType, username string) (*Item, error) {
+	return PatchWithOptionsContext(context.Background(), s, key, patch, patchType, username, PatchOptions{})
+}
+
+// PatchWithOptionsContext is the same as Patch, using the specified options, but gives up if the context is
+// done first, or the store is closed. If the context is done once the patch has been passed to the store, the
+// patch may still be made.
+func PatchWithOptionsContext(ctx context.Context, s *KVStore, key string, patch []byte, patchType PatchType,
+	username string, options PatchOptions) (*Item, error) {
+	responseChannel := make(chan *patchResponse, 1)
+	if err := sendRequest(ctx, shardFor(s, key), patchOperation,
+		&patchRequest{key, patch, patchType, username, options, responseChannel}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-responseChannel:
+		if response.err == nil {
+			enforceLimits(ctx, s, key)
+		}
+
+		return response.item, response.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// patchEntry applies the patch to the key's document, if permitted.
+func patchEntry(sh *shard, params *patchRequest) *patchResponse {
+	existingEntry, _ := lookupEntry(sh, params.key)
+
+	// check the user can write the key before looking at its value
+	updatedEntry, err := prepareStoreWrite(sh.store, existingEntry, nil, params.username,
+		WriteOptions{Condition: params.options.Condition}, time.Now())
+	if err != nil {
+		return &patchResponse{nil, err}
+	}
+
+	var document interface{}
+
+	updatedEntry.ContentType = ContentTypeJSON
+
+	if existingEntry != nil {
+		if document, err = decodeDocument(existingEntry.Value); err != nil {
+			return &patchResponse{nil, err}
+		}
+
+		updatedEntry.ExpiresAt = existingEntry.ExpiresAt
+		updatedEntry.Lease = existingEntry.Lease
+
+		if existingEntry.ContentType != "" {
+			updatedEntry.ContentType = existingEntry.ContentType
+		}
+	}
+
+	switch params.patchType {
+	case MergePatch:
+		document, err = applyMergePatch(document, params.patch)
+	case JSONPatch:
+		document, err = applyJSONPatch(document, params.patch)
+	default:
+		err = fmt.Errorf("%w: unknown patch type %d", ErrInvalidPatch, params.patchType)
+	}
+
+	if err != nil {
+		return &patchResponse{nil, err}
+	}
+
+	if updatedEntry.Value, err = json.Marshal(document); err != nil {
+		return &patchResponse{nil, fmt.Errorf("%w: %v", ErrPatchFailed, err)}
+	}
+
+	if err = storeEntry(sh.store, params.key, updatedEntry); err != nil {
+		return &patchResponse{nil, err}
+	}
+
+	return &patchResponse{newItem(updatedEntry), nil}
+}
+
+// applyMergePatch applies a JSON merge patch to the document.
+func applyMergePatch(document interface{}, patch []byte) (interface{}, error) {
+	decodedPatch, err := decodeDocument(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	return mergePatch(document, decodedPatch), nil
+}
+
+// mergePatch follows the MergePatch algorithm of RFC 7396.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+
+	return targetObject
+}
+
+// applyJSONPatch applies each operation of a JSON patch to the document in turn.
+func applyJSONPatch(document interface{}, patch []byte) (interface{}, error) {
+	var ops []patchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	for i, op := range ops {
+		var err error
+		if document, err = applyPatchOp(document, op); err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+	}
+
+	return document, nil
+}
+
+// applyPatchOp applies a single JSON patch operation to the document.
+func applyPatchOp(document interface{}, op patchOp) (interface{}, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("%w: no path specified", ErrInvalidPatch)
+	}
+
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value, from interface{}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: no value specified", ErrInvalidPatch)
+		}
+
+		if value, err = decodeDocument(*op.Value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+
+	case "move", "copy":
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: no from specified", ErrInvalidPatch)
+		}
+
+		var fromPath []string
+		if fromPath, err = parsePointer(*op.From); err != nil {
+			return nil, err
+		}
+
+		if from, err = getPointer(document, fromPath); err != nil {
+			return nil, err
+		}
+
+		if op.Op == "copy" {
+			// the copy mustn't share any objects or arrays with the original
+			from = copyDocument(from)
+		} else {
+			if strings.HasPrefix(*op.Path+"/", *op.From+"/") && *op.Path != *op.From {
+				return nil, fmt.Errorf("%w: cannot move a value into itself", ErrPatchFailed)
+			}
+
+			if document, err = removePointer(document, fromPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return addPointer(document, path, value)
+	case "remove":
+		return removePointer(document, path)
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+
+		if document, err = removePointer(document, path); err != nil {
+			return nil, err
+		}
+
+		return addPointer(document, path, value)
+	case "move", "copy":
+		return addPointer(document, path, from)
+	case "test":
+		current, getErr := getPointer(document, path)
+		if getErr != nil {
+			return nil, getErr
+		}
+
+		if !equalDocuments(current, value) {
+			return nil, fmt.Errorf("%w: test of %s failed", ErrPatchFailed, *op.Path)
+		}
+
+		return document, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, op.Op)
+	}
+}
+
+// parsePointer splits a JSON pointer (RFC 6901) such as /a/0/b~1c into its reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: pointer %q must start with /", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// getPointer returns the value at the pointer within the document.
+func getPointer(document interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		switch node := document.(type) {
+		case map[string]interface{}:
+			member, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: member %q not found", ErrPatchFailed, token)
+			}
+
+			document = member
+		case []interface{}:
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+
+			document = node[index]
+		default:
+			return nil, fmt.Errorf("%w: %q is not within an object or array", ErrPatchFailed, token)
+		}
+	}
+
+	return document, nil
+}
+
+// addPointer returns the document with the value added at the pointer, replacing any existing object member,
+// or inserting into an array.
+func addPointer(document interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	token := tokens[0]
+
+	switch node := document.(type) {
+	case map[string]interface{}:
+		if len(tokens) == 1 {
+			node[token] = value
+
+			return node, nil
+		}
+
+		member, ok := node[token]
+		if !ok {
+			return nil, fmt.Errorf("%w: member %q not found", ErrPatchFailed, token)
+		}
+
+		updated, err := addPointer(member, tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+
+		node[token] = updated
+
+		return node, nil
+
+	case []interface{}:
+		if len(tokens) == 1 {
+			index := len(node)
+			if token != "-" {
+				var err error
+				if index, err = arrayIndex(token, len(node)); err != nil {
+					return nil, err
+				}
+			}
+
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+
+			return node, nil
+		}
+
+		index, err := arrayIndex(token, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+
+		if node[index], err = addPointer(node[index], tokens[1:], value); err != nil {
+			return nil, err
+		}
+
+		return node, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %q is not within an object or array", ErrPatchFailed, token)
+	}
+}
+
+// removePointer returns the document with the value at the pointer removed.
+func removePointer(document interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w: cannot remove the whole document", ErrPatchFailed)
+	}
+
+	token := tokens[0]
+
+	switch node := document.(type) {
+	case map[string]interface{}:
+		member, ok := node[token]
+		if !ok {
+			return nil, fmt.Errorf("%w: member %q not found", ErrPatchFailed, token)
+		}
+
+		if len(tokens) == 1 {
+			delete(node, token)
+
+			return node, nil
+		}
+
+		updated, err := removePointer(member, tokens[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		node[token] = updated
+
+		return node, nil
+
+	case []interface{}:
+		index, err := arrayIndex(token, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(tokens) == 1 {
+			return append(node[:index], node[index+1:]...), nil
+		}
+
+		if node[index], err = removePointer(node[index], tokens[1:]); err != nil {
+			return nil, err
+		}
+
+		return node, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %q is not within an object or array", ErrPatchFailed, token)
+	}
+}
+
+// arrayIndex parses an array index from a pointer token, which must be no more than the last index allowed.
+func arrayIndex(token string, last int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrPatchFailed, token)
+	}
+
+	if index > last {
+		return 0, fmt.Errorf("%w: array index %d out of range", ErrPatchFailed, index)
+	}
+
+	return index, nil
+}
+
+// copyDocument returns a deep copy of a decoded JSON value.
+func copyDocument(value interface{}) interface{} {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(node))
+		for name, member := range node {
+			copied[name] = copyDocument(member)
+		}
+
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(node))
+		for i, element := range node {
+			copied[i] = copyDocument(element)
+		}
+
+		return copied
+	default:
+		return value
+	}
+}
+
+// equalDocuments returns whether two decoded JSON values are equal, with numbers compared by value.
+func equalDocuments(a interface{}, b interface{}) bool {
+	switch nodeA := a.(type) {
+	case map[string]interface{}:
+		nodeB, ok := b.(map[string]interface{})
+		if !ok || len(nodeA) != len(nodeB) {
+			return false
+		}
+
+		for name, member := range nodeA {
+			if other, found := nodeB[name]; !found || !equalDocuments(member, other) {
+				return false
+			}
+		}
+
+		return true
+	case []interface{}:
+		nodeB, ok := b.([]interface{})
+		if !ok || len(nodeA) != len(nodeB) {
+			return false
+		}
+
+		for i := range nodeA {
+			if !equalDocuments(nodeA[i], nodeB[i]) {
+				return false
+			}
+		}
+
+		return true
+	case json.Number:
+		nodeB, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+
+		floatA, errA := nodeA.Float64()
+		floatB, errB := nodeB.Float64()
+
+		return errA == nil && errB == nil && floatA == floatB
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,145 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	// ErrValueTooLarge is returned when a value is bigger than the quota allows for a single value.
+	ErrValueTooLarge = errors.New("value too large")
+	// ErrQuotaExceeded is returned when a change would take a user over their quota of keys or bytes.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+// Quota limits how much of the store each user can take up. Any limit that is zero is not enforced.
+type Quota struct {
+	// MaxKeys is the most keys a user can own.
+	MaxKeys int `json:"max_keys,omitempty"`
+	// MaxBytes is the most bytes a user's keys can take up, counting the length of each key and value.
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	// MaxValueSize is the longest a single value can be, in bytes.
+	MaxValueSize int64 `json:"max_value_size,omitempty"`
+}
+
+// Usage is how much of the store a user is taking up.
+type Usage struct {
+	Username string `json:"username"`
+	Keys     int    `json:"keys"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// StoreQuota returns the quota the store enforces for every user.
+func StoreQuota(s *KVStore) Quota {
+	return s.config.Quota
+}
+
+// UserUsage returns how much of the store the user is taking up.
+func UserUsage(s *KVStore, username string) Usage {
+	s.commitMutex.Lock()
+	defer s.commitMutex.Unlock()
+
+	usage := Usage{Username: username}
+	if userUsage, ok := s.usage[username]; ok {
+		usage = *userUsage
+	}
+
+	return usage
+}
+
+// AllUsage returns how much of the store each user owning any keys is taking up, in order of username.
+// Keys whose time-to-live has passed count towards their owner's usage until they are removed.
+func AllUsage(s *KVStore) []Usage {
+	s.commitMutex.Lock()
+	defer s.commitMutex.Unlock()
+
+	usages := make([]Usage, 0, len(s.usage))
+	for _, usage := range s.usage {
+		usages = append(usages, *usage)
+	}
+
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Username < usages[j].Username })
+
+	return usages
+}
+
+// checkQuota returns an error if making the changes would take any user over the store's quota. Changes
+// that leave a user's usage the same or lower are always allowed, even if they are already over their quota
+// (such as after the quota has been reduced), so that users can always delete or shrink their keys.
+// The caller must hold the commit mutex.
+func checkQuota(s *KVStore, changes []change) error {
+	quota := s.config.Quota
+	if quota == (Quota{}) {
+		return nil
+	}
+
+	deltas := make(map[string]*Usage)
+
+	delta := func(username string) *Usage {
+		userDelta, ok := deltas[username]
+		if !ok {
+			userDelta = &Usage{Username: username}
+			deltas[username] = userDelta
+		}
+
+		return userDelta
+	}
+
+	for _, keyChange := range changes {
+		if existingEntry, ok := shardFor(s, keyChange.Key).data[keyChange.Key]; ok {
+			userDelta := delta(existingEntry.Owner)
+			userDelta.Keys--
+			userDelta.Bytes -= entrySize(keyChange.Key, existingEntry)
+		}
+
+		if keyChange.Entry != nil {
+			size := int64(len(keyChange.Entry.Value))
+			if quota.MaxValueSize > 0 && size > quota.MaxValueSize {
+				return fmt.Errorf("%w: %d bytes is over the limit of %d", ErrValueTooLarge, size, quota.MaxValueSize)
+			}
+
+			userDelta := delta(keyChange.Entry.Owner)
+			userDelta.Keys++
+			userDelta.Bytes += entrySize(keyChange.Key, keyChange.Entry)
+		}
+	}
+
+	for username, userDelta := range deltas {
+		current := Usage{}
+		if userUsage, ok := s.usage[username]; ok {
+			current = *userUsage
+		}
+
+		if quota.MaxKeys > 0 && userDelta.Keys > 0 && current.Keys+userDelta.Keys > quota.MaxKeys {
+			return fmt.Errorf("%w: %s would own more than %d keys", ErrQuotaExceeded, username, quota.MaxKeys)
+		}
+
+		if quota.MaxBytes > 0 && userDelta.Bytes > 0 && current.Bytes+userDelta.Bytes > quota.MaxBytes {
+			return fmt.Errorf("%w: %s would use more than %d bytes", ErrQuotaExceeded, username, quota.MaxBytes)
+		}
+	}
+
+	return nil
+}
+
+// addUsage adds the key's entry to (or with a sign of -1, takes it off) its owner's usage.
+func addUsage(s *KVStore, key string, e *entry, sign int) {
+	usage, ok := s.usage[e.Owner]
+	if !ok {
+		usage = &Usage{Username: e.Owner}
+		s.usage[e.Owner] = usage
+	}
+
+	usage.Keys += sign
+	usage.Bytes += int64(sign) * entrySize(key, e)
+
+	if usage.Keys == 0 {
+		delete(s.usage, e.Owner)
+	}
+}
+
+// entrySize returns the number of bytes a key counts as using towards its owner's quota.
+func entrySize(key string, e *entry) int64 {
+	return int64(len(key) + len(e.Value))
+}
@@ -0,0 +1,128 @@
+package kvstore_test
+
+import (
+	"errors"
+	"path/filepath"
+	"store/pkg/kvstore"
+	"testing"
+)
+
+func newQuotaStore(t *testing.T, quota kvstore.Quota) *kvstore.KVStore {
+	t.Helper()
+
+	store, err := kvstore.NewKVStoreWithConfig(kvstore.Config{Shards: testShards, Quota: quota})
+	if err != nil {
+		t.Fatal("Error opening store: ", err)
+	}
+
+	return store
+}
+
+func TestQuotaMaxKeys(t *testing.T) {
+	store := newQuotaStore(t, kvstore.Quota{MaxKeys: 2})
+
+	kvstore.Write(store, "a", value1, user1)
+	kvstore.Write(store, "b", value1, user1)
+
+	if err := kvstore.Write(store, "c", value1, user1); !errors.Is(err, kvstore.ErrQuotaExceeded) {
+		t.Fatal("Write of third key should have been over quota but got: ", err)
+	}
+
+	if err := kvstore.Write(store, "b", value2, user1); err != nil {
+		t.Fatal("Update of existing key should have been within quota but got: ", err)
+	}
+
+	if err := kvstore.Write(store, "c", value1, user2); err != nil {
+		t.Fatal("Other user's quota should have been separate but got: ", err)
+	}
+
+	kvstore.Delete(store, "a", user1)
+
+	if err := kvstore.Write(store, "d", value1, user1); err != nil {
+		t.Fatal("Write should have been within quota after delete but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestQuotaMaxBytes(t *testing.T) {
+	store := newQuotaStore(t, kvstore.Quota{MaxBytes: 10})
+
+	// each key uses 1 byte for the key and 3 for the value
+	kvstore.Write(store, "a", value1, user1)
+	kvstore.Write(store, "b", value1, user1)
+
+	if err := kvstore.Write(store, "c", value1, user1); !errors.Is(err, kvstore.ErrQuotaExceeded) {
+		t.Fatal("Write should have been over quota but got: ", err)
+	}
+
+	if err := kvstore.Write(store, "c", []byte("x"), user1); err != nil {
+		t.Fatal("Write should have been within quota but got: ", err)
+	}
+
+	if err := kvstore.Write(store, "a", []byte("xy"), user1); err != nil {
+		t.Fatal("Shrinking a key should have been within quota but got: ", err)
+	}
+
+	usage := kvstore.UserUsage(store, user1)
+	if usage.Keys != 3 || usage.Bytes != 9 {
+		t.Fatal("Usage should have been 3 keys and 9 bytes but was: ", usage)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestQuotaMaxValueSize(t *testing.T) {
+	store := newQuotaStore(t, kvstore.Quota{MaxValueSize: 3})
+
+	if err := kvstore.Write(store, key1, []byte("ABCD"), user1); !errors.Is(err, kvstore.ErrValueTooLarge) {
+		t.Fatal("Write should have been too large but got: ", err)
+	}
+
+	if err := kvstore.Write(store, key1, value1, user1); err != nil {
+		t.Fatal("Write should have been within limit but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestQuotaTransaction(t *testing.T) {
+	store := newQuotaStore(t, kvstore.Quota{MaxKeys: 1})
+
+	_, err := kvstore.Transaction(store, []kvstore.TxnOp{
+		{Type: kvstore.TxnPut, Key: key1, Value: value1},
+		{Type: kvstore.TxnPut, Key: key2, Value: value2},
+	}, user1)
+	if !errors.Is(err, kvstore.ErrQuotaExceeded) {
+		t.Fatal("Transaction should have been over quota but got: ", err)
+	}
+
+	if usage := kvstore.UserUsage(store, user1); usage.Keys != 0 {
+		t.Fatal("None of the transaction should have been made but usage was: ", usage)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestUsageRestored(t *testing.T) {
+	config := kvstore.Config{Shards: testShards, WALPath: filepath.Join(t.TempDir(), "store.wal")}
+
+	store, _ := kvstore.NewKVStoreWithConfig(config)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key2, value2, user2)
+	kvstore.Write(store, "key3", value2, user2)
+	kvstore.Delete(store, key1, user1)
+	kvstore.Close(store)
+
+	store, err := kvstore.NewKVStoreWithConfig(config)
+	if err != nil {
+		t.Fatal("Error re-opening store: ", err)
+	}
+
+	usages := kvstore.AllUsage(store)
+	if len(usages) != 1 || usages[0] != (kvstore.Usage{Username: user2, Keys: 2, Bytes: 14}) {
+		t.Fatal("Usage should have been restored but was: ", usages)
+	}
+
+	kvstore.Close(store)
+}
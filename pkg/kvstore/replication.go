@@ -0,0 +1,213 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrReadOnly is returned when changing a follower store, other than by replicating its leader.
+	ErrReadOnly = errors.New("store is a read-only follower")
+
+	errNotFollower = fmt.Errorf("%w: store is not a follower", ErrNotSupported)
+)
+
+// ReplicationRecord is a single revision of a leader store, as replicated to its followers. Unlike the
+// mutations in the change feed, it holds the full state of each changed key (or nil if the key was removed),
+// so it can only be applied by another store, see ApplyReplicationRecord.
+type ReplicationRecord struct {
+	Revision uint64   `json:"revision"`
+	Changes  []change `json:"changes"`
+	// Removal is why any keys removed in the revision were removed
+	Removal MutationType `json:"removal"`
+	Time    time.Time    `json:"time"`
+}
+
+// ReplicationLog returns the records of the revisions made after the specified revision, in revision order,
+// so that a follower can keep up with the store by passing the last revision it has applied each time. If
+// there are none yet, waits until there are, the context is done, or the store is closed. At most limit
+// records are returned, if limit is positive. The records are kept for as long as their mutations are kept
+// in the change feed, after which ErrCompacted is returned and the follower must start again from a snapshot
+// (see ReplicationSnapshot).
+//
+// The records include every key regardless of access control, so callers must only give them to followers.
+func ReplicationLog(ctx context.Context, s *KVStore, since uint64, limit int) ([]*ReplicationRecord, error) {
+	var records []*ReplicationRecord
+
+	err := waitForFeed(ctx, s.feed, func() (bool, error) {
+		var readErr error
+		records, readErr = recordsSince(s.feed, since, limit)
+
+		return len(records) > 0, readErr
+	})
+
+	return records, err
+}
+
+// ReplicationSnapshot returns the whole store (other than its namespaces, which aren't replicated) at its
+// current revision, for a follower to start from, see RestoreReplicationSnapshot.
+func ReplicationSnapshot(ctx context.Context, s *KVStore) ([]byte, error) {
+	// nothing can change while every shard is parked
+	release, err := parkShards(ctx, s.shards)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	s.commitMutex.Lock()
+	defer s.commitMutex.Unlock()
+
+	return json.Marshal(&snapshot{s.revision, snapshotEntries(s)})
+}
+
+// ApplyReplicationRecord makes the changes of a revision of the follower's leader, read from the leader's
+// replication log, giving the follower the same revision. Revisions the follower already has are ignored,
+// so it's harmless to apply a record more than once. The changes are recorded in the follower's own
+// write-ahead log (if enabled), and reported to its watchers and change feed, like any other change.
+func ApplyReplicationRecord(ctx context.Context, s *KVStore, record *ReplicationRecord) error {
+	if !s.config.Follower {
+		return errNotFollower
+	}
+
+	keys := make([]string, len(record.Changes))
+	for i, keyChange := range record.Changes {
+		keys[i] = keyChange.Key
+	}
+
+	release, err := parkShards(ctx, shardsFor(s, keys))
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	s.commitMutex.Lock()
+	defer s.commitMutex.Unlock()
+
+	if record.Revision <= s.revision {
+		return nil
+	}
+
+	return replicateChanges(s, record)
+}
+
+// RestoreReplicationSnapshot replaces the whole of the follower store with a snapshot of its leader (see
+// ReplicationSnapshot), giving it the leader's revision, so that it can catch up once the revisions it's
+// missing are no longer in the leader's replication log. The follower's watchers and change feed are told of
+// the difference as a single revision.
+func RestoreReplicationSnapshot(ctx context.Context, s *KVStore, reader io.Reader) error {
+	if !s.config.Follower {
+		return errNotFollower
+	}
+
+	loaded := &snapshot{}
+	if err := json.NewDecoder(reader).Decode(loaded); err != nil {
+		return fmt.Errorf("unable to parse snapshot: %w", err)
+	}
+
+	release, err := parkShards(ctx, s.shards)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	s.commitMutex.Lock()
+	defer s.commitMutex.Unlock()
+
+	changes := make([]change, 0, len(loaded.Entries))
+
+	for _, sh := range s.shards {
+		for key := range sh.data {
+			if _, ok := loaded.Entries[key]; !ok {
+				changes = append(changes, change{key, nil})
+			}
+		}
+	}
+
+	for key, e := range loaded.Entries {
+		changes = append(changes, change{key, e})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+
+	return replicateChanges(s, &ReplicationRecord{loaded.Revision, changes, MutationDelete, time.Now()})
+}
+
+// replicateChanges makes the changes of a leader's revision, in the same way as applyChanges makes local
+// ones, other than keeping the leader's revision and leaving expiry and eviction to the leader. The caller
+// must hold the commit mutex, and have parked the shard of every changed key.
+func replicateChanges(s *KVStore, record *ReplicationRecord) error {
+	if s.wal != nil {
+		written, err := appendToWAL(s.wal, &walRecord{record.Revision, record.Changes})
+		if err != nil {
+			return err
+		}
+
+		if err = syncWrites(s.wal, written); err != nil {
+			return err
+		}
+	}
+
+	s.revision = record.Revision
+	updateUsage(s, record.Changes, 1)
+
+	for _, keyChange := range record.Changes {
+		sh := shardFor(s, keyChange.Key)
+		recordHistory(sh, keyChange.Key, record.Revision, record.Time, keyChange.Entry)
+
+		if keyChange.Entry == nil {
+			removeKey(sh, keyChange.Key)
+		} else {
+			setEntry(sh, keyChange.Key, keyChange.Entry)
+		}
+	}
+
+	publishEvents(s.watches, changeEvents(record.Revision, record.Changes))
+	recordRevision(s.feed, newReplicationRecord(record.Revision, record.Time, record.Changes, record.Removal))
+
+	return nil
+}
+
+// recordsSince returns the records of the revisions after the specified revision, see ReplicationLog. The
+// caller must hold the feed's mutex.
+func recordsSince(feed *changeFeed, since uint64, limit int) ([]*ReplicationRecord, error) {
+	if since+1 < feed.firstRevision {
+		return nil, ErrCompacted
+	}
+
+	start := sort.Search(len(feed.records), func(i int) bool {
+		return feed.records[i].Revision > since
+	})
+
+	end := len(feed.records)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+
+	return append([]*ReplicationRecord{}, feed.records[start:end]...), nil
+}
+
+// newReplicationRecord returns the record of a revision. The entries are copied, since the stored entries
+// have their access counts updated in place.
+func newReplicationRecord(revision uint64, now time.Time, changes []change,
+	removal MutationType) *ReplicationRecord {
+	copied := make([]change, len(changes))
+
+	for i, keyChange := range changes {
+		copied[i] = change{keyChange.Key, nil}
+
+		if keyChange.Entry != nil {
+			e := *keyChange.Entry
+			copied[i].Entry = &e
+		}
+	}
+
+	return &ReplicationRecord{revision, copied, removal, now}
+}
@@ -0,0 +1,181 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"store/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+// replicate applies every record in the leader's replication log after the revision to the follower,
+// returning the last revision applied.
+func replicate(t *testing.T, leader *kvstore.KVStore, follower *kvstore.KVStore, since uint64) uint64 {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	records, err := kvstore.ReplicationLog(ctx, leader, since, 0)
+	if err != nil {
+		t.Fatal("Replication log should have been read but got: ", err)
+	}
+
+	for _, record := range records {
+		if err = kvstore.ApplyReplicationRecord(ctx, follower, record); err != nil {
+			t.Fatal("Record should have been applied but got: ", err)
+		}
+	}
+
+	return records[len(records)-1].Revision
+}
+
+func TestFollowerReplicatesLeader(t *testing.T) {
+	leader := kvstore.NewKVStore(testShards)
+	follower, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Shards: testShards, Follower: true})
+
+	kvstore.Write(leader, key1, value1, user1)
+	kvstore.Transaction(leader, []kvstore.TxnOp{
+		{Type: kvstore.TxnPut, Key: key1, Value: value2},
+		{Type: kvstore.TxnPut, Key: key2, Value: value1},
+	}, user1)
+
+	since := replicate(t, leader, follower, 0)
+
+	kvstore.Delete(leader, key2, user1)
+
+	since = replicate(t, leader, follower, since)
+
+	if value, ok := kvstore.Read(follower, key1, user2); !ok || !bytes.Equal(value, value2) {
+		t.Fatalf("Follower should have had the leader's value but had: %t value %s", ok, value)
+	}
+
+	if info := kvstore.List(follower, key1, user1); info == nil || info.Owner != user1 || info.Version != 2 {
+		t.Fatal("Follower should have had the leader's owner and version but had: ", info)
+	}
+
+	if _, ok := kvstore.Read(follower, key2, user1); ok {
+		t.Fatal("Deleted key should have been removed from the follower")
+	}
+
+	if revision := kvstore.StoreStats(follower).Revision; revision != since {
+		t.Fatalf("Follower should have had the leader's revision %d but had %d", since, revision)
+	}
+
+	if err := kvstore.Write(follower, key1, value1, user1); !errors.Is(err, kvstore.ErrReadOnly) {
+		t.Fatal("Writes to the follower should have been rejected but got: ", err)
+	}
+
+	kvstore.Close(leader)
+	kvstore.Close(follower)
+}
+
+func TestFollowerKeepsLeasedKeys(t *testing.T) {
+	leader := kvstore.NewKVStore(testShards)
+	follower, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Shards: testShards, Follower: true})
+
+	lease, _ := kvstore.GrantLease(leader, "", time.Minute, user1)
+	kvstore.WriteWithOptions(leader, key1, value1, user1, kvstore.WriteOptions{Lease: lease.ID})
+
+	since := replicate(t, leader, follower, 0)
+
+	if _, ok := kvstore.Read(follower, key1, user1); !ok {
+		t.Fatal("Leased key should have been kept by the follower")
+	}
+
+	kvstore.ReleaseLease(leader, lease.ID, user1)
+
+	replicate(t, leader, follower, since)
+
+	if _, ok := kvstore.Read(follower, key1, user1); ok {
+		t.Fatal("Leased key should have been removed along with the lease")
+	}
+
+	kvstore.Close(leader)
+	kvstore.Close(follower)
+}
+
+func TestFollowerRestoresSnapshot(t *testing.T) {
+	leader, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Shards: testShards, ChangeFeed: 1})
+	follower, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Shards: testShards, Follower: true})
+
+	kvstore.Write(leader, key1, value1, user1)
+	replicate(t, leader, follower, 0)
+
+	kvstore.Delete(leader, key1, user1)
+	kvstore.Write(leader, key2, value2, user2)
+
+	ctx := context.Background()
+
+	if _, err := kvstore.ReplicationLog(ctx, leader, 1, 0); !errors.Is(err, kvstore.ErrCompacted) {
+		t.Fatal("Discarded records should have been compacted but got: ", err)
+	}
+
+	snapshot, err := kvstore.ReplicationSnapshot(ctx, leader)
+	if err != nil {
+		t.Fatal("Snapshot should have been taken but got: ", err)
+	}
+
+	if err = kvstore.RestoreReplicationSnapshot(ctx, follower, bytes.NewReader(snapshot)); err != nil {
+		t.Fatal("Snapshot should have been restored but got: ", err)
+	}
+
+	if _, ok := kvstore.Read(follower, key1, user1); ok {
+		t.Fatal("Key deleted by the leader should have been removed from the follower")
+	}
+
+	if value, ok := kvstore.Read(follower, key2, user1); !ok || !bytes.Equal(value, value2) {
+		t.Fatalf("Follower should have had the leader's value but had: %t value %s", ok, value)
+	}
+
+	if revision := kvstore.StoreStats(follower).Revision; revision != 3 {
+		t.Fatal("Follower should have had the leader's revision but had: ", revision)
+	}
+
+	err = kvstore.RestoreReplicationSnapshot(ctx, leader, bytes.NewReader(snapshot))
+	if !errors.Is(err, kvstore.ErrNotSupported) {
+		t.Fatal("Only a follower should have restored a snapshot but got: ", err)
+	}
+
+	kvstore.Close(leader)
+	kvstore.Close(follower)
+}
+
+func TestFollowerHasNoNamespaces(t *testing.T) {
+	leader := kvstore.NewKVStore(testShards)
+	follower, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Shards: testShards, Follower: true})
+
+	kvstore.CreateNamespace(leader, "team", kvstore.NamespaceConfig{})
+	namespace, _ := kvstore.Namespace(leader, "team")
+	kvstore.Write(namespace, key1, value1, user1)
+	kvstore.Write(leader, key2, value2, user1)
+
+	ctx := context.Background()
+
+	snapshot, err := kvstore.ReplicationSnapshot(ctx, leader)
+	if err != nil {
+		t.Fatal("Snapshot should have been taken but got: ", err)
+	}
+
+	if err = kvstore.RestoreReplicationSnapshot(ctx, follower, bytes.NewReader(snapshot)); err != nil {
+		t.Fatal("Snapshot should have been restored but got: ", err)
+	}
+
+	// namespaces aren't replicated, and can't be created on the follower either
+	if _, ok := kvstore.Namespace(follower, "team"); ok {
+		t.Fatal("Follower should not have had the leader's namespace")
+	}
+
+	if _, ok := kvstore.Read(follower, key1, user1); ok {
+		t.Fatal("Key within the leader's namespace should not have been replicated")
+	}
+
+	err = kvstore.CreateNamespace(follower, "team", kvstore.NamespaceConfig{})
+	if !errors.Is(err, kvstore.ErrReadOnly) {
+		t.Fatal("Namespace should not have been created on the follower but got: ", err)
+	}
+
+	kvstore.Close(leader)
+	kvstore.Close(follower)
+}
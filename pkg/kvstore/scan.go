@@ -0,0 +1,150 @@
+package kvstore
+
+import (
+	"context"
+	"time"
+)
+
+// ScanEntry is a key and its value, as returned by a scan. The value is encoded in JSON as base64, since it
+// may not be text.
+type ScanEntry struct {
+	Key             string `json:"key"`
+	Value           []byte `json:"value"`
+	ContentType     string `json:"content_type,omitempty"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	Owner           string `json:"owner"`
+	Version         uint64 `json:"version"`
+}
+
+type scanRequest struct {
+	username        string
+	start           string
+	end             string
+	limit           int
+	responseChannel chan<- *scanResponse
+}
+
+type scanResponse struct {
+	entries []*ScanEntry
+}
+
+// Scan returns the keys and values from start (inclusive) up to end (exclusive), in lexical order
+// of key. An empty end means there is no upper bound, and a limit of zero or less means there is
+// no limit on the number of keys returned.
+//
+// Any user can scan keys, but private keys the user can't read are left out. Scanning a key does not count
+// as reading it.
+func Scan(s *KVStore, start string, end string, limit int, username string) []*ScanEntry {
+	entries, _ := ScanContext(context.Background(), s, start, end, limit, username)
+
+	return entries
+}
+
+// ScanContext is the same as Scan, but gives up if the context is done first, or the store is closed.
+func ScanContext(ctx context.Context, s *KVStore, start string, end string, limit int,
+	username string) ([]*ScanEntry, error) {
+	if err := linearize(ctx, s); err != nil {
+		return nil, err
+	}
+
+	// each shard returns up to the limit from its own keys, and these are then merged
+	responseChannel := make(chan *scanResponse, len(s.shards))
+	params := &scanRequest{username, start, end, limit, responseChannel}
+
+	for _, sh := range s.shards {
+		if err := sendRequest(ctx, sh, scanOperation, params); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([][]*ScanEntry, 0, len(s.shards))
+
+	for range s.shards {
+		select {
+		case response := <-responseChannel:
+			results = append(results, response.entries)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return mergeScans(results, limit), nil
+}
+
+// ScanPrefix returns the keys starting with the prefix, and their values, in lexical order of key.
+// A limit of zero or less means there is no limit on the number of keys returned.
+//
+// Any user can scan keys, but private keys the user can't read are left out. Scanning a key does not count
+// as reading it.
+func ScanPrefix(s *KVStore, prefix string, limit int, username string) []*ScanEntry {
+	return Scan(s, prefix, PrefixEnd(prefix), limit, username)
+}
+
+// PrefixEnd returns the first key after all of those starting with the prefix, for use as the end of
+// a scan. Returns an empty string (meaning no upper bound) if there is no such key.
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+
+			return string(end[:i+1])
+		}
+	}
+
+	return ""
+}
+
+// scanEntries walks the shard's ordered index from the start key, skipping any expired keys and those the
+// user can't read.
+func scanEntries(sh *shard, params *scanRequest) []*ScanEntry {
+	now := time.Now()
+	entries := make([]*ScanEntry, 0)
+
+	for node := sh.index.seek(params.start); node != nil; node = node.next[0] {
+		if params.end != "" && node.key >= params.end {
+			break
+		}
+
+		if params.limit > 0 && len(entries) >= params.limit {
+			break
+		}
+
+		if e := sh.data[node.key]; !hasExpired(e, now) && !hasLapsed(sh.store, e, now) &&
+			hasPermission(e, params.username, sh.store.groups, PermissionRead) {
+			entries = append(entries, &ScanEntry{node.key, copyBytes(e.Value), e.ContentType, e.ContentEncoding,
+				e.Owner, e.Version})
+		}
+	}
+
+	return entries
+}
+
+// mergeScans combines the scans of each shard, which are already in key order, into a single scan.
+func mergeScans(results [][]*ScanEntry, limit int) []*ScanEntry {
+	if len(results) == 1 {
+		return results[0]
+	}
+
+	entries := make([]*ScanEntry, 0)
+
+	for limit <= 0 || len(entries) < limit {
+		next := -1
+
+		for i, result := range results {
+			if len(result) > 0 && (next < 0 || result[0].Key < results[next][0].Key) {
+				next = i
+			}
+		}
+
+		if next < 0 {
+			break
+		}
+
+		entries = append(entries, results[next][0])
+		results[next] = results[next][1:]
+	}
+
+	return entries
+}
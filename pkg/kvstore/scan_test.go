@@ -0,0 +1,96 @@
+package kvstore_test
+
+import (
+	"fmt"
+	"sort"
+	"store/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func TestScanInKeyOrder(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	for _, key := range []string{"orders/2024/3", "orders/2023/9", "users/1", "orders/2024/1", "orders/2024/2"} {
+		kvstore.Write(store, key, []byte("value-"+key), user1)
+	}
+
+	checkScan(t, kvstore.Scan(store, "", "", 0, user1),
+		"orders/2023/9", "orders/2024/1", "orders/2024/2", "orders/2024/3", "users/1")
+	checkScan(t, kvstore.Scan(store, "orders/2024/2", "users", 0, user1), "orders/2024/2", "orders/2024/3")
+	checkScan(t, kvstore.Scan(store, "", "", 2, user1), "orders/2023/9", "orders/2024/1")
+	checkScan(t, kvstore.ScanPrefix(store, "orders/2024/", 0, user1), "orders/2024/1", "orders/2024/2", "orders/2024/3")
+	checkScan(t, kvstore.ScanPrefix(store, "products/", 0, user1))
+
+	entries := kvstore.Scan(store, "users/1", "", 1, user1)
+	if string(entries[0].Value) != "value-users/1" || entries[0].Owner != user1 {
+		t.Fatal("Scan should have returned value and owner but got:", entries[0])
+	}
+
+	kvstore.Close(store)
+}
+
+func TestScanSkipsDeletedAndExpiredKeys(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	kvstore.Write(store, "a", value1, user1)
+	kvstore.Write(store, "b", value1, user1)
+	kvstore.WriteWithOptions(store, "c", value1, user1, kvstore.WriteOptions{TTL: time.Millisecond})
+	kvstore.Write(store, "d", value1, user1)
+	kvstore.Delete(store, "b", user1)
+
+	time.Sleep(5 * time.Millisecond)
+
+	checkScan(t, kvstore.Scan(store, "", "", 0, user1), "a", "d")
+
+	kvstore.Close(store)
+}
+
+func TestScanManyKeys(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	expected := make([]string, 0)
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", (i*7919)%1000) // written out of order
+
+		kvstore.Write(store, key, value1, user1)
+
+		if i%3 == 0 {
+			kvstore.Delete(store, key, user1)
+		} else {
+			expected = append(expected, key)
+		}
+	}
+
+	sort.Strings(expected)
+	checkScan(t, kvstore.Scan(store, "", "", 0, user1), expected...)
+
+	kvstore.Close(store)
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, expected := range map[string]string{
+		"abc":      "abd",
+		"ab\xff":   "ac",
+		"\xff\xff": "",
+		"":         "",
+	} {
+		if end := kvstore.PrefixEnd(prefix); end != expected {
+			t.Fatalf("Prefix end of %q should have been %q but was %q", prefix, expected, end)
+		}
+	}
+}
+
+func checkScan(t *testing.T, entries []*kvstore.ScanEntry, expectedKeys ...string) {
+	t.Helper()
+
+	if len(entries) != len(expectedKeys) {
+		t.Fatalf("Scan should have returned %d keys but got %d", len(expectedKeys), len(entries))
+	}
+
+	for i, entry := range entries {
+		if entry.Key != expectedKeys[i] {
+			t.Fatalf("Scan key %d should have been %s but was %s", i, expectedKeys[i], entry.Key)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package kvstore
+
+import (
+	"context"
+	"sort"
+)
+
+const (
+	fnvOffsetBasis = 2166136261
+	fnvPrime       = 16777619
+)
+
+// shard holds the keys whose hash maps to it, along with the go routine that performs all operations on them.
+// Shards are independent of each other apart from sharing the store's revision and write-ahead log, which are
+// guarded by the store's commit mutex, and its watches, which are told of changes in revision order.
+type shard struct {
+	id             int
+	store          *KVStore
+	data           map[string]*entry
+	bytes          int64 // the total size of the keys and values in data
+	index          *keyIndex
+	expiries       expiryQueue
+	evictions      *evictionQueue // nil if the store doesn't evict keys
+	history        map[string]*keyHistory
+	historyStart   *keyVersion // nothing is known about keys without a history from before this point
+	requestChannel chan *request
+}
+
+type parkRequest struct {
+	parked  chan<- struct{}
+	release <-chan struct{}
+}
+
+func newShard(s *KVStore, id int) *shard {
+	sh := &shard{
+		id:             id,
+		store:          s,
+		data:           make(map[string]*entry),
+		index:          newKeyIndex(),
+		history:        make(map[string]*keyHistory),
+		historyStart:   &keyVersion{},
+		requestChannel: make(chan *request),
+	}
+
+	if eviction := s.config.Eviction; eviction.MaxKeys > 0 || eviction.MaxBytes > 0 {
+		sh.evictions = newEvictionQueue(eviction.Policy)
+	}
+
+	return sh
+}
+
+// shardFor returns the shard holding the key, using the FNV-1a hash of the key.
+func shardFor(s *KVStore, key string) *shard {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+
+	var hash uint32 = fnvOffsetBasis
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= fnvPrime
+	}
+
+	return s.shards[hash%uint32(len(s.shards))]
+}
+
+// shardsFor returns the distinct shards holding the keys, in order of id.
+func shardsFor(s *KVStore, keys []string) []*shard {
+	found := make(map[int]*shard)
+	for _, key := range keys {
+		sh := shardFor(s, key)
+		found[sh.id] = sh
+	}
+
+	shards := make([]*shard, 0, len(found))
+	for _, sh := range found {
+		shards = append(shards, sh)
+	}
+
+	sort.Slice(shards, func(i, j int) bool { return shards[i].id < shards[j].id })
+
+	return shards
+}
+
+// parkShards has the go routine of each shard stop and wait, so that the caller can safely work on all of
+// the shards' data at once, such as for a transaction across keys in different shards. The returned function
+// must be called to let the shards carry on. If the context is done or the store is closed before every shard
+// is parked, the shards already parked are let go and an error is returned.
+//
+// The shards must be in order of id, so that callers parking overlapping sets of shards can't deadlock.
+func parkShards(ctx context.Context, shards []*shard) (func(), error) {
+	parked := make(chan struct{})
+	release := make(chan struct{})
+
+	releaseFunc := func() {
+		close(release)
+	}
+
+	for _, sh := range shards {
+		if err := sendRequest(ctx, sh, parkOperation, &parkRequest{parked, release}); err != nil {
+			releaseFunc()
+
+			return nil, err
+		}
+
+		<-parked
+	}
+
+	return releaseFunc, nil
+}
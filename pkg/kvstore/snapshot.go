@@ -0,0 +1,125 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrSnapshotsDisabled is returned when a snapshot is requested but no snapshot path was configured.
+var ErrSnapshotsDisabled = errors.New("snapshots are not enabled")
+
+// snapshot is the point-in-time state of the whole store, as written to disk.
+type snapshot struct {
+	Revision uint64            `json:"revision"`
+	Entries  map[string]*entry `json:"entries"`
+}
+
+// loadSnapshot reads the snapshot at the specified path into the store's data.
+// It is not an error for the snapshot not to exist yet.
+func loadSnapshot(s *KVStore, path string) error {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	loaded := &snapshot{}
+	if err = json.Unmarshal(bytes, loaded); err != nil {
+		return fmt.Errorf("unable to parse snapshot %s: %w", path, err)
+	}
+
+	s.revision = loaded.Revision
+
+	for key, entry := range loaded.Entries {
+		setEntry(shardFor(s, key), key, entry)
+		addUsage(s, key, entry, 1)
+	}
+
+	return nil
+}
+
+// takeSnapshot writes the whole store to the snapshot file, and then discards the write-ahead log
+// since everything in it is now covered by the snapshot. Every shard must be parked, so that no changes
+// can be made part way through.
+func takeSnapshot(s *KVStore) error {
+	entries := snapshotEntries(s)
+
+	bytes, err := json.Marshal(&snapshot{s.revision, entries})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	if err = writeFileAtomically(s.config.SnapshotPath, bytes); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	if s.wal != nil {
+		if err = truncateWAL(s.wal); err != nil {
+			return fmt.Errorf("%w: %v", ErrPersistence, err)
+		}
+	}
+
+	s.logger.Printf("Snapshot of %d keys written to %s", len(entries), s.config.SnapshotPath)
+
+	return nil
+}
+
+// snapshotEntries returns the entry of every key in the store. Every shard must be parked.
+func snapshotEntries(s *KVStore) map[string]*entry {
+	entries := make(map[string]*entry)
+
+	for _, sh := range s.shards {
+		for key, e := range sh.data {
+			entries[key] = e
+		}
+	}
+
+	return entries
+}
+
+// writeFileAtomically writes the bytes to a temporary file alongside the target path, then renames it
+// into place, so that a crash part way through never leaves a partially written file behind.
+func writeFileAtomically(path string, bytes []byte) error {
+	dir := filepath.Dir(path)
+
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name()) // no-op once renamed
+
+	if _, err = file.Write(bytes); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(file.Name(), path); err != nil {
+		return err
+	}
+
+	// make the rename itself durable, where the platform supports it
+	if dirFile, openErr := os.Open(dir); openErr == nil {
+		dirFile.Sync()
+		dirFile.Close()
+	}
+
+	return nil
+}
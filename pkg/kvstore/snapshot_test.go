@@ -0,0 +1,97 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"store/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func TestSnapshotAndLogReplayedOnRestart(t *testing.T) {
+	dir := t.TempDir()
+	config := kvstore.Config{
+		Shards:       testShards,
+		WALPath:      filepath.Join(dir, "store.wal"),
+		SnapshotPath: filepath.Join(dir, "store.snapshot"),
+	}
+
+	store, err := kvstore.NewKVStoreWithConfig(config)
+	if err != nil {
+		t.Fatal("Error opening store: ", err)
+	}
+
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Read(store, key1, user1)
+
+	if err = kvstore.Snapshot(store); err != nil {
+		t.Fatal("Snapshot should have been successful but got: ", err)
+	}
+
+	info, err := os.Stat(config.WALPath)
+	if err != nil || info.Size() != 0 {
+		t.Fatal("Log should have been truncated after snapshot but was: ", info, err)
+	}
+
+	kvstore.Write(store, key2, value2, user2) // only in the log
+	kvstore.Close(store)
+
+	store, err = kvstore.NewKVStoreWithConfig(config)
+	if err != nil {
+		t.Fatal("Error re-opening store: ", err)
+	}
+
+	entryInfo := kvstore.List(store, key1, user1)
+	if entryInfo == nil || entryInfo.Owner != user1 || entryInfo.Reads != 1 || entryInfo.Writes != 1 {
+		t.Fatal("Key should have been restored from snapshot but was: ", entryInfo)
+	}
+
+	if value, ok := kvstore.Read(store, key2, user1); !ok || !bytes.Equal(value, value2) {
+		t.Fatalf("Key should have been replayed from log but was: %t (value %s)", ok, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestPeriodicSnapshot(t *testing.T) {
+	config := kvstore.Config{
+		SnapshotPath:     filepath.Join(t.TempDir(), "store.snapshot"),
+		SnapshotInterval: 10 * time.Millisecond,
+	}
+
+	store, _ := kvstore.NewKVStoreWithConfig(config)
+	kvstore.Write(store, key1, value1, user1)
+
+	// closing the store doesn't take a snapshot, so wait for one to have been taken since the write
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if snapshot, err := os.ReadFile(config.SnapshotPath); err == nil && bytes.Contains(snapshot, []byte(key1)) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("Snapshot should have been taken with the key")
+		}
+	}
+
+	kvstore.Close(store)
+
+	store, _ = kvstore.NewKVStoreWithConfig(config)
+
+	if value, ok := kvstore.Read(store, key1, user1); !ok || !bytes.Equal(value, value1) {
+		t.Fatalf("Key should have been restored from snapshot but was: %t (value %s)", ok, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestSnapshotNotConfigured(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	if err := kvstore.Snapshot(store); !errors.Is(err, kvstore.ErrSnapshotsDisabled) {
+		t.Fatal("Snapshot should have been rejected but got: ", err)
+	}
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,77 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotSupported is returned when an option is used that the store implementation doesn't provide.
+var ErrNotSupported = errors.New("not supported by this store")
+
+// Store is the basic set of operations provided by every key value store implementation, allowing them to be
+// used interchangeably. The further features of KVStore (such as transactions and watches) are only available
+// through the package functions taking a *KVStore, and other implementations return ErrNotSupported for any
+// options they can't honour, such as a time-to-live.
+//
+// All implementations are thread-safe, and apply the same ownership rules: any user can list and read a key
+// unless its access control list makes it private, but only the owning user (or those granted permission
+// in the key's ACL) can update or delete it. Each operation gives up if the context is done first, returning
+// the context's error, and returns ErrClosed once the store has been closed.
+//
+// Writes are checked in the same way by every implementation, including that a value written with the JSON
+// content type is a valid document. Only KVStore has a Config, so the other implementations don't give keys a
+// default time-to-live or access control list, or enforce quotas or size limits.
+type Store interface {
+	// Read returns the value and details of the specified key, and a flag indicating if the key was present.
+	Read(ctx context.Context, key string, username string) (*Item, bool, error)
+	// Write sets or updates the key value, using the specified options.
+	Write(ctx context.Context, key string, value []byte, username string, options WriteOptions) error
+	// Delete removes a key using the specified options, and returns a flag indicating if the key was deleted.
+	Delete(ctx context.Context, key string, username string, options DeleteOptions) (bool, error)
+	// List returns the details of the specified key, or nil if not present, and ErrPermissionDenied if the
+	// user can't read the key.
+	List(ctx context.Context, key string, username string) (*EntryInfo, error)
+	// ListAll returns the details of all keys, other than those the user can't read.
+	ListAll(ctx context.Context, username string) ([]*EntryInfo, error)
+	// Close shuts down the store cleanly, flushing any outstanding changes to disk.
+	Close() error
+}
+
+// Read implements Store, see the ReadItemContext function.
+func (s *KVStore) Read(ctx context.Context, key string, username string) (*Item, bool, error) {
+	return ReadItemContext(ctx, s, key, username)
+}
+
+// Write implements Store, see the WriteWithOptionsContext function.
+func (s *KVStore) Write(ctx context.Context, key string, value []byte, username string, options WriteOptions) error {
+	return WriteWithOptionsContext(ctx, s, key, value, username, options)
+}
+
+// Delete implements Store, see the DeleteWithOptionsContext function.
+func (s *KVStore) Delete(ctx context.Context, key string, username string, options DeleteOptions) (bool, error) {
+	return DeleteWithOptionsContext(ctx, s, key, username, options)
+}
+
+// List implements Store, see the ListContext function.
+func (s *KVStore) List(ctx context.Context, key string, username string) (*EntryInfo, error) {
+	return ListContext(ctx, s, key, username)
+}
+
+// ListAll implements Store, see the ListAllContext function.
+func (s *KVStore) ListAll(ctx context.Context, username string) ([]*EntryInfo, error) {
+	return ListAllContext(ctx, s, username)
+}
+
+// Close implements Store, see the Close function.
+func (s *KVStore) Close() error {
+	return Close(s)
+}
+
+// checkUsable returns the reason an operation can't be made, if the store is closed or the context is done.
+func checkUsable(ctx context.Context, closed bool) error {
+	if closed {
+		return ErrClosed
+	}
+
+	return ctx.Err()
+}
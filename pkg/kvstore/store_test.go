@@ -0,0 +1,227 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"store/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+// storeImplementations returns a new instance of each Store implementation, by name.
+func storeImplementations(t *testing.T) map[string]kvstore.Store {
+	t.Helper()
+
+	diskStore, err := kvstore.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal("Error opening disk store: ", err)
+	}
+
+	return map[string]kvstore.Store{
+		"channel": kvstore.NewKVStore(testShards),
+		"mutex":   kvstore.NewMutexStore(),
+		"disk":    diskStore,
+	}
+}
+
+func TestStoreImplementations(t *testing.T) {
+	for name, store := range storeImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if err := store.Write(ctx, key1, value1, user1, kvstore.WriteOptions{}); err != nil {
+				t.Fatal("Write should have been successful but got:", err)
+			}
+
+			if err := store.Write(ctx, key1, value2, user2, kvstore.WriteOptions{}); err == nil {
+				t.Fatal("Update by different user should have failed")
+			}
+
+			if err := store.Write(ctx, key2, value2, user2, kvstore.WriteOptions{}); err != nil {
+				t.Fatal("Write should have been successful but got:", err)
+			}
+
+			if item, ok, _ := store.Read(ctx, key1, user1); !ok || !bytes.Equal(item.Value, value1) {
+				t.Fatalf("Key should have been %s but was: %t %v", value1, ok, item)
+			}
+
+			entryInfo, _ := store.List(ctx, key1, user1)
+			if entryInfo == nil || entryInfo.Owner != user1 || entryInfo.Reads != 1 || entryInfo.Version != 1 {
+				t.Fatal("Key details were wrong: ", entryInfo)
+			}
+
+			if entries, _ := store.ListAll(ctx, user1); len(entries) != 2 {
+				t.Fatal("ListAll should have 2 entries but was:", entries)
+			}
+
+			if _, err := store.Delete(ctx, key1, user2, kvstore.DeleteOptions{}); err == nil {
+				t.Fatal("Delete by different user should have failed")
+			}
+
+			if deleted, err := store.Delete(ctx, key1, user1, kvstore.DeleteOptions{}); !deleted || err != nil {
+				t.Fatal("Delete should have been successful but got:", deleted, err)
+			}
+
+			if _, ok, _ := store.Read(ctx, key1, user1); ok {
+				t.Fatal("Key should have been deleted")
+			}
+
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+
+			err := store.Write(cancelled, key1, value1, user1, kvstore.WriteOptions{})
+			if !errors.Is(err, context.Canceled) {
+				t.Fatal("Write with cancelled context should have failed but got:", err)
+			}
+
+			if err := store.Close(); err != nil {
+				t.Fatal("Close should have been successful but got:", err)
+			}
+
+			if _, _, err := store.Read(ctx, key2, user1); !errors.Is(err, kvstore.ErrClosed) {
+				t.Fatal("Read after close should have failed but got:", err)
+			}
+
+			if err := store.Close(); !errors.Is(err, kvstore.ErrClosed) {
+				t.Fatal("Second close should have failed but got:", err)
+			}
+		})
+	}
+}
+
+func TestStoreContentType(t *testing.T) {
+	binary := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	options := kvstore.WriteOptions{ContentType: "image/png", ContentEncoding: "gzip"}
+
+	for name, store := range storeImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if err := store.Write(ctx, key1, binary, user1, options); err != nil {
+				t.Fatal("Write should have been successful but got:", err)
+			}
+
+			item, _, _ := store.Read(ctx, key1, user1)
+			if !bytes.Equal(item.Value, binary) || item.ContentType != "image/png" || item.ContentEncoding != "gzip" {
+				t.Fatal("Value and content type should have been kept but got:", item)
+			}
+
+			// changing the returned value mustn't change the stored one
+			item.Value[0] = 0
+
+			if item, _, _ = store.Read(ctx, key1, user1); !bytes.Equal(item.Value, binary) {
+				t.Fatal("Stored value should have been unchanged but got:", item.Value)
+			}
+
+			entryInfo, _ := store.List(ctx, key1, user1)
+			if entryInfo.Size != len(binary) || entryInfo.ContentType != "image/png" {
+				t.Fatal("Key details were wrong: ", entryInfo)
+			}
+
+			store.Close()
+		})
+	}
+}
+
+func TestStoreRejectsInvalidDocument(t *testing.T) {
+	options := kvstore.WriteOptions{ContentType: kvstore.ContentTypeJSON}
+
+	for name, store := range storeImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			err := store.Write(context.Background(), key1, []byte("{not json"), user1, options)
+			if !errors.Is(err, kvstore.ErrNotDocument) {
+				t.Fatal("Write of invalid document should have failed but got:", err)
+			}
+
+			store.Close()
+		})
+	}
+}
+
+func TestTTLNotSupported(t *testing.T) {
+	for name, store := range storeImplementations(t) {
+		err := store.Write(context.Background(), key1, value1, user1, kvstore.WriteOptions{TTL: time.Minute})
+		if name != "channel" && !errors.Is(err, kvstore.ErrNotSupported) {
+			t.Errorf("Write with TTL should not be supported by %s store but got: %v", name, err)
+		}
+
+		store.Close()
+	}
+}
+
+func TestDiskStoreReopened(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, _ := kvstore.NewDiskStore(dir)
+	store.Write(ctx, key1, value1, user1, kvstore.WriteOptions{})
+	store.Write(ctx, "a/b?c", value2, user1, kvstore.WriteOptions{ContentType: "text/plain"})
+	store.Close()
+
+	store, err := kvstore.NewDiskStore(dir)
+	if err != nil {
+		t.Fatal("Error reopening disk store: ", err)
+	}
+
+	if item, ok, _ := store.Read(ctx, "a/b?c", user1); !ok || !bytes.Equal(item.Value, value2) ||
+		item.ContentType != "text/plain" {
+		t.Fatalf("Key should have been %s but was: %t %v", value2, ok, item)
+	}
+
+	store.Write(ctx, key2, value1, user1, kvstore.WriteOptions{})
+
+	if entryInfo, _ := store.List(ctx, key2, user1); entryInfo == nil || entryInfo.Version != 3 {
+		t.Fatal("Versions should have carried on from before but got: ", entryInfo)
+	}
+
+	store.Close()
+}
+
+func TestDiskStoreRevisionNotReused(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, _ := kvstore.NewDiskStore(dir)
+	store.Write(ctx, key1, value1, user1, kvstore.WriteOptions{})
+	store.Write(ctx, key2, value2, user1, kvstore.WriteOptions{})
+	store.Delete(ctx, key2, user1, kvstore.DeleteOptions{})
+	store.Close()
+
+	store, err := kvstore.NewDiskStore(dir)
+	if err != nil {
+		t.Fatal("Error reopening disk store: ", err)
+	}
+	defer store.Close()
+
+	store.Write(ctx, key2, value1, user1, kvstore.WriteOptions{})
+
+	// the newest key was deleted, but its version mustn't be given out again
+	if entryInfo, _ := store.List(ctx, key2, user1); entryInfo == nil || entryInfo.Version != 3 {
+		t.Fatal("Versions should have carried on from the latest but got: ", entryInfo)
+	}
+}
+
+func TestDiskStoreReadsSavedWithWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, _ := kvstore.NewDiskStore(dir)
+	store.Write(ctx, key1, value1, user1, kvstore.WriteOptions{})
+	store.Read(ctx, key1, user1)
+	store.Read(ctx, key1, user1)
+
+	if entryInfo, _ := store.List(ctx, key1, user1); entryInfo == nil || entryInfo.Reads != 2 {
+		t.Fatal("Reads should have been counted but got: ", entryInfo)
+	}
+
+	store.Write(ctx, key1, value2, user1, kvstore.WriteOptions{})
+	store.Close()
+
+	store, _ = kvstore.NewDiskStore(dir)
+	defer store.Close()
+
+	if entryInfo, _ := store.List(ctx, key1, user1); entryInfo == nil || entryInfo.Reads != 2 || entryInfo.Writes != 2 {
+		t.Fatal("Reads should have been saved with the write but got: ", entryInfo)
+	}
+}
@@ -0,0 +1,228 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TxnOpType is the type of a single operation within a transaction.
+type TxnOpType string
+
+const (
+	// TxnGet reads the value of a key.
+	TxnGet TxnOpType = "get"
+	// TxnPut sets or updates the value of a key.
+	TxnPut TxnOpType = "put"
+	// TxnDelete removes a key.
+	TxnDelete TxnOpType = "delete"
+	// TxnCheck checks a condition on the current version of a key, without changing it.
+	TxnCheck TxnOpType = "check"
+)
+
+// ErrInvalidTxn is returned when a transaction contains an operation that isn't valid.
+var ErrInvalidTxn = errors.New("invalid transaction")
+
+// TxnOp is a single operation within a transaction.
+type TxnOp struct {
+	Type  TxnOpType
+	Key   string
+	Value []byte
+	// TTL is how long a put key should live for before expiring. If zero the key never expires.
+	TTL time.Duration
+	// Condition must hold for the key for the transaction to be made. Required for a check,
+	// and optional for all other operations.
+	Condition *Condition
+}
+
+// TxnResult is the outcome of a single operation within a transaction.
+type TxnResult struct {
+	Key string `json:"key"`
+	// Found indicates if the key was present before a get, delete or check.
+	Found bool `json:"found"`
+	// Value is the value read by a get, encoded in JSON as base64 since it may not be text.
+	Value           []byte `json:"value,omitempty"`
+	ContentType     string `json:"content_type,omitempty"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	Version         uint64 `json:"version,omitempty"`
+}
+
+// TxnError is returned when an operation within a transaction fails,
+// in which case none of the transaction's changes are made.
+type TxnError struct {
+	// Op is the index of the operation which failed.
+	Op  int
+	Err error
+}
+
+func (e *TxnError) Error() string {
+	return fmt.Sprintf("transaction operation %d failed: %v", e.Op, e.Err)
+}
+
+func (e *TxnError) Unwrap() error {
+	return e.Err
+}
+
+// Transaction performs the operations in order as a single atomic change to the store, returning the
+// result of each one. Each operation sees the changes made by those before it. If any operation fails,
+// the store is left unchanged and a TxnError is returned.
+//
+// The usual ownership and access control rules apply to each operation.
+func Transaction(s *KVStore, ops []TxnOp, username string) ([]*TxnResult, error) {
+	return TransactionContext(context.Background(), s, ops, username)
+}
+
+// TransactionContext is the same as Transaction, but gives up if the context is done before the transaction
+// is started, or the store is closed.
+func TransactionContext(ctx context.Context, s *KVStore, ops []TxnOp, username string) ([]*TxnResult, error) {
+	keys := make([]string, len(ops))
+	for i, op := range ops {
+		keys[i] = op.Key
+	}
+
+	// the keys may be spread across several shards, so take over all of them while the transaction is made
+	release, err := parkShards(ctx, shardsFor(s, keys))
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := executeTxn(s, ops, username)
+	release()
+
+	if err == nil {
+		enforceLimits(ctx, s, keys...)
+	}
+
+	return results, err
+}
+
+// executeTxn performs each operation against a staged copy of the changed keys, and then
+// commits all the changes together if every operation succeeded.
+func executeTxn(s *KVStore, ops []TxnOp, username string) ([]*TxnResult, error) {
+	now := time.Now()
+	staged := make(map[string]*entry) // nil for a deleted key
+	changedKeys := make([]string, 0, len(ops))
+	readKeys := make([]string, 0)
+	results := make([]*TxnResult, len(ops))
+	resultEntries := make([]*entry, len(ops)) // to report versions once committed
+
+	current := func(key string) *entry {
+		if stagedEntry, ok := staged[key]; ok {
+			return stagedEntry
+		}
+
+		existingEntry, _ := lookupEntry(shardFor(s, key), key)
+
+		return existingEntry
+	}
+
+	stage := func(key string, updatedEntry *entry) {
+		if _, ok := staged[key]; !ok {
+			changedKeys = append(changedKeys, key)
+		}
+
+		staged[key] = updatedEntry
+	}
+
+	for i, op := range ops {
+		if err := validateTxnOp(op); err != nil {
+			return nil, &TxnError{i, err}
+		}
+
+		existingEntry := current(op.Key)
+		result := &TxnResult{Key: op.Key, Found: existingEntry != nil}
+		resultEntries[i] = existingEntry
+
+		switch op.Type {
+		case TxnGet:
+			if !checkCondition(op.Condition, existingEntry) {
+				return nil, &TxnError{i, ErrVersionMismatch}
+			}
+
+			if existingEntry != nil {
+				if !hasPermission(existingEntry, username, s.groups, PermissionRead) {
+					return nil, &TxnError{i, ErrPermissionDenied}
+				}
+
+				result.Value = copyBytes(existingEntry.Value)
+				result.ContentType = existingEntry.ContentType
+				result.ContentEncoding = existingEntry.ContentEncoding
+				readKeys = append(readKeys, op.Key)
+			}
+
+		case TxnPut:
+			updatedEntry, err := prepareStoreWrite(s, existingEntry, op.Value, username,
+				WriteOptions{TTL: op.TTL, Condition: op.Condition}, now)
+			if err != nil {
+				return nil, &TxnError{i, err}
+			}
+
+			stage(op.Key, updatedEntry)
+			resultEntries[i] = updatedEntry
+
+		case TxnDelete:
+			err := prepareDelete(existingEntry, username, s.groups, DeleteOptions{Condition: op.Condition})
+			if err != nil {
+				return nil, &TxnError{i, err}
+			}
+
+			if existingEntry != nil {
+				stage(op.Key, nil)
+			}
+
+		case TxnCheck:
+			if !checkCondition(op.Condition, existingEntry) {
+				return nil, &TxnError{i, ErrVersionMismatch}
+			}
+		}
+
+		results[i] = result
+	}
+
+	if len(changedKeys) > 0 {
+		changes := make([]change, 0, len(changedKeys))
+		for _, key := range changedKeys {
+			changes = append(changes, change{key, staged[key]})
+		}
+
+		if err := commitChanges(s, changes); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, resultEntry := range resultEntries {
+		if resultEntry != nil {
+			results[i].Version = resultEntry.Version
+		}
+	}
+
+	// reads are only counted once the transaction has been made
+	for _, key := range readKeys {
+		sh := shardFor(s, key)
+		if readEntry, ok := sh.data[key]; ok {
+			touchEntry(sh, key, readEntry, now)
+		}
+	}
+
+	return results, nil
+}
+
+func validateTxnOp(op TxnOp) error {
+	if op.Key == "" {
+		return fmt.Errorf("%w: no key specified", ErrInvalidTxn)
+	}
+
+	switch op.Type {
+	case TxnGet, TxnPut, TxnDelete:
+		return nil
+	case TxnCheck:
+		if op.Condition == nil {
+			return fmt.Errorf("%w: check has no condition", ErrInvalidTxn)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidTxn, op.Type)
+	}
+}
@@ -0,0 +1,127 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"store/pkg/kvstore"
+	"testing"
+)
+
+func TestTransactionAppliesAllOperations(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	kvstore.Write(store, key2, value2, user1)
+
+	results, err := kvstore.Transaction(store, []kvstore.TxnOp{
+		{Type: kvstore.TxnPut, Key: key1, Value: value1},
+		{Type: kvstore.TxnGet, Key: key1},
+		{Type: kvstore.TxnDelete, Key: key2},
+	}, user1)
+	if err != nil {
+		t.Fatal("Transaction should have been successful but got:", err)
+	}
+
+	if len(results) != 3 {
+		t.Fatal("Transaction should have 3 results but got:", results)
+	}
+
+	if !results[1].Found || !bytes.Equal(results[1].Value, value1) {
+		t.Fatal("Get should have seen earlier put but got:", results[1])
+	}
+
+	if !results[2].Found {
+		t.Fatal("Delete should have found key but got:", results[2])
+	}
+
+	if value, ok := kvstore.Read(store, key1, user1); !ok || !bytes.Equal(value, value1) {
+		t.Fatalf("Put key should be present but was: %t (value %s)", ok, value)
+	}
+
+	if value, ok := kvstore.Read(store, key2, user1); ok {
+		t.Fatalf("Deleted key should not be present but was: %t (value %s)", ok, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestTransactionFailedCheckMakesNoChanges(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	kvstore.Write(store, key2, value2, user1)
+
+	_, err := kvstore.Transaction(store, []kvstore.TxnOp{
+		{Type: kvstore.TxnPut, Key: key1, Value: value1},
+		{Type: kvstore.TxnCheck, Key: key2, Condition: &kvstore.Condition{Type: kvstore.IfVersion, Version: 99}},
+	}, user1)
+
+	var txnErr *kvstore.TxnError
+	if !errors.As(err, &txnErr) || txnErr.Op != 1 || !errors.Is(err, kvstore.ErrVersionMismatch) {
+		t.Fatal("Transaction should have failed on the check but got:", err)
+	}
+
+	if value, ok := kvstore.Read(store, key1, user1); ok {
+		t.Fatalf("Put key should not be present but was: %t (value %s)", ok, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestTransactionOwnership(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+	kvstore.Write(store, key2, value2, user2)
+
+	_, err := kvstore.Transaction(store, []kvstore.TxnOp{
+		{Type: kvstore.TxnPut, Key: key1, Value: value1},
+		{Type: kvstore.TxnPut, Key: key2, Value: value1}, // owned by someone else
+	}, user1)
+	if err == nil {
+		t.Fatal("Transaction should have failed")
+	}
+
+	if value, ok := kvstore.Read(store, key1, user1); ok {
+		t.Fatalf("Put key should not be present but was: %t (value %s)", ok, value)
+	}
+
+	if value, _ := kvstore.Read(store, key2, user1); !bytes.Equal(value, value2) {
+		t.Fatalf("Key value should have been %s but was: %s", value2, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestTransactionInvalidOperation(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	_, err := kvstore.Transaction(store, []kvstore.TxnOp{{Type: "upsert", Key: key1}}, user1)
+	if !errors.Is(err, kvstore.ErrInvalidTxn) {
+		t.Fatal("Transaction should have been invalid but got:", err)
+	}
+
+	_, err = kvstore.Transaction(store, []kvstore.TxnOp{{Type: kvstore.TxnCheck, Key: key1}}, user1)
+	if !errors.Is(err, kvstore.ErrInvalidTxn) {
+		t.Fatal("Check without condition should have been invalid but got:", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestTransactionReplayedOnRestart(t *testing.T) {
+	config := kvstore.Config{Shards: testShards, WALPath: filepath.Join(t.TempDir(), "store.wal")}
+
+	store, _ := kvstore.NewKVStoreWithConfig(config)
+	kvstore.Transaction(store, []kvstore.TxnOp{
+		{Type: kvstore.TxnPut, Key: key1, Value: value1},
+		{Type: kvstore.TxnPut, Key: key2, Value: value2},
+	}, user1)
+	kvstore.Close(store)
+
+	store, _ = kvstore.NewKVStoreWithConfig(config)
+
+	item1, ok1 := kvstore.ReadItem(store, key1, user1)
+	item2, ok2 := kvstore.ReadItem(store, key2, user1)
+
+	if !ok1 || !ok2 || item1.Version != item2.Version {
+		t.Fatal("Both keys should have been replayed at the same version but got:", item1, item2)
+	}
+
+	kvstore.Close(store)
+}
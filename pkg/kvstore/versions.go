@@ -0,0 +1,75 @@
+package kvstore
+
+import "errors"
+
+// ErrVersionMismatch is returned when a conditional write or delete is not made,
+// because the key's current version did not match the condition.
+var ErrVersionMismatch = errors.New("key version does not match")
+
+// ConditionType determines how a Condition checks the current version of a key.
+type ConditionType int
+
+const (
+	// IfVersion requires the key to exist with exactly the condition's version.
+	IfVersion ConditionType = iota + 1
+	// IfNotVersion requires the key to either not exist, or have a different version to the condition's.
+	IfNotVersion ConditionType = iota + 1
+	// IfExists requires the key to exist, with any version.
+	IfExists ConditionType = iota + 1
+	// IfNotExists requires the key to not exist.
+	IfNotExists ConditionType = iota + 1
+)
+
+// Condition is a check made against the current version of a key, which must pass
+// for a conditional write or delete to be made.
+type Condition struct {
+	Type    ConditionType
+	Version uint64
+}
+
+// Item is the value of a key, along with its version and the type of content it holds.
+//
+// Every change made to the store is given a new revision number, one higher than the last,
+// and a key's version is the revision at which it was last written. This means versions always
+// increase, even if a key is deleted and then written again.
+type Item struct {
+	Value           []byte
+	Version         uint64
+	ContentType     string
+	ContentEncoding string
+}
+
+// CompareAndSwap sets or updates the key value, but only if the key's current version
+// is expectedVersion. An expectedVersion of zero means the key must not currently exist.
+// Returns ErrVersionMismatch if the key's version was different.
+//
+// Only the owning user can update an existing entry.
+func CompareAndSwap(s *KVStore, key string, expectedVersion uint64, value []byte, username string) error {
+	condition := &Condition{IfVersion, expectedVersion}
+	if expectedVersion == 0 {
+		condition = &Condition{IfNotExists, 0}
+	}
+
+	return WriteWithOptions(s, key, value, username, WriteOptions{Condition: condition})
+}
+
+// checkCondition returns whether the condition (if any) holds for the key's current entry,
+// which is nil if the key doesn't exist.
+func checkCondition(condition *Condition, current *entry) bool {
+	if condition == nil {
+		return true
+	}
+
+	switch condition.Type {
+	case IfVersion:
+		return current != nil && current.Version == condition.Version
+	case IfNotVersion:
+		return current == nil || current.Version != condition.Version
+	case IfExists:
+		return current != nil
+	case IfNotExists:
+		return current == nil
+	default:
+		return false
+	}
+}
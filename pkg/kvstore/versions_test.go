@@ -0,0 +1,101 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"store/pkg/kvstore"
+	"testing"
+)
+
+func TestVersionIncreasesOnEachWrite(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key2, value1, user1)
+	kvstore.Write(store, key1, value2, user1)
+
+	item, ok := kvstore.ReadItem(store, key1, user1)
+	if !ok || !bytes.Equal(item.Value, value2) || item.Version != 3 {
+		t.Fatal("Key should have been at version 3 but was:", ok, item)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	if err := kvstore.CompareAndSwap(store, key1, 0, value1, user1); err != nil {
+		t.Fatal("Create should have been successful but got:", err)
+	}
+
+	if err := kvstore.CompareAndSwap(store, key1, 0, value2, user1); !errors.Is(err, kvstore.ErrVersionMismatch) {
+		t.Fatal("Create of existing key should have failed but got:", err)
+	}
+
+	item, _ := kvstore.ReadItem(store, key1, user1)
+
+	if err := kvstore.CompareAndSwap(store, key1, item.Version+1, value2, user1); !errors.Is(err,
+		kvstore.ErrVersionMismatch) {
+		t.Fatal("Update with wrong version should have failed but got:", err)
+	}
+
+	if err := kvstore.CompareAndSwap(store, key1, item.Version, value2, user2); errors.Is(err,
+		kvstore.ErrVersionMismatch) || err == nil {
+		t.Fatal("Update by different user should have failed as forbidden but got:", err)
+	}
+
+	if err := kvstore.CompareAndSwap(store, key1, item.Version, value2, user1); err != nil {
+		t.Fatal("Update with current version should have been successful but got:", err)
+	}
+
+	if value, _ := kvstore.Read(store, key1, user1); !bytes.Equal(value, value2) {
+		t.Fatalf("Key value should have been %s but was: %s", value2, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestConditionalDelete(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	kvstore.Write(store, key1, value1, user1)
+	item, _ := kvstore.ReadItem(store, key1, user1)
+
+	deleted, err := kvstore.DeleteWithOptions(store, key1, user1, kvstore.DeleteOptions{
+		Condition: &kvstore.Condition{Type: kvstore.IfVersion, Version: item.Version + 1},
+	})
+	if deleted || !errors.Is(err, kvstore.ErrVersionMismatch) {
+		t.Fatal("Delete with wrong version should have failed but got:", deleted, err)
+	}
+
+	deleted, err = kvstore.DeleteWithOptions(store, key1, user1, kvstore.DeleteOptions{
+		Condition: &kvstore.Condition{Type: kvstore.IfVersion, Version: item.Version},
+	})
+	if !deleted || err != nil {
+		t.Fatal("Delete with current version should have been successful but got:", deleted, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestVersionsNotReusedAfterRestart(t *testing.T) {
+	config := kvstore.Config{WALPath: filepath.Join(t.TempDir(), "store.wal")}
+
+	store, _ := kvstore.NewKVStoreWithConfig(config)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key2, value2, user1)
+	kvstore.Delete(store, key2, user1)
+	kvstore.Close(store)
+
+	store, _ = kvstore.NewKVStoreWithConfig(config)
+	kvstore.Write(store, key2, value2, user1)
+
+	item, _ := kvstore.ReadItem(store, key2, user1)
+	if item.Version != 4 {
+		t.Fatal("Key should have been at version 4 but was:", item.Version)
+	}
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,353 @@
+package kvstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// SyncPolicy determines how often the write-ahead log is flushed to disk.
+type SyncPolicy int
+
+const (
+	// SyncEveryWrite flushes the write-ahead log to disk before each write is acknowledged.
+	SyncEveryWrite SyncPolicy = iota
+	// SyncInterval flushes the write-ahead log to disk periodically in the background.
+	SyncInterval SyncPolicy = iota
+	// SyncNever leaves flushing the write-ahead log to disk up to the operating system.
+	SyncNever SyncPolicy = iota
+)
+
+// DefaultSyncInterval is how often the write-ahead log is flushed to disk when using SyncInterval, if not set.
+const DefaultSyncInterval = time.Second
+
+const (
+	walFilePermissions = 0600
+	walHeaderBytes     = 8
+)
+
+var (
+	// ErrPersistence is returned when a change could not be written to disk, in which case
+	// the change has not been applied to the store.
+	ErrPersistence = errors.New("unable to persist change")
+
+	errUnknownSyncPolicy = errors.New("unknown sync policy")
+	errCorruptRecord     = errors.New("corrupt write-ahead log record")
+)
+
+// ParseSyncPolicy converts the name of a sync policy ("always", "interval" or "never")
+// into a SyncPolicy.
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch name {
+	case "always":
+		return SyncEveryWrite, nil
+	case "interval":
+		return SyncInterval, nil
+	case "never":
+		return SyncNever, nil
+	default:
+		return SyncEveryWrite, fmt.Errorf("%w: %s", errUnknownSyncPolicy, name)
+	}
+}
+
+// walRecord is a single revision of the store in the write-ahead log, made up of one or more changes
+// which are applied together.
+type walRecord struct {
+	Revision uint64   `json:"revision"`
+	Changes  []change `json:"changes"`
+}
+
+// change holds the state of a key after it was changed (or nil if the key was deleted),
+// so replaying a change more than once is harmless.
+type change struct {
+	Key   string `json:"key"`
+	Entry *entry `json:"entry,omitempty"`
+}
+
+// writeAheadLog is an append-only file of changes made to the store, each one framed by
+// a length and CRC32 checksum so that a partially written final record can be detected.
+type writeAheadLog struct {
+	mutex sync.Mutex
+	file  *os.File
+	// size is the length of the log up to the end of the last record written in full
+	size int64
+	// written counts the bytes of every record ever written, and synced those flushed to disk, so that a sync
+	// can tell whether an earlier one has already covered its record, even across truncations
+	written  int64
+	synced   int64
+	policy   SyncPolicy
+	unsynced bool
+	// syncMutex lets a single sync run at once, so that records written while it runs share the next one
+	syncMutex sync.Mutex
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// openWAL opens (or creates) the write-ahead log at the specified path, passing each record
+// already present to the apply function in the order they were written. An incomplete or
+// corrupt final record (e.g. from a crash part way through a write) is discarded, but a corrupt
+// record followed by others means the log itself is damaged, and an error is returned.
+func openWAL(path string, policy SyncPolicy, interval time.Duration,
+	apply func(*walRecord)) (*writeAheadLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, walFilePermissions)
+	if err != nil {
+		return nil, err
+	}
+
+	validBytes, err := replayWAL(file, apply)
+	if err != nil {
+		file.Close()
+
+		return nil, err
+	}
+
+	// drop anything after the last complete record, and carry on writing from there
+	if err = file.Truncate(validBytes); err != nil {
+		file.Close()
+
+		return nil, err
+	}
+
+	if _, err = file.Seek(validBytes, io.SeekStart); err != nil {
+		file.Close()
+
+		return nil, err
+	}
+
+	wal := &writeAheadLog{file: file, size: validBytes, policy: policy}
+
+	if policy == SyncInterval {
+		if interval <= 0 {
+			interval = DefaultSyncInterval
+		}
+
+		wal.stop = make(chan struct{})
+		wal.stopped = make(chan struct{})
+
+		go syncPeriodically(wal, interval)
+	}
+
+	return wal, nil
+}
+
+// replayWAL reads each record from the start of the file, returning the number of bytes
+// that were read successfully. Only the final record may be incomplete or corrupt.
+func replayWAL(file *os.File, apply func(*walRecord)) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, walHeaderBytes)
+	validBytes := int64(0)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return validBytes, nil
+			}
+
+			return 0, err
+		}
+
+		length := binary.LittleEndian.Uint32(header[0:4])
+		checksum := binary.LittleEndian.Uint32(header[4:8])
+
+		// a record longer than the rest of the file was never finished, so don't trust its length any further
+		if int64(length) > info.Size()-validBytes-walHeaderBytes {
+			return validBytes, nil
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return validBytes, nil
+			}
+
+			return 0, err
+		}
+
+		record, err := decodeRecord(payload, checksum)
+		if err != nil {
+			// a crash can only have damaged the last record written
+			if _, peekErr := reader.Peek(1); errors.Is(peekErr, io.EOF) {
+				return validBytes, nil
+			}
+
+			return 0, fmt.Errorf("%w at offset %d: %v", errCorruptRecord, validBytes, err)
+		}
+
+		apply(record)
+
+		validBytes += walHeaderBytes + int64(length)
+	}
+}
+
+func decodeRecord(payload []byte, checksum uint32) (*walRecord, error) {
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, errCorruptRecord
+	}
+
+	record := &walRecord{}
+	if err := json.Unmarshal(payload, record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// appendToWAL writes the record to the end of the log, returning how much has been written to the log once it
+// has, to be passed to syncWrites. If the record can't be written in full, whatever was written of it is
+// discarded, so that the log can still be replayed and later records aren't stranded behind a damaged one.
+func appendToWAL(wal *writeAheadLog, record *walRecord) (int64, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	frame := make([]byte, walHeaderBytes+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[walHeaderBytes:], payload)
+
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if _, err = wal.file.Write(frame); err != nil {
+		if truncateErr := discardPartialRecord(wal); truncateErr != nil {
+			return 0, fmt.Errorf("%w: %v (and unable to discard partial record: %v)", ErrPersistence, err,
+				truncateErr)
+		}
+
+		return 0, fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	wal.size += int64(len(frame))
+	wal.written += int64(len(frame))
+	wal.unsynced = true
+
+	return wal.written, nil
+}
+
+// syncWrites flushes the log to disk, if required by the sync policy, up to at least the point given by
+// appendToWAL. Records written by other changes while a sync is running are flushed together by the next one, so
+// that changes don't each wait for a sync of their own.
+func syncWrites(wal *writeAheadLog, written int64) error {
+	if wal.policy != SyncEveryWrite {
+		return nil
+	}
+
+	wal.syncMutex.Lock()
+	defer wal.syncMutex.Unlock()
+
+	wal.mutex.Lock()
+	if wal.synced >= written {
+		wal.mutex.Unlock()
+
+		return nil
+	}
+
+	// everything written by now is covered by the sync
+	covered := wal.written
+	wal.mutex.Unlock()
+
+	if err := wal.file.Sync(); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	wal.mutex.Lock()
+	if covered > wal.synced {
+		wal.synced = covered
+	}
+	wal.mutex.Unlock()
+
+	return nil
+}
+
+// discardPartialRecord cuts the log back to the end of the last record written in full. The caller must hold the
+// log's mutex.
+func discardPartialRecord(wal *writeAheadLog) error {
+	if err := wal.file.Truncate(wal.size); err != nil {
+		return err
+	}
+
+	_, err := wal.file.Seek(wal.size, io.SeekStart)
+
+	return err
+}
+
+// truncateWAL discards everything in the log, once it has all been captured in a snapshot.
+func truncateWAL(wal *writeAheadLog) error {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if err := wal.file.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := wal.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	wal.size = 0
+
+	if err := wal.file.Sync(); err != nil {
+		return err
+	}
+
+	wal.synced = wal.written
+	wal.unsynced = false
+
+	return nil
+}
+
+// syncPeriodically flushes the log to disk at the specified interval, until the log is closed.
+func syncPeriodically(wal *writeAheadLog, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer close(wal.stopped)
+
+	for {
+		select {
+		case <-ticker.C:
+			wal.mutex.Lock()
+			if wal.unsynced {
+				// an error here will be reported again by the final sync when the log is closed
+				if err := wal.file.Sync(); err == nil {
+					wal.unsynced = false
+				}
+			}
+			wal.mutex.Unlock()
+
+		case <-wal.stop:
+			return
+		}
+	}
+}
+
+// closeWAL flushes any outstanding changes to disk and closes the log file.
+func closeWAL(wal *writeAheadLog) error {
+	if wal.stop != nil {
+		close(wal.stop)
+		<-wal.stopped
+	}
+
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if err := wal.file.Sync(); err != nil {
+		wal.file.Close()
+
+		return err
+	}
+
+	return wal.file.Close()
+}
@@ -0,0 +1,230 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"store/pkg/kvstore"
+	"sync"
+	"testing"
+)
+
+func TestWALReplayedOnRestart(t *testing.T) {
+	config := kvstore.Config{WALPath: filepath.Join(t.TempDir(), "store.wal")}
+
+	store, err := kvstore.NewKVStoreWithConfig(config)
+	if err != nil {
+		t.Fatal("Error opening store: ", err)
+	}
+
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key1, value2, user1) // update
+	kvstore.Write(store, key2, value2, user2)
+	kvstore.Delete(store, key2, user2)
+
+	if err = kvstore.Close(store); err != nil {
+		t.Fatal("Error closing store: ", err)
+	}
+
+	store, err = kvstore.NewKVStoreWithConfig(config)
+	if err != nil {
+		t.Fatal("Error re-opening store: ", err)
+	}
+
+	value, ok := kvstore.Read(store, key1, user1)
+	if !ok || !bytes.Equal(value, value2) {
+		t.Fatalf("Key should have been replayed with value %s but was: %t (value %s)", value2, ok, value)
+	}
+
+	entryInfo := kvstore.List(store, key1, user1)
+	if entryInfo.Owner != user1 || entryInfo.Writes != 2 {
+		t.Fatal("Key should have been replayed with owner and writes but was: ", entryInfo)
+	}
+
+	if value, ok = kvstore.Read(store, key2, user1); ok {
+		t.Fatalf("Deleted key should not have been replayed but was: %t (value %s)", ok, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestWALIncompleteRecordDiscarded(t *testing.T) {
+	config := kvstore.Config{WALPath: filepath.Join(t.TempDir(), "store.wal"), SyncPolicy: kvstore.SyncNever}
+
+	store, _ := kvstore.NewKVStoreWithConfig(config)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Close(store)
+
+	// simulate a crash part way through writing a record
+	file, err := os.OpenFile(config.WALPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal("Error opening log: ", err)
+	}
+	file.Write([]byte{42, 0, 0, 0, 1, 2})
+	file.Close()
+
+	store, err = kvstore.NewKVStoreWithConfig(config)
+	if err != nil {
+		t.Fatal("Error re-opening store: ", err)
+	}
+
+	kvstore.Write(store, key2, value2, user2)
+	kvstore.Close(store)
+
+	store, _ = kvstore.NewKVStoreWithConfig(config)
+
+	if value, ok := kvstore.Read(store, key1, user1); !ok || !bytes.Equal(value, value1) {
+		t.Fatalf("Key before incomplete record should be present but was: %t (value %s)", ok, value)
+	}
+
+	if value, ok := kvstore.Read(store, key2, user1); !ok || !bytes.Equal(value, value2) {
+		t.Fatalf("Key after incomplete record should be present but was: %t (value %s)", ok, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestWALOversizedLengthDiscarded(t *testing.T) {
+	config := kvstore.Config{WALPath: filepath.Join(t.TempDir(), "store.wal"), SyncPolicy: kvstore.SyncNever}
+
+	store, _ := kvstore.NewKVStoreWithConfig(config)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Close(store)
+
+	// a torn header claiming a record far longer than the file
+	file, err := os.OpenFile(config.WALPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal("Error opening log: ", err)
+	}
+	file.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	file.Close()
+
+	store, err = kvstore.NewKVStoreWithConfig(config)
+	if err != nil {
+		t.Fatal("Error re-opening store: ", err)
+	}
+	defer kvstore.Close(store)
+
+	if value, ok := kvstore.Read(store, key1, user1); !ok || !bytes.Equal(value, value1) {
+		t.Fatalf("Key before oversized record should be present but was: %t (value %s)", ok, value)
+	}
+}
+
+func TestWALCorruptionBeforeEndRejected(t *testing.T) {
+	config := kvstore.Config{WALPath: filepath.Join(t.TempDir(), "store.wal")}
+
+	store, _ := kvstore.NewKVStoreWithConfig(config)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key2, value2, user2)
+	kvstore.Close(store)
+
+	// damage the first record's value, leaving the second intact
+	contents, err := os.ReadFile(config.WALPath)
+	if err != nil {
+		t.Fatal("Error reading log: ", err)
+	}
+
+	os.WriteFile(config.WALPath, bytes.Replace(contents, []byte("QUJD"), []byte("QUJE"), 1), 0600)
+
+	if store, err = kvstore.NewKVStoreWithConfig(config); err == nil {
+		kvstore.Close(store)
+		t.Fatal("Store with a corrupt record before the end of its log should not have opened")
+	}
+}
+
+func TestWALConcurrentWrites(t *testing.T) {
+	const writers = 8
+	const writes = 50
+
+	config := kvstore.Config{Shards: 4, WALPath: filepath.Join(t.TempDir(), "store.wal")}
+
+	store, err := kvstore.NewKVStoreWithConfig(config)
+	if err != nil {
+		t.Fatal("Error opening store: ", err)
+	}
+
+	var group sync.WaitGroup
+
+	for i := 0; i < writers; i++ {
+		group.Add(1)
+
+		go func(writer int) {
+			defer group.Done()
+
+			for j := 0; j < writes; j++ {
+				kvstore.Write(store, fmt.Sprintf("key%d-%d", writer, j), value1, user1)
+			}
+		}(i)
+	}
+
+	group.Wait()
+
+	// changes made in parallel are still reported in revision order
+	records, err := kvstore.ReplicationLog(context.Background(), store, 0, 0)
+	if err != nil {
+		t.Fatal("Error reading change feed: ", err)
+	}
+
+	if len(records) != writers*writes {
+		t.Fatalf("Change feed should have %d records but had %d", writers*writes, len(records))
+	}
+
+	for i, record := range records {
+		if record.Revision != uint64(i+1) {
+			t.Fatalf("Change feed record %d should have revision %d but had %d", i, i+1, record.Revision)
+		}
+	}
+
+	kvstore.Close(store)
+
+	store, err = kvstore.NewKVStoreWithConfig(config)
+	if err != nil {
+		t.Fatal("Error re-opening store: ", err)
+	}
+	defer kvstore.Close(store)
+
+	if entries := kvstore.ListAll(store, user1); len(entries) != writers*writes {
+		t.Fatalf("Store should have %d keys after restart but had %d", writers*writes, len(entries))
+	}
+
+	if usage := kvstore.UserUsage(store, user1); usage.Keys != writers*writes {
+		t.Fatalf("User should own %d keys after restart but owned %d", writers*writes, usage.Keys)
+	}
+}
+
+func TestWALIntervalSync(t *testing.T) {
+	config := kvstore.Config{
+		WALPath:    filepath.Join(t.TempDir(), "store.wal"),
+		SyncPolicy: kvstore.SyncInterval,
+	}
+
+	store, _ := kvstore.NewKVStoreWithConfig(config)
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Close(store)
+
+	store, _ = kvstore.NewKVStoreWithConfig(config)
+
+	if value, ok := kvstore.Read(store, key1, user1); !ok || !bytes.Equal(value, value1) {
+		t.Fatalf("Key should have been replayed but was: %t (value %s)", ok, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for name, expected := range map[string]kvstore.SyncPolicy{
+		"always":   kvstore.SyncEveryWrite,
+		"interval": kvstore.SyncInterval,
+		"never":    kvstore.SyncNever,
+	} {
+		if policy, err := kvstore.ParseSyncPolicy(name); err != nil || policy != expected {
+			t.Fatalf("Policy %s should have parsed as %d but got %d (%v)", name, expected, policy, err)
+		}
+	}
+
+	if _, err := kvstore.ParseSyncPolicy("sometimes"); err == nil {
+		t.Fatal("Unknown policy should have been rejected")
+	}
+}
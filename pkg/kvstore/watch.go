@@ -0,0 +1,206 @@
+package kvstore
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// EventType is the type of change to a key reported to watchers.
+type EventType string
+
+const (
+	// PutEvent is reported when a key is set or updated.
+	PutEvent EventType = "put"
+	// DeleteEvent is reported when a key is deleted, or expires.
+	DeleteEvent EventType = "delete"
+)
+
+const (
+	defaultWatchHistory = 1000
+	watchBufferSize     = 256
+)
+
+// ErrCompacted is returned when watching from a revision whose events are no longer retained, or reading a key
+// at a revision or time whose version of the key is no longer kept.
+var ErrCompacted = errors.New("revision has been compacted")
+
+// Event is a change to a single key. All the changes made in one revision
+// (e.g. by a transaction) are reported as separate events with the same revision. The value is encoded in JSON
+// as base64, since it may not be text.
+type Event struct {
+	Type            EventType `json:"type"`
+	Key             string    `json:"key"`
+	Value           []byte    `json:"value,omitempty"`
+	ContentType     string    `json:"content_type,omitempty"`
+	ContentEncoding string    `json:"content_encoding,omitempty"`
+	Revision        uint64    `json:"revision"`
+}
+
+// Watcher receives the events for the keys being watched.
+type Watcher struct {
+	// Events receives each event in revision order. It is closed when the watch is cancelled, the store
+	// is closed, or the watcher falls too far behind in receiving events; in the last case the watch can
+	// be resumed by watching again from the revision after the last event received.
+	Events <-chan Event
+
+	events   chan Event
+	key      string
+	prefix   bool
+	username string
+}
+
+// watchEvent is an event along with the key's new entry, or nil for a delete, so that put events
+// can be held back from watchers who can't read the key.
+type watchEvent struct {
+	Event
+	entry *entry
+}
+
+// watchHub keeps track of the active watchers, along with a bounded history of recent events so that
+// watchers can resume from an earlier revision. It is safe to use from multiple go routines.
+type watchHub struct {
+	mutex    sync.Mutex
+	watchers map[*Watcher]struct{}
+	history  []watchEvent
+	capacity int
+	groups   userGroups
+	// firstRevision is the earliest revision whose events are all still held in the history
+	firstRevision uint64
+	closed        bool
+}
+
+func newWatchHub(capacity int, currentRevision uint64, groups userGroups) *watchHub {
+	if capacity <= 0 {
+		capacity = defaultWatchHistory
+	}
+
+	return &watchHub{
+		watchers:      make(map[*Watcher]struct{}),
+		history:       make([]watchEvent, 0, capacity),
+		capacity:      capacity,
+		groups:        groups,
+		firstRevision: currentRevision + 1,
+	}
+}
+
+// Watch returns a watcher for changes to the key, or to all keys starting with the key if prefix is set.
+// If fromRevision is non-zero, events from that revision onwards that have already happened are reported
+// first, or ErrCompacted is returned if they are no longer retained. Otherwise only new events are reported.
+//
+// Any user can watch any key, but the changes to private keys the user can't read are left out, other than
+// deletes. Unwatch must be called once the watcher is no longer needed.
+func Watch(s *KVStore, key string, prefix bool, fromRevision uint64, username string) (*Watcher, error) {
+	hub := s.watches
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	if hub.closed {
+		return nil, ErrClosed
+	}
+
+	if fromRevision > 0 && fromRevision < hub.firstRevision {
+		return nil, ErrCompacted
+	}
+
+	events := make(chan Event, watchBufferSize+len(hub.history))
+	watcher := &Watcher{events, events, key, prefix, username}
+
+	if fromRevision > 0 {
+		for _, event := range hub.history {
+			if event.Revision >= fromRevision && watcherMatches(hub, watcher, event) {
+				events <- event.Event
+			}
+		}
+	}
+
+	hub.watchers[watcher] = struct{}{}
+
+	return watcher, nil
+}
+
+// Unwatch stops the watcher from receiving any further events, and closes its events channel.
+func Unwatch(s *KVStore, watcher *Watcher) {
+	hub := s.watches
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	if _, ok := hub.watchers[watcher]; ok {
+		delete(hub.watchers, watcher)
+		close(watcher.events)
+	}
+}
+
+// publishEvents records the events in the history and sends them to the matching watchers.
+// Any watcher whose buffer is full is dropped rather than holding up the store.
+func publishEvents(hub *watchHub, events []watchEvent) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for _, event := range events {
+		if len(hub.history) == hub.capacity {
+			hub.firstRevision = hub.history[0].Revision + 1
+			hub.history = append(hub.history[:0], hub.history[1:]...)
+		}
+
+		hub.history = append(hub.history, event)
+
+		for watcher := range hub.watchers {
+			if !watcherMatches(hub, watcher, event) {
+				continue
+			}
+
+			select {
+			case watcher.events <- event.Event:
+			default:
+				delete(hub.watchers, watcher)
+				close(watcher.events)
+			}
+		}
+	}
+}
+
+// closeWatchers drops all the watchers, when the store is closed.
+func closeWatchers(hub *watchHub) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	hub.closed = true
+
+	for watcher := range hub.watchers {
+		delete(hub.watchers, watcher)
+		close(watcher.events)
+	}
+}
+
+// watcherMatches returns whether the event is for a key being watched, which the watcher can read.
+func watcherMatches(hub *watchHub, watcher *Watcher, event watchEvent) bool {
+	if event.entry != nil && !hasPermission(event.entry, watcher.username, hub.groups, PermissionRead) {
+		return false
+	}
+
+	if watcher.prefix {
+		return strings.HasPrefix(event.Key, watcher.key)
+	}
+
+	return event.Key == watcher.key
+}
+
+// changeEvents returns the events describing the changes made in a revision.
+func changeEvents(revision uint64, changes []change) []watchEvent {
+	events := make([]watchEvent, len(changes))
+
+	for i, keyChange := range changes {
+		if keyChange.Entry == nil {
+			events[i] = watchEvent{Event{Type: DeleteEvent, Key: keyChange.Key, Revision: revision}, nil}
+		} else {
+			e := keyChange.Entry
+			event := Event{PutEvent, keyChange.Key, copyBytes(e.Value), e.ContentType, e.ContentEncoding, revision}
+			events[i] = watchEvent{event, e}
+		}
+	}
+
+	return events
+}
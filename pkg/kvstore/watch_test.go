@@ -0,0 +1,107 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"errors"
+	"store/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func TestWatchKey(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	watcher, err := kvstore.Watch(store, key1, false, 0, user1)
+	if err != nil {
+		t.Fatal("Watch should have been successful but got:", err)
+	}
+
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key2, value1, user1)
+	kvstore.Delete(store, key1, user1)
+
+	checkEvent(t, watcher, kvstore.Event{Type: kvstore.PutEvent, Key: key1, Value: value1, Revision: 1})
+	checkEvent(t, watcher, kvstore.Event{Type: kvstore.DeleteEvent, Key: key1, Revision: 3})
+
+	kvstore.Unwatch(store, watcher)
+
+	if _, open := <-watcher.Events; open {
+		t.Fatal("Events should have been closed")
+	}
+
+	kvstore.Close(store)
+}
+
+func TestWatchPrefix(t *testing.T) {
+	store := kvstore.NewKVStore(testShards)
+
+	watcher, _ := kvstore.Watch(store, "a/", true, 0, user1)
+
+	kvstore.Write(store, "a/1", value1, user1)
+	kvstore.Write(store, "b/1", value1, user1)
+	kvstore.Transaction(store, []kvstore.TxnOp{
+		{Type: kvstore.TxnPut, Key: "a/2", Value: value2},
+		{Type: kvstore.TxnDelete, Key: "a/1"},
+	}, user1)
+
+	checkEvent(t, watcher, kvstore.Event{Type: kvstore.PutEvent, Key: "a/1", Value: value1, Revision: 1})
+	checkEvent(t, watcher, kvstore.Event{Type: kvstore.PutEvent, Key: "a/2", Value: value2, Revision: 3})
+	checkEvent(t, watcher, kvstore.Event{Type: kvstore.DeleteEvent, Key: "a/1", Revision: 3})
+
+	kvstore.Close(store)
+
+	if _, open := <-watcher.Events; open {
+		t.Fatal("Events should have been closed when the store was closed")
+	}
+}
+
+func TestWatchFromRevision(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{WatchHistory: 2})
+
+	kvstore.Write(store, key1, value1, user1)
+	kvstore.Write(store, key1, value2, user1)
+	kvstore.Write(store, key2, value1, user1)
+
+	if _, err := kvstore.Watch(store, key1, false, 1, user1); !errors.Is(err, kvstore.ErrCompacted) {
+		t.Fatal("Watch from a discarded revision should have failed but got:", err)
+	}
+
+	watcher, err := kvstore.Watch(store, key1, false, 2, user1)
+	if err != nil {
+		t.Fatal("Watch from a retained revision should have been successful but got:", err)
+	}
+
+	kvstore.Write(store, key1, value1, user1)
+
+	checkEvent(t, watcher, kvstore.Event{Type: kvstore.PutEvent, Key: key1, Value: value2, Revision: 2})
+	checkEvent(t, watcher, kvstore.Event{Type: kvstore.PutEvent, Key: key1, Value: value1, Revision: 4})
+
+	kvstore.Close(store)
+}
+
+func TestWatchExpiry(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{ExpiryInterval: 10 * time.Millisecond})
+
+	watcher, _ := kvstore.Watch(store, key1, false, 0, user1)
+
+	kvstore.WriteWithOptions(store, key1, value1, user1, kvstore.WriteOptions{TTL: 20 * time.Millisecond})
+
+	checkEvent(t, watcher, kvstore.Event{Type: kvstore.PutEvent, Key: key1, Value: value1, Revision: 1})
+	checkEvent(t, watcher, kvstore.Event{Type: kvstore.DeleteEvent, Key: key1, Revision: 2})
+
+	kvstore.Close(store)
+}
+
+func checkEvent(t *testing.T, watcher *kvstore.Watcher, expected kvstore.Event) {
+	t.Helper()
+
+	select {
+	case event := <-watcher.Events:
+		if event.Type != expected.Type || event.Key != expected.Key || !bytes.Equal(event.Value, expected.Value) ||
+			event.Revision != expected.Revision {
+			t.Fatalf("Expected event %v but got: %v", expected, event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for event:", expected)
+	}
+}
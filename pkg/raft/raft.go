@@ -0,0 +1,900 @@
+// Package raft provides the Raft consensus algorithm, which keeps a log of commands replicated across a cluster
+// of nodes so that each node applies the same commands in the same order. A command is only applied once it has
+// been committed by a majority of the nodes, so the cluster carries on as long as most of its nodes can reach
+// each other, and never loses a committed command.
+//
+// A node's term, vote and log are held in memory only, so a node that is restarted must be removed from the
+// cluster and added back again as a new member.
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultElectionTimeout   = 500 * time.Millisecond
+	defaultHeartbeatInterval = 50 * time.Millisecond
+
+	// maxAppendEntries is the most log entries sent to a follower in a single request.
+	maxAppendEntries = 100
+)
+
+var (
+	// ErrNotLeader is returned when a node that isn't the leader (or has only just become the leader, and
+	// hasn't yet caught up with the commands committed before it) is asked to do something only the leader can.
+	ErrNotLeader = errors.New("not the cluster leader")
+	// ErrMembershipChange is returned when changing the cluster's members while an earlier change is still
+	// being committed, or when the change isn't valid.
+	ErrMembershipChange = errors.New("unable to change cluster membership")
+	// ErrStopped is returned by operations made once the node has been stopped.
+	ErrStopped = errors.New("node is stopped")
+
+	errInvalidConfig = errors.New("invalid node config")
+)
+
+// State is a node's role in the cluster.
+type State string
+
+const (
+	// Follower nodes replicate the leader's log.
+	Follower State = "follower"
+	// Candidate nodes are standing for election as leader.
+	Candidate State = "candidate"
+	// Leader nodes accept commands, and replicate them to the other nodes.
+	Leader State = "leader"
+)
+
+// EntryType is what a log entry holds.
+type EntryType int
+
+const (
+	// EntryCommand holds a command to be applied by each node.
+	EntryCommand EntryType = iota
+	// EntryMembers holds the members of the cluster from then on.
+	EntryMembers EntryType = iota
+	// EntryNoop is added by each new leader, to commit the entries from earlier terms.
+	EntryNoop EntryType = iota
+)
+
+// Entry is a single entry in the replicated log.
+type Entry struct {
+	Index uint64    `json:"index"`
+	Term  uint64    `json:"term"`
+	Type  EntryType `json:"type"`
+	Data  []byte    `json:"data,omitempty"`
+}
+
+// Config holds the settings of a node.
+type Config struct {
+	// ID identifies the node within the cluster.
+	ID string
+	// Members maps the ID of each node in the cluster (including this one) to its address, as passed to the
+	// transport. A node joining an existing cluster starts with no members, and is then added by the leader.
+	Members map[string]string
+	// Transport sends requests to the other nodes.
+	Transport Transport
+	// Apply is called with each committed command, in log order, from a single go routine.
+	Apply func(index uint64, term uint64, command []byte)
+	// ElectionTimeout is how long a follower waits to hear from the leader before standing for election, with
+	// up to as long again added at random. If zero, a default of 500ms is used.
+	ElectionTimeout time.Duration
+	// HeartbeatInterval is how often the leader contacts each follower. If zero, a default of 50ms is used.
+	HeartbeatInterval time.Duration
+	// Logger is used to report elections and membership changes. If nil, nothing is logged.
+	Logger *log.Logger
+}
+
+// Status is a node's view of the cluster.
+type Status struct {
+	ID           string            `json:"id"`
+	State        State             `json:"state"`
+	Term         uint64            `json:"term"`
+	Leader       string            `json:"leader,omitempty"`
+	Members      map[string]string `json:"members"`
+	CommitIndex  uint64            `json:"commit_index"`
+	AppliedIndex uint64            `json:"applied_index"`
+}
+
+// Proposal is a command added to the leader's log, which is applied once committed unless the leader is
+// replaced first.
+type Proposal struct {
+	Index uint64
+	Term  uint64
+	// Lost is closed if the node stops being the leader in the proposal's term. The command may still be
+	// committed by the next leader.
+	Lost <-chan struct{}
+}
+
+// Node is a single member of a Raft cluster. It is safe to use from multiple go routines.
+type Node struct {
+	mutex    sync.Mutex
+	config   Config
+	state    State
+	term     uint64
+	votedFor string
+	leader   string
+	// log holds every entry, after a sentinel entry at index zero
+	log []Entry
+	// members are those of the latest membership entry in the log, whether committed or not
+	members      map[string]string
+	commitIndex  uint64
+	appliedIndex uint64
+	// electionDeadline is when a follower stands for election, if it hasn't heard from the leader by then
+	electionDeadline time.Time
+	leaderContact    time.Time
+	votes            map[string]bool
+	// nextIndex, matchIndex and lastContact are the leader's view of each follower, lastContact being when the
+	// latest request the follower responded to was sent
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	lastContact map[string]time.Time
+	replicating map[string]bool
+	// readyIndex is the leader's first entry, once applied the leader is up to date with earlier terms
+	readyIndex uint64
+	lost       chan struct{}
+	// changed is closed (and replaced) whenever the node's state changes, to wake up those waiting for it
+	changed chan struct{}
+	stopped bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+	logger  *log.Logger
+}
+
+// NewNode returns a new node, as a follower of the cluster with the members in its config, and starts it going.
+func NewNode(config Config) (*Node, error) {
+	if config.ID == "" || config.Transport == nil || config.Apply == nil {
+		return nil, fmt.Errorf("%w: an ID, transport and apply function are required", errInvalidConfig)
+	}
+
+	if config.ElectionTimeout <= 0 {
+		config.ElectionTimeout = defaultElectionTimeout
+	}
+
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = defaultHeartbeatInterval
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	n := &Node{
+		config:  config,
+		state:   Follower,
+		log:     []Entry{{}},
+		members: copyMembers(config.Members),
+		changed: make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+		logger:  logger,
+	}
+
+	resetElectionDeadline(n)
+
+	n.running.Add(2)
+
+	go runTimers(n)
+	go applyCommitted(n)
+
+	return n, nil
+}
+
+// Stop shuts down the node, after which it no longer takes part in the cluster.
+func Stop(n *Node) {
+	n.mutex.Lock()
+
+	if n.stopped {
+		n.mutex.Unlock()
+
+		return
+	}
+
+	n.stopped = true
+	becomeFollower(n, n.term)
+	n.cancel()
+	notify(n)
+
+	n.mutex.Unlock()
+
+	n.running.Wait()
+}
+
+// NodeStatus returns the node's view of the cluster.
+func NodeStatus(n *Node) Status {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return Status{n.config.ID, n.state, n.term, n.leader, copyMembers(n.members), n.commitIndex, n.appliedIndex}
+}
+
+// IsLeader returns whether the node is currently the leader.
+func IsLeader(n *Node) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.state == Leader
+}
+
+// Propose adds the command to the leader's log, to be committed and then applied by every node. ErrNotLeader
+// is returned if the node isn't the leader, or isn't yet ready to accept commands.
+func Propose(n *Node, command []byte) (*Proposal, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+
+	if n.state != Leader || n.appliedIndex < n.readyIndex {
+		return nil, ErrNotLeader
+	}
+
+	index := appendEntry(n, EntryCommand, command)
+
+	return &Proposal{index, n.term, n.lost}, nil
+}
+
+// ReadIndex waits until it's safe for the leader to serve a linearizable read from its own state, i.e. until
+// a majority of the cluster has confirmed it's still the leader, and it has applied every command committed
+// before the read. ErrNotLeader is returned if the node isn't the leader, or stops being the leader first.
+func ReadIndex(ctx context.Context, n *Node) error {
+	n.mutex.Lock()
+
+	if n.state != Leader || n.appliedIndex < n.readyIndex {
+		n.mutex.Unlock()
+
+		return ErrNotLeader
+	}
+
+	term := n.term
+	readIndex := n.commitIndex
+	start := time.Now()
+
+	replicateToAll(n)
+	n.mutex.Unlock()
+
+	return waitFor(ctx, n, func() (bool, error) {
+		if n.state != Leader || n.term != term {
+			return false, ErrNotLeader
+		}
+
+		return hasQuorumSince(n, start) && n.appliedIndex >= readIndex, nil
+	})
+}
+
+// AddMember adds a node to the cluster, waiting until the change has been committed. The node must have been
+// started with no members, and is brought up to date by the leader.
+func AddMember(ctx context.Context, n *Node, id string, address string) error {
+	if id == "" || address == "" {
+		return fmt.Errorf("%w: an ID and address are required", ErrMembershipChange)
+	}
+
+	return changeMembers(ctx, n, func(members map[string]string) error {
+		if _, ok := members[id]; ok {
+			return fmt.Errorf("%w: %s is already a member", ErrMembershipChange, id)
+		}
+
+		members[id] = address
+
+		return nil
+	})
+}
+
+// RemoveMember removes a node from the cluster, waiting until the change has been committed. If the leader
+// removes itself, it stands down once the change is committed.
+func RemoveMember(ctx context.Context, n *Node, id string) error {
+	return changeMembers(ctx, n, func(members map[string]string) error {
+		if _, ok := members[id]; !ok {
+			return fmt.Errorf("%w: %s is not a member", ErrMembershipChange, id)
+		}
+
+		if len(members) == 1 {
+			return fmt.Errorf("%w: cannot remove the last member", ErrMembershipChange)
+		}
+
+		delete(members, id)
+
+		return nil
+	})
+}
+
+// changeMembers adds an entry to the leader's log with the members changed by the function, and waits until
+// it's committed. Only one node is added or removed at a time, so that the majorities of the old and new
+// members always overlap.
+func changeMembers(ctx context.Context, n *Node, change func(members map[string]string) error) error {
+	n.mutex.Lock()
+
+	if n.state != Leader || n.appliedIndex < n.readyIndex {
+		n.mutex.Unlock()
+
+		return ErrNotLeader
+	}
+
+	for i := n.commitIndex + 1; i < uint64(len(n.log)); i++ {
+		if n.log[i].Type == EntryMembers {
+			n.mutex.Unlock()
+
+			return fmt.Errorf("%w: an earlier change is still being committed", ErrMembershipChange)
+		}
+	}
+
+	members := copyMembers(n.members)
+	if err := change(members); err != nil {
+		n.mutex.Unlock()
+
+		return err
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		n.mutex.Unlock()
+
+		return err
+	}
+
+	term := n.term
+	index := appendEntry(n, EntryMembers, data)
+	n.logger.Printf("Changing cluster members to %v", members)
+	n.mutex.Unlock()
+
+	return waitFor(ctx, n, func() (bool, error) {
+		if n.commitIndex >= index {
+			return true, nil
+		}
+
+		if n.state != Leader || n.term != term {
+			return false, ErrNotLeader
+		}
+
+		return false, nil
+	})
+}
+
+// HandleRequestVote responds to a candidate asking for the node's vote.
+func HandleRequestVote(n *Node, request *VoteRequest) *VoteResponse {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	// ignore candidates while still hearing from the leader, so that a node which was cut off (or has been
+	// removed from the cluster) can't force an election
+	if request.Term > n.term && n.leader != "" &&
+		(n.state == Leader || time.Since(n.leaderContact) < n.config.ElectionTimeout) {
+		return &VoteResponse{n.term, false}
+	}
+
+	if request.Term > n.term {
+		becomeFollower(n, request.Term)
+	}
+
+	lastIndex, lastTerm := lastEntry(n)
+	upToDate := request.LastLogTerm > lastTerm || (request.LastLogTerm == lastTerm && request.LastLogIndex >= lastIndex)
+
+	if request.Term < n.term || !upToDate || (n.votedFor != "" && n.votedFor != request.CandidateID) {
+		return &VoteResponse{n.term, false}
+	}
+
+	n.votedFor = request.CandidateID
+	resetElectionDeadline(n)
+
+	return &VoteResponse{n.term, true}
+}
+
+// HandleAppendEntries responds to the leader adding entries to the node's log, or just letting the node know
+// it's still the leader if there are none.
+func HandleAppendEntries(n *Node, request *AppendRequest) *AppendResponse {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if request.Term < n.term {
+		return &AppendResponse{n.term, false, 0}
+	}
+
+	if request.Term > n.term || n.state != Follower {
+		becomeFollower(n, request.Term)
+	}
+
+	if n.leader != request.LeaderID {
+		n.logger.Printf("Following leader %s in term %d", request.LeaderID, request.Term)
+	}
+
+	n.leader = request.LeaderID
+	n.leaderContact = time.Now()
+	resetElectionDeadline(n)
+
+	lastIndex, _ := lastEntry(n)
+
+	if request.PrevLogIndex > lastIndex {
+		return &AppendResponse{n.term, false, lastIndex + 1}
+	}
+
+	if prevTerm := n.log[request.PrevLogIndex].Term; prevTerm != request.PrevLogTerm {
+		// skip back over the whole of the conflicting term
+		conflict := request.PrevLogIndex
+		for conflict > n.commitIndex+1 && n.log[conflict-1].Term == prevTerm {
+			conflict--
+		}
+
+		return &AppendResponse{n.term, false, conflict}
+	}
+
+	for i, entry := range request.Entries {
+		index := request.PrevLogIndex + 1 + uint64(i)
+
+		if index < uint64(len(n.log)) {
+			if n.log[index].Term == entry.Term {
+				continue
+			}
+
+			// the entry (and all after it) were never committed, and have been replaced by the leader
+			n.log = n.log[:index]
+		}
+
+		n.log = append(n.log, request.Entries[i:]...)
+
+		break
+	}
+
+	n.members = latestMembers(n)
+
+	if lastNew := request.PrevLogIndex + uint64(len(request.Entries)); request.LeaderCommit > n.commitIndex {
+		n.commitIndex = request.LeaderCommit
+		if lastNew < n.commitIndex {
+			n.commitIndex = lastNew
+		}
+
+		notify(n)
+	}
+
+	return &AppendResponse{n.term, true, 0}
+}
+
+// runTimers has the leader contact each follower at every heartbeat, and stands for election if a follower
+// hasn't heard from the leader in time, until the node is stopped.
+func runTimers(n *Node) {
+	defer n.running.Done()
+
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.ctx.Done():
+			return
+		}
+
+		n.mutex.Lock()
+
+		switch {
+		case n.state == Leader:
+			// stand down if most of the cluster can't be reached, since another leader may have been elected
+			if !hasQuorumSince(n, time.Now().Add(-n.config.ElectionTimeout)) {
+				n.logger.Printf("Standing down as leader in term %d, as unable to reach most members", n.term)
+				becomeFollower(n, n.term)
+			} else {
+				replicateToAll(n)
+			}
+		case time.Now().After(n.electionDeadline):
+			if _, ok := n.members[n.config.ID]; ok {
+				startElection(n)
+			} else {
+				resetElectionDeadline(n)
+			}
+		}
+
+		n.mutex.Unlock()
+	}
+}
+
+// applyCommitted passes each command to the config's apply function once committed, in log order, until the
+// node is stopped.
+func applyCommitted(n *Node) {
+	defer n.running.Done()
+
+	for {
+		var entries []Entry
+
+		err := waitFor(n.ctx, n, func() (bool, error) {
+			entries = append([]Entry{}, n.log[n.appliedIndex+1:n.commitIndex+1]...)
+
+			return len(entries) > 0, nil
+		})
+		if err != nil {
+			return
+		}
+
+		for _, entry := range entries {
+			if entry.Type == EntryCommand {
+				n.config.Apply(entry.Index, entry.Term, entry.Data)
+			}
+
+			n.mutex.Lock()
+
+			n.appliedIndex = entry.Index
+
+			// a leader that has removed itself stands down once the change is committed
+			if _, ok := n.members[n.config.ID]; entry.Type == EntryMembers && !ok && n.state == Leader {
+				n.logger.Printf("Standing down as leader in term %d, as no longer a member", n.term)
+				becomeFollower(n, n.term)
+			}
+
+			notify(n)
+			n.mutex.Unlock()
+		}
+	}
+}
+
+// startElection stands for election as leader in the next term. The caller must hold the node's mutex.
+func startElection(n *Node) {
+	n.term++
+	n.state = Candidate
+	n.votedFor = n.config.ID
+	n.leader = ""
+	n.votes = map[string]bool{n.config.ID: true}
+	resetElectionDeadline(n)
+	notify(n)
+
+	n.logger.Printf("Standing for election in term %d", n.term)
+
+	if isQuorum(n, n.votes) {
+		becomeLeader(n)
+
+		return
+	}
+
+	lastIndex, lastTerm := lastEntry(n)
+	request := &VoteRequest{n.term, n.config.ID, lastIndex, lastTerm}
+
+	for id, address := range n.members {
+		if id != n.config.ID {
+			n.running.Add(1)
+
+			go requestVote(n, id, address, request)
+		}
+	}
+}
+
+// requestVote asks another member for its vote, and becomes the leader if that makes a majority.
+func requestVote(n *Node, id string, address string, request *VoteRequest) {
+	defer n.running.Done()
+
+	ctx, cancel := context.WithTimeout(n.ctx, n.config.ElectionTimeout)
+	defer cancel()
+
+	response, err := n.config.Transport.RequestVote(ctx, address, request)
+	if err != nil {
+		return
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if response.Term > n.term {
+		becomeFollower(n, response.Term)
+
+		return
+	}
+
+	if n.state != Candidate || n.term != request.Term || !response.Granted {
+		return
+	}
+
+	n.votes[id] = true
+
+	if isQuorum(n, n.votes) {
+		becomeLeader(n)
+	}
+}
+
+// becomeLeader takes over as leader, adding an entry to the log so that the entries from earlier terms are
+// committed along with it. The caller must hold the node's mutex.
+func becomeLeader(n *Node) {
+	n.logger.Printf("Elected leader in term %d", n.term)
+
+	n.state = Leader
+	n.leader = n.config.ID
+	n.lost = make(chan struct{})
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.lastContact = make(map[string]time.Time)
+	n.replicating = make(map[string]bool)
+
+	now := time.Now()
+	lastIndex, _ := lastEntry(n)
+
+	for id := range n.members {
+		n.nextIndex[id] = lastIndex + 1
+		n.lastContact[id] = now
+	}
+
+	n.readyIndex = appendEntry(n, EntryNoop, nil)
+}
+
+// becomeFollower stops being the leader or a candidate, moving on to the term if it's later. The caller must
+// hold the node's mutex.
+func becomeFollower(n *Node, term uint64) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.leader = ""
+	}
+
+	if n.state == Leader {
+		n.leader = ""
+		close(n.lost)
+	}
+
+	n.state = Follower
+	resetElectionDeadline(n)
+	notify(n)
+}
+
+// appendEntry adds an entry to the leader's log and sends it on to the followers, returning its index. The
+// caller must hold the node's mutex.
+func appendEntry(n *Node, entryType EntryType, data []byte) uint64 {
+	lastIndex, _ := lastEntry(n)
+	index := lastIndex + 1
+
+	n.log = append(n.log, Entry{index, n.term, entryType, data})
+	n.members = latestMembers(n)
+
+	// any new members start off being sent the entry just added
+	for id := range n.members {
+		if _, ok := n.nextIndex[id]; !ok {
+			n.nextIndex[id] = index
+			n.lastContact[id] = time.Now()
+		}
+	}
+
+	advanceCommitIndex(n)
+	replicateToAll(n)
+
+	return index
+}
+
+// replicateToAll sends any entries each follower is missing (or just a heartbeat), other than those already
+// being sent to. The caller must hold the node's mutex.
+func replicateToAll(n *Node) {
+	for id, address := range n.members {
+		if id != n.config.ID && !n.replicating[id] && !n.stopped {
+			n.replicating[id] = true
+			n.running.Add(1)
+
+			go replicate(n, id, address, n.term)
+		}
+	}
+}
+
+// replicate sends the follower the entries it's missing, until it's up to date or can't be reached.
+func replicate(n *Node, id string, address string, term uint64) {
+	defer n.running.Done()
+
+	for {
+		n.mutex.Lock()
+
+		if n.state != Leader || n.term != term {
+			n.mutex.Unlock()
+
+			return
+		}
+
+		request := appendRequest(n, id)
+		sent := time.Now()
+		n.mutex.Unlock()
+
+		ctx, cancel := context.WithTimeout(n.ctx, n.config.ElectionTimeout)
+		response, err := n.config.Transport.AppendEntries(ctx, address, request)
+
+		cancel()
+
+		n.mutex.Lock()
+
+		if err != nil || !handleAppendResponse(n, id, request, response, sent) {
+			if n.replicating != nil && n.term == term {
+				n.replicating[id] = false
+			}
+
+			n.mutex.Unlock()
+
+			return
+		}
+
+		n.mutex.Unlock()
+	}
+}
+
+// appendRequest returns the request sending the follower the entries it's missing. The caller must hold the
+// node's mutex.
+func appendRequest(n *Node, id string) *AppendRequest {
+	next := n.nextIndex[id]
+	end := uint64(len(n.log))
+
+	if end-next > maxAppendEntries {
+		end = next + maxAppendEntries
+	}
+
+	return &AppendRequest{
+		Term:         n.term,
+		LeaderID:     n.config.ID,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.log[next-1].Term,
+		Entries:      append([]Entry{}, n.log[next:end]...),
+		LeaderCommit: n.commitIndex,
+	}
+}
+
+// handleAppendResponse updates the leader's view of the follower from its response, returning whether there
+// are more entries to send it straight away. The caller must hold the node's mutex.
+func handleAppendResponse(n *Node, id string, request *AppendRequest, response *AppendResponse,
+	sent time.Time) bool {
+	if response.Term > n.term {
+		becomeFollower(n, response.Term)
+
+		return false
+	}
+
+	if n.state != Leader || n.term != request.Term {
+		return false
+	}
+
+	if sent.After(n.lastContact[id]) {
+		n.lastContact[id] = sent
+	}
+
+	if response.Success {
+		if match := request.PrevLogIndex + uint64(len(request.Entries)); match > n.matchIndex[id] {
+			n.matchIndex[id] = match
+			n.nextIndex[id] = match + 1
+		}
+
+		advanceCommitIndex(n)
+	} else {
+		next := n.nextIndex[id] - 1
+		if response.ConflictIndex > 0 && response.ConflictIndex < next {
+			next = response.ConflictIndex
+		}
+
+		if next < 1 {
+			next = 1
+		}
+
+		n.nextIndex[id] = next
+	}
+
+	notify(n)
+
+	return n.nextIndex[id] < uint64(len(n.log))
+}
+
+// advanceCommitIndex commits the latest entry from the leader's term that a majority of the members have.
+// The caller must hold the node's mutex.
+func advanceCommitIndex(n *Node) {
+	for index := uint64(len(n.log)) - 1; index > n.commitIndex && n.log[index].Term == n.term; index-- {
+		have := make(map[string]bool)
+
+		for id := range n.members {
+			if id == n.config.ID || n.matchIndex[id] >= index {
+				have[id] = true
+			}
+		}
+
+		if isQuorum(n, have) {
+			n.commitIndex = index
+			notify(n)
+
+			return
+		}
+	}
+}
+
+// hasQuorumSince returns whether a majority of the members have responded to the leader since the time. The
+// caller must hold the node's mutex.
+func hasQuorumSince(n *Node, since time.Time) bool {
+	contacted := make(map[string]bool)
+
+	for id := range n.members {
+		if id == n.config.ID || !n.lastContact[id].Before(since) {
+			contacted[id] = true
+		}
+	}
+
+	return isQuorum(n, contacted)
+}
+
+// isQuorum returns whether the nodes make up a majority of the members. The caller must hold the node's mutex.
+func isQuorum(n *Node, nodes map[string]bool) bool {
+	count := 0
+
+	for id := range n.members {
+		if nodes[id] {
+			count++
+		}
+	}
+
+	return count > len(n.members)/2
+}
+
+// latestMembers returns the members of the latest membership entry in the log, or those the node started
+// with if there isn't one. The caller must hold the node's mutex.
+func latestMembers(n *Node) map[string]string {
+	for i := len(n.log) - 1; i > 0; i-- {
+		if n.log[i].Type == EntryMembers {
+			members := make(map[string]string)
+			if err := json.Unmarshal(n.log[i].Data, &members); err == nil {
+				return members
+			}
+		}
+	}
+
+	return copyMembers(n.config.Members)
+}
+
+// lastEntry returns the index and term of the last entry in the log. The caller must hold the node's mutex.
+func lastEntry(n *Node) (uint64, uint64) {
+	last := n.log[len(n.log)-1]
+
+	return last.Index, last.Term
+}
+
+// resetElectionDeadline picks a random time within the election timeout to stand for election, so that
+// followers are unlikely to stand at once. The caller must hold the node's mutex.
+func resetElectionDeadline(n *Node) {
+	timeout := n.config.ElectionTimeout
+	n.electionDeadline = time.Now().Add(timeout + time.Duration(rand.Int63n(int64(timeout))))
+}
+
+// waitFor calls check with the node's mutex held, each time the node's state changes, until check is
+// satisfied or returns an error, the context is done, or the node is stopped.
+func waitFor(ctx context.Context, n *Node, check func() (bool, error)) error {
+	for {
+		n.mutex.Lock()
+
+		if n.stopped {
+			n.mutex.Unlock()
+
+			return ErrStopped
+		}
+
+		done, err := check()
+		changed := n.changed
+
+		n.mutex.Unlock()
+
+		if err != nil || done {
+			return err
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// notify wakes up anyone waiting for the node's state to change. The caller must hold the node's mutex.
+func notify(n *Node) {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+func copyMembers(members map[string]string) map[string]string {
+	copied := make(map[string]string, len(members))
+	for id, address := range members {
+		copied[id] = address
+	}
+
+	return copied
+}
@@ -0,0 +1,280 @@
+package raft_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"store/pkg/raft"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testElectionTimeout   = 100 * time.Millisecond
+	testHeartbeatInterval = 10 * time.Millisecond
+	waitTimeout           = 5 * time.Second
+)
+
+// testCluster is a cluster of nodes on a local network, recording the commands each node applies.
+type testCluster struct {
+	t       *testing.T
+	network *raft.LocalNetwork
+	nodes   map[string]*raft.Node
+	mutex   sync.Mutex
+	applied map[string][]string
+}
+
+func newTestCluster(t *testing.T, ids ...string) *testCluster {
+	t.Helper()
+
+	cluster := &testCluster{t, raft.NewLocalNetwork(), make(map[string]*raft.Node), sync.Mutex{},
+		make(map[string][]string)}
+
+	members := make(map[string]string)
+	for _, id := range ids {
+		members[id] = id
+	}
+
+	for _, id := range ids {
+		cluster.start(id, members)
+	}
+
+	t.Cleanup(func() {
+		for _, n := range cluster.nodes {
+			raft.Stop(n)
+		}
+	})
+
+	return cluster
+}
+
+// start adds a node to the network, with the members given (or none if it's to join the cluster).
+func (cluster *testCluster) start(id string, members map[string]string) *raft.Node {
+	cluster.t.Helper()
+
+	n, err := raft.NewNode(raft.Config{
+		ID:                id,
+		Members:           members,
+		Transport:         cluster.network,
+		ElectionTimeout:   testElectionTimeout,
+		HeartbeatInterval: testHeartbeatInterval,
+		Apply: func(index uint64, term uint64, command []byte) {
+			cluster.mutex.Lock()
+			defer cluster.mutex.Unlock()
+
+			cluster.applied[id] = append(cluster.applied[id], string(command))
+		},
+	})
+	if err != nil {
+		cluster.t.Fatal("Node should have been created but got: ", err)
+	}
+
+	cluster.nodes[id] = n
+	cluster.network.Connect(n)
+
+	return n
+}
+
+// leader waits for one of the nodes to be the leader, and returns its ID.
+func (cluster *testCluster) leader(ids ...string) string {
+	cluster.t.Helper()
+
+	for deadline := time.Now().Add(waitTimeout); time.Now().Before(deadline); time.Sleep(testHeartbeatInterval) {
+		for _, id := range ids {
+			if raft.IsLeader(cluster.nodes[id]) {
+				return id
+			}
+		}
+	}
+
+	cluster.t.Fatal("One of the nodes should have been elected leader: ", ids)
+
+	return ""
+}
+
+// propose has the leader propose the command, retrying until the leader is ready.
+func (cluster *testCluster) propose(id string, command string) (*raft.Proposal, error) {
+	for deadline := time.Now().Add(waitTimeout); ; time.Sleep(testHeartbeatInterval) {
+		proposal, err := raft.Propose(cluster.nodes[id], []byte(command))
+		if err == nil || time.Now().After(deadline) {
+			return proposal, err
+		}
+	}
+}
+
+// waitForApplied waits for each node to have applied exactly the commands.
+func (cluster *testCluster) waitForApplied(commands []string, ids ...string) {
+	cluster.t.Helper()
+
+	expected := fmt.Sprint(commands)
+
+	for deadline := time.Now().Add(waitTimeout); time.Now().Before(deadline); time.Sleep(testHeartbeatInterval) {
+		cluster.mutex.Lock()
+
+		done := true
+		for _, id := range ids {
+			done = done && fmt.Sprint(cluster.applied[id]) == expected
+		}
+
+		cluster.mutex.Unlock()
+
+		if done {
+			return
+		}
+	}
+
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	cluster.t.Fatalf("Nodes %v should have applied %s but applied %v", ids, expected, cluster.applied)
+}
+
+func others(ids []string, id string) []string {
+	rest := make([]string, 0, len(ids))
+
+	for _, other := range ids {
+		if other != id {
+			rest = append(rest, other)
+		}
+	}
+
+	return rest
+}
+
+func TestSingleNodeElectsItself(t *testing.T) {
+	cluster := newTestCluster(t, "a")
+
+	leader := cluster.leader("a")
+
+	if _, err := cluster.propose(leader, "x"); err != nil {
+		t.Fatal("Command should have been proposed but got: ", err)
+	}
+
+	cluster.waitForApplied([]string{"x"}, "a")
+}
+
+func TestCommandsAppliedByEveryNode(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	cluster := newTestCluster(t, ids...)
+
+	leader := cluster.leader(ids...)
+
+	for _, command := range []string{"x", "y", "z"} {
+		if _, err := cluster.propose(leader, command); err != nil {
+			t.Fatal("Command should have been proposed but got: ", err)
+		}
+	}
+
+	cluster.waitForApplied([]string{"x", "y", "z"}, ids...)
+
+	if _, err := raft.Propose(cluster.nodes[others(ids, leader)[0]], []byte("w")); !errors.Is(err, raft.ErrNotLeader) {
+		t.Fatal("Follower should have rejected the command but got: ", err)
+	}
+
+	if err := raft.ReadIndex(context.Background(), cluster.nodes[leader]); err != nil {
+		t.Fatal("Leader should have confirmed the read but got: ", err)
+	}
+}
+
+func TestPartitionedLeaderReplaced(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	cluster := newTestCluster(t, ids...)
+
+	oldLeader := cluster.leader(ids...)
+
+	cluster.propose(oldLeader, "x")
+	cluster.waitForApplied([]string{"x"}, ids...)
+
+	cluster.network.Partition(oldLeader)
+
+	// the old leader can't commit anything while cut off
+	proposal, err := raft.Propose(cluster.nodes[oldLeader], []byte("lost"))
+	if err != nil {
+		t.Fatal("Old leader should have accepted the command until standing down but got: ", err)
+	}
+
+	select {
+	case <-proposal.Lost:
+	case <-time.After(waitTimeout):
+		t.Fatal("Old leader should have stood down once unable to reach the other nodes")
+	}
+
+	err = raft.ReadIndex(context.Background(), cluster.nodes[oldLeader])
+	if !errors.Is(err, raft.ErrNotLeader) {
+		t.Fatal("Old leader should have refused a linearizable read but got: ", err)
+	}
+
+	rest := others(ids, oldLeader)
+	newLeader := cluster.leader(rest...)
+
+	cluster.propose(newLeader, "y")
+	cluster.waitForApplied([]string{"x", "y"}, rest...)
+
+	// the old leader catches up once it can reach the others again, discarding the uncommitted command
+	cluster.network.Heal()
+	cluster.waitForApplied([]string{"x", "y"}, ids...)
+}
+
+func TestMinorityCannotCommit(t *testing.T) {
+	ids := []string{"a", "b", "c", "d", "e"}
+	cluster := newTestCluster(t, ids...)
+
+	leader := cluster.leader(ids...)
+	followers := others(ids, leader)
+
+	// the leader keeps one follower, while the other three carry on without them
+	cluster.network.Partition(leader, followers[0])
+
+	majority := followers[1:]
+	newLeader := cluster.leader(majority...)
+
+	cluster.propose(newLeader, "x")
+	cluster.waitForApplied([]string{"x"}, majority...)
+
+	if raft.IsLeader(cluster.nodes[leader]) {
+		cluster.propose(leader, "lost")
+	}
+
+	cluster.network.Heal()
+	cluster.waitForApplied([]string{"x"}, ids...)
+}
+
+func TestMembershipChanges(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	cluster := newTestCluster(t, ids...)
+
+	leader := cluster.leader(ids...)
+	cluster.propose(leader, "x")
+
+	// a new node starts with no members, and is brought up to date once added
+	cluster.start("d", nil)
+
+	ctx := context.Background()
+
+	if err := raft.AddMember(ctx, cluster.nodes[leader], "d", "d"); err != nil {
+		t.Fatal("Member should have been added but got: ", err)
+	}
+
+	cluster.propose(leader, "y")
+	cluster.waitForApplied([]string{"x", "y"}, "a", "b", "c", "d")
+
+	if err := raft.AddMember(ctx, cluster.nodes[leader], "d", "d"); !errors.Is(err, raft.ErrMembershipChange) {
+		t.Fatal("Existing member should not have been added again but got: ", err)
+	}
+
+	// the leader removing itself stands down, and the rest elect a new leader
+	if err := raft.RemoveMember(ctx, cluster.nodes[leader], leader); err != nil {
+		t.Fatal("Member should have been removed but got: ", err)
+	}
+
+	remaining := others([]string{"a", "b", "c", "d"}, leader)
+	newLeader := cluster.leader(remaining...)
+
+	if members := raft.NodeStatus(cluster.nodes[newLeader]).Members; len(members) != 3 {
+		t.Fatal("Removed node should no longer have been a member but members were: ", members)
+	}
+
+	cluster.propose(newLeader, "z")
+	cluster.waitForApplied([]string{"x", "y", "z"}, remaining...)
+}
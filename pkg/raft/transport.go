@@ -0,0 +1,139 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrUnreachable is returned by the local network when the node being sent to can't be reached.
+var ErrUnreachable = errors.New("node is unreachable")
+
+// VoteRequest is sent by a candidate to ask for another node's vote.
+type VoteRequest struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidate_id"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+// VoteResponse is a node's answer to a candidate.
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+// AppendRequest is sent by the leader to add entries to a follower's log, or with no entries as a heartbeat.
+type AppendRequest struct {
+	Term         uint64  `json:"term"`
+	LeaderID     string  `json:"leader_id"`
+	PrevLogIndex uint64  `json:"prev_log_index"`
+	PrevLogTerm  uint64  `json:"prev_log_term"`
+	Entries      []Entry `json:"entries"`
+	LeaderCommit uint64  `json:"leader_commit"`
+}
+
+// AppendResponse is a follower's answer to the leader. If the follower's log doesn't hold the entry before
+// those sent, ConflictIndex is where the leader should try from next.
+type AppendResponse struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	ConflictIndex uint64 `json:"conflict_index,omitempty"`
+}
+
+// Transport sends requests to the other nodes in the cluster, by the address each was given in the cluster's
+// members. The requests are passed to the node's HandleRequestVote and HandleAppendEntries functions.
+type Transport interface {
+	// RequestVote asks the node at the address for its vote.
+	RequestVote(ctx context.Context, address string, request *VoteRequest) (*VoteResponse, error)
+	// AppendEntries adds entries to the log of the node at the address.
+	AppendEntries(ctx context.Context, address string, request *AppendRequest) (*AppendResponse, error)
+}
+
+// LocalNetwork is a transport between nodes in the same process, using each node's ID as its address, which
+// can be partitioned to test how a cluster copes with nodes being cut off from each other.
+type LocalNetwork struct {
+	mutex sync.Mutex
+	nodes map[string]*Node
+	// side gives the side of the partition each node is on, nodes not in the map being on the same side
+	side map[string]int
+}
+
+// NewLocalNetwork returns a local network without any nodes connected to it yet.
+func NewLocalNetwork() *LocalNetwork {
+	return &LocalNetwork{nodes: make(map[string]*Node), side: make(map[string]int)}
+}
+
+// Connect adds the node to the network, so that requests can be sent to it.
+func (network *LocalNetwork) Connect(n *Node) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	network.nodes[n.config.ID] = n
+}
+
+// Disconnect removes the node from the network, as if it had crashed.
+func (network *LocalNetwork) Disconnect(id string) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	delete(network.nodes, id)
+}
+
+// Partition cuts the nodes off from the rest of the network, so that they can only reach each other.
+func (network *LocalNetwork) Partition(ids ...string) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	side := len(network.side) + 1
+	for _, id := range ids {
+		network.side[id] = side
+	}
+}
+
+// Heal removes any partitions, so that every node can reach every other again.
+func (network *LocalNetwork) Heal() {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	network.side = make(map[string]int)
+}
+
+// RequestVote implements Transport.
+func (network *LocalNetwork) RequestVote(ctx context.Context, address string,
+	request *VoteRequest) (*VoteResponse, error) {
+	n, err := network.reach(ctx, request.CandidateID, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return HandleRequestVote(n, request), nil
+}
+
+// AppendEntries implements Transport.
+func (network *LocalNetwork) AppendEntries(ctx context.Context, address string,
+	request *AppendRequest) (*AppendResponse, error) {
+	n, err := network.reach(ctx, request.LeaderID, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return HandleAppendEntries(n, request), nil
+}
+
+// reach returns the node being sent to, if it can be reached from the sender.
+func (network *LocalNetwork) reach(ctx context.Context, from string, to string) (*Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	n, ok := network.nodes[to]
+	if _, connected := network.nodes[from]; !ok || !connected || network.side[from] != network.side[to] {
+		return nil, ErrUnreachable
+	}
+
+	return n, nil
+}
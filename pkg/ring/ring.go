@@ -0,0 +1,146 @@
+// Package ring provides a consistent hash ring, which spreads keys across a set of nodes so that adding or
+// removing a node only moves the keys to or from that node, rather than reshuffling every key.
+//
+// Each node is placed at a number of points (virtual nodes) around the ring, by hashing its ID, and each key is
+// owned by the node at the first point at or after the key's own hash. The more virtual nodes, the more evenly
+// the keys are spread.
+package ring
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultVirtualNodes is the number of points each node has on the ring, if not specified.
+const DefaultVirtualNodes = 100
+
+// point is a position on the ring, belonging to a node.
+type point struct {
+	hash uint64
+	node string
+}
+
+// Ring is a consistent hash ring of nodes. It is safe to use from multiple go routines.
+type Ring struct {
+	mutex        sync.RWMutex
+	virtualNodes int
+	nodes        map[string]struct{}
+	// points are kept in order of hash
+	points []point
+}
+
+// New returns a ring with the nodes given, each placed at the number of virtual nodes (or
+// DefaultVirtualNodes if not positive).
+func New(virtualNodes int, nodes ...string) *Ring {
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+
+	r := &Ring{virtualNodes: virtualNodes, nodes: make(map[string]struct{})}
+
+	for _, node := range nodes {
+		r.nodes[node] = struct{}{}
+	}
+
+	placeNodes(r)
+
+	return r
+}
+
+// Add places the node on the ring, if it isn't there already.
+func Add(r *Ring, node string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.nodes[node]; ok {
+		return
+	}
+
+	r.nodes[node] = struct{}{}
+	placeNodes(r)
+}
+
+// Remove takes the node off the ring, with its keys passing to the nodes that follow its points.
+func Remove(r *Ring, node string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.nodes[node]; !ok {
+		return
+	}
+
+	delete(r.nodes, node)
+	placeNodes(r)
+}
+
+// Owner returns the node that owns the key, and false if there are no nodes.
+func Owner(r *Ring, key string) (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if len(r.points) == 0 {
+		return "", false
+	}
+
+	hash := hashOf(key)
+
+	// the first point at or after the key, wrapping round to the start
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.points[i].node, true
+}
+
+// Nodes returns the nodes on the ring, in order.
+func Nodes(r *Ring) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+
+	sort.Strings(nodes)
+
+	return nodes
+}
+
+// placeNodes works out the points of every node. The caller must hold the mutex for writing.
+func placeNodes(r *Ring) {
+	r.points = make([]point, 0, len(r.nodes)*r.virtualNodes)
+
+	for node := range r.nodes {
+		for i := 0; i < r.virtualNodes; i++ {
+			r.points = append(r.points, point{hashOf(node + "#" + strconv.Itoa(i)), node})
+		}
+	}
+
+	// ties (which are unlikely) go to the lowest node, so that every ring with the same nodes agrees
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash == r.points[j].hash {
+			return r.points[i].node < r.points[j].node
+		}
+
+		return r.points[i].hash < r.points[j].hash
+	})
+}
+
+// hashOf returns the position of the value on the ring.
+func hashOf(value string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+
+	// FNV spreads similar values (such as a node's virtual nodes) poorly across the high bits, so mix them
+	hash := binary.BigEndian.Uint64(hasher.Sum(nil))
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+
+	return hash
+}
@@ -0,0 +1,88 @@
+package ring_test
+
+import (
+	"fmt"
+	"store/pkg/ring"
+	"testing"
+)
+
+const testKeys = 10000
+
+// owners returns the owner of each of the test keys.
+func owners(r *ring.Ring) map[string]string {
+	owned := make(map[string]string)
+
+	for i := 0; i < testKeys; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owned[key], _ = ring.Owner(r, key)
+	}
+
+	return owned
+}
+
+func TestEmptyRingHasNoOwner(t *testing.T) {
+	r := ring.New(0)
+
+	if owner, ok := ring.Owner(r, "key1"); ok {
+		t.Fatal("Empty ring should not have had an owner but had: ", owner)
+	}
+}
+
+func TestKeysSpreadEvenly(t *testing.T) {
+	r := ring.New(0, "a", "b", "c", "d")
+
+	counts := make(map[string]int)
+	for _, owner := range owners(r) {
+		counts[owner]++
+	}
+
+	// each node should have roughly a quarter of the keys
+	for _, node := range ring.Nodes(r) {
+		if counts[node] < testKeys/8 || counts[node] > testKeys/2 {
+			t.Fatal("Keys should have been spread evenly but were: ", counts)
+		}
+	}
+}
+
+func TestRingsWithSameNodesAgree(t *testing.T) {
+	first := owners(ring.New(0, "a", "b", "c"))
+	second := owners(ring.New(0, "c", "b", "a"))
+
+	for key, owner := range first {
+		if second[key] != owner {
+			t.Fatalf("Key %s should have had the same owner but had %s and %s", key, owner, second[key])
+		}
+	}
+}
+
+func TestAddingNodeOnlyMovesKeysToIt(t *testing.T) {
+	r := ring.New(0, "a", "b", "c")
+	before := owners(r)
+
+	ring.Add(r, "d")
+	after := owners(r)
+
+	moved := 0
+
+	for key, owner := range after {
+		if owner != before[key] {
+			if owner != "d" {
+				t.Fatalf("Key %s should only have moved to the new node but moved to %s", key, owner)
+			}
+
+			moved++
+		}
+	}
+
+	if moved == 0 || moved > testKeys/2 {
+		t.Fatal("About a quarter of the keys should have moved but moved: ", moved)
+	}
+
+	ring.Remove(r, "d")
+
+	for key, owner := range owners(r) {
+		if owner != before[key] {
+			t.Fatalf("Key %s should have returned to %s but was owned by %s", key, before[key], owner)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"store/pkg/kvstore"
+)
+
+// maxACLBytes is the largest access control list that can be set in one request.
+const maxACLBytes = 1 << 16
+
+// acl gets (GET) or replaces (PUT) the access control list of a key, as JSON.
+func acl(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	key := getKey(request.URL.Path)
+	if key == "" {
+		logger.Println("No key specified")
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	switch request.Method {
+	case http.MethodGet:
+		getACL(writer, request, username, store, key, logger)
+	case http.MethodPut:
+		putACL(writer, request, username, store, key, logger)
+	default:
+		http.NotFound(writer, request)
+	}
+}
+
+func getACL(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, key string, logger *log.Logger) {
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	logger.Printf("get ACL of key %s user %s", key, username)
+
+	keyACL, found, err := kvstore.GetACLContext(request.Context(), kvStore, key, username)
+
+	switch {
+	case err != nil:
+		logger.Println("unable to get ACL: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	case !found:
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	default:
+		bytes, marshalErr := json.Marshal(keyACL)
+		if marshalErr != nil {
+			logger.Print("Error marshalling ACL to JSON: ", marshalErr)
+			http.Error(writer, marshalErr.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(bytes)
+	}
+}
+
+func putACL(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, key string, logger *log.Logger) {
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	defer request.Body.Close()
+
+	var keyACL kvstore.ACL
+
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxACLBytes))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&keyACL); err != nil {
+		logger.Println("invalid ACL: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	logger.Printf("set ACL of key %s user %s", key, username)
+
+	found, err := kvstore.SetACLContext(request.Context(), kvStore, key, keyACL, username)
+
+	switch {
+	case errors.Is(err, kvstore.ErrInvalidACL):
+		logger.Println("invalid ACL: ", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		logger.Println("unable to set ACL: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	case !found:
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	default:
+		fmt.Fprint(writer, "OK")
+	}
+}
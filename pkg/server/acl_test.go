@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net/http/httptest"
+	"store/pkg/kvstore"
+	"strings"
+	"testing"
+)
+
+func TestACLPutAndGet(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/acl/abc",
+		strings.NewReader(`{"users":{"user_b":["read","write"]},"private":true}`))
+
+	acl(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/acl/abc", nil)
+
+	acl(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^{"users":{"user_b":\["read","write"\]},"private":true}$`)
+
+	kvstore.Close(store)
+}
+
+func TestACLNotAdmin(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/acl/abc", strings.NewReader(`{"private":true}`))
+
+	acl(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	kvstore.Close(store)
+}
+
+func TestACLInvalid(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/acl/abc", strings.NewReader(`{"users":{"user_b":["execute"]}}`))
+
+	acl(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "unknown permission")
+
+	kvstore.Close(store)
+}
+
+func TestACLMissingKey(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/acl/abc", nil)
+
+	acl(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 404, "Not Found")
+
+	kvstore.Close(store)
+}
+
+func TestGetPrivateKey(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+	kvstore.SetACL(store, "abc", kvstore.ACL{Private: true}, "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/store/abc", nil)
+
+	storeKey(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	kvstore.Close(store)
+}
+
+func TestListPrivateKey(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+	kvstore.Write(store, "def", []byte("456"), "user_a")
+	kvstore.SetACL(store, "abc", kvstore.ACL{Private: true}, "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/list/abc", nil)
+
+	listKey(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/list", nil)
+
+	listAll(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^\[{"key":"def",[^\]]*}\]$`)
+
+	kvstore.Close(store)
+}
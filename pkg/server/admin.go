@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"store/pkg/kvstore"
+)
+
+func snapshot(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	if request.Method != http.MethodPost {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	if username != adminUsername {
+		logger.Println("Ignoring snapshot request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	logger.Println("Taking snapshot of store")
+
+	err := kvstore.Snapshot(kvStore)
+
+	switch {
+	case err == nil:
+		fmt.Fprint(writer, "OK")
+	case errors.Is(err, kvstore.ErrSnapshotsDisabled):
+		logger.Println("Snapshot requested but snapshots are not enabled")
+		http.Error(writer, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	default:
+		logger.Println("Unable to take snapshot: ", err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func stats(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	if request.Method != http.MethodGet {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	if username != adminUsername {
+		logger.Println("Ignoring stats request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	bytes, err := json.Marshal(kvstore.StoreStats(kvStore))
+	if err != nil {
+		logger.Print("Error marshalling stats to JSON: ", err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(bytes)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"store/pkg/kvstore"
+	"testing"
+)
+
+func TestSnapshotNotAdmin(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/admin/snapshot", nil)
+	store := kvstore.NewKVStore(1)
+
+	snapshot(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden\n")
+
+	kvstore.Close(store)
+}
+
+func TestSnapshotNotEnabled(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/admin/snapshot", nil)
+	store := kvstore.NewKVStore(1)
+
+	snapshot(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 501, "Not Implemented\n")
+
+	kvstore.Close(store)
+}
+
+func TestSnapshotValid(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/admin/snapshot", nil)
+	store, err := kvstore.NewKVStoreWithConfig(kvstore.Config{
+		SnapshotPath: filepath.Join(t.TempDir(), "store.snapshot"),
+	})
+	if err != nil {
+		t.Fatal("Error opening store: ", err)
+	}
+
+	snapshot(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	kvstore.Close(store)
+}
+
+func TestStatsNotAdmin(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/admin/stats", nil)
+	store := kvstore.NewKVStore(1)
+
+	stats(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden\n")
+
+	kvstore.Close(store)
+}
+
+func TestStatsValid(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/admin/stats", nil)
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Eviction: kvstore.Eviction{MaxKeys: 1}})
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+	kvstore.Write(store, "def", []byte("456"), "user_a")
+
+	stats(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 200, `^{"revision":3,"keys":1,"bytes":6,"evictions":1}$`)
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"store/pkg/kvstore"
+	"strconv"
+	"time"
+)
+
+const (
+	// ndjsonContentType is the media type of a stream of newline-delimited JSON values.
+	ndjsonContentType = "application/x-ndjson"
+
+	defaultChangesWait = 30 * time.Second
+	maxChangesWait     = 5 * time.Minute
+)
+
+var errInvalidWait = errors.New("wait must be a duration of at most 5m")
+
+// changesBatch is a set of mutations returned by a long-poll for changes, along with the revision to pass as
+// since in the next request.
+type changesBatch struct {
+	Changes  []*kvstore.Mutation `json:"changes"`
+	Revision uint64              `json:"revision"`
+}
+
+// changes returns the mutations made to any key after the revision given by the since query parameter (admin
+// only). By default this is a long-poll, which waits (for up to the wait query parameter) for there to be
+// changes if there are none yet, and returns them as a JSON batch. If the request accepts NDJSON (or has
+// stream=true), the changes are instead streamed one per line as they happen, until the client disconnects.
+// Values are base64 encoded, since they may not be text.
+func changes(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	if request.Method != http.MethodGet {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	if username != adminUsername {
+		logger.Println("Ignoring changes request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	query := request.URL.Query()
+
+	var since uint64
+
+	if value := query.Get("since"); value != "" {
+		var err error
+
+		if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+			logger.Println("invalid revision: ", value)
+			http.Error(writer, errInvalidRevision.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	limit := defaultPageSize
+
+	if value := query.Get("limit"); value != "" {
+		var err error
+
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			logger.Println("invalid limit: ", value)
+			http.Error(writer, errInvalidLimit.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Accept"))
+	if mediaType == ndjsonContentType || query.Get("stream") == "true" {
+		streamChanges(writer, request, kvStore, since, limit, logger)
+
+		return
+	}
+
+	wait := defaultChangesWait
+
+	if value := query.Get("wait"); value != "" {
+		var err error
+
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 || wait > maxChangesWait {
+			logger.Println("invalid wait: ", value)
+			http.Error(writer, errInvalidWait.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	logger.Printf("changes since %d limit %d wait %s", since, limit, wait)
+
+	ctx, cancel := context.WithTimeout(request.Context(), wait)
+	defer cancel()
+
+	mutations, err := kvstore.WaitForChanges(ctx, kvStore, since, limit)
+	if errors.Is(err, context.DeadlineExceeded) && request.Context().Err() == nil {
+		// nothing changed while waiting
+		mutations, err = []*kvstore.Mutation{}, nil
+	}
+
+	switch {
+	case errors.Is(err, kvstore.ErrCompacted):
+		http.Error(writer, err.Error(), http.StatusGone)
+	case err != nil:
+		logger.Println("unable to read changes: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	default:
+		batch := &changesBatch{mutations, since}
+		if len(mutations) > 0 {
+			batch.Revision = mutations[len(mutations)-1].Revision
+		}
+
+		bytes, marshalErr := json.Marshal(batch)
+		if marshalErr != nil {
+			logger.Print("Error marshalling changes to JSON: ", marshalErr)
+			http.Error(writer, marshalErr.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(bytes)
+	}
+}
+
+// streamChanges streams the mutations after the revision as newline-delimited JSON as they happen, until the
+// client disconnects, the server shuts down, or the client falls so far behind that mutations are lost.
+func streamChanges(writer http.ResponseWriter, request *http.Request, kvStore *kvstore.KVStore, since uint64,
+	limit int, logger *log.Logger) {
+	flusher, canFlush := writer.(http.Flusher)
+	if !canFlush {
+		logger.Println("Unable to stream changes as response writer cannot be flushed")
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	// check the client can catch up before starting the stream
+	if _, err := kvstore.Changes(kvStore, since, 1); errors.Is(err, kvstore.ErrCompacted) {
+		http.Error(writer, err.Error(), http.StatusGone)
+
+		return
+	}
+
+	logger.Printf("stream changes since %d", since)
+
+	writer.Header().Set("Content-Type", ndjsonContentType)
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(writer)
+
+	for {
+		mutations, err := kvstore.WaitForChanges(request.Context(), kvStore, since, limit)
+		if err != nil {
+			// the client has gone away, the store was closed, or the client fell too far behind
+			return
+		}
+
+		for _, mutation := range mutations {
+			if err = encoder.Encode(mutation); err != nil {
+				logger.Println("Unable to encode change: ", err)
+
+				return
+			}
+		}
+
+		flusher.Flush()
+
+		since = mutations[len(mutations)-1].Revision
+	}
+}
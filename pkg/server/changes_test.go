@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"store/pkg/kvstore"
+	"strings"
+	"testing"
+)
+
+func TestChangesLongPoll(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+	kvstore.Delete(store, "abc", "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/changes?since=0", nil)
+
+	changes(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 200,
+		`^\{"changes":\[\{"revision":1,"type":"write","key":"abc","value":"MTIz","owner":"user_a",.*\},`+
+			`\{"revision":2,"type":"delete","key":"abc",.*\}\],"revision":2\}$`)
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/changes?since=2&wait=10ms", nil)
+
+	changes(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 200, `^\{"changes":\[\],"revision":2\}$`)
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/changes", nil)
+
+	changes(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/changes?since=x", nil)
+
+	changes(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 400, "invalid revision")
+
+	kvstore.Close(store)
+}
+
+func TestChangesCompacted(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{ChangeFeed: 1})
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+	kvstore.Write(store, "abc", []byte("456"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/changes?since=0", nil)
+
+	changes(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 410, "compacted")
+
+	kvstore.Close(store)
+}
+
+func TestChangesStream(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		changes(writer, request, adminUsername, store, testLogger)
+	}))
+	defer testServer.Close()
+
+	request, _ := http.NewRequest("GET", testServer.URL+"/changes", nil)
+	request.Header.Set("Accept", ndjsonContentType)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("Error making request: ", err)
+	}
+	defer response.Body.Close()
+
+	if contentType := response.Header.Get("Content-Type"); contentType != ndjsonContentType {
+		t.Fatal("Wrong content type: ", contentType)
+	}
+
+	// values that aren't text survive the stream, along with their content type
+	options := kvstore.WriteOptions{ContentType: "application/octet-stream"}
+	kvstore.WriteWithOptions(store, "abc", []byte{0xff, 0xfe}, "user_a", options)
+
+	reader := bufio.NewReader(response.Body)
+
+	for _, expected := range []string{`{"revision":1,"type":"write","key":"abc","value":"MTIz"`,
+		`{"revision":2,"type":"write","key":"abc","value":"//4=","content_type":"application/octet-stream"`} {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil {
+			t.Fatal("Error reading change stream: ", readErr)
+		}
+
+		if !strings.HasPrefix(line, expected) {
+			t.Fatalf("Wrong change stream line, expected %q, got %q", expected, line)
+		}
+	}
+
+	kvstore.Close(store)
+}
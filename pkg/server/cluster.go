@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"store/pkg/kvstore"
+	"store/pkg/raft"
+	"strings"
+)
+
+var errNotCluster = fmt.Errorf("%w: store is not part of a cluster", kvstore.ErrNotSupported)
+
+// clusterTransport sends Raft requests to the other nodes of a cluster over HTTP, with each node's address
+// being the base URL of its REST server, e.g. http://localhost:8000.
+type clusterTransport struct {
+	client *http.Client
+}
+
+// NewClusterTransport returns a transport for the nodes of a cluster to make requests of each other through
+// their REST servers. Every server must be using the same key to sign bearer tokens, since the requests are
+// made as the admin user.
+func NewClusterTransport() raft.Transport {
+	return &clusterTransport{http.DefaultClient}
+}
+
+// RequestVote implements raft.Transport.
+func (transport *clusterTransport) RequestVote(ctx context.Context, address string,
+	request *raft.VoteRequest) (*raft.VoteResponse, error) {
+	response := &raft.VoteResponse{}
+
+	return response, transport.post(ctx, address+"/raft/vote", request, response)
+}
+
+// AppendEntries implements raft.Transport.
+func (transport *clusterTransport) AppendEntries(ctx context.Context, address string,
+	request *raft.AppendRequest) (*raft.AppendResponse, error) {
+	response := &raft.AppendResponse{}
+
+	return response, transport.post(ctx, address+"/raft/append", request, response)
+}
+
+// post sends the request as JSON to the URL as the admin user, and decodes the JSON response.
+func (transport *clusterTransport) post(ctx context.Context, target string, request interface{},
+	response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	token, err := newToken(adminUsername)
+	if err != nil {
+		return err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	httpRequest.Header.Set("Authorization", "Bearer "+token)
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := transport.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", raft.ErrUnreachable, httpResponse.Status)
+	}
+
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
+
+// raftVote passes a candidate's request for a vote on to the store's Raft node (admin only).
+func raftVote(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	node, ok := raftRequest(writer, request, username, store, logger)
+	if !ok {
+		return
+	}
+
+	voteRequest := &raft.VoteRequest{}
+	if err := json.NewDecoder(request.Body).Decode(voteRequest); err != nil {
+		logger.Println("Unable to parse vote request: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	writeJSON(writer, raft.HandleRequestVote(node, voteRequest), logger)
+}
+
+// raftAppend passes the leader's entries (or heartbeat) on to the store's Raft node (admin only).
+func raftAppend(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	node, ok := raftRequest(writer, request, username, store, logger)
+	if !ok {
+		return
+	}
+
+	appendRequest := &raft.AppendRequest{}
+	if err := json.NewDecoder(request.Body).Decode(appendRequest); err != nil {
+		logger.Println("Unable to parse append request: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	writeJSON(writer, raft.HandleAppendEntries(node, appendRequest), logger)
+}
+
+// raftRequest checks the request is a POST from the admin user, and returns the store's Raft node. If not, or
+// the store isn't part of a cluster, the error is sent and false returned.
+func raftRequest(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) (*raft.Node, bool) {
+	if request.Method != http.MethodPost {
+		http.NotFound(writer, request)
+
+		return nil, false
+	}
+
+	if username != adminUsername {
+		logger.Println("Ignoring Raft request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return nil, false
+	}
+
+	return clusterNode(writer, store, logger)
+}
+
+// clusterStatus returns the state of the store's Raft node, including the cluster's leader and members (admin
+// only).
+func clusterStatus(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	if request.Method != http.MethodGet {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	if username != adminUsername {
+		logger.Println("Ignoring cluster status request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	node, ok := clusterNode(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	writeJSON(writer, raft.NodeStatus(node), logger)
+}
+
+// clusterMember adds a node to the cluster, with the request's body as its address, or removes it from the
+// cluster (admin only). Membership is changed by the leader, once any earlier change has been committed.
+func clusterMember(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	id := strings.TrimPrefix(request.URL.Path, "/admin/cluster/members/")
+
+	if username != adminUsername {
+		logger.Println("Ignoring cluster membership request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	node, ok := clusterNode(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	var err error
+
+	switch request.Method {
+	case http.MethodPut:
+		address, readErr := io.ReadAll(request.Body)
+		if readErr != nil {
+			logger.Println("Unable to read member address: ", readErr)
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		logger.Printf("add cluster member %s at %s", id, address)
+		err = raft.AddMember(request.Context(), node, id, strings.TrimSpace(string(address)))
+	case http.MethodDelete:
+		logger.Printf("remove cluster member %s", id)
+		err = raft.RemoveMember(request.Context(), node, id)
+	default:
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	switch {
+	case err == nil:
+		fmt.Fprint(writer, "OK")
+	case errors.Is(err, raft.ErrMembershipChange):
+		http.Error(writer, err.Error(), http.StatusConflict)
+	default:
+		logger.Println("Unable to change cluster members: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	}
+}
+
+// clusterNode returns the store's Raft node. If the store isn't part of a cluster, the error is sent and false
+// returned.
+func clusterNode(writer http.ResponseWriter, store kvstore.Store, logger *log.Logger) (*raft.Node, bool) {
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return nil, false
+	}
+
+	node, ok := kvstore.ClusterNode(kvStore)
+	if !ok {
+		logger.Println(errNotCluster)
+		http.Error(writer, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+
+		return nil, false
+	}
+
+	return node, true
+}
+
+// writeJSON responds with the value as JSON.
+func writeJSON(writer http.ResponseWriter, value interface{}, logger *log.Logger) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		logger.Print("Error marshalling response to JSON: ", err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(body)
+}
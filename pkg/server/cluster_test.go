@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"store/pkg/kvstore"
+	"store/pkg/raft"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startClusterNodes runs a REST server for each of the IDs until the test ends, with each server's store a
+// node of a cluster made up of the first members IDs, so that the rest can be added later.
+func startClusterNodes(t *testing.T, members int, ids ...string) (map[string]*kvstore.KVStore, map[string]string) {
+	t.Helper()
+
+	stores := make(map[string]*kvstore.KVStore)
+	addresses := make(map[string]string)
+	routers := make(map[string]http.Handler)
+
+	// the servers need their addresses before the stores can be created, so wait for the stores before routing
+	ready := make(chan struct{})
+
+	for _, id := range ids {
+		id := id
+		testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			<-ready
+			routers[id].ServeHTTP(writer, request)
+		}))
+		t.Cleanup(testServer.Close)
+
+		addresses[id] = testServer.URL
+	}
+
+	clusterMembers := make(map[string]string)
+	for _, id := range ids[:members] {
+		clusterMembers[id] = addresses[id]
+	}
+
+	for i, id := range ids {
+		cluster := &kvstore.Cluster{
+			ID:                id,
+			Transport:         NewClusterTransport(),
+			ElectionTimeout:   200 * time.Millisecond,
+			HeartbeatInterval: 20 * time.Millisecond,
+		}
+
+		if i < members {
+			cluster.Members = clusterMembers
+		}
+
+		store, err := kvstore.NewKVStoreWithConfig(kvstore.Config{Cluster: cluster})
+		if err != nil {
+			t.Fatal("Cluster node should have been created but got: ", err)
+		}
+
+		t.Cleanup(func() { kvstore.Close(store) })
+
+		stores[id] = store
+		routers[id] = newRouter(store, testLogger, testLogger, make(chan int))
+	}
+
+	close(ready)
+
+	return stores, addresses
+}
+
+// waitForLeader waits for one of the stores to be the cluster's leader, and ready for changes.
+func waitForLeader(t *testing.T, stores map[string]*kvstore.KVStore) string {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		for id, store := range stores {
+			if node, _ := kvstore.ClusterNode(store); raft.IsLeader(node) {
+				if err := kvstore.Write(store, "ready", []byte(id), "user_a"); err == nil {
+					return id
+				}
+			}
+		}
+	}
+
+	t.Fatal("One of the stores should have been elected leader")
+
+	return ""
+}
+
+// clusterRequest makes a request of the server as the admin user.
+func clusterRequest(t *testing.T, method string, target string, body string) *http.Response {
+	t.Helper()
+
+	token, _ := newToken(adminUsername)
+	request, _ := http.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("Error making request: ", err)
+	}
+
+	t.Cleanup(func() { response.Body.Close() })
+
+	return response
+}
+
+func TestClusterEndpointsNeedCluster(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/admin/cluster", nil)
+
+	clusterStatus(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/admin/cluster", nil)
+
+	clusterStatus(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 501, "Not Implemented")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/raft/vote", strings.NewReader("{}"))
+
+	raftVote(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 501, "Not Implemented")
+
+	kvstore.Close(store)
+}
+
+func TestRaftRequestsNotLogged(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	defer kvstore.Close(store)
+
+	accessLog := &bytes.Buffer{}
+	router := newRouter(store, log.New(accessLog, "", 0), testLogger, make(chan int))
+
+	// still only allowed with a bearer token
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", "/raft/append", strings.NewReader("{}")))
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	token, _ := newToken(adminUsername)
+	request := httptest.NewRequest("POST", "/raft/vote", strings.NewReader("{}"))
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	checkResponse(t, recorder, 501, "Not Implemented")
+
+	if accessLog.Len() != 0 {
+		t.Fatal("Raft requests should not have been logged but got: ", accessLog.String())
+	}
+}
+
+func TestClusterOverHTTP(t *testing.T) {
+	stores, addresses := startClusterNodes(t, 3, "a", "b", "c", "d")
+	delete(stores, "d")
+
+	leader := waitForLeader(t, stores)
+
+	for id := range stores {
+		if id == leader {
+			continue
+		}
+
+		response := clusterRequest(t, "PUT", addresses[id]+"/store/abc", "123")
+		if response.StatusCode != http.StatusMisdirectedRequest {
+			t.Fatal("Write to a follower should have been rejected but got: ", response.Status)
+		}
+	}
+
+	if response := clusterRequest(t, "PUT", addresses[leader]+"/store/abc", "123"); response.StatusCode != 200 {
+		t.Fatal("Write to the leader should have been committed but got: ", response.Status)
+	}
+
+	// the new node is brought up to date once added
+	response := clusterRequest(t, "PUT", addresses[leader]+"/admin/cluster/members/d", addresses["d"])
+	if response.StatusCode != http.StatusOK {
+		t.Fatal("Member should have been added but got: ", response.Status)
+	}
+
+	response = clusterRequest(t, "PUT", addresses[leader]+"/admin/cluster/members/d", addresses["d"])
+	if response.StatusCode != http.StatusConflict {
+		t.Fatal("Existing member should not have been added again but got: ", response.Status)
+	}
+
+	response = clusterRequest(t, "GET", addresses["d"]+"/admin/cluster", "")
+
+	status := raft.Status{}
+	if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+		t.Fatal("Cluster status should have been returned but got: ", err)
+	}
+
+	if status.ID != "d" || status.State != raft.Follower {
+		t.Fatal("New member should have been a follower but was: ", status)
+	}
+
+	revision := kvstore.StoreStats(stores[leader]).Revision
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		response = clusterRequest(t, "GET", addresses["d"]+"/admin/stats", "")
+
+		stats := kvstore.Stats{}
+		json.NewDecoder(response.Body).Decode(&stats)
+
+		if stats.Revision == revision {
+			return
+		}
+	}
+
+	t.Fatal("New member should have caught up with the leader's revision ", revision)
+}
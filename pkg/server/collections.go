@@ -0,0 +1,294 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"store/pkg/kvstore"
+	"strconv"
+	"strings"
+)
+
+// maxCollectionBytes is the largest set of values that can be given in one request to change a collection.
+const maxCollectionBytes = 1 << 20
+
+var errInvalidRange = errors.New("start and stop must be integers")
+
+// the actions that can be taken on each type of collection, as suffixes of the key
+var (
+	listActions = []string{"/push", "/pop"}
+	setActions  = []string{"/add", "/remove"}
+	hashActions = []string{"/set", "/delete"}
+)
+
+// lists reads (GET /lists/{key}?start=0&stop=-1) a list, or pushes values given as a JSON array
+// (POST /lists/{key}/push?end=front) to it or pops a value (POST /lists/{key}/pop?end=front) from it. Values
+// are pushed to and popped from the back of the list unless the end query parameter is front.
+func lists(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	kvStore, key, action, ok := collectionRequest(writer, request, store, listActions, logger)
+	if !ok {
+		return
+	}
+
+	front := request.URL.Query().Get("end") == "front"
+
+	switch action {
+	case "":
+		start, stop, err := getRange(request)
+		if err != nil {
+			logger.Println("invalid range: ", err)
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		logger.Printf("list range key %s from %d to %d", key, start, stop)
+
+		values, found, err := kvstore.ListRangeContext(request.Context(), kvStore, key, start, stop, username)
+		writeCollection(writer, values, found, err, logger)
+
+	case "/push":
+		var values []string
+		if !decodeCollectionBody(writer, request, &values, logger) {
+			return
+		}
+
+		logger.Printf("list push key %s values %d user %s", key, len(values), username)
+
+		length, err := kvstore.ListPushContext(request.Context(), kvStore, key, values, front, username)
+		writeCount(writer, length, err, logger)
+
+	case "/pop":
+		logger.Printf("list pop key %s user %s", key, username)
+
+		value, found, err := kvstore.ListPopContext(request.Context(), kvStore, key, front, username)
+		if err != nil || !found {
+			writeCollection(writer, nil, found, err, logger)
+
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(writer, value)
+	}
+}
+
+// sets reads the members (GET /sets/{key}) of a set, or adds (POST /sets/{key}/add) or removes
+// (POST /sets/{key}/remove) the members given as a JSON array.
+func sets(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	kvStore, key, action, ok := collectionRequest(writer, request, store, setActions, logger)
+	if !ok {
+		return
+	}
+
+	if action == "" {
+		logger.Printf("set members key %s", key)
+
+		members, found, err := kvstore.SetMembersContext(request.Context(), kvStore, key, username)
+		writeCollection(writer, members, found, err, logger)
+
+		return
+	}
+
+	var members []string
+	if !decodeCollectionBody(writer, request, &members, logger) {
+		return
+	}
+
+	logger.Printf("set %s key %s members %d user %s", action[1:], key, len(members), username)
+
+	change := kvstore.SetAddContext
+	if action == "/remove" {
+		change = kvstore.SetRemoveContext
+	}
+
+	count, err := change(request.Context(), kvStore, key, members, username)
+	writeCount(writer, count, err, logger)
+}
+
+// hashes reads all the fields (GET /hashes/{key}) or a single field (GET /hashes/{key}?field=name) of a hash,
+// or sets the fields given as a JSON object (POST /hashes/{key}/set) or deletes the fields given as a JSON
+// array (POST /hashes/{key}/delete).
+func hashes(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	kvStore, key, action, ok := collectionRequest(writer, request, store, hashActions, logger)
+	if !ok {
+		return
+	}
+
+	switch action {
+	case "":
+		query := request.URL.Query()
+		if !query.Has("field") {
+			logger.Printf("hash get all key %s", key)
+
+			fields, found, err := kvstore.HashGetAllContext(request.Context(), kvStore, key, username)
+			writeCollection(writer, fields, found, err, logger)
+
+			return
+		}
+
+		field := query.Get("field")
+
+		logger.Printf("hash get key %s field %s", key, field)
+
+		value, found, err := kvstore.HashGetContext(request.Context(), kvStore, key, field, username)
+		if err != nil || !found {
+			writeCollection(writer, nil, found, err, logger)
+
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(writer, value)
+
+	case "/set":
+		var fields map[string]string
+		if !decodeCollectionBody(writer, request, &fields, logger) {
+			return
+		}
+
+		logger.Printf("hash set key %s fields %d user %s", key, len(fields), username)
+
+		count, err := kvstore.HashSetContext(request.Context(), kvStore, key, fields, username)
+		writeCount(writer, count, err, logger)
+
+	case "/delete":
+		var fields []string
+		if !decodeCollectionBody(writer, request, &fields, logger) {
+			return
+		}
+
+		logger.Printf("hash delete key %s fields %d user %s", key, len(fields), username)
+
+		count, err := kvstore.HashDeleteContext(request.Context(), kvStore, key, fields, username)
+		writeCount(writer, count, err, logger)
+	}
+}
+
+// collectionRequest returns the key and the action (one of the suffixes) of a request to read (GET) or change
+// (POST) a collection, or responds with an error and returns false if the request isn't valid.
+func collectionRequest(writer http.ResponseWriter, request *http.Request, store kvstore.Store, actions []string,
+	logger *log.Logger) (*kvstore.KVStore, string, string, bool) {
+	key := getKey(request.URL.Path)
+	action := ""
+
+	switch request.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		for _, suffix := range actions {
+			if strings.HasSuffix(key, suffix) {
+				key = strings.TrimSuffix(key, suffix)
+				action = suffix
+
+				break
+			}
+		}
+
+		if action == "" {
+			http.NotFound(writer, request)
+
+			return nil, "", "", false
+		}
+	default:
+		http.NotFound(writer, request)
+
+		return nil, "", "", false
+	}
+
+	if key == "" {
+		logger.Println("No key specified")
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return nil, "", "", false
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+
+	return kvStore, key, action, ok
+}
+
+// getRange returns the start and stop query parameters of a list range, defaulting to the whole list.
+func getRange(request *http.Request) (int, int, error) {
+	query := request.URL.Query()
+	start, stop := 0, -1
+
+	var err error
+
+	if query.Has("start") {
+		if start, err = strconv.Atoi(query.Get("start")); err != nil {
+			return 0, 0, errInvalidRange
+		}
+	}
+
+	if query.Has("stop") {
+		if stop, err = strconv.Atoi(query.Get("stop")); err != nil {
+			return 0, 0, errInvalidRange
+		}
+	}
+
+	return start, stop, nil
+}
+
+// decodeCollectionBody parses the JSON request body into the value, or responds with 400 Bad Request and
+// returns false if it isn't valid.
+func decodeCollectionBody(writer http.ResponseWriter, request *http.Request, value interface{},
+	logger *log.Logger) bool {
+	defer request.Body.Close()
+
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxCollectionBytes))
+	if err := decoder.Decode(value); err != nil {
+		logger.Println("invalid collection values: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return false
+	}
+
+	return true
+}
+
+// writeCollection responds with the collection as JSON, or with the error.
+func writeCollection(writer http.ResponseWriter, value interface{}, found bool, err error, logger *log.Logger) {
+	switch {
+	case errors.Is(err, kvstore.ErrWrongType):
+		logger.Println("unable to read collection: ", err)
+		http.Error(writer, err.Error(), http.StatusConflict)
+	case err != nil:
+		logger.Println("unable to read collection: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	case !found:
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	default:
+		bytes, marshalErr := json.Marshal(value)
+		if marshalErr != nil {
+			logger.Print("Error marshalling collection to JSON: ", marshalErr)
+			http.Error(writer, marshalErr.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(bytes)
+	}
+}
+
+// writeCount responds with the count resulting from changing a collection, or with the error.
+func writeCount(writer http.ResponseWriter, count int, err error, logger *log.Logger) {
+	switch {
+	case err == nil:
+		writer.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(writer, count)
+	case errors.Is(err, kvstore.ErrWrongType):
+		logger.Println("unable to change collection: ", err)
+		http.Error(writer, err.Error(), http.StatusConflict)
+	default:
+		logger.Println("unable to change collection: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	}
+}
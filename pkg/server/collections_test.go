@@ -0,0 +1,152 @@
+package server
+
+import (
+	"net/http/httptest"
+	"store/pkg/kvstore"
+	"strings"
+	"testing"
+)
+
+func TestListsPushAndPop(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/lists/abc/push", strings.NewReader(`["b","c"]`))
+
+	lists(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "^2$")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/lists/abc/push?end=front", strings.NewReader(`["a"]`))
+
+	lists(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "^3$")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/lists/abc?start=1", nil)
+
+	lists(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^\["b","c"\]$`)
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/lists/abc/pop", nil)
+
+	lists(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "^c$")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/lists/abc/pop", nil)
+
+	lists(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	kvstore.Close(store)
+}
+
+func TestListsInvalid(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/lists/abc/push", strings.NewReader(`"a"`))
+
+	lists(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "Bad Request")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/lists/abc/push", strings.NewReader(`["a"]`))
+
+	lists(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 409, "wrong type")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/lists/xyz", nil)
+
+	lists(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 404, "Not Found")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/lists/abc/shift", nil)
+
+	lists(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 404, "not found")
+
+	kvstore.Close(store)
+}
+
+func TestSets(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/sets/abc/add", strings.NewReader(`["b","a","b"]`))
+
+	sets(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "^2$")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/sets/abc/remove", strings.NewReader(`["b","c"]`))
+
+	sets(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "^1$")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/sets/abc", nil)
+
+	sets(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^\["a"\]$`)
+
+	kvstore.Close(store)
+}
+
+func TestHashes(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/hashes/abc/set", strings.NewReader(`{"a":"1","b":"2"}`))
+
+	hashes(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "^2$")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/hashes/abc?field=b", nil)
+
+	hashes(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "^2$")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/hashes/abc/delete", strings.NewReader(`["b"]`))
+
+	hashes(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "^1$")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/hashes/abc", nil)
+
+	hashes(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^{"a":"1"}$`)
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/hashes/abc?field=b", nil)
+
+	hashes(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 404, "Not Found")
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"store/pkg/kvstore"
+	"strconv"
+)
+
+// incrSuffix follows the key in the path to increment the key's value, e.g. POST /store/abc/incr?delta=5.
+const incrSuffix = "/incr"
+
+var (
+	errInvalidDelta = errors.New("delta must be a number")
+	errInvalidBound = errors.New("min and max must be numbers")
+)
+
+// increment adds the delta query parameter (one if not given) to the key's value, and responds with the new
+// value. The delta is treated as an integer if it is one, otherwise as a floating point number. The optional
+// min and max query parameters bound the new value.
+func increment(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, key string, logger *log.Logger) {
+	if key == "" {
+		logger.Println("No key specified")
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	options, err := getIncrementOptions(request)
+	if err != nil {
+		logger.Println("invalid increment: ", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	query := request.URL.Query()
+
+	delta := query.Get("delta")
+	if delta == "" {
+		delta = "1"
+	}
+
+	logger.Printf("increment key %s by %s user %s", key, delta, username)
+
+	var value string
+
+	if intDelta, parseErr := strconv.ParseInt(delta, 10, 64); parseErr == nil {
+		var newValue int64
+
+		newValue, err = kvstore.IncrementWithOptionsContext(request.Context(), kvStore, key, intDelta, username,
+			options)
+		value = strconv.FormatInt(newValue, 10)
+	} else {
+		floatDelta, floatErr := strconv.ParseFloat(delta, 64)
+		if floatErr != nil {
+			logger.Println("invalid increment: ", floatErr)
+			http.Error(writer, errInvalidDelta.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		var newValue float64
+
+		newValue, err = kvstore.IncrementFloatWithOptionsContext(request.Context(), kvStore, key, floatDelta,
+			username, options)
+		value = strconv.FormatFloat(newValue, 'f', -1, 64)
+	}
+
+	switch {
+	case err == nil:
+		writer.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(writer, value)
+	case errors.Is(err, kvstore.ErrNotNumber), errors.Is(err, kvstore.ErrOutOfBounds):
+		logger.Println("unable to increment key: ", err)
+		http.Error(writer, err.Error(), http.StatusConflict)
+	default:
+		logger.Println("unable to increment key: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	}
+}
+
+// getIncrementOptions returns the bounds, time-to-live and precondition given in the request.
+func getIncrementOptions(request *http.Request) (kvstore.IncrementOptions, error) {
+	options := kvstore.IncrementOptions{}
+	query := request.URL.Query()
+
+	for name, bound := range map[string]**float64{"min": &options.Min, "max": &options.Max} {
+		if !query.Has(name) {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(query.Get(name), 64)
+		if err != nil {
+			return options, errInvalidBound
+		}
+
+		*bound = &value
+	}
+
+	var err error
+
+	if options.TTL, err = getTTL(request); err != nil {
+		return options, err
+	}
+
+	options.Condition, err = getCondition(request)
+
+	return options, err
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http/httptest"
+	"store/pkg/kvstore"
+	"testing"
+)
+
+func TestIncrementValid(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/store/abc/incr", nil)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "^1$")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/store/abc/incr?delta=-5", nil)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "^-4$")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/store/abc/incr?delta=0.5", nil)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^-3\.5$`)
+
+	kvstore.Close(store)
+}
+
+func TestIncrementBounds(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/store/abc/incr?delta=10&max=5", nil)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 409, "above maximum")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/store/abc/incr?min=zero", nil)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "min and max must be numbers")
+
+	kvstore.Close(store)
+}
+
+func TestIncrementInvalid(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("xyz"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/store/abc/incr?delta=one", nil)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "delta must be a number")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/store/abc/incr", nil)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 409, "value is not a number")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/store/abc/incr", nil)
+
+	storeKey(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"store/pkg/kvstore"
+)
+
+// the content types of the patch formats accepted by PATCH /store/{key}
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// getPath responds with the part of the key's JSON document given by the path query parameter, e.g. $.a.b[0].
+func getPath(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, key string, logger *log.Logger) {
+	if key == "" {
+		logger.Println("No key specified")
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	path := request.URL.Query().Get("path")
+
+	logger.Printf("get key %s path %s", key, path)
+
+	value, found, err := kvstore.ReadPathContext(request.Context(), kvStore, key, path, username)
+
+	switch {
+	case errors.Is(err, kvstore.ErrInvalidPath):
+		logger.Println("invalid path: ", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, kvstore.ErrNotDocument):
+		logger.Println("unable to read path: ", err)
+		http.Error(writer, err.Error(), http.StatusConflict)
+	case err != nil:
+		logger.Println("unable to read path: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	case !found:
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	default:
+		writer.Header().Set("Content-Type", kvstore.ContentTypeJSON)
+		writer.Write(value)
+	}
+}
+
+// patch applies a JSON merge patch or JSON patch to the key's JSON document, depending on the content type of
+// the request, and responds with the patched document.
+func patch(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, key string, logger *log.Logger) {
+	if key == "" {
+		logger.Println("No key specified")
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	var patchType kvstore.PatchType
+
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case mergePatchContentType:
+		patchType = kvstore.MergePatch
+	case jsonPatchContentType:
+		patchType = kvstore.JSONPatch
+	default:
+		logger.Printf("unsupported patch content type %s", mediaType)
+		writer.Header().Set("Accept-Patch", mergePatchContentType+", "+jsonPatchContentType)
+		http.Error(writer, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+
+		return
+	}
+
+	condition, err := getCondition(request)
+	if err != nil {
+		logger.Println("invalid precondition: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	defer request.Body.Close()
+
+	body := io.Reader(request.Body)
+
+	// don't read any more of a patch than could be stored
+	maxValueSize := maxValueSize(store)
+	if maxValueSize > 0 {
+		body = io.LimitReader(body, maxValueSize+1)
+	}
+
+	patchBytes, err := io.ReadAll(body)
+	if err != nil {
+		logger.Println("unable to read HTTP body: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	if maxValueSize > 0 && int64(len(patchBytes)) > maxValueSize {
+		logger.Printf("patch for key %s is over the limit of %d bytes", key, maxValueSize)
+		http.Error(writer, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	logger.Printf("patch key %s type %s user %s", key, mediaType, username)
+
+	item, err := kvstore.PatchWithOptionsContext(request.Context(), kvStore, key, patchBytes, patchType, username,
+		kvstore.PatchOptions{Condition: condition})
+
+	switch {
+	case errors.Is(err, kvstore.ErrInvalidPatch):
+		logger.Println("invalid patch: ", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, kvstore.ErrNotDocument), errors.Is(err, kvstore.ErrPatchFailed):
+		logger.Println("unable to patch key: ", err)
+		http.Error(writer, err.Error(), http.StatusConflict)
+	case err != nil:
+		logger.Println("unable to patch key: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	default:
+		writer.Header().Set("ETag", formatETag(item.Version))
+		writer.Header().Set("Content-Type", item.ContentType)
+		writer.Write(item.Value)
+	}
+}
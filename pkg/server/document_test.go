@@ -0,0 +1,134 @@
+package server
+
+import (
+	"net/http/httptest"
+	"store/pkg/kvstore"
+	"strings"
+	"testing"
+)
+
+func newDocumentStore(t *testing.T) *kvstore.KVStore {
+	t.Helper()
+
+	store := kvstore.NewKVStore(1)
+
+	options := kvstore.WriteOptions{ContentType: kvstore.ContentTypeJSON}
+	if err := kvstore.WriteWithOptions(store, "abc", []byte(`{"a":{"b":[1,2]}}`), "user_a", options); err != nil {
+		t.Fatal("Error writing document: ", err)
+	}
+
+	return store
+}
+
+func TestGetPath(t *testing.T) {
+	store := newDocumentStore(t)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/store/abc?path=$.a.b[1]", nil)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "^2$")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/store/abc?path=$.c", nil)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 404, "Not Found")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/store/abc?path=a", nil)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "invalid JSON path")
+
+	kvstore.Close(store)
+}
+
+func TestPutInvalidDocument(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/abc", strings.NewReader(`{"a":`))
+	request.Header.Set("Content-Type", "application/json")
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "Bad Request")
+
+	kvstore.Close(store)
+}
+
+func TestPatchMerge(t *testing.T) {
+	store := newDocumentStore(t)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PATCH", "/store/abc", strings.NewReader(`{"a":{"c":true}}`))
+	request.Header.Set("Content-Type", mergePatchContentType)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^{"a":{"b":\[1,2\],"c":true}}$`)
+
+	if etag := recorder.Header().Get("ETag"); etag != `"2"` {
+		t.Fatal("Patched document should have had new version but ETag was: ", etag)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestPatchJSON(t *testing.T) {
+	store := newDocumentStore(t)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PATCH", "/store/abc",
+		strings.NewReader(`[{"op":"add","path":"/a/b/0","value":0},{"op":"remove","path":"/a/b/2"}]`))
+	request.Header.Set("Content-Type", jsonPatchContentType)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^{"a":{"b":\[0,1\]}}$`)
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("PATCH", "/store/abc",
+		strings.NewReader(`[{"op":"test","path":"/a/b/0","value":5}]`))
+	request.Header.Set("Content-Type", jsonPatchContentType)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 409, "patch failed")
+
+	kvstore.Close(store)
+}
+
+func TestPatchInvalid(t *testing.T) {
+	store := newDocumentStore(t)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PATCH", "/store/abc", strings.NewReader(`{}`))
+	request.Header.Set("Content-Type", "text/plain")
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 415, "Unsupported Media Type")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("PATCH", "/store/abc", strings.NewReader(`[{"op":"add"`))
+	request.Header.Set("Content-Type", jsonPatchContentType)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "invalid patch")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("PATCH", "/store/abc", strings.NewReader(`{"a":1}`))
+	request.Header.Set("Content-Type", mergePatchContentType)
+
+	storeKey(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	kvstore.Close(store)
+}
@@ -1,37 +1,86 @@
 package server
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"regexp"
 	"store/pkg/kvstore"
+	"store/pkg/raft"
+	"strconv"
 	"strings"
+	"time"
 )
 
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// ttlHeader is the request header that can be used to give a key a time-to-live,
+// as an alternative to the ttl query parameter.
+const ttlHeader = "X-TTL"
+
+var (
+	errInvalidTTL       = errors.New("time-to-live must be positive")
+	errInvalidETag      = errors.New("invalid entity tag")
+	errConflictingMatch = errors.New("cannot use both If-Match and If-None-Match")
+	errInvalidLimit     = errors.New("limit must be between 1 and 1000")
+)
+
+// listPage is a page of keys and values returned when listing with paging parameters. Cursor is
+// only set if there are further keys, and can be passed in the next request to get the next page.
+type listPage struct {
+	Entries []*kvstore.ScanEntry `json:"entries"`
+	Cursor  string               `json:"cursor,omitempty"`
+}
+
 func ping(writer http.ResponseWriter, request *http.Request, username string,
-	kvstore *kvstore.KVStore, logger *log.Logger) {
+	kvstore kvstore.Store, logger *log.Logger) {
 	fmt.Fprintf(writer, "pong")
 }
 
 func storeKey(writer http.ResponseWriter, request *http.Request, username string,
-	kvstore *kvstore.KVStore, logger *log.Logger) {
+	kvstore kvstore.Store, logger *log.Logger) {
 	switch request.Method {
 	case http.MethodPut:
 		put(writer, request, username, kvstore, getKey(request.URL.Path), logger)
 	case http.MethodGet:
-		get(writer, request, username, kvstore, getKey(request.URL.Path), logger)
+		switch query := request.URL.Query(); {
+		case query.Has("path"):
+			getPath(writer, request, username, kvstore, getKey(request.URL.Path), logger)
+		case query.Has("revision"):
+			getRevision(writer, request, username, kvstore, getKey(request.URL.Path), logger)
+		default:
+			get(writer, request, username, kvstore, getKey(request.URL.Path), logger)
+		}
+	case http.MethodPatch:
+		patch(writer, request, username, kvstore, getKey(request.URL.Path), logger)
 	case http.MethodDelete:
 		deleteKey(writer, request, username, kvstore, getKey(request.URL.Path), logger)
+	case http.MethodPost:
+		key := getKey(request.URL.Path)
+
+		switch {
+		case strings.HasSuffix(key, ownerSuffix):
+			transferOwner(writer, request, username, kvstore, strings.TrimSuffix(key, ownerSuffix), logger)
+		case strings.HasSuffix(key, incrSuffix):
+			increment(writer, request, username, kvstore, strings.TrimSuffix(key, incrSuffix), logger)
+		default:
+			http.NotFound(writer, request)
+		}
 	default:
 		http.NotFound(writer, request)
 	}
 }
 
 func put(writer http.ResponseWriter, request *http.Request, username string,
-	store *kvstore.KVStore, key string, logger *log.Logger) {
+	store kvstore.Store, key string, logger *log.Logger) {
 	if key == "" {
 		logger.Println("No key specified")
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -46,9 +95,46 @@ func put(writer http.ResponseWriter, request *http.Request, username string,
 		return
 	}
 
+	ttl, err := getTTL(request)
+	if err != nil {
+		logger.Println("invalid time-to-live: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	condition, err := getCondition(request)
+	if err != nil {
+		logger.Println("invalid precondition: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	leaseID, err := getLease(request)
+	if err != nil {
+		logger.Println("invalid lease: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	override, ok := getOverride(writer, request, username, logger)
+	if !ok {
+		return
+	}
+
 	defer request.Body.Close()
 
-	bytes, err := io.ReadAll(request.Body)
+	body := io.Reader(request.Body)
+
+	// don't read any more of a value than could be stored
+	maxValueSize := maxValueSize(store)
+	if maxValueSize > 0 {
+		body = io.LimitReader(body, maxValueSize+1)
+	}
+
+	value, err := io.ReadAll(body)
 	if err != nil {
 		logger.Println("unable to read HTTP body: ", err)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -56,20 +142,47 @@ func put(writer http.ResponseWriter, request *http.Request, username string,
 		return
 	}
 
-	value := string(bytes)
+	if maxValueSize > 0 && int64(len(value)) > maxValueSize {
+		logger.Printf("value for key %s is over the limit of %d bytes", key, maxValueSize)
+		http.Error(writer, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	// the content type and encoding are kept, to be returned when the key is read
+	options := kvstore.WriteOptions{
+		TTL:             ttl,
+		Condition:       condition,
+		ContentType:     request.Header.Get("Content-Type"),
+		ContentEncoding: request.Header.Get("Content-Encoding"),
+		Override:        override,
+		Lease:           leaseID,
+	}
+
+	logger.Printf("put key %s size %d content type %s owner %s ttl %s lease %d",
+		key, len(value), options.ContentType, username, ttl, leaseID)
 
-	logger.Printf("put key %s value %s owner %s", key, value, username)
+	if override {
+		audit(logger, "user %s overriding access control to put key %s", username, key)
+	}
 
-	err = kvstore.Write(store, key, value, username)
-	if err == nil {
+	err = store.Write(request.Context(), key, value, username, options)
+
+	switch {
+	case err == nil:
 		fmt.Fprint(writer, "OK")
-	} else {
-		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case errors.Is(err, kvstore.ErrLeaseNotFound):
+		logger.Println("unable to write key: ", err)
+		http.Error(writer, err.Error(), http.StatusConflict)
+	default:
+		logger.Println("unable to write key: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
 	}
 }
 
 func get(writer http.ResponseWriter, request *http.Request, username string,
-	store *kvstore.KVStore, key string, logger *log.Logger) {
+	store kvstore.Store, key string, logger *log.Logger) {
 	if key == "" {
 		logger.Println("No key specified")
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -79,16 +192,37 @@ func get(writer http.ResponseWriter, request *http.Request, username string,
 
 	logger.Printf("get key %s", key)
 
-	value, ok := kvstore.Read(store, key)
-	if ok {
-		fmt.Fprint(writer, value)
-	} else {
+	item, ok, err := store.Read(request.Context(), key, username)
+
+	switch {
+	case err != nil:
+		logger.Println("unable to read key: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	case !ok:
 		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	default:
+		writeItem(writer, item)
+	}
+}
+
+// writeItem responds with the key's value, along with its version and the type of content it holds.
+func writeItem(writer http.ResponseWriter, item *kvstore.Item) {
+	writer.Header().Set("ETag", formatETag(item.Version))
+
+	if item.ContentType != "" {
+		writer.Header().Set("Content-Type", item.ContentType)
+	}
+
+	if item.ContentEncoding != "" {
+		writer.Header().Set("Content-Encoding", item.ContentEncoding)
 	}
+
+	writer.Write(item.Value)
 }
 
 func deleteKey(writer http.ResponseWriter, request *http.Request, username string,
-	store *kvstore.KVStore, key string, logger *log.Logger) {
+	store kvstore.Store, key string, logger *log.Logger) {
 	if key == "" {
 		logger.Println("No key specified")
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -103,21 +237,43 @@ func deleteKey(writer http.ResponseWriter, request *http.Request, username strin
 		return
 	}
 
+	condition, err := getCondition(request)
+	if err != nil {
+		logger.Println("invalid precondition: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	override, ok := getOverride(writer, request, username, logger)
+	if !ok {
+		return
+	}
+
 	logger.Printf("delete key %s owner %s", key, username)
-	ok, err := kvstore.Delete(store, key, username)
+
+	if override {
+		audit(logger, "user %s overriding access control to delete key %s", username, key)
+	}
+
+	options := kvstore.DeleteOptions{Condition: condition, Override: override}
+
+	ok, err = store.Delete(request.Context(), key, username, options)
 
 	switch {
 	case ok:
 		fmt.Fprint(writer, "OK")
 	case err != nil:
-		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		logger.Println("unable to delete key: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
 	default:
 		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 	}
 }
 
 func listKey(writer http.ResponseWriter, request *http.Request, username string,
-	store *kvstore.KVStore, logger *log.Logger) {
+	store kvstore.Store, logger *log.Logger) {
 	key := getKey(request.URL.Path)
 	if key == "" {
 		logger.Println("No key specified")
@@ -128,7 +284,15 @@ func listKey(writer http.ResponseWriter, request *http.Request, username string,
 
 	logger.Printf("list key %s", key)
 
-	entry := kvstore.List(store, key)
+	entry, err := store.List(request.Context(), key, username)
+	if err != nil {
+		logger.Println("unable to list key: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+
+		return
+	}
+
 	if entry == nil {
 		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 
@@ -148,10 +312,24 @@ func listKey(writer http.ResponseWriter, request *http.Request, username string,
 }
 
 func listAll(writer http.ResponseWriter, request *http.Request, username string,
-	store *kvstore.KVStore, logger *log.Logger) {
+	store kvstore.Store, logger *log.Logger) {
+	query := request.URL.Query()
+	if query.Has("prefix") || query.Has("start") || query.Has("limit") || query.Has("cursor") {
+		listRange(writer, request, username, store, logger)
+
+		return
+	}
+
 	logger.Print("list all keys")
 
-	entries := kvstore.ListAll(store)
+	entries, err := store.ListAll(request.Context(), username)
+	if err != nil {
+		logger.Println("unable to list keys: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+
+		return
+	}
 
 	bytes, err := json.Marshal(entries)
 	if err != nil {
@@ -165,10 +343,225 @@ func listAll(writer http.ResponseWriter, request *http.Request, username string,
 	writer.Write(bytes)
 }
 
-var storeKeyRegex = regexp.MustCompile(`^\/[^\/].*\/(.*)$`)
+// getTTL returns the time-to-live specified by the request header or query parameter, or zero if none
+// was specified. It can be given either as a whole number of seconds or as a duration such as "1m30s".
+func getTTL(request *http.Request) (time.Duration, error) {
+	value := request.Header.Get(ttlHeader)
+	if value == "" {
+		value = request.URL.Query().Get("ttl")
+	}
 
-// getKey extracts the key from the end of the REST path,
-// or returns an empty string if not defined.
+	if value == "" {
+		return 0, nil
+	}
+
+	var ttl time.Duration
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		ttl = time.Duration(seconds) * time.Second
+	} else {
+		ttl, err = time.ParseDuration(value)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if ttl <= 0 {
+		return 0, errInvalidTTL
+	}
+
+	return ttl, nil
+}
+
+// getCondition returns the condition on the key's current version specified by the request's
+// If-Match or If-None-Match header, or nil if neither was specified. Each header can be either
+// "*" or a single entity tag, as returned in the ETag header when getting the key.
+func getCondition(request *http.Request) (*kvstore.Condition, error) {
+	ifMatch := request.Header.Get("If-Match")
+	ifNoneMatch := request.Header.Get("If-None-Match")
+
+	switch {
+	case ifMatch != "" && ifNoneMatch != "":
+		return nil, errConflictingMatch
+	case ifMatch == "*":
+		return &kvstore.Condition{Type: kvstore.IfExists}, nil
+	case ifMatch != "":
+		version, err := parseETag(ifMatch)
+		if err != nil {
+			return nil, err
+		}
+
+		return &kvstore.Condition{Type: kvstore.IfVersion, Version: version}, nil
+	case ifNoneMatch == "*":
+		return &kvstore.Condition{Type: kvstore.IfNotExists}, nil
+	case ifNoneMatch != "":
+		version, err := parseETag(ifNoneMatch)
+		if err != nil {
+			return nil, err
+		}
+
+		return &kvstore.Condition{Type: kvstore.IfNotVersion, Version: version}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// formatETag returns the entity tag for a key version.
+func formatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// parseETag returns the key version from an entity tag.
+func parseETag(etag string) (uint64, error) {
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return 0, fmt.Errorf("%w: %s", errInvalidETag, etag)
+	}
+
+	version, err := strconv.ParseUint(etag[1:len(etag)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", errInvalidETag, etag)
+	}
+
+	return version, nil
+}
+
+// storeErrorStatus maps an error returned by the store onto the HTTP status code to respond with. Errors it
+// doesn't recognise are the server's fault, so are reported as internal server errors.
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, kvstore.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, kvstore.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, kvstore.ErrNotSupported), errors.Is(err, kvstore.ErrSnapshotsDisabled):
+		return http.StatusNotImplemented
+	case errors.Is(err, kvstore.ErrValueTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, kvstore.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, kvstore.ErrNotDocument), errors.Is(err, kvstore.ErrInvalidACL),
+		errors.Is(err, kvstore.ErrInvalidPath), errors.Is(err, kvstore.ErrInvalidPatch),
+		errors.Is(err, kvstore.ErrInvalidLeaseTTL), errors.Is(err, kvstore.ErrInvalidNamespace),
+		errors.Is(err, kvstore.ErrInvalidTxn), errors.Is(err, kvstore.ErrNoOwner):
+		return http.StatusBadRequest
+	case errors.Is(err, kvstore.ErrWrongType), errors.Is(err, kvstore.ErrNotNumber),
+		errors.Is(err, kvstore.ErrOutOfBounds), errors.Is(err, kvstore.ErrPatchFailed),
+		errors.Is(err, kvstore.ErrLeaseNotFound), errors.Is(err, kvstore.ErrLeaseHeld),
+		errors.Is(err, kvstore.ErrNamespaceExists), errors.Is(err, raft.ErrMembershipChange):
+		return http.StatusConflict
+	case errors.Is(err, kvstore.ErrCompacted):
+		return http.StatusGone
+	case errors.Is(err, kvstore.ErrReadOnly), errors.Is(err, raft.ErrNotLeader):
+		// the write should have been made to the leader
+		return http.StatusMisdirectedRequest
+	case errors.Is(err, kvstore.ErrClosed), errors.Is(err, raft.ErrStopped), errors.Is(err, raft.ErrUnreachable),
+		errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// the store is shutting down, the cluster can't be reached, or the client has gone away
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// listRange lists the keys and values in lexical order of key, a page at a time, optionally only those
+// starting with a prefix. The first page starts from the start key if specified, otherwise the beginning.
+func listRange(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	query := request.URL.Query()
+	prefix := query.Get("prefix")
+	start := query.Get("start")
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			logger.Println("invalid cursor: ", err)
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		start = string(decoded)
+	}
+
+	if start < prefix {
+		start = prefix
+	}
+
+	limit := defaultPageSize
+
+	if value := query.Get("limit"); value != "" {
+		var err error
+
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			logger.Println("invalid limit: ", value)
+			http.Error(writer, errInvalidLimit.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	logger.Printf("list keys prefix %s start %s limit %d", prefix, start, limit)
+
+	// fetch one more than needed, to find out where the next page starts
+	entries, err := kvstore.ScanContext(request.Context(), kvStore, start, kvstore.PrefixEnd(prefix), limit+1,
+		username)
+	if err != nil {
+		logger.Println("unable to list keys: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+
+		return
+	}
+
+	page := &listPage{Entries: entries}
+	if len(page.Entries) > limit {
+		page.Cursor = base64.RawURLEncoding.EncodeToString([]byte(page.Entries[limit].Key))
+		page.Entries = page.Entries[:limit]
+	}
+
+	bytes, err := json.Marshal(page)
+	if err != nil {
+		logger.Print("Error marshalling entries to JSON: ", err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(bytes)
+}
+
+// fullStore returns the store as a *kvstore.KVStore, for the features that only it provides. Otherwise
+// it responds with 501 Not Implemented, and returns false.
+func fullStore(writer http.ResponseWriter, store kvstore.Store, logger *log.Logger) (*kvstore.KVStore, bool) {
+	kvStore, ok := store.(*kvstore.KVStore)
+	if !ok {
+		logger.Println("Feature not supported by the store in use")
+		http.Error(writer, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+
+	return kvStore, ok
+}
+
+// maxValueSize returns the longest value the store accepts, or zero if there's no limit.
+func maxValueSize(store kvstore.Store) int64 {
+	if kvStore, ok := store.(*kvstore.KVStore); ok {
+		return kvstore.StoreQuota(kvStore).MaxValueSize
+	}
+
+	return 0
+}
+
+var storeKeyRegex = regexp.MustCompile(`^\/[^\/]+\/([^\/]+(?:\/[^\/]+)*)$`)
+
+// getKey extracts the key from the REST path, being everything after the first path section
+// (so keys can themselves contain slashes, though not empty sections), or returns an empty string if not defined.
 func getKey(path string) string {
 	matches := storeKeyRegex.FindStringSubmatch(path)
 	key := ""
@@ -180,15 +573,23 @@ func getKey(path string) string {
 	return key
 }
 
-// getKeyAlt extracts the key from the end of the REST path,
+// getPrefix extracts a key prefix from the REST path, being everything after the first path section, which
+// unlike a key may end in a slash (e.g. /watch/orders/ for every key starting orders/).
+func getPrefix(path string) string {
+	_, prefix, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+
+	return prefix
+}
+
+// getKeyAlt extracts the key from the REST path,
 // or returns an empty string if not defined.
 //
 // This version doesn't use a regex, and is here merely as a
 // contrived example for comparative benchmarking.
 func getKeyAlt(path string) string {
-	pathSections := strings.Split(path, "/")
-	if num := len(pathSections); num > 2 {
-		return pathSections[num-1]
+	pathSections := strings.SplitN(path, "/", 3)
+	if len(pathSections) == 3 && !strings.Contains("/"+pathSections[2]+"/", "//") {
+		return pathSections[2]
 	}
 
 	return ""
@@ -1,10 +1,14 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http/httptest"
 	"regexp"
 	"store/pkg/kvstore"
+	"store/pkg/raft"
 	"strings"
 	"testing"
 )
@@ -21,7 +25,7 @@ func TestPing(t *testing.T) {
 func TestGetNoKeySpecified(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("GET", "/store", nil) // no key specified
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(1)
 
 	storeKey(recorder, request, "", store, testLogger)
 
@@ -33,7 +37,7 @@ func TestGetNoKeySpecified(t *testing.T) {
 func TestGetNoSuchKey(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("GET", "/store/abc", nil)
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(1)
 
 	storeKey(recorder, request, "", store, testLogger)
 
@@ -45,8 +49,8 @@ func TestGetNoSuchKey(t *testing.T) {
 func TestGetPopulatedKey(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("GET", "/store/abc", nil)
-	store := kvstore.NewKVStore()
-	if err := kvstore.Write(store, "abc", "123", "my_user"); err != nil {
+	store := kvstore.NewKVStore(1)
+	if err := kvstore.Write(store, "abc", []byte("123"), "my_user"); err != nil {
 		t.Fatal("Error setting key: ", err)
 	}
 
@@ -57,10 +61,39 @@ func TestGetPopulatedKey(t *testing.T) {
 	kvstore.Close(store)
 }
 
+func TestGetClosedStore(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/store/abc", nil)
+	store := kvstore.NewKVStore(1)
+	kvstore.Close(store)
+
+	storeKey(recorder, request, "", store, testLogger)
+
+	checkResponse(t, recorder, 503, "Service Unavailable")
+}
+
+func TestGetReturnsETag(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/store/abc", nil)
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+	kvstore.Write(store, "abc", []byte("456"), "user_a")
+
+	storeKey(recorder, request, "", store, testLogger)
+
+	checkResponse(t, recorder, 200, "456")
+
+	if etag := recorder.Result().Header.Get("ETag"); etag != `"2"` {
+		t.Fatal("Wrong ETag: ", etag)
+	}
+
+	kvstore.Close(store)
+}
+
 func TestPutNoKeySpecified(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("PUT", "/store", nil) // no key specified
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(1)
 
 	storeKey(recorder, request, "", store, testLogger)
 
@@ -72,7 +105,7 @@ func TestPutNoKeySpecified(t *testing.T) {
 func TestPutNoOwnerSpecified(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("PUT", "/store/abc", nil)
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(1)
 
 	storeKey(recorder, request, "", store, testLogger) // no owner
 
@@ -84,28 +117,189 @@ func TestPutNoOwnerSpecified(t *testing.T) {
 func TestPutValid(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("PUT", "/store/abc", strings.NewReader("123"))
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(1)
 
 	storeKey(recorder, request, "user_a", store, testLogger)
 
 	checkResponse(t, recorder, 200, "OK")
 
-	value, present := kvstore.Read(store, "abc")
+	value, present := kvstore.Read(store, "abc", "user_a")
 	if !present {
 		t.Fatal("Key PUT didn't write to store")
 	}
-	if value != "123" {
+	if string(value) != "123" {
 		t.Fatal("Invalid key value: ", value)
 	}
 
 	kvstore.Close(store)
 }
 
+func TestPutWithTTL(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/abc?ttl=1m", strings.NewReader("123"))
+	store := kvstore.NewKVStore(1)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	entry := kvstore.List(store, "abc", "user_a")
+	if entry == nil || entry.TTL <= 0 {
+		t.Fatal("Key PUT didn't set TTL: ", entry)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestPutWithTTLHeader(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/abc", strings.NewReader("123"))
+	request.Header.Set("X-TTL", "30")
+	store := kvstore.NewKVStore(1)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	entry := kvstore.List(store, "abc", "user_a")
+	if entry == nil || entry.TTL <= 0 {
+		t.Fatal("Key PUT didn't set TTL: ", entry)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestPutMutexStore(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/abc", strings.NewReader("123"))
+	store := kvstore.NewMutexStore()
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	if item, present, _ := store.Read(context.Background(), "abc", "user_a"); !present || string(item.Value) != "123" {
+		t.Fatal("Key PUT didn't write to store: ", item)
+	}
+}
+
+func TestPutContentType(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/abc", strings.NewReader("\x89PNG\x00\xff"))
+	request.Header.Set("Content-Type", "image/png")
+	request.Header.Set("Content-Encoding", "gzip")
+	store := kvstore.NewKVStore(1)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/store/abc", nil)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	if recorder.Body.String() != "\x89PNG\x00\xff" {
+		t.Fatalf("Value should have been returned unchanged but was: %q", recorder.Body.String())
+	}
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "image/png" {
+		t.Fatal("Content type should have been returned but was: ", contentType)
+	}
+
+	if contentEncoding := recorder.Header().Get("Content-Encoding"); contentEncoding != "gzip" {
+		t.Fatal("Content encoding should have been returned but was: ", contentEncoding)
+	}
+
+	entry := kvstore.List(store, "abc", "user_a")
+	if entry == nil || entry.Size != 6 || entry.ContentType != "image/png" {
+		t.Fatal("Key details should have included the size and content type: ", entry)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestPutWithTTLNotSupported(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/abc?ttl=1m", strings.NewReader("123"))
+	store := kvstore.NewMutexStore()
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 501, "Not Implemented")
+
+	if _, present, _ := store.Read(context.Background(), "abc", "user_a"); present {
+		t.Fatal("Key PUT with unsupported TTL wrote to store")
+	}
+}
+
+func TestPutInvalidTTL(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/abc?ttl=-5", strings.NewReader("123"))
+	store := kvstore.NewKVStore(1)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "Bad Request")
+
+	kvstore.Close(store)
+}
+
+func TestPutIfMatch(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/abc", strings.NewReader("456"))
+	request.Header.Set("If-Match", `"2"`) // stale version
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 412, "Precondition Failed\n")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("PUT", "/store/abc", strings.NewReader("456"))
+	request.Header.Set("If-Match", `"1"`) // current version
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	kvstore.Close(store)
+}
+
+func TestPutIfNoneMatch(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/abc", strings.NewReader("456"))
+	request.Header.Set("If-None-Match", "*")
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a") // key already exists
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 412, "Precondition Failed\n")
+
+	kvstore.Close(store)
+}
+
+func TestPutInvalidETag(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/abc", strings.NewReader("456"))
+	request.Header.Set("If-Match", "1") // not quoted
+	store := kvstore.NewKVStore(1)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "Bad Request")
+
+	kvstore.Close(store)
+}
+
 func TestPutWrongOwner(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("PUT", "/store/abc", strings.NewReader("123"))
-	store := kvstore.NewKVStore()
-	kvstore.Write(store, "abc", "123", "user_b") // key already exists, owned by user_b
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_b") // key already exists, owned by user_b
 
 	storeKey(recorder, request, "user_a", store, testLogger) // attempt to write by user_a
 
@@ -114,10 +308,33 @@ func TestPutWrongOwner(t *testing.T) {
 	kvstore.Close(store)
 }
 
+func TestStoreErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("%w: abc", kvstore.ErrPermissionDenied), 403},
+		{kvstore.ErrVersionMismatch, 412},
+		{kvstore.ErrInvalidPath, 400},
+		{kvstore.ErrNotNumber, 409},
+		{kvstore.ErrCompacted, 410},
+		{raft.ErrNotLeader, 421},
+		{kvstore.ErrClosed, 503},
+		{kvstore.ErrPersistence, 500},
+		{errors.New("unexpected"), 500}, // not a permission problem, so not forbidden
+	}
+
+	for _, test := range tests {
+		if status := storeErrorStatus(test.err); status != test.status {
+			t.Errorf("%v should have been status %d but got %d", test.err, test.status, status)
+		}
+	}
+}
+
 func TestDeleteNoKeySpecified(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("DELETE", "/store", nil) // no key specified
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(1)
 
 	storeKey(recorder, request, "", store, testLogger)
 
@@ -129,7 +346,7 @@ func TestDeleteNoKeySpecified(t *testing.T) {
 func TestDeleteNoOwnerSpecified(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("DELETE", "/store/abc", nil)
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(1)
 
 	storeKey(recorder, request, "", store, testLogger) // no owner
 
@@ -141,7 +358,7 @@ func TestDeleteNoOwnerSpecified(t *testing.T) {
 func TestDeleteNoSuchKey(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("DELETE", "/store/abc", nil)
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(1)
 	// key not in store
 
 	storeKey(recorder, request, "user_a", store, testLogger)
@@ -154,14 +371,14 @@ func TestDeleteNoSuchKey(t *testing.T) {
 func TestDeleteValid(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("DELETE", "/store/abc", nil)
-	store := kvstore.NewKVStore()
-	kvstore.Write(store, "abc", "123", "user_a")
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
 
 	storeKey(recorder, request, "user_a", store, testLogger)
 
 	checkResponse(t, recorder, 200, "OK")
 
-	_, present := kvstore.Read(store, "abc")
+	_, present := kvstore.Read(store, "abc", "user_a")
 	if present {
 		t.Fatal("Key DELETE didn't delete in store")
 	}
@@ -169,11 +386,29 @@ func TestDeleteValid(t *testing.T) {
 	kvstore.Close(store)
 }
 
+func TestDeleteIfMatch(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("DELETE", "/store/abc", nil)
+	request.Header.Set("If-Match", `"5"`)
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 412, "Precondition Failed\n")
+
+	if _, present := kvstore.Read(store, "abc", "user_a"); !present {
+		t.Fatal("Key DELETE with stale version deleted key")
+	}
+
+	kvstore.Close(store)
+}
+
 func TestDeleteWrongUser(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("DELETE", "/store/abc", nil)
-	store := kvstore.NewKVStore()
-	kvstore.Write(store, "abc", "123", "user_a")
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
 
 	storeKey(recorder, request, "user_b", store, testLogger) // different user
 
@@ -185,7 +420,7 @@ func TestDeleteWrongUser(t *testing.T) {
 func TestListNoKeySpecified(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("GET", "/list", nil) // no key specified
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(1)
 
 	listKey(recorder, request, "", store, testLogger)
 
@@ -197,7 +432,7 @@ func TestListNoKeySpecified(t *testing.T) {
 func TestListNoSuchKey(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("GET", "/list/abc", nil)
-	store := kvstore.NewKVStore()
+	store := kvstore.NewKVStore(1)
 	// no key populated
 
 	listKey(recorder, request, "user_a", store, testLogger)
@@ -210,12 +445,12 @@ func TestListNoSuchKey(t *testing.T) {
 func TestListValid(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("GET", "/list/abc", nil)
-	store := kvstore.NewKVStore()
-	kvstore.Write(store, "abc", "123", "user_a")
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
 
 	listKey(recorder, request, "user_a", store, testLogger)
 
-	checkResponse(t, recorder, 200, `{"key":"abc","owner":"user_a","writes":1,"reads":0,"age":0}`)
+	checkResponse(t, recorder, 200, `{"key":"abc","owner":"user_a","version":1,"size":3,"writes":1,"reads":0,"age":0}`)
 
 	kvstore.Close(store)
 }
@@ -223,10 +458,10 @@ func TestListValid(t *testing.T) {
 func TestListAllValid(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("GET", "/list", nil)
-	store := kvstore.NewKVStore()
-	kvstore.Write(store, "abc", "123", "user_a")
-	kvstore.Write(store, "def", "456", "user_b")
-	kvstore.Write(store, "ghi", "789", "user_a")
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+	kvstore.Write(store, "def", []byte("456"), "user_b")
+	kvstore.Write(store, "ghi", []byte("789"), "user_a")
 
 	listAll(recorder, request, "user_a", store, testLogger)
 
@@ -239,6 +474,43 @@ func TestListAllValid(t *testing.T) {
 	kvstore.Close(store)
 }
 
+func TestListPaged(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "orders/3", []byte("789"), "user_a")
+	kvstore.Write(store, "orders/1", []byte("123"), "user_a")
+	kvstore.Write(store, "orders/2", []byte("456"), "user_b")
+	kvstore.Write(store, "users/1", []byte("abc"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/list?prefix=orders/&limit=2", nil)
+
+	listAll(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^{"entries":\[{"key":"orders/1","value":"MTIz","owner":"user_a","version":2},`+
+		`{"key":"orders/2","value":"NDU2","owner":"user_b","version":3}\],"cursor":"b3JkZXJzLzM"}$`)
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/list?prefix=orders/&limit=2&cursor=b3JkZXJzLzM", nil)
+
+	listAll(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^{"entries":\[{"key":"orders/3","value":"Nzg5","owner":"user_a","version":1}\]}$`)
+
+	kvstore.Close(store)
+}
+
+func TestListPagedInvalidLimit(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/list?limit=0", nil)
+	store := kvstore.NewKVStore(1)
+
+	listAll(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "limit")
+
+	kvstore.Close(store)
+}
+
 // checkResponse checks for the expected response code and body. If expectedBodyRegex is empty this is
 // used to mean to check the response body is empty.
 func checkResponse(t *testing.T, recorder *httptest.ResponseRecorder, expectedCode int, expectedBodyRegex string) {
@@ -270,6 +542,10 @@ func TestPathWithKey(t *testing.T) {
 	checkGetKey(t, "/store", "")
 	checkGetKey(t, "/list/123", "123")
 	checkGetKey(t, "/list", "")
+	checkGetKey(t, "/store/orders/2024/123", "orders/2024/123")
+	checkGetKey(t, "/store/orders/", "")
+	checkGetKey(t, "/store//orders", "")
+	checkGetKey(t, "/store/orders//123", "")
 }
 
 func checkGetKey(t *testing.T, path string, expectedKey string) {
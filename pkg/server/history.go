@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"store/pkg/kvstore"
+	"strconv"
+)
+
+// getRevision responds with the value the key had at the revision given by the revision query parameter,
+// or 410 Gone if that version of the key is no longer kept.
+func getRevision(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, key string, logger *log.Logger) {
+	if key == "" {
+		logger.Println("No key specified")
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	revision, err := strconv.ParseUint(request.URL.Query().Get("revision"), 10, 64)
+	if err != nil {
+		logger.Println("invalid revision: ", err)
+		http.Error(writer, errInvalidRevision.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	logger.Printf("get key %s revision %d", key, revision)
+
+	item, found, err := kvstore.ReadAtContext(request.Context(), kvStore, key, revision, username)
+
+	switch {
+	case errors.Is(err, kvstore.ErrCompacted):
+		logger.Println("unable to read key: ", err)
+		http.Error(writer, err.Error(), http.StatusGone)
+	case err != nil:
+		logger.Println("unable to read key: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	case !found:
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	default:
+		writeItem(writer, item)
+	}
+}
+
+// history responds with the versions of the key which are still kept (GET /history/{key}), oldest first. Values
+// are base64 encoded, since they may not be text.
+func history(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	if request.Method != http.MethodGet {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	key := getKey(request.URL.Path)
+	if key == "" {
+		logger.Println("No key specified")
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	logger.Printf("history key %s", key)
+
+	entries, err := kvstore.KeyHistoryContext(request.Context(), kvStore, key, username)
+	if err != nil {
+		logger.Println("unable to read key history: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+
+		return
+	}
+
+	bytes, err := json.Marshal(entries)
+	if err != nil {
+		logger.Print("Error marshalling history to JSON: ", err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(bytes)
+}
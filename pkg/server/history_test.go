@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http/httptest"
+	"store/pkg/kvstore"
+	"testing"
+)
+
+func TestGetRevision(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{History: kvstore.History{MaxVersions: 1}})
+	kvstore.Write(store, "abc", []byte("one"), "user_a")
+	kvstore.WriteWithOptions(store, "abc", []byte("two"), "user_a", kvstore.WriteOptions{ContentType: "text/plain"})
+	kvstore.Write(store, "abc", []byte("three"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/store/abc?revision=2", nil)
+
+	storeKey(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 200, "^two$")
+
+	if etag := recorder.Header().Get("ETag"); etag != `"2"` {
+		t.Fatal("ETag should have been the earlier version but was: ", etag)
+	}
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/plain" {
+		t.Fatal("Content type should have been the earlier version's but was: ", contentType)
+	}
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/store/abc?revision=1", nil)
+
+	storeKey(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 410, "compacted")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/store/xyz?revision=2", nil)
+
+	storeKey(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 404, "Not Found")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/store/abc?revision=x", nil)
+
+	storeKey(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 400, "invalid revision")
+
+	kvstore.Close(store)
+}
+
+func TestHistory(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{History: kvstore.History{MaxVersions: 10}})
+	kvstore.WriteWithOptions(store, "abc", []byte{0xff, 0xfe}, "user_a",
+		kvstore.WriteOptions{ContentType: "application/octet-stream"})
+	kvstore.Delete(store, "abc", "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/history/abc", nil)
+
+	history(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^\[\{"version":1,.*"value":"//4=","content_type":"application/octet-stream".*\},`+
+		`\{"version":2,.*"deleted":true\}\]$`)
+
+	kvstore.Close(store)
+
+	store = kvstore.NewKVStore(1)
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/history/abc", nil)
+
+	history(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 501, "Not Implemented")
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"store/pkg/kvstore"
+	"strconv"
+	"strings"
+)
+
+// renewSuffix follows the lease ID in the path to keep the lease alive, e.g. POST /lease/12/renew.
+const renewSuffix = "/renew"
+
+var (
+	errNoLeaseTTL     = errors.New("lease time-to-live must be given")
+	errInvalidLeaseID = errors.New("invalid lease ID")
+)
+
+// leases lists the leases still held (GET /lease), or grants a new lease (POST /lease?ttl=10s). A lease given a
+// name (POST /lease?ttl=10s&name=jobs) is a lock, which can only be held by one lease at a time. The new lease's
+// ID is also its fencing token.
+func leases(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	switch request.Method {
+	case http.MethodGet:
+		logger.Println("list leases")
+
+		writeLease(writer, kvstore.ListLeases(kvStore), logger)
+
+	case http.MethodPost:
+		grantLease(writer, request, username, kvStore, logger)
+
+	default:
+		http.NotFound(writer, request)
+	}
+}
+
+// lease reads (GET /lease/{id}), releases (DELETE /lease/{id}) or renews (POST /lease/{id}/renew) a lease.
+// Releasing a lease removes any keys attached to it.
+func lease(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	path := getKey(request.URL.Path)
+
+	renew := request.Method == http.MethodPost && strings.HasSuffix(path, renewSuffix)
+	if renew {
+		path = strings.TrimSuffix(path, renewSuffix)
+	}
+
+	id, err := strconv.ParseUint(path, 10, 64)
+	if err != nil || id == 0 {
+		logger.Println("invalid lease: ", path)
+		http.Error(writer, errInvalidLeaseID.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	switch {
+	case request.Method == http.MethodGet:
+		logger.Printf("get lease %d", id)
+
+		held, found := kvstore.ReadLease(kvStore, id)
+		if !found {
+			http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+
+			return
+		}
+
+		writeLease(writer, held, logger)
+
+	case request.Method == http.MethodDelete:
+		logger.Printf("release lease %d user %s", id, username)
+
+		released, releaseErr := kvstore.ReleaseLeaseContext(request.Context(), kvStore, id, username)
+
+		switch {
+		case releaseErr != nil:
+			logger.Println("unable to release lease: ", releaseErr)
+			status := storeErrorStatus(releaseErr)
+			http.Error(writer, http.StatusText(status), status)
+		case !released:
+			http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		default:
+			fmt.Fprint(writer, "OK")
+		}
+
+	case renew:
+		logger.Printf("renew lease %d user %s", id, username)
+
+		renewed, renewErr := kvstore.RenewLease(kvStore, id, username)
+
+		switch {
+		case errors.Is(renewErr, kvstore.ErrLeaseNotFound):
+			http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		case renewErr != nil:
+			logger.Println("unable to renew lease: ", renewErr)
+			status := storeErrorStatus(renewErr)
+			http.Error(writer, http.StatusText(status), status)
+		default:
+			writeLease(writer, renewed, logger)
+		}
+
+	default:
+		http.NotFound(writer, request)
+	}
+}
+
+func grantLease(writer http.ResponseWriter, request *http.Request, username string, kvStore *kvstore.KVStore,
+	logger *log.Logger) {
+	ttl, err := getTTL(request)
+	if err == nil && ttl == 0 {
+		err = errNoLeaseTTL
+	}
+
+	if err != nil {
+		logger.Println("invalid lease time-to-live: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	name := request.URL.Query().Get("name")
+
+	logger.Printf("grant lease %s ttl %s user %s", name, ttl, username)
+
+	granted, err := kvstore.GrantLease(kvStore, name, ttl, username)
+
+	switch {
+	case errors.Is(err, kvstore.ErrLeaseHeld):
+		logger.Println("unable to grant lease: ", err)
+		http.Error(writer, err.Error(), http.StatusConflict)
+	case err != nil:
+		logger.Println("unable to grant lease: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	default:
+		writeLease(writer, granted, logger)
+	}
+}
+
+// getLease returns the lease ID specified by the lease query parameter, or zero if none was specified.
+func getLease(request *http.Request) (uint64, error) {
+	value := request.URL.Query().Get("lease")
+	if value == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || id == 0 {
+		return 0, errInvalidLeaseID
+	}
+
+	return id, nil
+}
+
+// writeLease responds with the details of one or more leases as JSON.
+func writeLease(writer http.ResponseWriter, value interface{}, logger *log.Logger) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		logger.Print("Error marshalling lease to JSON: ", err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(bytes)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net/http/httptest"
+	"store/pkg/kvstore"
+	"strings"
+	"testing"
+)
+
+func TestLeaseLifecycle(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/lease?ttl=60&name=jobs", nil)
+
+	leases(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^\{"id":1,"name":"jobs","owner":"user_a",.*\}$`)
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/lease?ttl=60&name=jobs", nil)
+
+	leases(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 409, "lease already held")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("PUT", "/store/abc?lease=1", strings.NewReader("xyz"))
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/lease/1", nil)
+
+	lease(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 200, `"keys":1`)
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/lease/1/renew", nil)
+
+	lease(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/lease/1/renew", nil)
+
+	lease(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `"id":1`)
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("DELETE", "/lease/1", nil)
+
+	lease(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	// the attached key goes with the lease
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/store/abc", nil)
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 404, "Not Found")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/lease/1/renew", nil)
+
+	lease(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 404, "Not Found")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("PUT", "/store/abc?lease=1", strings.NewReader("xyz"))
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 409, "lease not found")
+
+	kvstore.Close(store)
+}
+
+func TestLeaseInvalid(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/lease", nil)
+
+	leases(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "Bad Request")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/lease/abc", nil)
+
+	lease(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "invalid lease ID")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("PUT", "/store/abc?lease=x", strings.NewReader("xyz"))
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "Bad Request")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/lease", nil)
+
+	leases(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^\[\]$`)
+
+	kvstore.Close(store)
+}
@@ -34,7 +34,7 @@ type claims struct {
 var jwtKey = []byte("ChangeMeThisIsNotSecure")
 
 func login(writer http.ResponseWriter, request *http.Request, unused string,
-	kvstore *kvstore.KVStore, logger *log.Logger) {
+	kvstore kvstore.Store, logger *log.Logger) {
 	username, password, present := request.BasicAuth()
 	if !present {
 		logger.Println("Error parsing basic auth credentials")
@@ -66,11 +66,7 @@ func login(writer http.ResponseWriter, request *http.Request, unused string,
 		return
 	}
 
-	expirationTime := time.Now().Add(tokenExpiryMins * time.Minute)
-	claims := &claims{username, jwt.StandardClaims{ExpiresAt: expirationTime.Unix(), Issuer: "MyRESTService"}}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := newToken(username)
 	if err != nil {
 		logger.Println("Error generating JWT: ", err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -82,11 +78,28 @@ func login(writer http.ResponseWriter, request *http.Request, unused string,
 	fmt.Fprint(writer, "Bearer ", tokenString)
 }
 
-func withAccessLogAndSecurityCheck(store *kvstore.KVStore, accessLog *log.Logger,
+// newToken returns a signed JWT bearer token for the user, which expires after a few minutes.
+func newToken(username string) (string, error) {
+	expirationTime := time.Now().Add(tokenExpiryMins * time.Minute)
+	claims := &claims{username, jwt.StandardClaims{ExpiresAt: expirationTime.Unix(), Issuer: "MyRESTService"}}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString(jwtKey)
+}
+
+func withAccessLogAndSecurityCheck(store kvstore.Store, accessLog *log.Logger,
 	appLog *log.Logger, handlerFunc handler) http.HandlerFunc {
+	checked := withSecurityCheck(store, appLog, handlerFunc)
+
 	return func(writer http.ResponseWriter, request *http.Request) {
 		accessLog.Printf("%s %s %s", request.RemoteAddr, request.Method, request.URL)
+		checked(writer, request)
+	}
+}
 
+// withSecurityCheck only passes requests with a valid bearer token on to the handler, without logging them.
+func withSecurityCheck(store kvstore.Store, appLog *log.Logger, handlerFunc handler) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
 		authHeader := request.Header.Get("Authorization")
 		if authHeader == "" {
 			appLog.Println("no Authorization header present")
@@ -64,7 +64,7 @@ func TestWithAccessLogAndSecurityCheckNoHeader(t *testing.T) {
 	handlerCalled := false
 
 	withAccessLogAndSecurityCheck(nil, testLogger, testLogger,
-		func(w http.ResponseWriter, r *http.Request, username string, kvstore *kvstore.KVStore, logger *log.Logger) {
+		func(w http.ResponseWriter, r *http.Request, username string, kvstore kvstore.Store, logger *log.Logger) {
 			handlerCalled = true
 		})(recorder, request)
 
@@ -80,7 +80,7 @@ func TestWithAccessLogAndSecurityCheckInvalidHeader(t *testing.T) {
 	handlerCalled := false
 
 	withAccessLogAndSecurityCheck(nil, testLogger, testLogger,
-		func(w http.ResponseWriter, r *http.Request, username string, kvstore *kvstore.KVStore, logger *log.Logger) {
+		func(w http.ResponseWriter, r *http.Request, username string, kvstore kvstore.Store, logger *log.Logger) {
 			handlerCalled = true
 		})(recorder, request)
 
@@ -96,7 +96,7 @@ func TestWithAccessLogAndSecurityCheckInvalidToken(t *testing.T) {
 	handlerCalled := false
 
 	withAccessLogAndSecurityCheck(nil, testLogger, testLogger,
-		func(w http.ResponseWriter, r *http.Request, username string, kvstore *kvstore.KVStore, logger *log.Logger) {
+		func(w http.ResponseWriter, r *http.Request, username string, kvstore kvstore.Store, logger *log.Logger) {
 			handlerCalled = true
 		})(recorder, request)
 
@@ -126,7 +126,7 @@ func TestWithAccessLogAndSecurityCheckValid(t *testing.T) {
 	usernamePassed := ""
 
 	withAccessLogAndSecurityCheck(nil, testLogger, testLogger,
-		func(w http.ResponseWriter, r *http.Request, username string, kvstore *kvstore.KVStore, logger *log.Logger) {
+		func(w http.ResponseWriter, r *http.Request, username string, kvstore kvstore.Store, logger *log.Logger) {
 			handlerCalled = true
 			usernamePassed = username
 			fmt.Fprint(w, "All is ok")
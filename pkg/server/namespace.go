@@ -0,0 +1,245 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"store/pkg/kvstore"
+	"strings"
+	"time"
+)
+
+// maxNamespaceBytes is the largest namespace config that can be given in one request.
+const maxNamespaceBytes = 1 << 16
+
+var errInvalidDefaultTTL = errors.New("default time-to-live must not be negative")
+
+// namespaceConfig is the config of a namespace as given when creating it, with the default time-to-live
+// in seconds.
+type namespaceConfig struct {
+	DefaultACL *kvstore.ACL  `json:"default_acl,omitempty"`
+	Quota      kvstore.Quota `json:"quota"`
+	DefaultTTL int64         `json:"default_ttl,omitempty"`
+}
+
+// namespaceInfo provides details on a single namespace when listing them.
+type namespaceInfo struct {
+	Name string `json:"name"`
+	namespaceConfig
+	Keys  int   `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+// namespaceResources are the handlers that can be used within a namespace, e.g. /ns/team/store/abc,
+// by the first section of the path within the namespace.
+var namespaceResources = map[string]handler{
+	"store":   storeKey,
+	"list":    listKey,
+	"watch":   watch,
+	"acl":     acl,
+	"lists":   lists,
+	"sets":    sets,
+	"hashes":  hashes,
+	"lease":   lease,
+	"history": history,
+}
+
+// namespaceCollections are the handlers that can be used within a namespace without a key, e.g. /ns/team/list.
+var namespaceCollections = map[string]handler{
+	"list":    listAll,
+	"txn":     txn,
+	"usage":   usage,
+	"lease":   leases,
+	"changes": changes,
+}
+
+// listNamespaces lists all the namespaces (admin only).
+func listNamespaces(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	if request.Method != http.MethodGet {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	if username != adminUsername {
+		logger.Println("Ignoring namespace list request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	namespaces := make([]*namespaceInfo, 0)
+	for _, info := range kvstore.ListNamespaces(kvStore) {
+		namespaces = append(namespaces, &namespaceInfo{
+			Name: info.Name,
+			namespaceConfig: namespaceConfig{
+				DefaultACL: info.Config.DefaultACL,
+				Quota:      info.Config.Quota,
+				DefaultTTL: int64(info.Config.DefaultTTL / time.Second),
+			},
+			Keys:  info.Stats.Keys,
+			Bytes: info.Stats.Bytes,
+		})
+	}
+
+	bytes, err := json.Marshal(namespaces)
+	if err != nil {
+		logger.Print("Error marshalling namespaces to JSON: ", err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(bytes)
+}
+
+// namespaceRequest creates (PUT) or deletes (DELETE) a namespace given by /ns/{namespace} (admin only), or
+// passes requests within a namespace, such as /ns/{namespace}/store/{key}, on to the usual handlers using the
+// namespace's store.
+func namespaceRequest(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	name, path, _ := strings.Cut(getKey(request.URL.Path), "/")
+	if name == "" {
+		logger.Println("No namespace specified")
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	if path == "" {
+		switch request.Method {
+		case http.MethodPut:
+			createNamespace(writer, request, username, kvStore, name, logger)
+		case http.MethodDelete:
+			deleteNamespace(writer, username, kvStore, name, logger)
+		default:
+			http.NotFound(writer, request)
+		}
+
+		return
+	}
+
+	namespaceStore, ok := kvstore.Namespace(kvStore, name)
+	if !ok {
+		logger.Printf("Namespace %s not found", name)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+
+		return
+	}
+
+	resource, key, hasKey := strings.Cut(path, "/")
+
+	handlers := namespaceCollections
+	if hasKey {
+		handlers = namespaceResources
+	}
+
+	handlerFunc, ok := handlers[resource]
+	if !ok {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	// the handlers find the key in the path, so give them the path within the namespace
+	namespacedRequest := request.Clone(request.Context())
+	namespacedRequest.URL.Path = "/" + path
+	namespacedRequest.URL.RawPath = ""
+
+	logger.Printf("namespace %s %s %s", name, resource, key)
+
+	handlerFunc(writer, namespacedRequest, username, namespaceStore, logger)
+}
+
+func createNamespace(writer http.ResponseWriter, request *http.Request, username string,
+	kvStore *kvstore.KVStore, name string, logger *log.Logger) {
+	if username != adminUsername {
+		logger.Println("Ignoring namespace create request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	defer request.Body.Close()
+
+	var config namespaceConfig
+
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxNamespaceBytes))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&config); err != nil {
+		logger.Println("invalid namespace config: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	if config.DefaultTTL < 0 {
+		logger.Println("invalid namespace config: ", errInvalidDefaultTTL)
+		http.Error(writer, errInvalidDefaultTTL.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	logger.Printf("create namespace %s", name)
+
+	err := kvstore.CreateNamespace(kvStore, name, kvstore.NamespaceConfig{
+		DefaultACL: config.DefaultACL,
+		Quota:      config.Quota,
+		DefaultTTL: time.Duration(config.DefaultTTL) * time.Second,
+	})
+
+	switch {
+	case err == nil:
+		fmt.Fprint(writer, "OK")
+	case errors.Is(err, kvstore.ErrInvalidNamespace):
+		logger.Println("invalid namespace: ", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, kvstore.ErrNamespaceExists):
+		logger.Println("namespace exists: ", err)
+		http.Error(writer, err.Error(), http.StatusConflict)
+	default:
+		logger.Println("unable to create namespace: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	}
+}
+
+func deleteNamespace(writer http.ResponseWriter, username string, kvStore *kvstore.KVStore, name string,
+	logger *log.Logger) {
+	if username != adminUsername {
+		logger.Println("Ignoring namespace delete request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	logger.Printf("delete namespace %s", name)
+
+	deleted, err := kvstore.DeleteNamespace(kvStore, name)
+
+	switch {
+	case err != nil:
+		logger.Println("unable to delete namespace: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	case !deleted:
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	default:
+		fmt.Fprint(writer, "OK")
+	}
+}
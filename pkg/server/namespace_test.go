@@ -0,0 +1,133 @@
+package server
+
+import (
+	"net/http/httptest"
+	"store/pkg/kvstore"
+	"strings"
+	"testing"
+)
+
+func TestCreateNamespaceNotAdmin(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/ns/team", strings.NewReader(`{}`))
+
+	namespaceRequest(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	kvstore.Close(store)
+}
+
+func TestCreateNamespaceValid(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/ns/team",
+		strings.NewReader(`{"quota":{"max_keys":10},"default_ttl":60,"default_acl":{"private":true}}`))
+
+	namespaceRequest(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("PUT", "/ns/team", strings.NewReader(`{}`))
+
+	namespaceRequest(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 409, "namespace already exists")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/ns", nil)
+
+	listNamespaces(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 200, `^\[{"name":"team","default_acl":{"private":true},"quota":{"max_keys":10},`+
+		`"default_ttl":60,"keys":0,"bytes":0}\]$`)
+
+	kvstore.Close(store)
+}
+
+func TestCreateNamespaceInvalid(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/ns/te.am", strings.NewReader(`{}`))
+
+	namespaceRequest(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 400, "invalid namespace")
+
+	kvstore.Close(store)
+}
+
+func TestNamespaceStore(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.CreateNamespace(store, "team", kvstore.NamespaceConfig{})
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/ns/team/store/abc", strings.NewReader("456"))
+
+	namespaceRequest(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/ns/team/store/abc", nil)
+
+	namespaceRequest(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "^456$")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/ns/team/list", nil)
+
+	namespaceRequest(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `"owner":"user_b"`)
+
+	kvstore.Close(store)
+}
+
+func TestNamespaceMissing(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/ns/team/store/abc", nil)
+
+	namespaceRequest(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 404, "Not Found")
+
+	kvstore.Close(store)
+}
+
+func TestDeleteNamespace(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.CreateNamespace(store, "team", kvstore.NamespaceConfig{})
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("DELETE", "/ns/team", nil)
+
+	namespaceRequest(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("DELETE", "/ns/team", nil)
+
+	namespaceRequest(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("DELETE", "/ns/team", nil)
+
+	namespaceRequest(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 404, "Not Found")
+
+	kvstore.Close(store)
+}
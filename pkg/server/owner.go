@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"store/pkg/kvstore"
+)
+
+// ownerSuffix follows the key in the path to transfer ownership of the key, e.g. POST /store/abc/owner.
+const ownerSuffix = "/owner"
+
+// maxOwnerBytes is the largest request body accepted when transferring ownership.
+const maxOwnerBytes = 1024
+
+// ownerTransfer is the request body when transferring ownership of a key.
+type ownerTransfer struct {
+	Owner string `json:"owner"`
+}
+
+// transferOwner makes another user the owner of the key.
+func transferOwner(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, key string, logger *log.Logger) {
+	if key == "" {
+		logger.Println("No key specified")
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	condition, err := getCondition(request)
+	if err != nil {
+		logger.Println("invalid precondition: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	override, ok := getOverride(writer, request, username, logger)
+	if !ok {
+		return
+	}
+
+	defer request.Body.Close()
+
+	var transfer ownerTransfer
+
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxOwnerBytes))
+	decoder.DisallowUnknownFields()
+
+	if err = decoder.Decode(&transfer); err != nil || transfer.Owner == "" {
+		logger.Println("invalid ownership transfer: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	logger.Printf("transfer key %s owner %s to %s", key, username, transfer.Owner)
+
+	if override {
+		audit(logger, "user %s overriding access control to transfer key %s to %s", username, key, transfer.Owner)
+	}
+
+	options := kvstore.TransferOptions{Condition: condition, Override: override}
+
+	found, err := kvstore.TransferOwnershipContext(request.Context(), kvStore, key, transfer.Owner, username, options)
+
+	switch {
+	case err != nil:
+		logger.Println("unable to transfer key: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+	case !found:
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	default:
+		fmt.Fprint(writer, "OK")
+	}
+}
+
+var errOverrideNotAdmin = errors.New("only the admin user can override access control")
+
+// getOverride returns whether the request asks to override the key's access control, using the override
+// query parameter. Only the admin user can do so, otherwise it responds with 403 Forbidden and returns false.
+func getOverride(writer http.ResponseWriter, request *http.Request, username string,
+	logger *log.Logger) (bool, bool) {
+	if request.URL.Query().Get("override") != "true" {
+		return false, true
+	}
+
+	if username != adminUsername {
+		logger.Printf("Ignoring override request from user %s", username)
+		http.Error(writer, errOverrideNotAdmin.Error(), http.StatusForbidden)
+
+		return false, false
+	}
+
+	return true, true
+}
+
+// audit records an action that needs to be accounted for, such as an administrator overriding access control.
+func audit(logger *log.Logger, format string, args ...interface{}) {
+	logger.Printf("AUDIT: "+format, args...)
+}
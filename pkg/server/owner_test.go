@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http/httptest"
+	"store/pkg/kvstore"
+	"strings"
+	"testing"
+)
+
+func TestTransferOwner(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/store/abc/owner", strings.NewReader(`{"owner":"user_b"}`))
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	if entryInfo := kvstore.List(store, "abc", "user_a"); entryInfo.Owner != "user_b" {
+		t.Fatal("Key should have been transferred but was owned by: ", entryInfo.Owner)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestTransferOwnerNotOwner(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/store/abc/owner", strings.NewReader(`{"owner":"user_b"}`))
+
+	storeKey(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	kvstore.Close(store)
+}
+
+func TestTransferOwnerInvalid(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/store/abc/owner", strings.NewReader(`{"owner":""}`))
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 400, "Bad Request")
+
+	kvstore.Close(store)
+}
+
+func TestTransferOwnerMissingKey(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/store/abc/owner", strings.NewReader(`{"owner":"user_b"}`))
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 404, "Not Found")
+
+	kvstore.Close(store)
+}
+
+func TestOverrideNotAdmin(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/abc?override=true", strings.NewReader("456"))
+
+	storeKey(recorder, request, "user_b", store, testLogger)
+
+	checkResponse(t, recorder, 403, "only the admin user can override")
+
+	kvstore.Close(store)
+}
+
+func TestOverrideAdmin(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/abc?override=true", strings.NewReader("456"))
+
+	storeKey(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("DELETE", "/store/abc?override=true", nil)
+
+	storeKey(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 200, "OK")
+
+	kvstore.Close(store)
+}
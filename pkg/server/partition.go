@@ -0,0 +1,681 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"store/pkg/kvstore"
+	"store/pkg/ring"
+	"strings"
+	"sync"
+)
+
+const (
+	// forwardedHeader lists the nodes of a partitioned cluster that have passed a request on, so that a request
+	// can't go round in circles while the nodes disagree on who owns a key.
+	forwardedHeader = "X-Store-Forwarded-By"
+	// maxForwards is how many times a request can be passed on, first to the previous owner of its key and then
+	// to its new owner, before being handled where it lands.
+	maxForwards = 2
+)
+
+var (
+	errNodeResponse = errors.New("unexpected response from node")
+	errLastNode     = errors.New("the last node can't be removed")
+	errInvalidNode  = errors.New("node address must be an absolute URL")
+
+	errPartitionedNamespaces = fmt.Errorf("%w: namespaces can't be used in a partitioned cluster",
+		kvstore.ErrNotSupported)
+)
+
+// partitionedPaths are the endpoints for a single key, which are passed on to the node that owns the key, along
+// with the action suffixes that may follow the key in a POST request.
+var partitionedPaths = map[string][]string{
+	"/store/":   {ownerSuffix, incrSuffix},
+	"/list/":    nil,
+	"/acl/":     nil,
+	"/history/": nil,
+	"/lists/":   listActions,
+	"/sets/":    setActions,
+	"/hashes/":  hashActions,
+}
+
+// Partitioning configures a server as one node of a partitioned cluster, where each key is held by just one of
+// the nodes, chosen by consistent hashing (see package ring). Any node accepts requests for a key, and passes
+// them on to the node that owns it. When nodes join or leave (see the /admin/partitions/nodes/ endpoints),
+// the keys whose owner has changed are moved to their new owner. Until every node has finished moving its keys,
+// requests for each key are passed on to its previous owner, which handles them itself while it still holds
+// the key, so that a key is never read or changed by its new owner before it has arrived.
+//
+// Only requests for a single key are passed on. Those covering many keys (such as transactions, listing every
+// key, watches and the change feed) only cover the keys held by the node they're made to, and leases are only
+// held by the node that granted them. Keys attached to a lease can't move with it, so they're removed when
+// their owner changes, as if the lease had expired. Namespaces aren't available, since each would be a store of
+// its own on whichever node it was created, and requests for them are rejected. Every node must be using the
+// same key to sign bearer tokens, since the nodes make requests of each other as the admin user.
+type Partitioning struct {
+	// ID identifies the node within the cluster.
+	ID string
+	// Nodes maps the ID of each node in the cluster (including this one) to the base URL of its REST server,
+	// e.g. http://localhost:8000. A node joining an existing cluster need only know of itself.
+	Nodes map[string]*url.URL
+	// VirtualNodes is the number of points each node has on the hash ring. If zero, ring.DefaultVirtualNodes
+	// is used.
+	VirtualNodes int
+}
+
+// partitions is a node's view of a partitioned cluster.
+type partitions struct {
+	id           string
+	virtualNodes int
+	store        *kvstore.KVStore
+	mutex        sync.RWMutex
+	ring         *ring.Ring
+	nodes        map[string]*url.URL
+	// previous are the nodes from before they last changed (with their ring), while keys are still being
+	// moved, or nil once every node has finished
+	previous     map[string]*url.URL
+	previousRing *ring.Ring
+	proxies      map[string]*httputil.ReverseProxy
+	// moving is held while keys are moved, and for reading while a request for a key is handled locally, so
+	// that a key can't change between being handed off and removed
+	moving sync.RWMutex
+}
+
+// partitionStatus is a node's view of a partitioned cluster, as reported to the admin user.
+type partitionStatus struct {
+	ID       string            `json:"id"`
+	Nodes    map[string]string `json:"nodes"`
+	Previous map[string]string `json:"previous,omitempty"`
+}
+
+// nodeChange is the change to the nodes of the cluster sent to every node, with the nodes from before the
+// change while keys are being moved, or without them once every node has finished.
+type nodeChange struct {
+	Nodes    map[string]string `json:"nodes"`
+	Previous map[string]string `json:"previous,omitempty"`
+}
+
+// StartPartitioned is the same as Start, but the server is one node of a partitioned cluster.
+func StartPartitioned(address string, store *kvstore.KVStore, partitioning Partitioning, accessLog *log.Logger,
+	appLog *log.Logger) {
+	serve(address, store, nil, &partitioning, accessLog, appLog)
+}
+
+// newPartitions returns the node's view of the partitioned cluster as configured.
+func newPartitions(store *kvstore.KVStore, partitioning *Partitioning) *partitions {
+	p := &partitions{id: partitioning.ID, virtualNodes: partitioning.VirtualNodes, store: store}
+	setNodes(p, partitioning.Nodes, nil)
+
+	return p
+}
+
+// setNodes replaces the nodes of the cluster, so that requests are passed on to the new owner of each key, or
+// to its previous owner if the nodes from before the change are given, until they're replaced without them.
+func setNodes(p *partitions, nodes map[string]*url.URL, previous map[string]*url.URL) {
+	proxies := make(map[string]*httputil.ReverseProxy)
+
+	for _, addresses := range []map[string]*url.URL{previous, nodes} {
+		for id, address := range addresses {
+			proxies[id] = httputil.NewSingleHostReverseProxy(address)
+		}
+	}
+
+	var previousRing *ring.Ring
+	if previous != nil {
+		previousRing = ring.New(p.virtualNodes, nodeIDs(previous)...)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.ring = ring.New(p.virtualNodes, nodeIDs(nodes)...)
+	p.nodes = nodes
+	p.previous = previous
+	p.previousRing = previousRing
+	p.proxies = proxies
+}
+
+// nodeIDs returns the IDs of the nodes.
+func nodeIDs(nodes map[string]*url.URL) []string {
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// currentNodes returns a copy of the nodes of the cluster.
+func currentNodes(p *partitions) map[string]*url.URL {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	nodes := make(map[string]*url.URL)
+	for id, address := range p.nodes {
+		nodes[id] = address
+	}
+
+	return nodes
+}
+
+// currentPrevious returns a copy of the nodes from before they last changed, or nil if every node has
+// finished moving its keys since.
+func currentPrevious(p *partitions) map[string]*url.URL {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.previous == nil {
+		return nil
+	}
+
+	nodes := make(map[string]*url.URL)
+	for id, address := range p.previous {
+		nodes[id] = address
+	}
+
+	return nodes
+}
+
+// ownerOf returns the node that owns the key, and the proxy for passing requests on to it.
+func ownerOf(p *partitions, key string) (string, *httputil.ReverseProxy) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	owner, _ := ring.Owner(p.ring, key)
+
+	return owner, p.proxies[owner]
+}
+
+// previousOwnerOf returns the node that owned the key before the nodes last changed, and the proxy for passing
+// requests on to it, or the node that owns it now if every node has finished moving its keys since.
+func previousOwnerOf(p *partitions, key string) (string, *httputil.ReverseProxy) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	r := p.ring
+	if p.previousRing != nil {
+		r = p.previousRing
+	}
+
+	owner, _ := ring.Owner(r, key)
+
+	return owner, p.proxies[owner]
+}
+
+// routeToOwner passes requests for a single key on to the node that owned the key before the nodes last
+// changed, until the keys have all been moved since, and then on to the node that owns it. The previous owner
+// handles the request itself while it still holds the key, and otherwise passes it on to the new owner.
+// Requests for namespaces are rejected, and the rest are handled locally.
+func routeToOwner(p *partitions, local http.Handler, accessLog *log.Logger) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if isNamespaced(request) {
+			accessLog.Printf("%s %s %s rejected: %v", request.RemoteAddr, request.Method, request.URL,
+				errPartitionedNamespaces)
+			http.Error(writer, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+
+			return
+		}
+
+		key, ok := partitionKey(request)
+		if !ok {
+			local.ServeHTTP(writer, request)
+
+			return
+		}
+
+		forwardedBy := request.Header.Values(forwardedHeader)
+
+		// the key can't be moved away while it's being handled, so its owners are looked up once it can't be,
+		// otherwise the key could be written here just after being moved
+		p.moving.RLock()
+
+		owner, ownerProxy := ownerOf(p, key)
+		next, proxy := previousOwnerOf(p, key)
+
+		if next == p.id || forwardedFrom(forwardedBy, next) {
+			// the previous owner handles the request while it still holds the key, otherwise the owner does
+			if next == p.id && owner != p.id && holdsKey(request.Context(), p, key) {
+				defer p.moving.RUnlock()
+				local.ServeHTTP(writer, request)
+
+				return
+			}
+
+			next, proxy = owner, ownerProxy
+		}
+
+		if next != p.id && proxy != nil && !forwardedFrom(forwardedBy, next) && len(forwardedBy) < maxForwards {
+			p.moving.RUnlock()
+
+			accessLog.Printf("%s %s %s forwarded to %s", request.RemoteAddr, request.Method, request.URL, next)
+			request.Header.Add(forwardedHeader, p.id)
+			proxy.ServeHTTP(writer, request)
+
+			return
+		}
+
+		defer p.moving.RUnlock()
+		local.ServeHTTP(writer, request)
+	})
+}
+
+// forwardedFrom returns whether the request has already been passed on by the node.
+func forwardedFrom(forwardedBy []string, id string) bool {
+	for _, by := range forwardedBy {
+		if by == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// holdsKey returns whether the node's store holds the key, including private keys that can't be listed.
+func holdsKey(ctx context.Context, p *partitions, key string) bool {
+	info, err := kvstore.ListContext(ctx, p.store, key, "")
+
+	return (err == nil && info != nil) || errors.Is(err, kvstore.ErrPermissionDenied)
+}
+
+// partitionKey returns the key of a request for a single key, and false if the request isn't for a single key.
+func partitionKey(request *http.Request) (string, bool) {
+	for prefix, actions := range partitionedPaths {
+		if !strings.HasPrefix(request.URL.Path, prefix) {
+			continue
+		}
+
+		key := getKey(request.URL.Path)
+
+		if request.Method == http.MethodPost {
+			for _, suffix := range actions {
+				if strings.HasSuffix(key, suffix) {
+					key = strings.TrimSuffix(key, suffix)
+
+					break
+				}
+			}
+		}
+
+		return key, key != ""
+	}
+
+	return "", false
+}
+
+// registerPartitions adds the endpoints for administering a partitioned cluster to the router.
+func registerPartitions(router *http.ServeMux, p *partitions, store kvstore.Store, accessLog *log.Logger,
+	appLog *log.Logger) {
+	withPartitions := func(h func(http.ResponseWriter, *http.Request, string, *partitions, *log.Logger)) handler {
+		return func(writer http.ResponseWriter, request *http.Request, username string, _ kvstore.Store,
+			logger *log.Logger) {
+			h(writer, request, username, p, logger)
+		}
+	}
+
+	router.HandleFunc("/admin/partitions", withAccessLogAndSecurityCheck(store, accessLog, appLog,
+		withPartitions(partitionNodes)))
+	router.HandleFunc("/admin/partitions/nodes/", withAccessLogAndSecurityCheck(store, accessLog, appLog,
+		withPartitions(partitionNode)))
+	router.HandleFunc("/partition/nodes", withAccessLogAndSecurityCheck(store, accessLog, appLog,
+		withPartitions(replacePartitionNodes)))
+	router.HandleFunc("/partition/handoff", withAccessLogAndSecurityCheck(store, accessLog, appLog,
+		withPartitions(handoff)))
+}
+
+// partitionNodes returns the node's ID and the base URL of every node in the cluster (admin only).
+func partitionNodes(writer http.ResponseWriter, request *http.Request, username string, p *partitions,
+	logger *log.Logger) {
+	if request.Method != http.MethodGet {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	if username != adminUsername {
+		logger.Println("Ignoring partitions request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	status := &partitionStatus{ID: p.id, Nodes: formatNodes(currentNodes(p))}
+	if previous := currentPrevious(p); previous != nil {
+		status.Previous = formatNodes(previous)
+	}
+
+	writeJSON(writer, status, logger)
+}
+
+// partitionNode adds a node to the cluster (PUT /admin/partitions/nodes/{id}, with the base URL of its REST
+// server as the body), or removes it (DELETE), then has every node move the keys whose owner has changed
+// (admin only). Adding a node that's already in the cluster leaves the nodes as they are, but still moves any
+// keys held by the wrong node, such as after an earlier change failed part way through.
+func partitionNode(writer http.ResponseWriter, request *http.Request, username string, p *partitions,
+	logger *log.Logger) {
+	id := strings.TrimPrefix(request.URL.Path, "/admin/partitions/nodes/")
+
+	if username != adminUsername {
+		logger.Println("Ignoring partition node request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	if id == "" {
+		logger.Println("No node specified")
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	before := currentNodes(p)
+	after := currentNodes(p)
+
+	switch request.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			logger.Println("Unable to read node address: ", err)
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		address, err := url.Parse(strings.TrimSpace(string(body)))
+		if err != nil || !address.IsAbs() {
+			logger.Printf("%v: %s", errInvalidNode, body)
+			http.Error(writer, errInvalidNode.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		logger.Printf("add partition node %s at %s", id, address)
+		after[id] = address
+	case http.MethodDelete:
+		if _, ok := before[id]; !ok {
+			http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+
+			return
+		}
+
+		if len(before) == 1 {
+			http.Error(writer, errLastNode.Error(), http.StatusConflict)
+
+			return
+		}
+
+		logger.Printf("remove partition node %s", id)
+		delete(after, id)
+	default:
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	if err := changeNodes(request.Context(), p, before, after, logger); err != nil {
+		logger.Println("Unable to change partition nodes: ", err)
+		http.Error(writer, err.Error(), http.StatusBadGateway)
+
+		return
+	}
+
+	fmt.Fprint(writer, "OK")
+}
+
+// replacePartitionNodes replaces the nodes of the cluster with those given as JSON, as sent by the node a
+// change was made to, then moves the keys this node no longer owns (admin only). Requests for each key are
+// passed on to its previous owner until the nodes are replaced again without the previous nodes, once every
+// node has moved its keys.
+func replacePartitionNodes(writer http.ResponseWriter, request *http.Request, username string, p *partitions,
+	logger *log.Logger) {
+	if request.Method != http.MethodPut {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	if username != adminUsername {
+		logger.Println("Ignoring partition nodes request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	nodes, previous, err := parseNodeChange(request.Body)
+	if err != nil {
+		logger.Println("Unable to parse partition nodes: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	setNodes(p, nodes, previous)
+
+	if err = rebalance(request.Context(), p, logger); err != nil {
+		logger.Println("Unable to move keys: ", err)
+		http.Error(writer, err.Error(), http.StatusBadGateway)
+
+		return
+	}
+
+	fmt.Fprint(writer, "OK")
+}
+
+// handoff takes on the keys moved from another node (admin only).
+func handoff(writer http.ResponseWriter, request *http.Request, username string, p *partitions,
+	logger *log.Logger) {
+	if request.Method != http.MethodPost {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	if username != adminUsername {
+		logger.Println("Ignoring handoff request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	moved := &kvstore.Handoff{}
+	if err := json.NewDecoder(request.Body).Decode(moved); err != nil {
+		logger.Println("Unable to parse handoff: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	logger.Printf("take on %d keys", len(moved.Entries))
+
+	if err := kvstore.ImportKeys(request.Context(), p.store, moved); err != nil {
+		logger.Println("Unable to take on keys: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+
+		return
+	}
+
+	fmt.Fprint(writer, "OK")
+}
+
+// changeNodes tells every node, old and new, of the cluster's new nodes, then moves the keys this node no
+// longer owns. Each of the other nodes moves its own keys before responding. Once they all have, every node is
+// told the nodes again without those from before, so that requests are passed straight on to the new owners.
+func changeNodes(ctx context.Context, p *partitions, before map[string]*url.URL, after map[string]*url.URL,
+	logger *log.Logger) error {
+	setNodes(p, after, before)
+
+	if err := tellNodes(ctx, p, before, after, &nodeChange{formatNodes(after), formatNodes(before)}); err != nil {
+		return err
+	}
+
+	if err := rebalance(ctx, p, logger); err != nil {
+		return err
+	}
+
+	setNodes(p, after, nil)
+
+	return tellNodes(ctx, p, before, after, &nodeChange{Nodes: formatNodes(after)})
+}
+
+// tellNodes sends the change to every other node, old and new, in turn.
+func tellNodes(ctx context.Context, p *partitions, before map[string]*url.URL, after map[string]*url.URL,
+	change *nodeChange) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	told := make(map[string]bool)
+
+	for _, nodes := range []map[string]*url.URL{before, after} {
+		for id, address := range nodes {
+			if id == p.id || told[id] {
+				continue
+			}
+
+			told[id] = true
+
+			if err = nodeRequest(ctx, http.MethodPut, address, "/partition/nodes", body); err != nil {
+				return fmt.Errorf("unable to update node %s: %w", id, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// rebalance moves every key this node holds but doesn't own to its owner. Requests for the keys held by the
+// node wait until they have been moved, and are then passed on to their new owner.
+func rebalance(ctx context.Context, p *partitions, logger *log.Logger) error {
+	p.moving.Lock()
+	defer p.moving.Unlock()
+
+	handoffs, err := kvstore.ExportKeys(ctx, p.store, func(key string) (string, bool) {
+		owner, proxy := ownerOf(p, key)
+
+		return owner, proxy != nil && owner != p.id
+	})
+	if err != nil {
+		return err
+	}
+
+	nodes := currentNodes(p)
+
+	for owner, moved := range handoffs {
+		address, ok := nodes[owner]
+		if !ok {
+			// the nodes have changed again since, so the keys are left for the next rebalance
+			continue
+		}
+
+		body, marshalErr := json.Marshal(moved)
+		if marshalErr != nil {
+			return marshalErr
+		}
+
+		if err = nodeRequest(ctx, http.MethodPost, address, "/partition/handoff", body); err != nil {
+			return fmt.Errorf("unable to move keys to node %s: %w", owner, err)
+		}
+
+		if err = kvstore.RemoveHandoff(ctx, p.store, moved); err != nil {
+			return err
+		}
+
+		logger.Printf("Moved %d keys to node %s", len(moved.Entries), owner)
+	}
+
+	return nil
+}
+
+// nodeRequest makes a request of another node as the admin user, with the body as JSON.
+func nodeRequest(ctx context.Context, method string, node *url.URL, path string, body []byte) error {
+	token, err := newToken(adminUsername)
+	if err != nil {
+		return err
+	}
+
+	target := node.ResolveReference(&url.URL{Path: path})
+
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(response.Body)
+
+		return fmt.Errorf("%w: %s %s", errNodeResponse, response.Status, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
+// formatNodes returns the base URL of each node as a string.
+func formatNodes(nodes map[string]*url.URL) map[string]string {
+	formatted := make(map[string]string)
+	for id, address := range nodes {
+		formatted[id] = address.String()
+	}
+
+	return formatted
+}
+
+// parseNodeChange reads the new nodes, and those from before the change if the keys are still being moved,
+// from JSON.
+func parseNodeChange(reader io.Reader) (map[string]*url.URL, map[string]*url.URL, error) {
+	change := &nodeChange{}
+	if err := json.NewDecoder(reader).Decode(change); err != nil {
+		return nil, nil, err
+	}
+
+	nodes, err := parseNodes(change.Nodes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if change.Previous == nil {
+		return nodes, nil, nil
+	}
+
+	previous, err := parseNodes(change.Previous)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return nodes, previous, nil
+}
+
+// parseNodes parses the base URL of each node.
+func parseNodes(formatted map[string]string) (map[string]*url.URL, error) {
+	nodes := make(map[string]*url.URL)
+
+	for id, address := range formatted {
+		parsed, err := url.Parse(address)
+		if err != nil || !parsed.IsAbs() {
+			return nil, fmt.Errorf("%w: %s", errInvalidNode, address)
+		}
+
+		nodes[id] = parsed
+	}
+
+	return nodes, nil
+}
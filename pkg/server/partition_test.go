@@ -0,0 +1,368 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"store/pkg/kvstore"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const partitionTestKeys = 50
+
+// startPartitionNodes runs a REST server for each of the IDs until the test ends, as the nodes of a partitioned
+// cluster made up of the first members IDs, with the rest knowing only of themselves so they can be added later.
+func startPartitionNodes(t *testing.T, members int, ids ...string) (map[string]*kvstore.KVStore,
+	map[string]*url.URL) {
+	t.Helper()
+
+	stores := make(map[string]*kvstore.KVStore)
+	addresses := make(map[string]*url.URL)
+	handlers := make(map[string]http.Handler)
+
+	// the servers need their addresses before the nodes can be configured, so wait for them before routing
+	ready := make(chan struct{})
+
+	for _, id := range ids {
+		id := id
+		testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			<-ready
+			handlers[id].ServeHTTP(writer, request)
+		}))
+		t.Cleanup(testServer.Close)
+
+		addresses[id], _ = url.Parse(testServer.URL)
+	}
+
+	for i, id := range ids {
+		nodes := map[string]*url.URL{id: addresses[id]}
+
+		if i < members {
+			for _, member := range ids[:members] {
+				nodes[member] = addresses[member]
+			}
+		}
+
+		store := kvstore.NewKVStore(1)
+		t.Cleanup(func() { kvstore.Close(store) })
+
+		router := newRouter(store, testLogger, testLogger, make(chan int))
+		p := newPartitions(store, &Partitioning{ID: id, Nodes: nodes})
+		registerPartitions(router, p, store, testLogger, testLogger)
+
+		stores[id] = store
+		handlers[id] = routeToOwner(p, router, testLogger)
+	}
+
+	close(ready)
+
+	return stores, addresses
+}
+
+// getValue reads the key through the REST server, returning the status code and value.
+func getValue(t *testing.T, instance *url.URL, key string) (int, string) {
+	t.Helper()
+
+	token, _ := newToken("user_a")
+	request, _ := http.NewRequest("GET", instance.String()+"/store/"+key, nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("Error making request: ", err)
+	}
+
+	defer response.Body.Close()
+
+	value, _ := io.ReadAll(response.Body)
+
+	return response.StatusCode, string(value)
+}
+
+// checkPartitioned checks that every test key can be read through each of the instances, and that each key is
+// held by just one of the stores.
+func checkPartitioned(t *testing.T, stores map[string]*kvstore.KVStore, instances ...*url.URL) {
+	t.Helper()
+
+	for i := 0; i < partitionTestKeys; i++ {
+		key := fmt.Sprintf("key%d", i)
+
+		for _, instance := range instances {
+			if status, value := getValue(t, instance, key); status != http.StatusOK || value != key {
+				t.Fatalf("Key %s should have been read through %s but got: %d %s", key, instance, status, value)
+			}
+		}
+
+		held := 0
+
+		for _, store := range stores {
+			if _, ok := kvstore.Read(store, key, "user_a"); ok {
+				held++
+			}
+		}
+
+		if held != 1 {
+			t.Fatalf("Key %s should have been held by one store but was held by %d", key, held)
+		}
+	}
+}
+
+func TestPartitionKey(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		key    string
+	}{
+		{"GET", "/store/abc", "abc"},
+		{"GET", "/store/abc/owner", "abc/owner"},
+		{"POST", "/store/abc/owner", "abc"},
+		{"POST", "/store/abc/incr", "abc"},
+		{"POST", "/store/orders/1/incr", "orders/1"},
+		{"POST", "/lists/abc/push", "abc"},
+		{"PUT", "/acl/abc", "abc"},
+		{"GET", "/list", ""},
+		{"POST", "/txn", ""},
+		{"GET", "/store/", ""},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.path, nil)
+
+		if key, ok := partitionKey(request); key != test.key || ok != (test.key != "") {
+			t.Errorf("%s %s should have been for key %q but got %q %t", test.method, test.path, test.key, key, ok)
+		}
+	}
+}
+
+func TestPartitionedNodesRouteAndRebalance(t *testing.T) {
+	stores, addresses := startPartitionNodes(t, 2, "a", "b", "c")
+
+	for i := 0; i < partitionTestKeys; i++ {
+		key := fmt.Sprintf("key%d", i)
+
+		if response := putValue(t, addresses["a"], key, key, "user_a"); response.StatusCode != http.StatusOK {
+			t.Fatal("Key should have been written but got: ", response.Status)
+		}
+	}
+
+	if kvstore.StoreStats(stores["b"]).Keys == 0 {
+		t.Fatal("Some keys should have been passed on to the other node")
+	}
+
+	checkPartitioned(t, stores, addresses["a"], addresses["b"])
+
+	// the new node takes on its share of the keys
+	response := clusterRequest(t, "PUT", addresses["b"].String()+"/admin/partitions/nodes/c", addresses["c"].String())
+	if response.StatusCode != http.StatusOK {
+		t.Fatal("Node should have been added but got: ", response.Status)
+	}
+
+	if kvstore.StoreStats(stores["c"]).Keys == 0 {
+		t.Fatal("Some keys should have been moved to the new node")
+	}
+
+	checkPartitioned(t, stores, addresses["a"], addresses["b"], addresses["c"])
+
+	// the leaving node hands off all of its keys
+	response = clusterRequest(t, "DELETE", addresses["c"].String()+"/admin/partitions/nodes/a", "")
+	if response.StatusCode != http.StatusOK {
+		t.Fatal("Node should have been removed but got: ", response.Status)
+	}
+
+	if keys := kvstore.StoreStats(stores["a"]).Keys; keys != 0 {
+		t.Fatal("Removed node should have moved all of its keys but still had: ", keys)
+	}
+
+	checkPartitioned(t, stores, addresses["b"], addresses["c"])
+
+	response = clusterRequest(t, "DELETE", addresses["c"].String()+"/admin/partitions/nodes/a", "")
+	if response.StatusCode != http.StatusNotFound {
+		t.Fatal("Removing a node no longer in the cluster should have failed but got: ", response.Status)
+	}
+}
+
+func TestPartitionedWritesDuringRebalance(t *testing.T) {
+	stores, addresses := startPartitionNodes(t, 2, "a", "b", "c")
+
+	for i := 0; i < partitionTestKeys; i++ {
+		key := fmt.Sprintf("key%d", i)
+		putValue(t, addresses["a"], key, key, "user_a")
+	}
+
+	// the new node is added while the keys are being written and read, each through a different node
+	token, _ := newToken(adminUsername)
+	request, _ := http.NewRequest("PUT", addresses["a"].String()+"/admin/partitions/nodes/c",
+		strings.NewReader(addresses["c"].String()))
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	added := make(chan error, 1)
+
+	go func() {
+		response, err := http.DefaultClient.Do(request)
+		if err == nil {
+			response.Body.Close()
+
+			if response.StatusCode != http.StatusOK {
+				err = fmt.Errorf("%w: %s", errNodeResponse, response.Status)
+			}
+		}
+
+		added <- err
+	}()
+
+	instances := []*url.URL{addresses["a"], addresses["b"], addresses["c"]}
+
+	for round, done := 0, false; !done; round++ {
+		select {
+		case err := <-added:
+			if err != nil {
+				t.Fatal("Node should have been added but got: ", err)
+			}
+
+			done = true
+		default:
+		}
+
+		for i := 0; i < partitionTestKeys; i++ {
+			key := fmt.Sprintf("key%d", i)
+			value := fmt.Sprintf("%s-%d", key, round)
+
+			if response := putValue(t, instances[i%2], key, value, "user_a"); response.StatusCode != http.StatusOK {
+				t.Fatalf("Key %s should have been written but got: %s", key, response.Status)
+			}
+
+			if status, actual := getValue(t, instances[(i+1)%3], key); status != http.StatusOK || actual != value {
+				t.Fatalf("Key %s should have been read as %s but got: %d %s", key, value, status, actual)
+			}
+		}
+	}
+
+	if kvstore.StoreStats(stores["c"]).Keys == 0 {
+		t.Fatal("Some keys should have been moved to the new node")
+	}
+
+	for i := 0; i < partitionTestKeys; i++ {
+		key := fmt.Sprintf("key%d", i)
+
+		held := 0
+
+		for _, store := range stores {
+			if _, ok := kvstore.Read(store, key, "user_a"); ok {
+				held++
+			}
+		}
+
+		if held != 1 {
+			t.Fatalf("Key %s should have been held by one store but was held by %d", key, held)
+		}
+	}
+}
+
+func TestPartitionedWritesWhileKeysMoved(t *testing.T) {
+	stores, addresses := startPartitionNodes(t, 1, "b")
+
+	store := kvstore.NewKVStore(1)
+	t.Cleanup(func() { kvstore.Close(store) })
+
+	router := newRouter(store, testLogger, testLogger, make(chan int))
+	p := newPartitions(store, &Partitioning{ID: "a", Nodes: map[string]*url.URL{"a": {}}})
+	handler := routeToOwner(p, router, testLogger)
+
+	const writers = 4
+
+	// a request is being handled while the keys are about to be moved, so that the writes made to the previous
+	// owner wait for the move
+	p.moving.RLock()
+
+	moved := make(chan error, 1)
+
+	go func() {
+		moved <- rebalance(context.Background(), p, testLogger)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	token, _ := newToken("user_a")
+	written := make([]string, writers)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < writers; w++ {
+		w := w
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for round := 0; round < 3; round++ {
+				value := fmt.Sprintf("%d", round)
+				request := httptest.NewRequest("PUT", fmt.Sprintf("/store/key%d", w), strings.NewReader(value))
+				request.Header.Set("Authorization", "Bearer "+token)
+				recorder := httptest.NewRecorder()
+
+				handler.ServeHTTP(recorder, request)
+
+				if recorder.Code == http.StatusOK {
+					written[w] = value
+				}
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	setNodes(p, map[string]*url.URL{"b": addresses["b"]}, map[string]*url.URL{"a": {}})
+	p.moving.RUnlock()
+
+	if err := <-moved; err != nil {
+		t.Fatal("Keys should have been moved but got: ", err)
+	}
+
+	wg.Wait()
+
+	// every acknowledged write must have been made to the new owner
+	for w, value := range written {
+		key := fmt.Sprintf("key%d", w)
+
+		if value == "" {
+			t.Fatalf("Key %s should have been written", key)
+		}
+
+		if actual, ok := kvstore.Read(stores["b"], key, "user_a"); !ok || string(actual) != value {
+			t.Fatalf("Key %s should have been %s on the new owner but got: %s", key, value, actual)
+		}
+
+		if _, ok := kvstore.Read(store, key, "user_a"); ok {
+			t.Fatalf("Key %s should not have been left on the previous owner", key)
+		}
+	}
+}
+
+func TestPartitionedNamespacesRejected(t *testing.T) {
+	_, addresses := startPartitionNodes(t, 1, "a")
+
+	token, _ := newToken("user_a")
+
+	for _, path := range []string{"/ns", "/ns/team", "/ns/team/store/abc"} {
+		request, _ := http.NewRequest("PUT", addresses["a"].String()+path, strings.NewReader("{}"))
+		request.Header.Set("Authorization", "Bearer "+token)
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal("Error making request: ", err)
+		}
+
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusNotImplemented {
+			t.Fatalf("Request for %s should have been rejected but got: %d", path, response.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"store/pkg/kvstore"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// replicationBatchSize is the most records read from the replication log at a time.
+	replicationBatchSize = 100
+
+	followRetryInterval = time.Second
+)
+
+var errLeaderResponse = errors.New("unexpected response from leader")
+
+// Follower configures a server whose store is a follower of another server's store.
+type Follower struct {
+	// Leader is the base URL of the leader's REST server, e.g. http://localhost:8000.
+	Leader *url.URL
+	// ForwardWrites has requests that would change the store passed on to the leader, rather than rejected.
+	ForwardWrites bool
+}
+
+// replicationLog streams the records of the store's revisions after the revision given by the since query
+// parameter, one per line as newline-delimited JSON, as they happen (admin only). The stream ends when the
+// follower disconnects, the server shuts down, or the follower falls so far behind that records are lost, in
+// which case it must restore a snapshot before trying again.
+func replicationLog(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	if request.Method != http.MethodGet {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	if username != adminUsername {
+		logger.Println("Ignoring replication request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	since, err := strconv.ParseUint(request.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		logger.Println("invalid revision: ", request.URL.Query().Get("since"))
+		http.Error(writer, errInvalidRevision.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	flusher, canFlush := writer.(http.Flusher)
+	if !canFlush {
+		logger.Println("Unable to stream replication log as response writer cannot be flushed")
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	// wait for the first records before starting the stream, so that a follower too far behind can be told
+	records, err := kvstore.ReplicationLog(request.Context(), kvStore, since, replicationBatchSize)
+
+	switch {
+	case errors.Is(err, kvstore.ErrCompacted):
+		http.Error(writer, err.Error(), http.StatusGone)
+
+		return
+	case err != nil:
+		logger.Println("unable to read replication log: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+
+		return
+	}
+
+	logger.Printf("stream replication log since %d", since)
+
+	writer.Header().Set("Content-Type", ndjsonContentType)
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(writer)
+
+	for {
+		for _, record := range records {
+			if err = encoder.Encode(record); err != nil {
+				logger.Println("Unable to encode replication record: ", err)
+
+				return
+			}
+		}
+
+		flusher.Flush()
+
+		since = records[len(records)-1].Revision
+
+		records, err = kvstore.ReplicationLog(request.Context(), kvStore, since, replicationBatchSize)
+		if err != nil {
+			// the follower has gone away, the store was closed, or the follower fell too far behind
+			return
+		}
+	}
+}
+
+// replicationSnapshot returns the whole store at its current revision, for a follower to start from (admin
+// only).
+func replicationSnapshot(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	if request.Method != http.MethodGet {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	if username != adminUsername {
+		logger.Println("Ignoring replication snapshot request not from admin user")
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	bytes, err := kvstore.ReplicationSnapshot(request.Context(), kvStore)
+	if err != nil {
+		logger.Println("Unable to take replication snapshot: ", err)
+		status := storeErrorStatus(err)
+		http.Error(writer, http.StatusText(status), status)
+
+		return
+	}
+
+	logger.Println("Returning replication snapshot")
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(bytes)
+}
+
+// Follow keeps the follower store up to date with the leader's, by streaming the leader's replication log
+// from the follower's current revision, until the context is done. A follower which is new, or has fallen too
+// far behind, first restores a snapshot of the leader's store. If the leader can't be reached, or the stream
+// ends, it tries again after a short wait.
+//
+// The leader must be using the same key to sign bearer tokens, since the follower makes its requests as the
+// admin user.
+func Follow(ctx context.Context, leader *url.URL, store *kvstore.KVStore, logger *log.Logger) {
+	for {
+		err := followLog(ctx, leader, store, logger)
+		if errors.Is(err, kvstore.ErrCompacted) {
+			logger.Println("Follower too far behind leader, restoring snapshot")
+
+			if err = restoreSnapshot(ctx, leader, store); err == nil {
+				continue
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		logger.Println("Unable to follow leader: ", err)
+
+		select {
+		case <-time.After(followRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// followLog applies each record streamed from the leader's replication log to the follower store, until the
+// stream ends or fails. ErrCompacted is returned if the follower must first restore a snapshot.
+func followLog(ctx context.Context, leader *url.URL, store *kvstore.KVStore, logger *log.Logger) error {
+	since := kvstore.StoreStats(store).Revision
+
+	response, err := leaderRequest(ctx, leader, "/replication/log", "since="+strconv.FormatUint(since, 10))
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return kvstore.ErrCompacted
+	default:
+		return fmt.Errorf("%w: %s", errLeaderResponse, response.Status)
+	}
+
+	logger.Printf("Following leader %s from revision %d", leader, since)
+
+	decoder := json.NewDecoder(response.Body)
+
+	for {
+		record := &kvstore.ReplicationRecord{}
+		if err = decoder.Decode(record); err != nil {
+			return err
+		}
+
+		if err = kvstore.ApplyReplicationRecord(ctx, store, record); err != nil {
+			return err
+		}
+	}
+}
+
+// restoreSnapshot replaces the follower store with a snapshot of the leader's store.
+func restoreSnapshot(ctx context.Context, leader *url.URL, store *kvstore.KVStore) error {
+	response, err := leaderRequest(ctx, leader, "/replication/snapshot", "")
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", errLeaderResponse, response.Status)
+	}
+
+	return kvstore.RestoreReplicationSnapshot(ctx, store, response.Body)
+}
+
+// leaderRequest makes a GET request of the leader as the admin user.
+func leaderRequest(ctx context.Context, leader *url.URL, path string, query string) (*http.Response, error) {
+	token, err := newToken(adminUsername)
+	if err != nil {
+		return nil, err
+	}
+
+	target := leader.ResolveReference(&url.URL{Path: path, RawQuery: query})
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	return http.DefaultClient.Do(request)
+}
+
+// forwardWrites passes requests that would change the store on to the leader, along with all requests for
+// leases and namespaces since they are only held by the leader, and has the rest handled locally.
+func forwardWrites(leader *url.URL, local http.Handler, accessLog *log.Logger) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(leader)
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if isWrite(request) || strings.HasPrefix(request.URL.Path, "/lease") || isNamespaced(request) {
+			accessLog.Printf("%s %s %s forwarded to %s", request.RemoteAddr, request.Method, request.URL, leader)
+			proxy.ServeHTTP(writer, request)
+
+			return
+		}
+
+		local.ServeHTTP(writer, request)
+	})
+}
+
+// isNamespaced returns whether the request is for the namespaces, or for keys within one.
+func isNamespaced(request *http.Request) bool {
+	return request.URL.Path == "/ns" || strings.HasPrefix(request.URL.Path, "/ns/")
+}
+
+// isWrite returns whether the request may change the store, other than those administering the server itself.
+func isWrite(request *http.Request) bool {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+
+	return request.URL.Path != "/login" && request.URL.Path != "/shutdown" &&
+		!strings.HasPrefix(request.URL.Path, "/admin/")
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"store/pkg/kvstore"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startInstance runs a REST server for the store on localhost until the test ends, with requests that would
+// change the store forwarded to the leader if there is one.
+func startInstance(t *testing.T, store *kvstore.KVStore, leader *url.URL) *url.URL {
+	t.Helper()
+
+	var handler http.Handler = newRouter(store, testLogger, testLogger, make(chan int))
+	if leader != nil {
+		handler = forwardWrites(leader, handler, testLogger)
+	}
+
+	testServer := httptest.NewServer(handler)
+	t.Cleanup(testServer.Close)
+
+	instanceURL, _ := url.Parse(testServer.URL)
+
+	return instanceURL
+}
+
+// startFollower has the follower store follow the leader until the test ends.
+func startFollower(t *testing.T, leader *url.URL, follower *kvstore.KVStore) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		Follow(ctx, leader, follower, testLogger)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// waitForValue waits for the key to have the value in the store, failing the test if it takes too long.
+func waitForValue(t *testing.T, store *kvstore.KVStore, key string, value string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if actual, ok := kvstore.Read(store, key, "user_a"); ok && string(actual) == value {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Key %s should have been replicated with value %s", key, value)
+}
+
+// putValue writes the value to the key through the REST server, as the user.
+func putValue(t *testing.T, instance *url.URL, key string, value string, username string) *http.Response {
+	t.Helper()
+
+	token, _ := newToken(username)
+	request, _ := http.NewRequest("PUT", instance.String()+"/store/"+key, strings.NewReader(value))
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("Error making request: ", err)
+	}
+
+	response.Body.Close()
+
+	return response
+}
+
+func TestFollowerReplicatesLeader(t *testing.T) {
+	leaderStore := kvstore.NewKVStore(1)
+	t.Cleanup(func() { kvstore.Close(leaderStore) })
+
+	followerStore, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Follower: true})
+	t.Cleanup(func() { kvstore.Close(followerStore) })
+
+	leader := startInstance(t, leaderStore, nil)
+	follower := startInstance(t, followerStore, nil)
+	startFollower(t, leader, followerStore)
+
+	putValue(t, leader, "abc", "123", "user_a")
+	waitForValue(t, followerStore, "abc", "123")
+
+	putValue(t, leader, "abc", "456", "user_a")
+	waitForValue(t, followerStore, "abc", "456")
+
+	response := putValue(t, follower, "abc", "789", "user_a")
+	if response.StatusCode != http.StatusMisdirectedRequest {
+		t.Fatal("Write to the follower should have been rejected but got: ", response.Status)
+	}
+}
+
+func TestFollowerCatchesUpFromSnapshot(t *testing.T) {
+	leaderStore, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{ChangeFeed: 1})
+	t.Cleanup(func() { kvstore.Close(leaderStore) })
+
+	followerStore, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Follower: true})
+	t.Cleanup(func() { kvstore.Close(followerStore) })
+
+	kvstore.Write(leaderStore, "abc", []byte("123"), "user_a")
+	kvstore.Write(leaderStore, "def", []byte("456"), "user_a")
+
+	leader := startInstance(t, leaderStore, nil)
+	startFollower(t, leader, followerStore)
+
+	waitForValue(t, followerStore, "abc", "123")
+	waitForValue(t, followerStore, "def", "456")
+
+	// carries on from the snapshot's revision
+	kvstore.Write(leaderStore, "ghi", []byte("789"), "user_a")
+	waitForValue(t, followerStore, "ghi", "789")
+}
+
+func TestFollowerForwardsWrites(t *testing.T) {
+	leaderStore := kvstore.NewKVStore(1)
+	t.Cleanup(func() { kvstore.Close(leaderStore) })
+
+	followerStore, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Follower: true})
+	t.Cleanup(func() { kvstore.Close(followerStore) })
+
+	leader := startInstance(t, leaderStore, nil)
+	follower := startInstance(t, followerStore, leader)
+	startFollower(t, leader, followerStore)
+
+	if response := putValue(t, follower, "abc", "123", "user_a"); response.StatusCode != http.StatusOK {
+		t.Fatal("Write to the follower should have been forwarded but got: ", response.Status)
+	}
+
+	if value, ok := kvstore.Read(leaderStore, "abc", "user_a"); !ok || string(value) != "123" {
+		t.Fatal("Forwarded write should have been made to the leader")
+	}
+
+	waitForValue(t, followerStore, "abc", "123")
+}
+
+func TestFollowerForwardsNamespaces(t *testing.T) {
+	leaderStore := kvstore.NewKVStore(1)
+	t.Cleanup(func() { kvstore.Close(leaderStore) })
+
+	followerStore, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{Follower: true})
+	t.Cleanup(func() { kvstore.Close(followerStore) })
+
+	kvstore.CreateNamespace(leaderStore, "team", kvstore.NamespaceConfig{})
+	namespaceStore, _ := kvstore.Namespace(leaderStore, "team")
+	kvstore.Write(namespaceStore, "abc", []byte("123"), "user_a")
+
+	leader := startInstance(t, leaderStore, nil)
+	follower := startInstance(t, followerStore, leader)
+	startFollower(t, leader, followerStore)
+
+	// the follower has no namespaces of its own, so reads within one are made of the leader
+	response := clusterRequest(t, "GET", follower.String()+"/ns/team/store/abc", "")
+	if value, _ := io.ReadAll(response.Body); response.StatusCode != http.StatusOK || string(value) != "123" {
+		t.Fatalf("Read within a namespace should have been forwarded but got: %s %s", response.Status, value)
+	}
+
+	if _, ok := kvstore.Namespace(followerStore, "team"); ok {
+		t.Fatal("Namespace should not have been replicated to the follower")
+	}
+}
+
+func TestReplicationLog(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{ChangeFeed: 1})
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+	kvstore.Write(store, "abc", []byte("456"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/replication/log?since=0", nil)
+
+	replicationLog(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 403, "Forbidden")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/replication/log?since=x", nil)
+
+	replicationLog(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 400, "invalid revision")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/replication/log?since=0", nil)
+
+	replicationLog(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 410, "compacted")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/replication/snapshot", nil)
+
+	replicationSnapshot(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 200, `^\{"revision":2,"entries":\{"abc":\{"Value":"NDU2",.*\}\}\}$`)
+
+	kvstore.Close(store)
+}
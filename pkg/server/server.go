@@ -6,18 +6,20 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"store/pkg/kvstore"
+	"sync"
 	"time"
 )
 
 const forceIdleRequestsClosedAfterSecs = 5
 
 // common arguments needed by most of the handlers.
-type handler func(w http.ResponseWriter, r *http.Request, username string, kvstore *kvstore.KVStore, logger *log.Logger)
+type handler func(w http.ResponseWriter, r *http.Request, username string, kvstore kvstore.Store, logger *log.Logger)
 
-func withAccessLog(store *kvstore.KVStore, accessLog *log.Logger, appLog *log.Logger, h handler) http.HandlerFunc {
+func withAccessLog(store kvstore.Store, accessLog *log.Logger, appLog *log.Logger, h handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		accessLog.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL)
 		h(w, r, "", store, appLog)
@@ -25,7 +27,7 @@ func withAccessLog(store *kvstore.KVStore, accessLog *log.Logger, appLog *log.Lo
 }
 
 func shutdown(writer http.ResponseWriter, r *http.Request, username string,
-	ksstore *kvstore.KVStore, logger *log.Logger, c chan<- int) {
+	ksstore kvstore.Store, logger *log.Logger, c chan<- int) {
 	if username == adminUsername {
 		fmt.Fprintf(writer, "OK")
 		logger.Println("Requesting shut down of REST server")
@@ -37,28 +39,61 @@ func shutdown(writer http.ResponseWriter, r *http.Request, username string,
 	}
 }
 
-// Start sets up the REST server and starts it going. This function only returns after the server has been shutdown.
-func Start(port int, store *kvstore.KVStore, accessLog *log.Logger, appLog *log.Logger) {
-	server := &http.Server{
-		Addr: fmt.Sprintf("localhost:%d", port),
-	}
+// Start sets up the REST server and starts it going, listening on the address (host:port, e.g. localhost:8000,
+// or :8000 for every interface). This function only returns after the server has been shutdown.
+func Start(address string, store kvstore.Store, accessLog *log.Logger, appLog *log.Logger) {
+	serve(address, store, nil, nil, accessLog, appLog)
+}
+
+// StartFollower is the same as Start, but the store is kept up to date as a follower of another server's store
+// (see Follow), and requests that would change it are forwarded to the leader or rejected.
+func StartFollower(address string, store *kvstore.KVStore, follower Follower, accessLog *log.Logger,
+	appLog *log.Logger) {
+	serve(address, store, &follower, nil, accessLog, appLog)
+}
+
+// serve runs the REST server until it's shut down, following the leader if the server is a follower, or passing
+// requests on to the owner of each key if the server is one node of a partitioned cluster.
+func serve(address string, store kvstore.Store, follower *Follower, partitioning *Partitioning, accessLog *log.Logger,
+	appLog *log.Logger) {
+	// cancelled on shutdown, so that long-lived requests such as watches end promptly
+	baseContext, cancelRequests := context.WithCancel(context.Background())
 
 	gracefulShutdown := make(chan int)
 
-	// endpoints that don't require JWT bearer tokens
-	http.HandleFunc("/ping", withAccessLog(store, accessLog, appLog, ping))
-	http.HandleFunc("/login", withAccessLog(store, accessLog, appLog, login))
+	router := newRouter(store, accessLog, appLog, gracefulShutdown)
 
-	// endpoints that do require JWT bearer tokens
-	http.HandleFunc("/store/", withAccessLogAndSecurityCheck(store, accessLog, appLog, storeKey))
-	http.HandleFunc("/list/", withAccessLogAndSecurityCheck(store, accessLog, appLog, listKey))
-	http.HandleFunc("/list", withAccessLogAndSecurityCheck(store, accessLog, appLog, listAll))
-	http.HandleFunc("/shutdown", withAccessLogAndSecurityCheck(store, accessLog, appLog,
-		func(w http.ResponseWriter, r *http.Request, username string, s *kvstore.KVStore, logger *log.Logger) {
-			shutdown(w, r, username, s, logger, gracefulShutdown)
-		}))
+	var handler http.Handler = router
+
+	if kvStore, ok := store.(*kvstore.KVStore); ok && partitioning != nil {
+		p := newPartitions(kvStore, partitioning)
+		registerPartitions(router, p, store, accessLog, appLog)
+		handler = routeToOwner(p, router, accessLog)
+	}
 
-	appLog.Printf("Starting REST server on port %d", port)
+	var following sync.WaitGroup
+
+	if kvStore, ok := store.(*kvstore.KVStore); ok && follower != nil {
+		if follower.ForwardWrites {
+			handler = forwardWrites(follower.Leader, handler, accessLog)
+		}
+
+		following.Add(1)
+
+		go func() {
+			defer following.Done()
+			Follow(baseContext, follower.Leader, kvStore, appLog)
+		}()
+	}
+
+	server := &http.Server{
+		Addr:        address,
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return baseContext },
+	}
+	server.RegisterOnShutdown(cancelRequests)
+
+	appLog.Printf("Starting REST server on %s", address)
 
 	go func() {
 		err := server.ListenAndServe()
@@ -83,5 +118,55 @@ func Start(port int, store *kvstore.KVStore, accessLog *log.Logger, appLog *log.
 		appLog.Fatalf("REST server shutdown failed: %+v", err)
 	}
 
+	// stop following before the store is closed
+	cancelRequests()
+	following.Wait()
+
 	appLog.Println("REST server showdown completed")
 }
+
+// newRouter returns the handler for every endpoint of the REST server, with requests to shut down the server
+// passed on to the channel.
+func newRouter(store kvstore.Store, accessLog *log.Logger, appLog *log.Logger,
+	gracefulShutdown chan<- int) *http.ServeMux {
+	router := http.NewServeMux()
+
+	// endpoints that don't require JWT bearer tokens
+	router.HandleFunc("/ping", withAccessLog(store, accessLog, appLog, ping))
+	router.HandleFunc("/login", withAccessLog(store, accessLog, appLog, login))
+
+	// endpoints that do require JWT bearer tokens
+	router.HandleFunc("/store/", withAccessLogAndSecurityCheck(store, accessLog, appLog, storeKey))
+	router.HandleFunc("/list/", withAccessLogAndSecurityCheck(store, accessLog, appLog, listKey))
+	router.HandleFunc("/list", withAccessLogAndSecurityCheck(store, accessLog, appLog, listAll))
+	router.HandleFunc("/txn", withAccessLogAndSecurityCheck(store, accessLog, appLog, txn))
+	router.HandleFunc("/watch/", withAccessLogAndSecurityCheck(store, accessLog, appLog, watch))
+	router.HandleFunc("/acl/", withAccessLogAndSecurityCheck(store, accessLog, appLog, acl))
+	router.HandleFunc("/lists/", withAccessLogAndSecurityCheck(store, accessLog, appLog, lists))
+	router.HandleFunc("/sets/", withAccessLogAndSecurityCheck(store, accessLog, appLog, sets))
+	router.HandleFunc("/hashes/", withAccessLogAndSecurityCheck(store, accessLog, appLog, hashes))
+	router.HandleFunc("/changes", withAccessLogAndSecurityCheck(store, accessLog, appLog, changes))
+	router.HandleFunc("/history/", withAccessLogAndSecurityCheck(store, accessLog, appLog, history))
+	router.HandleFunc("/lease/", withAccessLogAndSecurityCheck(store, accessLog, appLog, lease))
+	router.HandleFunc("/lease", withAccessLogAndSecurityCheck(store, accessLog, appLog, leases))
+	router.HandleFunc("/ns/", withAccessLogAndSecurityCheck(store, accessLog, appLog, namespaceRequest))
+	router.HandleFunc("/ns", withAccessLogAndSecurityCheck(store, accessLog, appLog, listNamespaces))
+	router.HandleFunc("/usage", withAccessLogAndSecurityCheck(store, accessLog, appLog, usage))
+	router.HandleFunc("/replication/log", withAccessLogAndSecurityCheck(store, accessLog, appLog, replicationLog))
+	router.HandleFunc("/replication/snapshot", withAccessLogAndSecurityCheck(store, accessLog, appLog,
+		replicationSnapshot))
+	// Raft's heartbeats would swamp the access log
+	router.HandleFunc("/raft/vote", withSecurityCheck(store, appLog, raftVote))
+	router.HandleFunc("/raft/append", withSecurityCheck(store, appLog, raftAppend))
+	router.HandleFunc("/admin/cluster", withAccessLogAndSecurityCheck(store, accessLog, appLog, clusterStatus))
+	router.HandleFunc("/admin/cluster/members/", withAccessLogAndSecurityCheck(store, accessLog, appLog,
+		clusterMember))
+	router.HandleFunc("/admin/snapshot", withAccessLogAndSecurityCheck(store, accessLog, appLog, snapshot))
+	router.HandleFunc("/admin/stats", withAccessLogAndSecurityCheck(store, accessLog, appLog, stats))
+	router.HandleFunc("/shutdown", withAccessLogAndSecurityCheck(store, accessLog, appLog,
+		func(w http.ResponseWriter, r *http.Request, username string, s kvstore.Store, logger *log.Logger) {
+			shutdown(w, r, username, s, logger, gracefulShutdown)
+		}))
+
+	return router
+}
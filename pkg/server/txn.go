@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"store/pkg/kvstore"
+	"time"
+)
+
+const maxTxnOperations = 100
+
+var (
+	errTooManyOperations = errors.New("too many operations in transaction")
+	errUnknownCondition  = errors.New("unknown condition")
+)
+
+// txnOperation is the JSON representation of a single operation in a transaction request, e.g.
+//
+//	{"op": "put", "key": "abc", "value": "123", "ttl": 60, "if": "version", "version": 3}
+//
+// where ttl is in seconds, and "if" is one of "version", "not_version", "exists" or "not_exists".
+type txnOperation struct {
+	Op        string `json:"op"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	TTL       int64  `json:"ttl"`
+	Condition string `json:"if"`
+	Version   uint64 `json:"version"`
+}
+
+// txn handles a POST of a JSON list of operations, to be performed as a single atomic transaction. The values
+// read by get operations are returned base64 encoded, along with their content type.
+func txn(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	if request.Method != http.MethodPost {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	if username == "" {
+		logger.Println("No owner specified")
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	defer request.Body.Close()
+
+	var operations []txnOperation
+	if err := json.NewDecoder(request.Body).Decode(&operations); err != nil {
+		logger.Println("unable to parse transaction: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	ops, err := toTxnOps(operations)
+	if err != nil {
+		logger.Println("invalid transaction: ", err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	logger.Printf("transaction of %d operations owner %s", len(ops), username)
+
+	results, err := kvstore.TransactionContext(request.Context(), kvStore, ops, username)
+	if err != nil {
+		logger.Println("transaction failed: ", err)
+
+		status := storeErrorStatus(err)
+		if errors.Is(err, kvstore.ErrInvalidTxn) {
+			status = http.StatusBadRequest
+		}
+
+		http.Error(writer, err.Error(), status)
+
+		return
+	}
+
+	bytes, err := json.Marshal(results)
+	if err != nil {
+		logger.Print("Error marshalling transaction results to JSON: ", err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(bytes)
+}
+
+// toTxnOps converts the operations from the request into those used by the store.
+func toTxnOps(operations []txnOperation) ([]kvstore.TxnOp, error) {
+	if len(operations) > maxTxnOperations {
+		return nil, errTooManyOperations
+	}
+
+	ops := make([]kvstore.TxnOp, len(operations))
+
+	for i, operation := range operations {
+		ops[i] = kvstore.TxnOp{
+			Type:  kvstore.TxnOpType(operation.Op),
+			Key:   operation.Key,
+			Value: []byte(operation.Value),
+			TTL:   time.Duration(operation.TTL) * time.Second,
+		}
+
+		if operation.Condition != "" {
+			condition, err := toCondition(operation.Condition, operation.Version)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+
+			ops[i].Condition = condition
+		}
+	}
+
+	return ops, nil
+}
+
+func toCondition(name string, version uint64) (*kvstore.Condition, error) {
+	switch name {
+	case "version":
+		return &kvstore.Condition{Type: kvstore.IfVersion, Version: version}, nil
+	case "not_version":
+		return &kvstore.Condition{Type: kvstore.IfNotVersion, Version: version}, nil
+	case "exists":
+		return &kvstore.Condition{Type: kvstore.IfExists}, nil
+	case "not_exists":
+		return &kvstore.Condition{Type: kvstore.IfNotExists}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnknownCondition, name)
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http/httptest"
+	"store/pkg/kvstore"
+	"strings"
+	"testing"
+)
+
+func TestTxnValid(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/txn", strings.NewReader(`[
+		{"op": "put", "key": "abc", "value": "123"},
+		{"op": "check", "key": "def", "if": "not_exists"},
+		{"op": "get", "key": "abc"}
+	]`))
+	store := kvstore.NewKVStore(1)
+
+	txn(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^\[{"key":"abc","found":false,"version":1},{"key":"def","found":false},`+
+		`{"key":"abc","found":true,"value":"MTIz","version":1}\]$`)
+
+	kvstore.Close(store)
+}
+
+func TestTxnFailedCheck(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/txn", strings.NewReader(`[
+		{"op": "put", "key": "abc", "value": "123"},
+		{"op": "check", "key": "def", "if": "exists"}
+	]`))
+	store := kvstore.NewKVStore(1)
+
+	txn(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 412, "operation 1 failed")
+
+	if _, present := kvstore.Read(store, "abc", "user_a"); present {
+		t.Fatal("Failed transaction wrote to store")
+	}
+
+	kvstore.Close(store)
+}
+
+func TestTxnWrongOwner(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/txn", strings.NewReader(`[{"op": "delete", "key": "abc"}]`))
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "abc", []byte("123"), "user_b")
+
+	txn(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 403, "operation 0 failed")
+
+	kvstore.Close(store)
+}
+
+func TestTxnInvalid(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+
+	for _, body := range []string{
+		`not json`,
+		`[{"op": "upsert", "key": "abc"}]`,
+		`[{"op": "check", "key": "abc", "if": "maybe"}]`,
+	} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("POST", "/txn", strings.NewReader(body))
+
+		txn(recorder, request, "user_a", store, testLogger)
+
+		checkResponse(t, recorder, 400, ".")
+	}
+
+	kvstore.Close(store)
+}
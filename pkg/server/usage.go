@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"store/pkg/kvstore"
+)
+
+// usageReport is the quota each user has, and how much of it they are using.
+type usageReport struct {
+	Quota kvstore.Quota   `json:"quota"`
+	Users []kvstore.Usage `json:"users"`
+}
+
+// usage reports how much of the store the user is taking up, or for the admin user, how much every user is.
+func usage(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	if request.Method != http.MethodGet {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	report := &usageReport{Quota: kvstore.StoreQuota(kvStore)}
+
+	if username == adminUsername {
+		logger.Println("usage of all users")
+		report.Users = kvstore.AllUsage(kvStore)
+	} else {
+		logger.Printf("usage of user %s", username)
+		report.Users = []kvstore.Usage{kvstore.UserUsage(kvStore, username)}
+	}
+
+	bytes, err := json.Marshal(report)
+	if err != nil {
+		logger.Print("Error marshalling usage to JSON: ", err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(bytes)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http/httptest"
+	"store/pkg/kvstore"
+	"strings"
+	"testing"
+)
+
+func newQuotaStore(t *testing.T, quota kvstore.Quota) *kvstore.KVStore {
+	t.Helper()
+
+	store, err := kvstore.NewKVStoreWithConfig(kvstore.Config{Quota: quota})
+	if err != nil {
+		t.Fatal("Error opening store: ", err)
+	}
+
+	return store
+}
+
+func TestUsageOwnUser(t *testing.T) {
+	store := newQuotaStore(t, kvstore.Quota{MaxKeys: 10})
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+	kvstore.Write(store, "def", []byte("456"), "user_b")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/usage", nil)
+
+	usage(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 200, `^{"quota":{"max_keys":10},"users":\[{"username":"user_a","keys":1,"bytes":6}\]}$`)
+
+	kvstore.Close(store)
+}
+
+func TestUsageAdmin(t *testing.T) {
+	store := newQuotaStore(t, kvstore.Quota{})
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+	kvstore.Write(store, "def", []byte("4567"), "user_b")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/usage", nil)
+
+	usage(recorder, request, adminUsername, store, testLogger)
+
+	checkResponse(t, recorder, 200, `^{"quota":{},"users":\[`+
+		`{"username":"user_a","keys":1,"bytes":6},{"username":"user_b","keys":1,"bytes":7}\]}$`)
+
+	kvstore.Close(store)
+}
+
+func TestUsageNotSupported(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/usage", nil)
+
+	usage(recorder, request, "user_a", kvstore.NewMutexStore(), testLogger)
+
+	checkResponse(t, recorder, 501, "Not Implemented")
+}
+
+func TestPutValueTooLarge(t *testing.T) {
+	store := newQuotaStore(t, kvstore.Quota{MaxValueSize: 3})
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/abc", strings.NewReader("1234"))
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 413, "Request Entity Too Large")
+
+	if _, present := kvstore.Read(store, "abc", "user_a"); present {
+		t.Fatal("Key PUT over the value size limit wrote to store")
+	}
+
+	kvstore.Close(store)
+}
+
+func TestPutQuotaExceeded(t *testing.T) {
+	store := newQuotaStore(t, kvstore.Quota{MaxKeys: 1})
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("PUT", "/store/def", strings.NewReader("456"))
+
+	storeKey(recorder, request, "user_a", store, testLogger)
+
+	checkResponse(t, recorder, 507, "Insufficient Storage")
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"store/pkg/kvstore"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keepAliveInterval is how often a comment is sent on an idle event stream,
+// so that proxies and clients don't time out the connection.
+const keepAliveInterval = 15 * time.Second
+
+var errInvalidRevision = errors.New("invalid revision")
+
+// watch streams changes to a key (or all keys with a prefix) as server-sent events, until the client
+// disconnects or the server shuts down. Each event's id is its revision followed by its position among the
+// revision's events (e.g. 12.0, 12.1), since a revision can change many keys, so a reconnecting client can
+// resume from just after the last event it received using the Last-Event-ID header, or from the start of a
+// revision using the revision query parameter.
+func watch(writer http.ResponseWriter, request *http.Request, username string,
+	store kvstore.Store, logger *log.Logger) {
+	if request.Method != http.MethodGet {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	kvStore, ok := fullStore(writer, store, logger)
+	if !ok {
+		return
+	}
+
+	prefix := request.URL.Query().Get("prefix") == "true"
+
+	key := getKey(request.URL.Path)
+	if prefix {
+		key = getPrefix(request.URL.Path)
+	}
+
+	if key == "" && !prefix {
+		http.Error(writer, "no key specified", http.StatusBadRequest)
+
+		return
+	}
+
+	fromRevision, delivered, err := getFromRevision(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	flusher, canFlush := writer.(http.Flusher)
+	if !canFlush {
+		logger.Println("Unable to stream events as response writer cannot be flushed")
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	watcher, err := kvstore.Watch(kvStore, key, prefix, fromRevision, username)
+	if errors.Is(err, kvstore.ErrCompacted) {
+		http.Error(writer, err.Error(), http.StatusGone)
+
+		return
+	} else if err != nil {
+		logger.Println("Unable to watch key: ", err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	defer kvstore.Unwatch(kvStore, watcher)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	// the revision of the last event, and its position among the revision's events
+	revision := uint64(0)
+	position := 0
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			fmt.Fprint(writer, ": keep-alive\n\n")
+			flusher.Flush()
+
+		case event, open := <-watcher.Events:
+			if !open {
+				// the store was closed, or this client fell too far behind
+				return
+			}
+
+			if event.Revision == revision {
+				position++
+			} else {
+				revision, position = event.Revision, 0
+			}
+
+			// a resuming client has already received the first events of the revision it resumes from
+			if revision == fromRevision && position < delivered {
+				continue
+			}
+
+			data, encodeErr := json.Marshal(event)
+			if encodeErr != nil {
+				logger.Println("Unable to encode event: ", encodeErr)
+
+				return
+			}
+
+			fmt.Fprintf(writer, "id: %d.%d\nevent: %s\ndata: %s\n\n", revision, position, event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// getFromRevision returns the revision to start watching from, and how many of its events the client has
+// already received. When a client is reconnecting, that's the revision of the Last-Event-ID header, less the
+// events up to and including that one (or the revision after, if the id is only a revision), otherwise the
+// revision query parameter (if any).
+func getFromRevision(request *http.Request) (uint64, int, error) {
+	if lastEventID := request.Header.Get("Last-Event-ID"); lastEventID != "" {
+		revisionID, positionID, hasPosition := strings.Cut(lastEventID, ".")
+
+		revision, err := strconv.ParseUint(revisionID, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: %v", errInvalidRevision, err)
+		}
+
+		if !hasPosition {
+			return revision + 1, 0, nil
+		}
+
+		position, err := strconv.Atoi(positionID)
+		if err != nil || position < 0 {
+			return 0, 0, fmt.Errorf("%w: invalid event position %q", errInvalidRevision, positionID)
+		}
+
+		return revision, position + 1, nil
+	}
+
+	if param := request.URL.Query().Get("revision"); param != "" {
+		revision, err := strconv.ParseUint(param, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: %v", errInvalidRevision, err)
+		}
+
+		return revision, 0, nil
+	}
+
+	return 0, 0, nil
+}
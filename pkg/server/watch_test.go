@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"store/pkg/kvstore"
+	"strings"
+	"testing"
+)
+
+func TestWatchStream(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	kvstore.Write(store, "a/1", []byte("123"), "user_a")
+	kvstore.Write(store, "b/1", []byte("456"), "user_a")
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		watch(writer, request, "user_a", store, testLogger)
+	}))
+	defer testServer.Close()
+
+	// resume after the first event, so the second event is replayed from history
+	request, _ := http.NewRequest("GET", testServer.URL+"/watch/a/?prefix=true", nil)
+	request.Header.Set("Last-Event-ID", "0")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("Error making request: ", err)
+	}
+	defer response.Body.Close()
+
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatal("Wrong content type: ", contentType)
+	}
+
+	kvstore.Delete(store, "a/1", "user_a")
+
+	reader := bufio.NewReader(response.Body)
+	expected := []string{
+		"id: 1.0", "event: put", `data: {"type":"put","key":"a/1","value":"MTIz","revision":1}`, "",
+		"id: 3.0", "event: delete", `data: {"type":"delete","key":"a/1","revision":3}`, "",
+	}
+
+	for _, expectedLine := range expected {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil {
+			t.Fatal("Error reading event stream: ", readErr)
+		}
+
+		if line = strings.TrimSuffix(line, "\n"); line != expectedLine {
+			t.Fatalf("Wrong event stream line, expected %q, got %q", expectedLine, line)
+		}
+	}
+
+	kvstore.Close(store)
+}
+
+func TestWatchResumeWithinRevision(t *testing.T) {
+	store := kvstore.NewKVStore(1)
+	defer kvstore.Close(store)
+
+	kvstore.Transaction(store, []kvstore.TxnOp{
+		{Type: kvstore.TxnPut, Key: "a/1", Value: []byte("1")},
+		{Type: kvstore.TxnPut, Key: "a/2", Value: []byte("2")},
+		{Type: kvstore.TxnPut, Key: "a/3", Value: []byte("3")},
+	}, "user_a")
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		watch(writer, request, "user_a", store, testLogger)
+	}))
+	defer testServer.Close()
+
+	// the client received the first of the revision's events before disconnecting
+	request, _ := http.NewRequest("GET", testServer.URL+"/watch/a/?prefix=true", nil)
+	request.Header.Set("Last-Event-ID", "1.0")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("Error making request: ", err)
+	}
+	defer response.Body.Close()
+
+	reader := bufio.NewReader(response.Body)
+	expected := []string{
+		"id: 1.1", "event: put", `data: {"type":"put","key":"a/2","value":"Mg==","revision":1}`, "",
+		"id: 1.2", "event: put", `data: {"type":"put","key":"a/3","value":"Mw==","revision":1}`, "",
+	}
+
+	for _, expectedLine := range expected {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil {
+			t.Fatal("Error reading event stream: ", readErr)
+		}
+
+		if line = strings.TrimSuffix(line, "\n"); line != expectedLine {
+			t.Fatalf("Wrong event stream line, expected %q, got %q", expectedLine, line)
+		}
+	}
+}
+
+func TestWatchInvalid(t *testing.T) {
+	store, _ := kvstore.NewKVStoreWithConfig(kvstore.Config{WatchHistory: 1})
+	kvstore.Write(store, "abc", []byte("123"), "user_a")
+	kvstore.Write(store, "abc", []byte("456"), "user_a")
+
+	for _, test := range []struct {
+		target string
+		code   int
+	}{
+		{"/watch/", 400},
+		{"/watch/abc?revision=x", 400},
+		{"/watch/abc?revision=1", 410},
+	} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", test.target, nil)
+
+		watch(recorder, request, "user_a", store, testLogger)
+
+		checkResponse(t, recorder, test.code, ".")
+	}
+
+	kvstore.Close(store)
+}